@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// authorizationKey is the metadata key that carries the admin token.
+const authorizationKey = "authorization"
+
+// bearerPrefix is the scheme of the admin token in the authorization metadata.
+const bearerPrefix = "Bearer "
+
+// authenticate checks the bearer token of an incoming call.
+func authenticate(ctx context.Context, token string) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing credentials")
+	}
+
+	for _, value := range md.Get(authorizationKey) {
+		if !strings.HasPrefix(value, bearerPrefix) {
+			continue
+		}
+
+		given := strings.TrimPrefix(value, bearerPrefix)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid credentials")
+}
+
+// UnaryAuthInterceptor rejects calls that do not carry the admin token.
+func UnaryAuthInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authenticate(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+var _ credentials.PerRPCCredentials = TokenCredentials{}
+
+// TokenCredentials attaches the admin token to every call of a client.
+type TokenCredentials struct {
+	Token string
+
+	// Insecure allows sending the token over connections without transport
+	// security, e.g. to a node on the same host.
+	Insecure bool
+}
+
+// GetRequestMetadata returns the authorization metadata.
+func (c TokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: bearerPrefix + c.Token}, nil
+}
+
+// RequireTransportSecurity reports whether the token needs a secure connection.
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return !c.Insecure
+}
@@ -0,0 +1,238 @@
+// Package admin serves the NodeAdminService, the control plane of a node. It
+// runs on its own listener, apart from the peer port, and requires a token.
+package admin
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"simple-p2p/consensus"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+)
+
+var ErrMissingToken = errors.New("admin token must not be empty")
+
+var _ proto.NodeAdminServiceServer = (*Server)(nil)
+
+// Server is the admin server of a node.
+type Server struct {
+	node     *node.Node
+	engines  map[string]consensus.Consensus // consensus instances by topic
+	shutdown func()                         // shuts the node down
+	server   *grpc.Server
+}
+
+// NewServer creates the admin server of a node. Every call must carry token,
+// see TokenCredentials. shutdown is called in background by the Shutdown
+// call. opts are added to the gRPC server, e.g. TLS credentials.
+func NewServer(n *node.Node, token string, shutdown func(), engines []consensus.Consensus, opts ...grpc.ServerOption) (*Server, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	s := &Server{
+		node:     n,
+		engines:  make(map[string]consensus.Consensus, len(engines)),
+		shutdown: shutdown,
+	}
+	for _, engine := range engines {
+		s.engines[engine.Topic()] = engine
+	}
+
+	opts = append(opts, grpc.ChainUnaryInterceptor(UnaryAuthInterceptor(token)))
+	s.server = grpc.NewServer(opts...)
+	proto.RegisterNodeAdminServiceServer(s.server, s)
+	return s, nil
+}
+
+// Start listens on a TCP address and serves in background.
+func (s *Server) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.node.Logger().Info("admin is listening", logger.F("addr", lis.Addr()))
+	go s.Serve(lis)
+	return nil
+}
+
+// Serve serves on a listener until the server stops.
+func (s *Server) Serve(lis net.Listener) {
+	if err := s.server.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+		s.node.Logger().Error("admin stopped", logger.Err(err))
+	}
+}
+
+// Stop stops the admin server once the in-flight calls, such as Shutdown, are
+// answered.
+func (s *Server) Stop() {
+	s.server.GracefulStop()
+}
+
+// AddPeer adds a peer to the node.
+func (s *Server) AddPeer(_ context.Context, request *proto.PeerRequest) (*proto.Empty, error) {
+	if request.Address == "" {
+		return nil, status.Error(codes.InvalidArgument, "address must not be empty")
+	}
+
+	s.node.PeerManager.AddPeers(request.Address)
+	return &proto.Empty{}, nil
+}
+
+// RemovePeer removes a peer from the node.
+func (s *Server) RemovePeer(_ context.Context, request *proto.PeerRequest) (*proto.Empty, error) {
+	if err := s.node.PeerManager.RemovePeer(request.Address); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &proto.Empty{}, nil
+}
+
+// BanPeer removes a peer from the node and never adds it again.
+func (s *Server) BanPeer(_ context.Context, request *proto.PeerRequest) (*proto.Empty, error) {
+	if request.Address == "" {
+		return nil, status.Error(codes.InvalidArgument, "address must not be empty")
+	}
+
+	if err := s.node.PeerManager.BanPeer(request.Address); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &proto.Empty{}, nil
+}
+
+// ListPeers returns the peers of the node, banned peers included.
+func (s *Server) ListPeers(context.Context, *proto.Empty) (*proto.ListPeersResponse, error) {
+	response := &proto.ListPeersResponse{}
+
+	for _, addr := range s.node.PeerManager.GetPeers() {
+		state := "NOT_CONNECTED"
+		if st := s.node.PeerManager.GetPeerState(addr); st >= 0 {
+			state = st.String()
+		}
+		response.Peers = append(response.Peers, &proto.PeerInfo{Address: addr, State: state})
+	}
+
+	for _, addr := range s.node.PeerManager.GetBannedPeers() {
+		response.Peers = append(response.Peers, &proto.PeerInfo{Address: addr, State: "BANNED", Banned: true})
+	}
+	return response, nil
+}
+
+// GetConsensusStatus returns the status of a consensus instance.
+func (s *Server) GetConsensusStatus(_ context.Context, request *proto.ConsensusRequest) (*proto.ConsensusStatus, error) {
+	engine, err := s.engine(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(engine.Status()), nil
+}
+
+// StartConsensus starts a Sync of a consensus instance in background.
+func (s *Server) StartConsensus(_ context.Context, request *proto.ConsensusRequest) (*proto.ConsensusStatus, error) {
+	engine, err := s.engine(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if engine.Status().Running {
+		return nil, status.Errorf(codes.FailedPrecondition, "consensus topic %q is already running", request.Topic)
+	}
+
+	go engine.Sync()
+	return toProto(engine.Status()), nil
+}
+
+// StopConsensus stops the running Sync of a consensus instance.
+func (s *Server) StopConsensus(_ context.Context, request *proto.ConsensusRequest) (*proto.ConsensusStatus, error) {
+	engine, err := s.engine(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.Stop()
+	return toProto(engine.Status()), nil
+}
+
+// UpdatePreference updates the preference of a consensus instance.
+func (s *Server) UpdatePreference(_ context.Context, request *proto.UpdatePreferenceRequest) (*proto.ConsensusStatus, error) {
+	engine, err := s.engine(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.UpdatePreference(int(request.Preference))
+	return toProto(engine.Status()), nil
+}
+
+// GetChainHead returns the last block of the chain.
+func (s *Server) GetChainHead(context.Context, *proto.Empty) (*proto.Block, error) {
+	head, ok := s.node.Chain.Head()
+	if !ok {
+		return nil, status.Error(codes.NotFound, "chain is empty")
+	}
+	return node.BlockToProto(head), nil
+}
+
+// GetBlock returns the block at a height.
+func (s *Server) GetBlock(_ context.Context, request *proto.BlockRequest) (*proto.Block, error) {
+	block, ok := s.node.Chain.Get(request.Height)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "block %d not found", request.Height)
+	}
+	return node.BlockToProto(block), nil
+}
+
+// TailMessages returns the last logs of sent and received messages.
+func (s *Server) TailMessages(_ context.Context, request *proto.TailMessagesRequest) (*proto.TailMessagesResponse, error) {
+	if request.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+
+	response := &proto.TailMessagesResponse{}
+	for _, l := range s.node.MessageManager.GetMessageLogs(int(request.Limit)) {
+		response.Logs = append(response.Logs, &proto.MessageLog{
+			Hash:     l.Hash,
+			Type:     l.MessageType,
+			Sender:   l.Sender,
+			Receiver: l.Receiver,
+			Time:     l.Time.UnixNano(),
+		})
+	}
+	return response, nil
+}
+
+// Shutdown shuts the node down after answering.
+func (s *Server) Shutdown(context.Context, *proto.Empty) (*proto.Empty, error) {
+	if s.shutdown == nil {
+		return nil, status.Error(codes.Unimplemented, "shutdown is not supported")
+	}
+
+	go s.shutdown()
+	return &proto.Empty{}, nil
+}
+
+// engine returns the consensus instance of a topic.
+func (s *Server) engine(topic string) (consensus.Consensus, error) {
+	engine, ok := s.engines[topic]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown consensus topic: %q", topic)
+	}
+	return engine, nil
+}
+
+// toProto converts a consensus status to its proto message.
+func toProto(st consensus.Status) *proto.ConsensusStatus {
+	return &proto.ConsensusStatus{
+		Topic:      st.Topic,
+		Preference: int64(st.Preference),
+		Confidence: int64(st.Confidence),
+		Round:      int64(st.Round),
+		Accepted:   st.Accepted,
+		Running:    st.Running,
+	}
+}
@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-p2p/consensus"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+const token = "secret"
+
+func TestServer(t *testing.T) {
+	network := transport.NewMemory()
+
+	n := node.NewNode("node-1", node.WithTransport(network))
+	engine := consensus.NewConsensus(consensus.SnowParams{K: 1, A: 1, B: 1000, MaxStep: 100000})
+	engine.AddNode(n)
+	proto.RegisterConsensusServiceServer(n.Server, engine)
+	n.StartServer()
+	defer n.StopServer()
+
+	other := node.NewNode("node-2", node.WithTransport(network))
+	proto.RegisterConsensusServiceServer(other.Server, consensus.NewConsensus(consensus.SnowParams{}))
+	other.StartServer()
+	defer other.StopServer()
+
+	shutdown := make(chan struct{})
+	server, err := NewServer(n, token, func() { close(shutdown) }, []consensus.Consensus{engine})
+	assert.NoError(t, err)
+
+	lis, err := network.Listen("admin-1")
+	assert.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ctx := context.Background()
+
+	// calls without the token are rejected
+	anonymous := proto.NewNodeAdminServiceClient(dial(t, network, "wrong"))
+	_, err = anonymous.ListPeers(ctx, &proto.Empty{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	client := proto.NewNodeAdminServiceClient(dial(t, network, token))
+
+	_, err = client.AddPeer(ctx, &proto.PeerRequest{Address: "node-2"})
+	assert.NoError(t, err)
+	_, err = client.AddPeer(ctx, &proto.PeerRequest{Address: "node-3"})
+	assert.NoError(t, err)
+	_, err = client.BanPeer(ctx, &proto.PeerRequest{Address: "node-3"})
+	assert.NoError(t, err)
+
+	// a banned peer is never added again
+	n.PeerManager.AddPeers("node-3")
+	peers, err := client.ListPeers(ctx, &proto.Empty{})
+	assert.NoError(t, err)
+	assert.Len(t, peers.Peers, 2)
+	assert.Equal(t, "node-2", peers.Peers[0].Address)
+	assert.True(t, peers.Peers[1].Banned)
+
+	st, err := client.UpdatePreference(ctx, &proto.UpdatePreferenceRequest{Preference: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), st.Preference)
+
+	// node-2 prefers 0 and B is out of reach, so the consensus runs until stopped
+	_, err = client.StartConsensus(ctx, &proto.ConsensusRequest{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		st, err := client.GetConsensusStatus(ctx, &proto.ConsensusRequest{})
+		return err == nil && st.Running
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = client.StopConsensus(ctx, &proto.ConsensusRequest{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		st, err := client.GetConsensusStatus(ctx, &proto.ConsensusRequest{})
+		return err == nil && !st.Running && !st.Accepted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = client.GetConsensusStatus(ctx, &proto.ConsensusRequest{Topic: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetChainHead(ctx, &proto.Empty{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	n.Chain.Append(4)
+	head, err := client.GetChainHead(ctx, &proto.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), head.Value)
+
+	block, err := client.GetBlock(ctx, &proto.BlockRequest{Height: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, head.Hash, block.Hash)
+
+	conn, err := n.PeerManager.GetConnection("node-2")
+	assert.NoError(t, err)
+	assert.NoError(t, n.MessageManager.SendMessage(context.Background(), conn, &proto.MessageRequest{Type: proto.MessageType_QUERY}))
+	logs, err := client.TailMessages(ctx, &proto.TailMessagesRequest{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, logs.Logs, 1)
+	assert.Equal(t, "node-2", logs.Logs[0].Receiver)
+
+	_, err = client.Shutdown(ctx, &proto.Empty{})
+	assert.NoError(t, err)
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("node is not shut down")
+	}
+
+	_, err = NewServer(n, "", nil, nil)
+	assert.ErrorIs(t, err, ErrMissingToken)
+}
+
+// dial connects to the admin server with a token.
+func dial(t *testing.T, network *transport.Memory, token string) *grpc.ClientConn {
+	conn, err := network.Dial("admin-1", grpc.WithPerRPCCredentials(TokenCredentials{Token: token, Insecure: true}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,360 @@
+// Package chain keeps the values decided by consensus as a chain of blocks.
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"simple-p2p/merkle"
+	"simple-p2p/storage"
+	"sync"
+)
+
+var (
+	ErrCorrupted    = errors.New("corrupted chain")
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
+// Tx is a transaction submitted by a client. Data is opaque to the chain and
+// interpreted by the application. Fee is the priority of the transaction in
+// the mempool.
+type Tx struct {
+	Data []byte `json:"data"`
+	Fee  uint64 `json:"fee"`
+}
+
+// Hash returns the hex SHA-256 of the fee and the data, which identifies the
+// transaction.
+func (tx Tx) Hash() string {
+	var fee [8]byte
+	binary.BigEndian.PutUint64(fee[:], tx.Fee)
+
+	h := sha256.New()
+	h.Write(fee[:])
+	h.Write(tx.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Size returns the size of the data of the transaction.
+func (tx Tx) Size() int {
+	return len(tx.Data)
+}
+
+// leaf returns the leaf of the transaction in the Merkle tree of a block, its
+// raw hash.
+func (tx Tx) leaf() []byte {
+	leaf, _ := hex.DecodeString(tx.Hash())
+	return leaf
+}
+
+// TxRoot returns the Merkle root of transactions.
+func TxRoot(txs []Tx) string {
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.leaf()
+	}
+	return merkle.Root(leaves)
+}
+
+// ProveTx returns the proof of the transaction at an index against the
+// Merkle root of transactions.
+func ProveTx(txs []Tx, index int) (merkle.Proof, error) {
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.leaf()
+	}
+	return merkle.Prove(leaves, index)
+}
+
+// VerifyTx checks the proof that a transaction is in a block of a Merkle root
+// of transactions. It returns merkle.ErrInvalidProof otherwise.
+func VerifyTx(txRoot string, tx Tx, proof merkle.Proof) error {
+	return proof.Verify(txRoot, tx.leaf())
+}
+
+// Header is the content of a block its hash commits to. The transactions are
+// committed by their Merkle root, so headers are verified without them.
+type Header struct {
+	Height   uint64 `json:"height"`             // position in the chain, the first block is at height 1
+	Value    int    `json:"value"`              // decided value
+	PrevHash string `json:"prev_hash"`          // hash of the previous block, empty for the first block
+	Hash     string `json:"hash"`               // hash of the block
+	AppHash  string `json:"app_hash,omitempty"` // state hash of the application after the previous block
+	TxRoot   string `json:"tx_root"`            // Merkle root of the transactions
+	Op       []byte `json:"op,omitempty"`       // validator set operation recorded in the block, opaque to the chain
+}
+
+// ComputeHash returns the hash of the header content.
+func (h Header) ComputeHash() string {
+	sum := sha256.New()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], h.Height)
+	sum.Write(buf[:])
+	binary.BigEndian.PutUint64(buf[:], uint64(h.Value))
+	sum.Write(buf[:])
+	sum.Write([]byte(h.PrevHash))
+	sum.Write([]byte(h.AppHash))
+	sum.Write([]byte(h.TxRoot))
+	if len(h.Op) > 0 {
+		binary.BigEndian.PutUint64(buf[:], uint64(len(h.Op)))
+		sum.Write(buf[:])
+		sum.Write(h.Op)
+	}
+
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// Block is a decided value at a height of the chain, with the transactions
+// ordered by the decision.
+type Block struct {
+	Height   uint64 `json:"height"`             // position in the chain, the first block is at height 1
+	Value    int    `json:"value"`              // decided value
+	PrevHash string `json:"prev_hash"`          // hash of the previous block, empty for the first block
+	Hash     string `json:"hash"`               // hash of the block
+	Txs      []Tx   `json:"txs,omitempty"`      // transactions, in execution order
+	AppHash  string `json:"app_hash,omitempty"` // state hash of the application after the previous block
+	TxRoot   string `json:"tx_root"`            // Merkle root of the transactions
+	Op       []byte `json:"op,omitempty"`       // validator set operation recorded in the block, opaque to the chain
+}
+
+// Header returns the header of the block.
+func (b Block) Header() Header {
+	return Header{
+		Height:   b.Height,
+		Value:    b.Value,
+		PrevHash: b.PrevHash,
+		Hash:     b.Hash,
+		AppHash:  b.AppHash,
+		TxRoot:   b.TxRoot,
+		Op:       b.Op,
+	}
+}
+
+// ComputeHash returns the hash of the block content.
+func (b Block) ComputeHash() string {
+	return b.Header().ComputeHash()
+}
+
+// Valid reports whether the hash of the block matches its content, and the
+// Merkle root its transactions.
+func (b Block) Valid() bool {
+	return b.Hash == b.ComputeHash() && b.TxRoot == TxRoot(b.Txs)
+}
+
+// Chain is an append-only chain of blocks. A chain restored from a snapshot
+// starts at the block of the snapshot, the blocks below it are not kept.
+type Chain struct {
+	base   uint64        // height of the block before the first one kept, 0 unless restored
+	blocks []Block       // blocks by height - base - 1
+	store  storage.Store // store of the blocks, nil if the chain is kept in memory only
+	mux    sync.RWMutex  // mutual exclusion lock for blocks
+}
+
+// New creates an empty chain kept in memory only.
+func New() *Chain {
+	return &Chain{}
+}
+
+// Open opens the chain persisted in a store, and checks that every block
+// links to the previous one. The first block stored is the base of a restored
+// chain if it is above height 1.
+func Open(s storage.Store) (*Chain, error) {
+	c := &Chain{store: s}
+
+	it := s.Iterator(nil)
+	defer it.Release()
+	for it.Next() {
+		var b Block
+		if err := json.Unmarshal(it.Value(), &b); err != nil {
+			return nil, fmt.Errorf("%w: block %x: %v", ErrCorrupted, it.Key(), err)
+		}
+		if len(c.blocks) == 0 && b.Height > 0 {
+			c.base = b.Height - 1
+		}
+		next := c.base + uint64(len(c.blocks)) + 1
+		if b.Height != next || (len(c.blocks) > 0 && b.PrevHash != c.headHash()) || !b.Valid() {
+			return nil, fmt.Errorf("%w: invalid block at height %d", ErrCorrupted, next)
+		}
+		c.blocks = append(c.blocks, b)
+	}
+	return c, nil
+}
+
+// Restore replaces the chain with a single block, the block of a snapshot
+// verified by the caller. The chain then grows from that block, the blocks
+// below it are not kept.
+func (c *Chain) Restore(b Block) error {
+	if b.Height == 0 || !b.Valid() {
+		return fmt.Errorf("%w at height %d", ErrInvalidBlock, b.Height)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.store != nil {
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+
+		batch := storage.NewBatch()
+		it := c.store.Iterator(nil)
+		for it.Next() {
+			batch.Delete(it.Key())
+		}
+		it.Release()
+		batch.Set(heightKey(b.Height), data)
+		if err := c.store.Write(batch); err != nil {
+			return err
+		}
+	}
+
+	c.base = b.Height - 1
+	c.blocks = []Block{b}
+	return nil
+}
+
+// Append appends a decided value as the next block. The block is persisted
+// before it is appended.
+func (c *Chain) Append(value int) (Block, error) {
+	return c.AppendTxs(value, nil, "")
+}
+
+// AppendTxs appends a decided value and its transactions as the next block,
+// with the state hash of the application after the previous block.
+func (c *Chain) AppendTxs(value int, txs []Tx, appHash string) (Block, error) {
+	return c.AppendOp(value, txs, nil, appHash)
+}
+
+// AppendOp is AppendTxs with a validator set operation recorded in the block.
+func (c *Chain) AppendOp(value int, txs []Tx, op []byte, appHash string) (Block, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	b := Block{
+		Height:   c.base + uint64(len(c.blocks)) + 1,
+		Value:    value,
+		PrevHash: c.headHash(),
+		Txs:      txs,
+		AppHash:  appHash,
+		TxRoot:   TxRoot(txs),
+		Op:       op,
+	}
+	b.Hash = b.ComputeHash()
+
+	if err := c.append(b); err != nil {
+		return Block{}, err
+	}
+	return b, nil
+}
+
+// AppendBlock appends a block decided elsewhere, e.g. downloaded from a peer.
+// It returns ErrInvalidBlock unless the block is the next one of the chain.
+func (c *Chain) AppendBlock(b Block) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if b.Height != c.base+uint64(len(c.blocks))+1 || b.PrevHash != c.headHash() || !b.Valid() {
+		return fmt.Errorf("%w at height %d", ErrInvalidBlock, b.Height)
+	}
+	return c.append(b)
+}
+
+// headHash returns the hash of the last block, empty if the chain is empty.
+// The lock must be held.
+func (c *Chain) headHash() string {
+	if len(c.blocks) == 0 {
+		return ""
+	}
+	return c.blocks[len(c.blocks)-1].Hash
+}
+
+// append persists and appends a block, the lock must be held.
+func (c *Chain) append(b Block) error {
+	if c.store != nil {
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		if err := c.store.Set(heightKey(b.Height), data); err != nil {
+			return err
+		}
+	}
+
+	c.blocks = append(c.blocks, b)
+	return nil
+}
+
+// heightKey returns the key of the block at a height, sorted by height.
+func heightKey(height uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], height)
+	return key[:]
+}
+
+// Height returns the height of the last block, 0 if the chain is empty.
+func (c *Chain) Height() uint64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.base + uint64(len(c.blocks))
+}
+
+// Base returns the height of the first block kept, above 1 if the chain was
+// restored from a snapshot, 0 if the chain is empty.
+func (c *Chain) Base() uint64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if len(c.blocks) == 0 {
+		return 0
+	}
+	return c.base + 1
+}
+
+// Head returns the last block.
+func (c *Chain) Head() (Block, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if len(c.blocks) == 0 {
+		return Block{}, false
+	}
+	return c.blocks[len(c.blocks)-1], true
+}
+
+// Get returns the block at a height.
+func (c *Chain) Get(height uint64) (Block, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if height <= c.base || height > c.base+uint64(len(c.blocks)) {
+		return Block{}, false
+	}
+	return c.blocks[height-c.base-1], true
+}
+
+// Range returns at most limit blocks starting from a height, none if the
+// height is below the base of the chain.
+func (c *Chain) Range(from uint64, limit int) []Block {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if from == 0 {
+		from = c.base + 1
+	}
+	height := c.base + uint64(len(c.blocks))
+	if from <= c.base || from > height || limit <= 0 {
+		return []Block{}
+	}
+
+	to := from - 1 + uint64(limit)
+	if to > height {
+		to = height
+	}
+	return append([]Block(nil), c.blocks[from-c.base-1:to-c.base]...)
+}
@@ -0,0 +1,114 @@
+// Package clock abstracts time so background loops can be driven by tests.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for durations.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep blocks until the duration has elapsed.
+	Sleep(d time.Duration)
+}
+
+var _ Clock = Real{}
+
+// Real is the wall clock.
+type Real struct{}
+
+// Now returns time.Now.
+func (Real) Now() time.Time { return time.Now() }
+
+// After returns time.After.
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Sleep calls time.Sleep.
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+var _ Clock = (*Fake)(nil)
+
+// waiter is a pending After or Sleep of a fake clock.
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// Fake is a clock that only moves when Advance is called.
+type Fake struct {
+	now     time.Time
+	waiters []waiter
+	mux     sync.Mutex // mutual exclusion lock for now and waiters
+}
+
+// NewFake returns a fake clock starting at the given time.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the current fake time.
+func (f *Fake) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.now
+}
+
+// After returns a channel that receives the fake time once the clock has
+// been advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, waiter{until: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Sleep blocks until the clock has been advanced by d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the clock forward and wakes up the waiters that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.now = f.now.Add(d)
+
+	sort.Slice(f.waiters, func(i, j int) bool {
+		return f.waiters[i].until.Before(f.waiters[j].until)
+	})
+
+	remaining := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = remaining
+}
+
+// Waiters returns the number of pending After and Sleep calls.
+func (f *Fake) Waiters() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return len(f.waiters)
+}
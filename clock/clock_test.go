@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(0, 0)
+	fake := NewFake(start)
+	assert.Equal(t, start, fake.Now())
+
+	// a waiter fires once the clock reaches its time
+	first := fake.After(time.Second)
+	third := fake.After(3 * time.Second)
+	second := fake.After(2 * time.Second)
+	assert.Equal(t, 3, fake.Waiters())
+
+	fake.Advance(500 * time.Millisecond)
+	assert.Len(t, first, 0)
+
+	fake.Advance(1500 * time.Millisecond)
+	assert.Equal(t, start.Add(2*time.Second), <-first)
+	assert.Equal(t, start.Add(2*time.Second), <-second)
+	assert.Len(t, third, 0)
+	assert.Equal(t, 1, fake.Waiters())
+
+	fake.Advance(time.Second)
+	assert.Equal(t, start.Add(3*time.Second), <-third)
+	assert.Equal(t, 0, fake.Waiters())
+
+	// a wait of zero does not block
+	assert.Equal(t, fake.Now(), <-fake.After(0))
+}
+
+func TestFakeSleep(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+
+	// sleepers wake up in the order of their durations as the clock advances
+	woken := make(chan int, 3)
+	for i, d := range []int{3, 1, 2} {
+		go func(d int) {
+			fake.Sleep(time.Duration(d) * time.Second)
+			woken <- d
+		}(d)
+		waiters := i + 1
+		assert.Eventually(t, func() bool { return fake.Waiters() == waiters }, time.Second, time.Millisecond)
+	}
+	for d := 1; d <= 3; d++ {
+		fake.Advance(time.Second)
+		assert.Equal(t, d, <-woken)
+	}
+	assert.Equal(t, 0, fake.Waiters())
+}
+
+func TestReal(t *testing.T) {
+	before := Real{}.Now()
+	Real{}.Sleep(time.Millisecond)
+	assert.True(t, (<-Real{}.After(time.Millisecond)).After(before))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"simple-p2p/config"
+	"simple-p2p/consensus"
+	"simple-p2p/kvstore"
+	"simple-p2p/logger"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"syscall"
+	"time"
+)
+
+// flagKeys are the config keys set by the flags.
+var flagKeys = map[string]string{
+	"host":              "node.host",
+	"port":              "node.port",
+	"neighbors":         "node.neighbors",
+	"K":                 "consensus.k",
+	"A":                 "consensus.a",
+	"B":                 "consensus.b",
+	"block-interval":    "consensus.block_interval",
+	"api":               "api.addr",
+	"log-level":         "log.level",
+	"data":              "storage.path",
+	"snapshot-interval": "snapshot.interval",
+	"snapshot-keep":     "snapshot.keep",
+}
+
+// appPrefix is the prefix of the keys of the store application in the node
+// storage.
+const appPrefix = "kvstore/"
+
+// defaultAPI is the address of the HTTP API when none is configured, the
+// keys are only served over HTTP.
+const defaultAPI = "127.0.0.1:8080"
+
+func main() {
+	def := config.Default()
+
+	// add flag
+	configPath := flag.String("config", "", "path of a YAML, TOML or JSON config file")
+	flag.String("neighbors", "", "comma separated bootstrap addresses to join the p2p network")
+	flag.String("host", def.Node.Host, "host address")
+	flag.Int("port", def.Node.Port, "port to listen")
+	flag.Int("K", def.Consensus.K, "sample K of each round of query. K <= max-peers")
+	flag.Int("A", def.Consensus.A, "is quorum size. A <= K")
+	flag.Int("B", def.Consensus.B, "is decision threshold")
+	flag.Duration("block-interval", time.Duration(def.Consensus.BlockInterval), "sleep time between blocks of pending operations")
+	flag.String("api", defaultAPI, "address of the HTTP API serving the keys")
+	flag.String("log-level", def.Log.Level, "minimum level of the logs: debug, info, warn or error")
+	flag.String("data", "", "file of the node state and the keys, kept in memory only if empty")
+	flag.Int("snapshot-interval", def.Snapshot.Interval, "blocks between two snapshots of the keys served to joining nodes, none if 0")
+	flag.Int("snapshot-keep", def.Snapshot.Keep, "number of snapshots kept, all if 0")
+	flag.Parse()
+
+	// load config, flags take precedence over the environment and the file
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.API.Addr == "" {
+		cfg.API.Addr = defaultAPI
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			if err := cfg.Set(key, f.Value.String()); err != nil {
+				log.Fatal(err)
+			}
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// open storage, the keys are kept with the node state
+	var store storage.Store = storage.NewMemory()
+	if cfg.Storage.Path != "" {
+		store, err = storage.Open(cfg.Storage.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	app, err := kvstore.NewApp(storage.NewPrefix(store, appPrefix))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// create node
+	snow := consensus.NewConsensus(cfg.SnowParams())
+	newNode := node.NewNode(cfg.Address(),
+		node.WithLogger(cfg.Logger(os.Stderr)),
+		node.WithPeerOptions(cfg.PeerOptions()...),
+		node.WithStorage(store),
+		node.WithConsensus(snow),
+		node.WithApplication(app),
+		cfg.SnapshotOption(),
+	)
+	l := newNode.Logger()
+	newNode.OnClose(store.Close)
+
+	// shut down on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	if len(cfg.Node.Neighbors) > 0 || newNode.PeerManager.GetPeersNum() > 0 {
+		newNode.PeerManager.StartDiscoverPeers(cfg.Node.Neighbors...)
+	}
+
+	// operations are transactions of the mempool, ordered into blocks
+	pool := mempool.New(newNode, cfg.MempoolOptions()...)
+	builder := consensus.NewBlockBuilder(newNode, snow, pool)
+	client := kvstore.NewClient(newNode, app, pool)
+
+	// start http api
+	api := node.NewAPI(newNode)
+	mempool.RegisterAPI(api, pool)
+	kvstore.RegisterAPI(api, client)
+	if err := api.Start(cfg.API.Addr); err != nil {
+		l.Error("failed to start api", logger.Err(err))
+		os.Exit(1)
+	}
+
+	// start server, the node votes once its chain is caught up
+	newNode.SetVoter(false)
+	if err := newNode.StartServer(); err != nil {
+		l.Error("failed to start server", logger.Err(err))
+		os.Exit(1)
+	}
+	go func() {
+		if newNode.SyncChain(ctx) == nil {
+			builder.Run(ctx, time.Duration(cfg.Consensus.BlockInterval))
+		}
+	}()
+
+	l.Info("kvstore is started", logger.F("api", cfg.API.Addr))
+	<-ctx.Done()
+	stop() // a second signal kills the process
+
+	// stop the api, then the node
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Node.ShutdownTimeout))
+	code := 0
+	if err := api.Stop(shutdownCtx); err != nil {
+		l.Error("failed to stop api", logger.Err(err))
+		code = 1
+	}
+	if err := newNode.Shutdown(shutdownCtx); err != nil {
+		code = 1
+	}
+	cancel()
+	os.Exit(code)
+}
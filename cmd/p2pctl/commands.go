@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"simple-p2p/consensus"
+	"simple-p2p/node"
+	"simple-p2p/p2p/message"
+	"simple-p2p/proto/proto"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var ErrUsage = errors.New("invalid usage, see p2pctl -h")
+
+// cli runs the commands against the admin service of a node.
+type cli struct {
+	client proto.NodeAdminServiceClient
+	topic  string   // topic of the consensus instance
+	out    *printer // output of the results
+}
+
+// peer is a peer as printed by the peers commands.
+type peer struct {
+	Address string `json:"address"`
+	State   string `json:"state"`
+	Banned  bool   `json:"banned"`
+}
+
+// run runs the command of the arguments, e.g. "peers list".
+func (c *cli) run(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "peers":
+		return c.peers(ctx, args[1], args[2:])
+	case "consensus":
+		return c.consensus(ctx, args[1], args[2:])
+	case "chain":
+		return c.chain(ctx, args[1], args[2:])
+	case "messages":
+		return c.messages(ctx, args[1], args[2:])
+	case "node":
+		return c.node(ctx, args[1], args[2:])
+	}
+	return fmt.Errorf("unknown command %q: %w", args[0], ErrUsage)
+}
+
+// peers runs the peers commands.
+func (c *cli) peers(ctx context.Context, cmd string, args []string) error {
+	if cmd == "list" {
+		response, err := c.client.ListPeers(ctx, &proto.Empty{})
+		if err != nil {
+			return err
+		}
+
+		peers := make([]peer, 0, len(response.Peers))
+		for _, p := range response.Peers {
+			peers = append(peers, peer{Address: p.Address, State: p.State, Banned: p.Banned})
+		}
+		return c.out.print(peers, func(w io.Writer) {
+			fmt.Fprintln(w, "ADDRESS\tSTATE")
+			for _, p := range peers {
+				fmt.Fprintf(w, "%v\t%v\n", p.Address, p.State)
+			}
+		})
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("peers %v needs an address: %w", cmd, ErrUsage)
+	}
+	request := &proto.PeerRequest{Address: args[0]}
+
+	var err error
+	switch cmd {
+	case "add":
+		_, err = c.client.AddPeer(ctx, request)
+	case "remove":
+		_, err = c.client.RemovePeer(ctx, request)
+	case "ban":
+		_, err = c.client.BanPeer(ctx, request)
+	default:
+		return fmt.Errorf("unknown command peers %q: %w", cmd, ErrUsage)
+	}
+	if err != nil {
+		return err
+	}
+	return c.out.done(fmt.Sprintf("peers %v %v", cmd, args[0]))
+}
+
+// consensus runs the consensus commands.
+func (c *cli) consensus(ctx context.Context, cmd string, args []string) error {
+	request := &proto.ConsensusRequest{Topic: c.topic}
+
+	var st *proto.ConsensusStatus
+	var err error
+	switch cmd {
+	case "status":
+		st, err = c.client.GetConsensusStatus(ctx, request)
+	case "start":
+		st, err = c.client.StartConsensus(ctx, request)
+	case "stop":
+		st, err = c.client.StopConsensus(ctx, request)
+	case "propose":
+		st, err = c.propose(ctx, args)
+	default:
+		return fmt.Errorf("unknown command consensus %q: %w", cmd, ErrUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	status := consensus.Status{
+		Topic:      st.Topic,
+		Preference: int(st.Preference),
+		Confidence: int(st.Confidence),
+		Round:      int(st.Round),
+		Accepted:   st.Accepted,
+		Running:    st.Running,
+	}
+	return c.out.print(status, func(w io.Writer) {
+		fmt.Fprintf(w, "topic:\t%q\n", status.Topic)
+		fmt.Fprintf(w, "preference:\t%v\n", status.Preference)
+		fmt.Fprintf(w, "confidence:\t%v\n", status.Confidence)
+		fmt.Fprintf(w, "round:\t%v\n", status.Round)
+		fmt.Fprintf(w, "accepted:\t%v\n", status.Accepted)
+		fmt.Fprintf(w, "running:\t%v\n", status.Running)
+	})
+}
+
+// propose updates the preference to the value of the arguments, then starts
+// a Sync if they end with -start.
+func (c *cli) propose(ctx context.Context, args []string) (*proto.ConsensusStatus, error) {
+	start := len(args) == 2 && args[1] == "-start"
+	if len(args) != 1 && !start {
+		return nil, fmt.Errorf("consensus propose needs a value: %w", ErrUsage)
+	}
+
+	value, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q: %w", args[0], ErrUsage)
+	}
+
+	st, err := c.client.UpdatePreference(ctx, &proto.UpdatePreferenceRequest{Topic: c.topic, Preference: value})
+	if err != nil || !start {
+		return st, err
+	}
+	return c.client.StartConsensus(ctx, &proto.ConsensusRequest{Topic: c.topic})
+}
+
+// chain runs the chain commands.
+func (c *cli) chain(ctx context.Context, cmd string, args []string) error {
+	var b *proto.Block
+	var err error
+	switch cmd {
+	case "head":
+		b, err = c.client.GetChainHead(ctx, &proto.Empty{})
+	case "block":
+		if len(args) != 1 {
+			return fmt.Errorf("chain block needs a height: %w", ErrUsage)
+		}
+
+		height, perr := strconv.ParseUint(args[0], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid height %q: %w", args[0], ErrUsage)
+		}
+		b, err = c.client.GetBlock(ctx, &proto.BlockRequest{Height: height})
+	default:
+		return fmt.Errorf("unknown command chain %q: %w", cmd, ErrUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	block := node.BlockFromProto(b)
+	return c.out.print(block, func(w io.Writer) {
+		fmt.Fprintf(w, "height:\t%v\n", block.Height)
+		fmt.Fprintf(w, "value:\t%v\n", block.Value)
+		fmt.Fprintf(w, "txs:\t%v\n", len(block.Txs))
+		fmt.Fprintf(w, "prev hash:\t%v\n", block.PrevHash)
+		fmt.Fprintf(w, "hash:\t%v\n", block.Hash)
+		fmt.Fprintf(w, "app hash:\t%v\n", block.AppHash)
+	})
+}
+
+// messages runs the messages commands.
+func (c *cli) messages(ctx context.Context, cmd string, args []string) error {
+	if cmd != "tail" {
+		return fmt.Errorf("unknown command messages %q: %w", cmd, ErrUsage)
+	}
+
+	limit := int64(20)
+	if len(args) == 2 && args[0] == "-n" {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid limit %q: %w", args[1], ErrUsage)
+		}
+		limit = n
+	} else if len(args) != 0 {
+		return fmt.Errorf("messages tail only accepts -n: %w", ErrUsage)
+	}
+
+	response, err := c.client.TailMessages(ctx, &proto.TailMessagesRequest{Limit: limit})
+	if err != nil {
+		return err
+	}
+
+	logs := make([]message.MessageLog, 0, len(response.Logs))
+	for _, l := range response.Logs {
+		logs = append(logs, message.MessageLog{
+			Hash:        l.Hash,
+			MessageType: l.Type,
+			Sender:      l.Sender,
+			Receiver:    l.Receiver,
+			Time:        time.Unix(0, l.Time),
+		})
+	}
+	return c.out.print(logs, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tTYPE\tSENDER\tRECEIVER\tHASH")
+		for _, l := range logs {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", l.Time.Format(time.RFC3339Nano), l.MessageType, l.Sender, l.Receiver, l.Hash)
+		}
+	})
+}
+
+// node runs the node commands.
+func (c *cli) node(ctx context.Context, cmd string, args []string) error {
+	if cmd != "shutdown" || len(args) != 0 {
+		return fmt.Errorf("unknown command node %q: %w", cmd, ErrUsage)
+	}
+
+	if _, err := c.client.Shutdown(ctx, &proto.Empty{}); err != nil {
+		return err
+	}
+	return c.out.done("node shutdown")
+}
+
+// printer prints the results either as JSON or as human readable text.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// newPrinter creates a printer to w.
+func newPrinter(w io.Writer, json bool) *printer {
+	return &printer{w: w, json: json}
+}
+
+// print prints v as indented JSON, or with human in aligned columns.
+func (p *printer) print(v interface{}, human func(w io.Writer)) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	human(tw)
+	return tw.Flush()
+}
+
+// done prints the result of a command without output.
+func (p *printer) done(command string) error {
+	return p.print(map[string]bool{"ok": true}, func(w io.Writer) {
+		fmt.Fprintf(w, "%v: ok\n", command)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"os"
+	"simple-p2p/admin"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"time"
+)
+
+const usage = `p2pctl controls and inspects a node through its admin service.
+
+Usage:
+  p2pctl [flags] <command> [arguments]
+
+Commands:
+  peers list                     list the peers, banned peers included
+  peers add <address>            add a peer
+  peers remove <address>         remove a peer
+  peers ban <address>            remove a peer and never add it again
+  consensus status               show the status of a consensus instance
+  consensus start                start a Sync in background
+  consensus stop                 stop the running Sync
+  consensus propose <value>      update the preference, -start also starts a Sync
+  chain head                     show the last block
+  chain block <height>           show the block at a height
+  messages tail [-n limit]       show the last sent and received messages
+  node shutdown                  shut the node down gracefully
+
+Flags:
+`
+
+func main() {
+
+	// add flag
+	addr := flag.String("addr", envOr("P2P_ADMIN_ADDR", "127.0.0.1:9448"), "address of the admin service, defaults to $P2P_ADMIN_ADDR")
+	token := flag.String("token", os.Getenv("P2P_ADMIN_TOKEN"), "token of the admin service, defaults to $P2P_ADMIN_TOKEN")
+	topic := flag.String("topic", "", "topic of the consensus instance")
+	jsonOutput := flag.Bool("json", false, "print JSON instead of human readable output")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of the call")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// connect to the node
+	conn, err := transport.NewTCP().Dial(*addr, grpc.WithPerRPCCredentials(admin.TokenCredentials{Token: *token, Insecure: true}))
+	if err != nil {
+		fail(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c := &cli{
+		client: proto.NewNodeAdminServiceClient(conn),
+		topic:  *topic,
+		out:    newPrinter(os.Stdout, *jsonOutput),
+	}
+	if err := c.run(ctx, flag.Args()); err != nil {
+		fail(err)
+	}
+}
+
+// envOr returns an environment variable, or def if it is not set.
+func envOr(key string, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// fail prints an error and exits.
+func fail(err error) {
+	if s, ok := status.FromError(err); ok {
+		fmt.Fprintf(os.Stderr, "p2pctl: %v: %v\n", s.Code(), s.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "p2pctl: %v\n", err)
+	}
+	os.Exit(1)
+}
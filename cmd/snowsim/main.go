@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"simple-p2p/consensus"
+	"simple-p2p/consensus/byzantine"
+	"simple-p2p/consensus/sim"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+
+	// add flag
+	nodes := flag.Int("nodes", 1000, "number of simulated nodes")
+	Ks := flag.String("K", "10", "comma separated sample sizes to explore")
+	Alphas := flag.String("A", "7", "comma separated quorum sizes to explore")
+	Betas := flag.String("B", "15", "comma separated decision thresholds to explore")
+	MaxStep := flag.Int("max-step", 100, "is the maximum number of rounds of query")
+	timeout := flag.Duration("timeout", time.Second, "timeout of a single query")
+	byzantines := flag.Float64("byzantine", 0, "fraction of Byzantine nodes")
+	behavior := flag.String("behavior", "minority", "behavior of Byzantine nodes: minority, fixed, random or drop")
+	latency := flag.Duration("latency", 10*time.Millisecond, "minimum one-way network delay")
+	jitter := flag.Duration("jitter", 0, "random extra one-way network delay")
+	drop := flag.Float64("drop", 0, "probability that a message is lost")
+	trials := flag.Int("trials", 10, "number of simulations per parameter set")
+	seed := flag.Int64("seed", 1, "seed of the first simulation")
+	flag.Parse()
+
+	var b byzantine.Behavior
+	switch *behavior {
+	case "minority":
+		b = byzantine.MinorityVote{}
+	case "fixed":
+		b = byzantine.FixedVote(2)
+	case "random":
+		b = byzantine.RandomVote{1, 2}
+	case "drop":
+		b = byzantine.DropVote{}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown behavior: %v\n", *behavior)
+		os.Exit(2)
+	}
+
+	ks, err := parseInts(*Ks)
+	exitIf(err)
+	as, err := parseInts(*Alphas)
+	exitIf(err)
+	bs, err := parseInts(*Betas)
+	exitIf(err)
+
+	fmt.Printf("%4s %4s %4s  %12s %12s  %-56s %s\n", "K", "A", "B", "P(unsafe)", "P(undecided)", "time to decision (ms)", "rounds")
+	for _, k := range ks {
+		for _, a := range as {
+			if a > k {
+				continue
+			}
+			for _, beta := range bs {
+				summary := sim.Explore(sim.Config{
+					Nodes: *nodes,
+					Params: consensus.SnowParams{
+						K:            k,
+						A:            a,
+						B:            beta,
+						MaxStep:      *MaxStep,
+						QueryTimeout: *timeout,
+					},
+					Preferences: []int{1, 2},
+					Byzantine:   *byzantines,
+					Behavior:    b,
+					Latency:     sim.Uniform(*latency, *latency+*jitter),
+					DropRate:    *drop,
+					Seed:        *seed,
+				}, *trials)
+
+				fmt.Printf("%4d %4d %4d  %12.4f %12.4f  %-56s %s\n", k, a, beta,
+					summary.SafetyFailureProbability(), summary.LivenessFailureProbability(),
+					summary.TimeToDecision, summary.Rounds)
+			}
+		}
+	}
+}
+
+// parseInts parses a comma separated list of integers.
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q: %w", field, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func exitIf(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
@@ -1,47 +1,312 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"flag"
-	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"simple-p2p/admin"
+	"simple-p2p/config"
 	"simple-p2p/consensus"
+	"simple-p2p/light"
+	"simple-p2p/logger"
+	"simple-p2p/mempool"
 	"simple-p2p/node"
-	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/tracing"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// flagKeys are the config keys set by the flags.
+var flagKeys = map[string]string{
+	"host":                "node.host",
+	"shutdown-timeout":    "node.shutdown_timeout",
+	"port":                "node.port",
+	"neighbors":           "node.neighbors",
+	"max-peers":           "peers.max",
+	"discover-interval":   "peers.discover_interval",
+	"request-timeout":     "peers.request_timeout",
+	"K":                   "consensus.k",
+	"A":                   "consensus.a",
+	"B":                   "consensus.b",
+	"max-step":            "consensus.max_step",
+	"query-timeout":       "consensus.query_timeout",
+	"block-interval":      "consensus.block_interval",
+	"api":                 "api.addr",
+	"admin":               "admin.addr",
+	"admin-token":         "admin.token",
+	"log-level":           "log.level",
+	"log-format":          "log.format",
+	"trace":               "trace.exporter",
+	"data":                "storage.path",
+	"mempool-size":        "mempool.max_txs",
+	"mempool-max-age":     "mempool.max_age",
+	"validators":          "light.validators",
+	"light-unsigned":      "light.unsigned",
+	"finality-key":        "finality.key",
+	"finality-validators": "finality.validators",
+	"finality-threshold":  "finality.threshold",
+}
+
 func main() {
+	def := config.Default()
 
 	// add flag
-	neighbors := flag.String("neighbors", "", "bootstrap address to join the p2p network")
-	host := flag.String("host", "127.0.0.1", "host address")
-	port := flag.Int64("port", 9447, "port to listen")
-	K := flag.Int("K", 3, "sample K of each round of query. K < number_of_peers")
-	Alpha := flag.Int("A", 2, "is quorum size. A < K")
-	Beta := flag.Int("B", 10, "is decision threshold")
-	MaxStep := flag.Int("max-step", 100, "is the maximum number of rounds of query")
+	configPath := flag.String("config", "", "path of a YAML, TOML or JSON config file")
+	lightMode := flag.Bool("light", false, "run a light client following the finalized headers of the validators instead of a node")
+	flag.String("validators", "", "comma separated validators of the light client, address=weight=pubkey, defaults to the finality validators or the neighbors")
+	flag.Bool("light-unsigned", false, "make a header served by a quorum of the validators final without a certificate, the validators need no public key")
+	flag.String("neighbors", "", "comma separated bootstrap addresses to join the p2p network")
+	flag.String("host", def.Node.Host, "host address")
+	flag.Int("port", def.Node.Port, "port to listen")
+	flag.Duration("shutdown-timeout", time.Duration(def.Node.ShutdownTimeout), "time to drain in-flight rpcs on shutdown")
+	flag.Int("max-peers", def.Peers.Max, "number of peers beyond which discovery stops adding peers")
+	flag.Duration("discover-interval", time.Duration(def.Peers.DiscoverInterval), "sleep time between peer discovery rounds")
+	flag.Duration("request-timeout", time.Duration(def.Peers.RequestTimeout), "timeout of a peer discovery request")
+	flag.Int("K", def.Consensus.K, "sample K of each round of query. K <= max-peers")
+	flag.Int("A", def.Consensus.A, "is quorum size. A <= K")
+	flag.Int("B", def.Consensus.B, "is decision threshold")
+	flag.Int("max-step", def.Consensus.MaxStep, "is the maximum number of rounds of query")
+	flag.Duration("query-timeout", time.Duration(def.Consensus.QueryTimeout), "timeout of a round of query")
+	flag.Duration("block-interval", time.Duration(def.Consensus.BlockInterval), "sleep time between blocks of pending transactions")
+	flag.String("api", "", "address of the HTTP API, disabled if empty")
+	flag.String("admin", "", "address of the gRPC admin service, disabled if empty")
+	flag.String("admin-token", "", "token of the gRPC admin service, defaults to $P2P_ADMIN_TOKEN")
+	flag.String("log-level", def.Log.Level, "minimum level of the logs: debug, info, warn or error")
+	flag.String("log-format", def.Log.Format, "format of the logs: text or json")
+	flag.String("data", "", "file of the node state, kept in memory only if empty")
+	flag.Int("mempool-size", def.Mempool.MaxTxs, "number of pending transactions beyond which the lowest fees are evicted")
+	flag.Duration("mempool-max-age", time.Duration(def.Mempool.MaxAge), "time after which a pending transaction expires")
+	flag.String("finality-key", "", "file of the key signing the finality votes of the node, created if missing, the node does not vote if empty")
+	flag.String("finality-validators", "", "comma separated validators signing finality votes, address=weight=pubkey, no certificate if empty")
+	flag.String("finality-threshold", def.Finality.Threshold, "fraction num/den of the total weight of the votes of a finality certificate, above 1/2")
+	flag.String("trace", "", "exporter of the traces: stdout, a file path or an OTLP/HTTP endpoint like http://localhost:4318, disabled if empty")
 	flag.Parse()
 
-	// start node
-	newNode := node.NewNode(fmt.Sprintf("%v:%d", *host, *port))
+	// load config, flags take precedence over the environment and the file
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			if err := cfg.Set(key, f.Value.String()); err != nil {
+				log.Fatal(err)
+			}
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
-	// start peer discovery
-	if *neighbors != "" {
-		newNode.PeerManager.StartDiscoverPeers(*neighbors)
+	// create logger
+	l := cfg.Logger(os.Stderr)
+	if *lightMode {
+		os.Exit(runLight(cfg, l))
 	}
+	opts := []node.Option{node.WithLogger(l), node.WithPeerOptions(cfg.PeerOptions()...)}
 
-	// start consensus
-	snow := consensus.NewConsensus(consensus.SnowParams{
-		K:       *K,
-		A:       *Alpha,
-		B:       *Beta,
-		MaxStep: *MaxStep,
-	})
-	snow.AddNode(newNode)
-	proto.RegisterConsensusServiceServer(newNode.Server, snow)
+	// create tracer
+	var exporter tracing.Exporter
+	if cfg.Trace.Exporter != "" {
+		exporter, err = newExporter(cfg.Trace.Exporter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, node.WithTracer(tracing.NewTracer("simple-p2p", exporter)))
+	}
+
+	// open storage
+	var store storage.Store
+	if cfg.Storage.Path != "" {
+		store, err = storage.Open(cfg.Storage.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, node.WithStorage(store))
+	}
+
+	// create consensus
+	snow := consensus.NewConsensus(cfg.SnowParams())
+	opts = append(opts, node.WithConsensus(snow))
+
+	// create node
+	newNode := node.NewNode(cfg.Address(), opts...)
+	l = newNode.Logger()
+	if store != nil {
+		newNode.OnClose(store.Close)
+	}
+	if exporter != nil {
+		newNode.OnClose(exporter.Close)
+	}
+
+	// shut down on SIGINT, SIGTERM or an admin request
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, requestShutdown := context.WithCancel(ctx)
+
+	// start peer discovery, from the stored peers on a restart
+	if len(cfg.Node.Neighbors) > 0 || newNode.PeerManager.GetPeersNum() > 0 {
+		newNode.PeerManager.StartDiscoverPeers(cfg.Node.Neighbors...)
+	}
+
+	// create mempool, decisions are blocks of its transactions
+	pool := mempool.New(newNode, cfg.MempoolOptions()...)
+	builder := consensus.NewBlockBuilder(newNode, snow, pool)
+
+	// create certifier, the votes of the validators certify the blocks, and
+	// consensus samples the validators by weight
+	if len(cfg.Finality.Validators) > 0 {
+		validators, err := cfg.FinalityValidators()
+		if err != nil {
+			l.Error("failed to create validator set", logger.Err(err))
+			os.Exit(1)
+		}
+		snow.SetValidators(validators)
+		var key ed25519.PrivateKey
+		if cfg.Finality.Key != "" {
+			if key, err = cfg.FinalityKey(); err != nil {
+				l.Error("failed to load finality key", logger.Err(err))
+				os.Exit(1)
+			}
+			l.Info("finality key loaded", logger.F("pubkey", hex.EncodeToString(key.Public().(ed25519.PublicKey))))
+		}
+		threshold, err := cfg.FinalityThreshold()
+		if err != nil {
+			l.Error("invalid finality threshold", logger.Err(err))
+			os.Exit(1)
+		}
+		consensus.NewCertifier(newNode, validators, key, threshold)
+	}
+
+	// start http api
+	var api *node.API
+	if cfg.API.Addr != "" {
+		api = node.NewAPI(newNode)
+		consensus.RegisterAPI(api, snow)
+		mempool.RegisterAPI(api, pool)
+		if err := api.Start(cfg.API.Addr); err != nil {
+			l.Error("failed to start api", logger.Err(err))
+			os.Exit(1)
+		}
+	}
+
+	// start admin service
+	var adminServer *admin.Server
+	if cfg.Admin.Addr != "" {
+		adminServer, err = admin.NewServer(newNode, cfg.Admin.Token, requestShutdown, []consensus.Consensus{snow})
+		if err != nil {
+			l.Error("failed to create admin service", logger.Err(err))
+			os.Exit(1)
+		}
+		if err := adminServer.Start(cfg.Admin.Addr); err != nil {
+			l.Error("failed to start admin service", logger.Err(err))
+			os.Exit(1)
+		}
+	}
+
+	//  start server, the node votes once its chain is caught up
+	newNode.SetVoter(false)
+	if err := newNode.StartServer(); err != nil {
+		l.Error("failed to start server", logger.Err(err))
+		os.Exit(1)
+	}
+	go func() {
+		if newNode.SyncChain(ctx) == nil {
+			builder.Run(ctx, time.Duration(cfg.Consensus.BlockInterval))
+		}
+	}()
+
+	l.Info("node is started")
+	<-ctx.Done()
+	stop() // a second signal kills the process
+
+	os.Exit(shutdown(newNode, api, adminServer, time.Duration(cfg.Node.ShutdownTimeout)))
+}
+
+// runLight runs a light client until SIGINT or SIGTERM, and returns the exit
+// code.
+func runLight(cfg config.Config, l logger.Logger) int {
+	validators, err := cfg.Validators()
+	if err != nil {
+		l.Error("failed to create validator set", logger.Err(err))
+		return 1
+	}
+	threshold, err := cfg.FinalityThreshold()
+	if err != nil {
+		l.Error("invalid finality threshold", logger.Err(err))
+		return 1
+	}
+	opts := []light.Option{light.WithLogger(l), light.WithQuorum(threshold.Num, threshold.Den)}
+	if cfg.Light.Unsigned {
+		l.Warn("headers are final once a quorum of the validators serves them, without a certificate: colluding validators can forge them")
+		opts = append(opts, light.WithUnsignedQuorum())
+	}
+	client := light.New(validators, opts...)
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	//  start server
-	newNode.StartServer()
+	var api *light.API
+	if cfg.API.Addr != "" {
+		api = light.NewAPI(client)
+		if err := api.Start(cfg.API.Addr); err != nil {
+			l.Error("failed to start api", logger.Err(err))
+			return 1
+		}
+	}
+
+	l.Info("light client is started", logger.F("validators", len(validators.Validators())))
+	client.Follow(ctx, time.Duration(cfg.Consensus.BlockInterval))
+	stop() // a second signal kills the process
+
+	if api != nil {
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Node.ShutdownTimeout))
+		defer cancel()
+		if err := api.Stop(sctx); err != nil {
+			l.Error("failed to stop api", logger.Err(err))
+			return 1
+		}
+	}
+	return 0
+}
 
-	newNode.Waiter.Wait()
+// shutdown stops the api, the admin service and the node within the timeout,
+// and returns the exit code: 0 once everything is stopped and flushed, 1
+// otherwise.
+func shutdown(n *node.Node, api *node.API, adminServer *admin.Server, timeout time.Duration) int {
+	l := n.Logger()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	fmt.Printf("Node %v is started \n", newNode.Address)
+	code := 0
+	if adminServer != nil {
+		adminServer.Stop()
+	}
+	if api != nil {
+		if err := api.Stop(ctx); err != nil {
+			l.Error("failed to stop api", logger.Err(err))
+			code = 1
+		}
+	}
+	if err := n.Shutdown(ctx); err != nil {
+		code = 1
+	}
+	return code
+}
+
+// newExporter returns the trace exporter of the -trace flag.
+func newExporter(trace string) (tracing.Exporter, error) {
+	switch {
+	case trace == "stdout":
+		return tracing.NewWriterExporter(os.Stdout), nil
+	case strings.HasPrefix(trace, "http://"), strings.HasPrefix(trace, "https://"):
+		return tracing.NewOTLPExporter(trace), nil
+	}
+	return tracing.NewFileExporter(trace)
 }
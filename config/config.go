@@ -0,0 +1,533 @@
+// Package config loads and validates the configuration of a node. Settings
+// come, from lowest to highest precedence, from the defaults, a YAML, TOML or
+// JSON file, P2P_* environment variables and command line flags.
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"simple-p2p/consensus"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/p2p"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown config file format")
+	ErrInvalid       = errors.New("invalid config")
+)
+
+// envPrefix is the prefix of the environment variables, P2P_<SECTION>_<KEY>.
+const envPrefix = "P2P"
+
+// Config is the configuration of a node.
+type Config struct {
+	Node      NodeConfig      `json:"node" yaml:"node" toml:"node"`
+	Peers     PeersConfig     `json:"peers" yaml:"peers" toml:"peers"`
+	Consensus ConsensusConfig `json:"consensus" yaml:"consensus" toml:"consensus"`
+	API       APIConfig       `json:"api" yaml:"api" toml:"api"`
+	Admin     AdminConfig     `json:"admin" yaml:"admin" toml:"admin"`
+	Log       LogConfig       `json:"log" yaml:"log" toml:"log"`
+	Trace     TraceConfig     `json:"trace" yaml:"trace" toml:"trace"`
+	Storage   StorageConfig   `json:"storage" yaml:"storage" toml:"storage"`
+	Mempool   MempoolConfig   `json:"mempool" yaml:"mempool" toml:"mempool"`
+	Snapshot  SnapshotConfig  `json:"snapshot" yaml:"snapshot" toml:"snapshot"`
+	Light     LightConfig     `json:"light" yaml:"light" toml:"light"`
+	Finality  FinalityConfig  `json:"finality" yaml:"finality" toml:"finality"`
+}
+
+// NodeConfig is the network identity and lifecycle of the node.
+type NodeConfig struct {
+	Host            string   `json:"host" yaml:"host" toml:"host"`
+	Port            int      `json:"port" yaml:"port" toml:"port"`
+	Neighbors       []string `json:"neighbors" yaml:"neighbors" toml:"neighbors"`                      // bootstrap addresses
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"` // time to drain in-flight rpcs on shutdown
+}
+
+// PeersConfig configures peer discovery.
+type PeersConfig struct {
+	Max              int      `json:"max" yaml:"max" toml:"max"`                                           // discovery stops adding peers beyond it
+	DiscoverInterval Duration `json:"discover_interval" yaml:"discover_interval" toml:"discover_interval"` // sleep time between discovery rounds
+	RequestTimeout   Duration `json:"request_timeout" yaml:"request_timeout" toml:"request_timeout"`       // timeout of a discovery request
+}
+
+// ConsensusConfig configures the Snowball parameters.
+type ConsensusConfig struct {
+	K             int      `json:"k" yaml:"k" toml:"k"`                                        // sample size
+	A             int      `json:"a" yaml:"a" toml:"a"`                                        // quorum size
+	B             int      `json:"b" yaml:"b" toml:"b"`                                        // decision threshold
+	MaxStep       int      `json:"max_step" yaml:"max_step" toml:"max_step"`                   // maximum number of rounds
+	QueryTimeout  Duration `json:"query_timeout" yaml:"query_timeout" toml:"query_timeout"`    // timeout of a query round
+	BlockInterval Duration `json:"block_interval" yaml:"block_interval" toml:"block_interval"` // sleep time between blocks of pending transactions
+}
+
+// APIConfig configures the HTTP API.
+type APIConfig struct {
+	Addr string `json:"addr" yaml:"addr" toml:"addr"` // disabled if empty
+}
+
+// AdminConfig configures the gRPC admin service.
+type AdminConfig struct {
+	Addr  string `json:"addr" yaml:"addr" toml:"addr"` // disabled if empty
+	Token string `json:"token" yaml:"token" toml:"token"`
+}
+
+// LogConfig configures the logs.
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level" toml:"level"`    // debug, info, warn or error
+	Format string `json:"format" yaml:"format" toml:"format"` // text or json
+}
+
+// TraceConfig configures the traces.
+type TraceConfig struct {
+	Exporter string `json:"exporter" yaml:"exporter" toml:"exporter"` // stdout, a file path or an OTLP/HTTP endpoint, disabled if empty
+}
+
+// StorageConfig configures the storage of the node state.
+type StorageConfig struct {
+	Path string `json:"path" yaml:"path" toml:"path"` // file of the store, the state is kept in memory only if empty
+}
+
+// MempoolConfig configures the pool of pending transactions.
+type MempoolConfig struct {
+	MaxTxs     int      `json:"max_txs" yaml:"max_txs" toml:"max_txs"`                // number of transactions beyond which the pool is full
+	MaxBytes   int      `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes"`          // total size beyond which the pool is full
+	MaxTxBytes int      `json:"max_tx_bytes" yaml:"max_tx_bytes" toml:"max_tx_bytes"` // size of the largest transaction
+	MaxAge     Duration `json:"max_age" yaml:"max_age" toml:"max_age"`                // time after which a pending transaction expires
+}
+
+// SnapshotConfig configures the snapshots of the application state.
+type SnapshotConfig struct {
+	Interval int `json:"interval" yaml:"interval" toml:"interval"` // blocks between two snapshots, disabled if 0
+	Keep     int `json:"keep" yaml:"keep" toml:"keep"`             // number of snapshots kept, all if 0
+}
+
+// LightConfig configures the light client mode.
+type LightConfig struct {
+	Validators []string `json:"validators" yaml:"validators" toml:"validators"` // "address", "address=weight" or "address=weight=pubkey" of the validators followed, finality.validators or the neighbors if empty, with a pubkey unless unsigned
+	Unsigned   bool     `json:"unsigned" yaml:"unsigned" toml:"unsigned"`       // a header served by a quorum of the validators is final without a certificate, the validators need no public key
+}
+
+// FinalityConfig configures the finality certificates.
+type FinalityConfig struct {
+	Key        string   `json:"key" yaml:"key" toml:"key"`                      // file of the key of the votes of the node, created if missing, the node does not vote if empty
+	Validators []string `json:"validators" yaml:"validators" toml:"validators"` // "address=weight=pubkey" of the signing validators, no certificate if empty
+	Threshold  string   `json:"threshold" yaml:"threshold" toml:"threshold"`    // fraction num/den of the total weight the votes of a certificate hold, above 1/2
+}
+
+// Duration is a time.Duration written as a string like "5s" in config files.
+type Duration time.Duration
+
+// UnmarshalText parses a duration like "500ms".
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		Node: NodeConfig{
+			Host:            "127.0.0.1",
+			Port:            9447,
+			ShutdownTimeout: Duration(10 * time.Second),
+		},
+		Peers: PeersConfig{
+			Max:              20,
+			DiscoverInterval: Duration(5 * time.Second),
+			RequestTimeout:   Duration(5 * time.Second),
+		},
+		Consensus: ConsensusConfig{
+			K:             3,
+			A:             2,
+			B:             10,
+			MaxStep:       100,
+			QueryTimeout:  Duration(5 * time.Second),
+			BlockInterval: Duration(time.Second),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Mempool: MempoolConfig{
+			MaxTxs:     5000,
+			MaxBytes:   64 << 20,
+			MaxTxBytes: 1 << 20,
+			MaxAge:     Duration(10 * time.Minute),
+		},
+		Snapshot: SnapshotConfig{
+			Interval: 1000,
+			Keep:     2,
+		},
+		Finality: FinalityConfig{
+			Threshold: finality.DefaultThreshold.String(),
+		},
+	}
+}
+
+// Load returns the default configuration overridden by a file, if path is not
+// empty, then by the environment.
+func Load(path string) (Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return c, err
+		}
+	}
+
+	if err := c.LoadEnv(os.LookupEnv); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// LoadFile overrides the configuration with the settings of a file. The format
+// follows the extension: .yaml, .yml, .toml or .json.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	case ".json":
+		err = json.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
+
+// LoadEnv overrides the configuration with the environment variables
+// P2P_<SECTION>_<KEY>, e.g. P2P_CONSENSUS_K or P2P_ADMIN_TOKEN. Lists are
+// comma separated.
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	return c.fields(func(section string, key string, field reflect.Value) error {
+		name := strings.ToUpper(strings.Join([]string{envPrefix, section, key}, "_"))
+		value, ok := lookup(name)
+		if !ok {
+			return nil
+		}
+
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// Set sets the setting of a key like "consensus.k" from a string, as given by
+// a flag. Lists are comma separated.
+func (c *Config) Set(key string, value string) error {
+	found := false
+	err := c.fields(func(section string, name string, field reflect.Value) error {
+		if section+"."+name != key {
+			return nil
+		}
+
+		found = true
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
+		return nil
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("unknown config key: %v", key)
+	}
+	return err
+}
+
+// fields calls fn with the section, key and value of every setting.
+func (c *Config) fields(fn func(section string, key string, field reflect.Value) error) error {
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := tagName(sections.Type().Field(i))
+
+		for j := 0; j < section.NumField(); j++ {
+			if err := fn(sectionName, tagName(section.Type().Field(j)), section.Field(j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tagName returns the key of a field in config files.
+func tagName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("yaml"), ",")[0]
+}
+
+// setValue sets a field from the string of an environment variable.
+func setValue(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(v))
+	case bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case Duration:
+		var d Duration
+		if err := d.UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+// Validate checks the configuration and returns every problem found.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Node.Host != "", "node.host must not be empty")
+	check(c.Node.Port > 0 && c.Node.Port < 65536, "node.port %d must be between 1 and 65535", c.Node.Port)
+	check(c.Node.ShutdownTimeout > 0, "node.shutdown_timeout must be positive")
+
+	check(c.Peers.Max >= 1, "peers.max %d must be at least 1", c.Peers.Max)
+	check(c.Peers.DiscoverInterval > 0, "peers.discover_interval must be positive")
+	check(c.Peers.RequestTimeout > 0, "peers.request_timeout must be positive")
+
+	check(c.Consensus.K >= 1, "consensus.k %d must be at least 1", c.Consensus.K)
+	check(c.Consensus.A >= 1 && c.Consensus.A <= c.Consensus.K, "consensus.a %d must be between 1 and k %d", c.Consensus.A, c.Consensus.K)
+	check(c.Consensus.B >= 1, "consensus.b %d must be at least 1", c.Consensus.B)
+	check(c.Consensus.K <= c.Peers.Max, "consensus.k %d must not exceed the expected number of peers, peers.max %d", c.Consensus.K, c.Peers.Max)
+	check(c.Consensus.MaxStep >= 1, "consensus.max_step %d must be at least 1", c.Consensus.MaxStep)
+	check(c.Consensus.QueryTimeout > 0, "consensus.query_timeout must be positive")
+	check(c.Consensus.BlockInterval > 0, "consensus.block_interval must be positive")
+
+	check(c.Mempool.MaxTxs >= 1, "mempool.max_txs %d must be at least 1", c.Mempool.MaxTxs)
+	check(c.Mempool.MaxTxBytes >= 1 && c.Mempool.MaxTxBytes <= c.Mempool.MaxBytes, "mempool.max_tx_bytes %d must be between 1 and max_bytes %d", c.Mempool.MaxTxBytes, c.Mempool.MaxBytes)
+	check(c.Mempool.MaxAge > 0, "mempool.max_age must be positive")
+
+	check(c.Snapshot.Interval >= 0, "snapshot.interval %d must not be negative", c.Snapshot.Interval)
+	check(c.Snapshot.Keep >= 0, "snapshot.keep %d must not be negative", c.Snapshot.Keep)
+
+	_, err := parseValidators(c.Light.Validators)
+	check(err == nil, "light.validators: %v", err)
+	threshold, err := finality.ParseThreshold(c.Finality.Threshold)
+	check(err == nil, "finality.threshold: %v", err)
+	check(err != nil || threshold.Majority(), "finality.threshold %v must be above 1/2 and at most 1", threshold)
+	_, err = c.FinalityValidators()
+	check(err == nil, "finality.validators: %v", err)
+
+	check(c.Admin.Addr == "" || c.Admin.Token != "", "admin.token must be set when admin.addr is set")
+
+	_, err = logger.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: %v", err)
+	_, err = logger.ParseFormat(c.Log.Format)
+	check(err == nil, "log.format: %v", err)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %v", ErrInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Address returns the network address of the node.
+func (c Config) Address() string {
+	return fmt.Sprintf("%v:%d", c.Node.Host, c.Node.Port)
+}
+
+// PeerOptions returns the options of the peer manager.
+func (c Config) PeerOptions() []p2p.Option {
+	return []p2p.Option{
+		p2p.WithMaxPeers(c.Peers.Max),
+		p2p.WithDiscoverInterval(time.Duration(c.Peers.DiscoverInterval)),
+		p2p.WithRequestTimeout(time.Duration(c.Peers.RequestTimeout)),
+	}
+}
+
+// SnowParams returns the parameters of the consensus.
+func (c Config) SnowParams() consensus.SnowParams {
+	return consensus.SnowParams{
+		K:            c.Consensus.K,
+		A:            c.Consensus.A,
+		B:            c.Consensus.B,
+		MaxStep:      c.Consensus.MaxStep,
+		QueryTimeout: time.Duration(c.Consensus.QueryTimeout),
+	}
+}
+
+// MempoolOptions returns the options of the mempool.
+func (c Config) MempoolOptions() []mempool.Option {
+	return []mempool.Option{
+		mempool.WithMaxTxs(c.Mempool.MaxTxs),
+		mempool.WithMaxBytes(c.Mempool.MaxBytes),
+		mempool.WithMaxTxBytes(c.Mempool.MaxTxBytes),
+		mempool.WithMaxAge(time.Duration(c.Mempool.MaxAge)),
+	}
+}
+
+// SnapshotOption returns the option of the snapshots of the node.
+func (c Config) SnapshotOption() node.Option {
+	return node.WithSnapshots(uint64(c.Snapshot.Interval), c.Snapshot.Keep)
+}
+
+// Validators returns the validator set followed in light client mode: the
+// validators of light.validators, else those of finality.validators, else the
+// neighbors with a weight of 1 each. Every validator must have a public key to
+// check the certificates, unless light.unsigned is set.
+func (c Config) Validators() (*consensus.ValidatorSet, error) {
+	entries := c.Node.Neighbors
+	switch {
+	case len(c.Light.Validators) > 0:
+		entries = c.Light.Validators
+	case len(c.Finality.Validators) > 0:
+		entries = c.Finality.Validators
+	}
+
+	set, err := parseValidators(entries)
+	if err != nil || c.Light.Unsigned {
+		return set, err
+	}
+	for _, v := range set.Validators() {
+		if v.PubKey == nil {
+			return nil, fmt.Errorf("%v has no public key to check the certificates, set light.unsigned to trust the validators serving a header", v.ID)
+		}
+	}
+	return set, nil
+}
+
+// FinalityValidators returns the validators signing the finality votes, each
+// with its public key.
+func (c Config) FinalityValidators() (*consensus.ValidatorSet, error) {
+	set, err := parseValidators(c.Finality.Validators)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range set.Validators() {
+		if v.PubKey == nil {
+			return nil, fmt.Errorf("%v has no public key", v.ID)
+		}
+	}
+	return set, nil
+}
+
+// FinalityThreshold returns the threshold of the finality certificates, above
+// half of the total weight.
+func (c Config) FinalityThreshold() (finality.Threshold, error) {
+	t, err := finality.ParseThreshold(c.Finality.Threshold)
+	if err != nil {
+		return finality.Threshold{}, err
+	}
+	if !t.Majority() {
+		return finality.Threshold{}, fmt.Errorf("threshold %v is not above 1/2 and at most 1", t)
+	}
+	return t, nil
+}
+
+// FinalityKey returns the key of the votes of the node, read from the hex
+// seed in finality.key. A new key is written to the file if it does not
+// exist.
+func (c Config) FinalityKey() (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(c.Finality.Key)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		return key, os.WriteFile(c.Finality.Key, []byte(hex.EncodeToString(key.Seed())+"\n"), 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%v: not a hex ed25519 seed", c.Finality.Key)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// parseValidators returns the validator set of entries "address",
+// "address=weight" or "address=weight=pubkey", of weight 1 by default and a
+// hex ed25519 public key.
+func parseValidators(entries []string) (*consensus.ValidatorSet, error) {
+	var validators []consensus.Validator
+	for _, entry := range entries {
+		fields := strings.Split(entry, "=")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("validator %q: too many fields", entry)
+		}
+
+		v := consensus.Validator{ID: fields[0], Address: fields[0], Weight: 1}
+		if len(fields) > 1 {
+			w, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("weight of %q: %w", entry, err)
+			}
+			v.Weight = w
+		}
+		if len(fields) > 2 {
+			pub, err := hex.DecodeString(fields[2])
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("public key of %q: not a hex ed25519 key", entry)
+			}
+			v.PubKey = pub
+		}
+		validators = append(validators, v)
+	}
+	return consensus.NewValidatorSet(validators...)
+}
+
+// Logger returns the logger of the log settings, the configuration must be valid.
+func (c Config) Logger(w io.Writer) logger.Logger {
+	level, _ := logger.ParseLevel(c.Log.Level)
+	format, _ := logger.ParseFormat(c.Log.Format)
+	return logger.New(w, level, format)
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"simple-p2p/consensus"
+	"simple-p2p/finality"
+	"testing"
+	"time"
+)
+
+var files = map[string]string{
+	"node.yaml": `
+node:
+  port: 5000
+  neighbors: [localhost:5001, localhost:5002]
+peers:
+  discover_interval: 1s
+consensus:
+  k: 10
+  a: 7
+  b: 15
+  query_timeout: 500ms
+`,
+	"node.toml": `
+[node]
+port = 5000
+neighbors = ["localhost:5001", "localhost:5002"]
+
+[peers]
+discover_interval = "1s"
+
+[consensus]
+k = 10
+a = 7
+b = 15
+query_timeout = "500ms"
+`,
+	"node.json": `{
+  "node": {"port": 5000, "neighbors": ["localhost:5001", "localhost:5002"]},
+  "peers": {"discover_interval": "1s"},
+  "consensus": {"k": 10, "a": 7, "b": 15, "query_timeout": "500ms"}
+}`,
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+		c := Default()
+		assert.NoError(t, c.LoadFile(path), name)
+		assert.NoError(t, c.Validate(), name)
+
+		// settings of the file override the defaults, the others are kept
+		assert.Equal(t, "127.0.0.1:5000", c.Address(), name)
+		assert.Equal(t, []string{"localhost:5001", "localhost:5002"}, c.Node.Neighbors, name)
+		assert.Equal(t, Duration(time.Second), c.Peers.DiscoverInterval, name)
+		assert.Equal(t, 20, c.Peers.Max, name)
+		assert.Equal(t, consensus.SnowParams{K: 10, A: 7, B: 15, MaxStep: 100, QueryTimeout: 500 * time.Millisecond}, c.SnowParams(), name)
+	}
+
+	path := filepath.Join(dir, "node.ini")
+	assert.NoError(t, os.WriteFile(path, nil, 0o644))
+	c := Default()
+	assert.ErrorIs(t, c.LoadFile(path), ErrUnknownFormat)
+}
+
+func TestLoadEnv(t *testing.T) {
+	env := map[string]string{
+		"P2P_NODE_PORT":             "6000",
+		"P2P_NODE_NEIGHBORS":        "localhost:6001, localhost:6002",
+		"P2P_CONSENSUS_K":           "5",
+		"P2P_PEERS_REQUEST_TIMEOUT": "2s",
+		"P2P_ADMIN_TOKEN":           "secret",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	c := Default()
+	assert.NoError(t, c.LoadEnv(lookup))
+	assert.Equal(t, 6000, c.Node.Port)
+	assert.Equal(t, []string{"localhost:6001", "localhost:6002"}, c.Node.Neighbors)
+	assert.Equal(t, 5, c.Consensus.K)
+	assert.Equal(t, Duration(2*time.Second), c.Peers.RequestTimeout)
+	assert.Equal(t, "secret", c.Admin.Token)
+
+	env["P2P_CONSENSUS_B"] = "many"
+	assert.Error(t, c.LoadEnv(lookup))
+}
+
+func TestSet(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Set("consensus.max_step", "50"))
+	assert.NoError(t, c.Set("peers.discover_interval", "10s"))
+	assert.NoError(t, c.Set("node.neighbors", "localhost:5001,localhost:5002"))
+	assert.Equal(t, 50, c.Consensus.MaxStep)
+	assert.Equal(t, Duration(10*time.Second), c.Peers.DiscoverInterval)
+	assert.Len(t, c.Node.Neighbors, 2)
+
+	assert.Error(t, c.Set("consensus.k", "ten"))
+	assert.Error(t, c.Set("consensus.gamma", "1"))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(files["node.yaml"]), 0o644))
+
+	// the environment overrides the file
+	t.Setenv("P2P_CONSENSUS_K", "12")
+
+	c, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, c.Consensus.K)
+	assert.Equal(t, 7, c.Consensus.A)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+
+	c := Default()
+	c.Consensus.A = 4
+	c.Consensus.B = 0
+	c.Peers.Max = 2
+	c.Admin.Addr = "127.0.0.1:9000"
+	c.Log.Level = "verbose"
+	c.Mempool.MaxTxBytes = 2 * c.Mempool.MaxBytes
+	c.Snapshot.Keep = -1
+	c.Light.Validators = []string{"127.0.0.1:9001=0"}
+	c.Finality.Threshold = "1/100"
+	c.Finality.Validators = []string{"127.0.0.1:9001=1"}
+
+	err := c.Validate()
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Contains(t, err.Error(), "consensus.a 4 must be between 1 and k 3")
+	assert.Contains(t, err.Error(), "consensus.b 0 must be at least 1")
+	assert.Contains(t, err.Error(), "consensus.k 3 must not exceed the expected number of peers, peers.max 2")
+	assert.Contains(t, err.Error(), "admin.token must be set")
+	assert.Contains(t, err.Error(), "log.level")
+	assert.Contains(t, err.Error(), "mempool.max_tx_bytes")
+	assert.Contains(t, err.Error(), "snapshot.keep -1 must not be negative")
+	assert.Contains(t, err.Error(), "light.validators")
+	assert.Contains(t, err.Error(), "finality.threshold 1/100 must be above 1/2 and at most 1")
+
+	c = Default()
+	c.Finality.Threshold = "67"
+	assert.ErrorContains(t, c.Validate(), "finality.threshold: threshold \"67\" is not a fraction num/den")
+	assert.Contains(t, err.Error(), "finality.validators: 127.0.0.1:9001 has no public key")
+}
+
+func TestValidators(t *testing.T) {
+	// the neighbors by default, without public keys the certificates cannot
+	// be checked
+	c := Default()
+	assert.NoError(t, c.Set("node.neighbors", "127.0.0.1:9001,127.0.0.1:9002"))
+	_, err := c.Validators()
+	assert.ErrorContains(t, err, "127.0.0.1:9001 has no public key")
+	assert.NoError(t, c.Set("light.unsigned", "true"))
+	set, err := c.Validators()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), set.TotalWeight())
+
+	assert.NoError(t, c.Set("light.validators", "127.0.0.1:9001=3,127.0.0.1:9003"))
+	set, err = c.Validators()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), set.TotalWeight())
+	v, ok := set.Get("127.0.0.1:9001")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), v.Weight)
+
+	assert.NoError(t, c.Set("light.validators", "127.0.0.1:9001=heavy"))
+	_, err = c.Validators()
+	assert.Error(t, err)
+}
+
+func TestFinality(t *testing.T) {
+	c := Default()
+	c.Finality.Key = filepath.Join(t.TempDir(), "node.key")
+
+	// the key is created once, then read
+	key, err := c.FinalityKey()
+	assert.NoError(t, err)
+	again, err := c.FinalityKey()
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	pub := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	assert.NoError(t, c.Set("finality.validators", "127.0.0.1:9001=2="+pub+",127.0.0.1:9002=1="+pub))
+	set, err := c.FinalityValidators()
+	assert.NoError(t, err)
+	v, _ := set.Get("127.0.0.1:9001")
+	assert.Equal(t, key.Public(), v.PubKey)
+	assert.Equal(t, uint64(2), v.Weight)
+	threshold, err := c.FinalityThreshold()
+	assert.NoError(t, err)
+	assert.Equal(t, finality.DefaultThreshold, threshold)
+	assert.NoError(t, c.Set("finality.threshold", "1/2"))
+	_, err = c.FinalityThreshold()
+	assert.Error(t, err)
+
+	// the light client follows the signing validators by default
+	set, err = c.Validators()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), set.TotalWeight())
+
+	assert.NoError(t, c.Set("finality.validators", "127.0.0.1:9001=2=beef"))
+	_, err = c.FinalityValidators()
+	assert.Error(t, err)
+}
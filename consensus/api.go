@@ -0,0 +1,114 @@
+package consensus
+
+import (
+	"fmt"
+	"net/http"
+	"simple-p2p/node"
+)
+
+// PreferenceRequest is the body of a preference update.
+type PreferenceRequest struct {
+	Topic      string `json:"topic"`
+	Preference int    `json:"preference"`
+}
+
+// SyncRequest is the body of a Sync trigger.
+type SyncRequest struct {
+	Topic string `json:"topic"`
+}
+
+// api serves the consensus endpoints of the node API.
+type api struct {
+	engines map[string]Consensus // consensus instances by topic
+	order   []string             // topics in registration order
+}
+
+// RegisterAPI adds the endpoints of the consensus instances to the node API:
+//
+//	GET  /consensus             status of every instance
+//	GET  /consensus/status      status of one instance, ?topic= selects it
+//	POST /consensus/preference  update the preference, see PreferenceRequest
+//	POST /consensus/sync        start a Sync in background, see SyncRequest
+func RegisterAPI(a *node.API, engines ...Consensus) {
+	s := &api{
+		engines: make(map[string]Consensus, len(engines)),
+	}
+
+	for _, engine := range engines {
+		s.engines[engine.Topic()] = engine
+		s.order = append(s.order, engine.Topic())
+	}
+
+	a.HandleFunc("/consensus", http.MethodGet, s.getStatuses)
+	a.HandleFunc("/consensus/status", http.MethodGet, s.getStatus)
+	a.HandleFunc("/consensus/preference", http.MethodPost, s.postPreference)
+	a.HandleFunc("/consensus/sync", http.MethodPost, s.postSync)
+}
+
+// engine returns the instance of a topic or writes a not found error.
+func (s *api) engine(w http.ResponseWriter, topic string) (Consensus, bool) {
+	engine, ok := s.engines[topic]
+	if !ok {
+		node.WriteError(w, http.StatusNotFound, fmt.Errorf("consensus topic %q: %w", topic, node.ErrNotFound))
+	}
+	return engine, ok
+}
+
+// getStatuses returns the status of every instance.
+func (s *api) getStatuses(w http.ResponseWriter, _ *http.Request) {
+	statuses := make([]Status, 0, len(s.order))
+	for _, topic := range s.order {
+		statuses = append(statuses, s.engines[topic].Status())
+	}
+	node.WriteJSON(w, http.StatusOK, statuses)
+}
+
+// getStatus returns the status of one instance.
+func (s *api) getStatus(w http.ResponseWriter, r *http.Request) {
+	engine, ok := s.engine(w, r.URL.Query().Get("topic"))
+	if !ok {
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, engine.Status())
+}
+
+// postPreference updates the preference of an instance.
+func (s *api) postPreference(w http.ResponseWriter, r *http.Request) {
+	var request PreferenceRequest
+	if err := node.ReadJSON(r, &request); err != nil {
+		node.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	engine, ok := s.engine(w, request.Topic)
+	if !ok {
+		return
+	}
+
+	engine.UpdatePreference(request.Preference)
+	node.WriteJSON(w, http.StatusOK, engine.Status())
+}
+
+// postSync starts a Sync of an instance in background.
+func (s *api) postSync(w http.ResponseWriter, r *http.Request) {
+	var request SyncRequest
+	if r.ContentLength != 0 {
+		if err := node.ReadJSON(r, &request); err != nil {
+			node.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	engine, ok := s.engine(w, request.Topic)
+	if !ok {
+		return
+	}
+
+	if engine.Status().Running {
+		node.WriteError(w, http.StatusConflict, fmt.Errorf("consensus topic %q is already running", request.Topic))
+		return
+	}
+
+	go engine.Sync()
+	node.WriteJSON(w, http.StatusAccepted, engine.Status())
+}
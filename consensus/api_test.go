@@ -0,0 +1,71 @@
+package consensus
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/chain"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	network := transport.NewMemory()
+	params := SnowParams{K: 2, A: 2, B: 3, MaxStep: 50}
+
+	var engines []Consensus
+	for i := 0; i < 3; i++ {
+		n := createNode(network, 9480+int64(i))
+		c := NewConsensus(params)
+		c.AddNode(n)
+		c.OnDecide(func(value int) { n.Chain.Append(value) })
+		proto.RegisterConsensusServiceServer(n.Server, c)
+		n.StartServer()
+		engines = append(engines, c)
+	}
+	for _, c := range engines {
+		for _, other := range engines {
+			c.GetNode().PeerManager.AddPeers(other.GetNode().Address)
+		}
+	}
+
+	api := node.NewAPI(engines[0].GetNode())
+	RegisterAPI(api, engines[0])
+
+	var status Status
+	assert.Equal(t, http.StatusOK, send(api, http.MethodPost, "/consensus/preference", `{"preference": 7}`, &status))
+	assert.Equal(t, 7, status.Preference)
+
+	assert.Equal(t, http.StatusNotFound, send(api, http.MethodGet, "/consensus/status?topic=unknown", "", nil))
+	assert.Equal(t, http.StatusBadRequest, send(api, http.MethodPost, "/consensus/preference", `{`, nil))
+
+	// the other nodes prefer the same value, so a Sync accepts it and the
+	// decision is appended to the chain
+	engines[1].UpdatePreference(7)
+	engines[2].UpdatePreference(7)
+	assert.Equal(t, http.StatusAccepted, send(api, http.MethodPost, "/consensus/sync", "", nil))
+	assert.Eventually(t, func() bool {
+		var statuses []Status
+		send(api, http.MethodGet, "/consensus", "", &statuses)
+		return len(statuses) == 1 && statuses[0].Accepted && !statuses[0].Running
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var head chain.Block
+	assert.Equal(t, http.StatusOK, send(api, http.MethodGet, "/chain/head", "", &head))
+	assert.Equal(t, 7, head.Value)
+}
+
+// send sends a request to the API and decodes the response into v.
+func send(api *node.API, method string, path string, body string, v interface{}) int {
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if v != nil {
+		_ = json.NewDecoder(w.Body).Decode(v)
+	}
+	return w.Code
+}
@@ -0,0 +1,647 @@
+package consensus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"simple-p2p/chain"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/tracing"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownProposal  = errors.New("decided proposal is unknown")
+	ErrProposalConflict = errors.New("proposals of the same key differ")
+	ErrMissingBlocks    = errors.New("recent blocks are missing")
+)
+
+var (
+	maxBlockTxs            = 1000                      // largest number of transactions of a block
+	maxBlockBytes          = 4 << 20                   // largest total size of the transactions of a block
+	finalizedWindow        = 100                       // number of heights a finalized or conflicting proposal is remembered
+	proposalRequestTimeout = time.Second               // timeout of a request of a proposal hash to a peer
+	conflictQuorum         = finality.DefaultThreshold // share of the weight answering other hashes that makes a key conflicting
+)
+
+// Proposal is a batch of pending transactions proposed as the content of the
+// next block. A proposal for the block at an epoch boundary may also carry the
+// validator set operation decided for the next epoch.
+type Proposal struct {
+	Txs []chain.Tx   `json:"txs"`
+	Op  *ValidatorOp `json:"op,omitempty"`
+}
+
+// Hash returns the hex SHA-256 of the transaction hashes and the operation,
+// which identifies the proposal.
+func (p Proposal) Hash() string {
+	return hex.EncodeToString(p.sum())
+}
+
+// Key returns the positive consensus value identifying the proposal, a
+// truncation of its hash. Zero is reserved for an empty block. As the key is
+// short, proposals of the same key but of different hashes are told apart
+// before a block is finalized.
+func (p Proposal) Key() int {
+	sum := p.sum()
+
+	key := int(binary.BigEndian.Uint32(sum[:4]) & math.MaxInt32)
+	if key == 0 {
+		key = 1
+	}
+	return key
+}
+
+// sum returns the SHA-256 of the transaction hashes and the operation.
+func (p Proposal) sum() []byte {
+	h := sha256.New()
+	for _, tx := range p.Txs {
+		h.Write([]byte(tx.Hash()))
+	}
+	if p.Op != nil {
+		op, _ := json.Marshal(p.Op)
+		h.Write(op)
+	}
+	return h.Sum(nil)
+}
+
+// check checks that the proposal fits in a block and includes each
+// transaction once.
+func (p Proposal) check() error {
+	size := 0
+	seen := make(map[string]bool, len(p.Txs))
+	for _, tx := range p.Txs {
+		hash := tx.Hash()
+		if seen[hash] {
+			return fmt.Errorf("invalid proposal: transaction %v included twice", hash)
+		}
+		seen[hash] = true
+		size += tx.Size()
+	}
+	if len(p.Txs) == 0 && p.Op == nil || len(p.Txs) > maxBlockTxs || size > maxBlockBytes {
+		return fmt.Errorf("invalid proposal: %d transactions of %d bytes", len(p.Txs), size)
+	}
+	return nil
+}
+
+// finalizedProposal is a proposal finalized recently by the node.
+type finalizedProposal struct {
+	height uint64 // height of its block
+	hash   string // hash of the proposal
+}
+
+// BlockBuilder builds the blocks of the chain from the transactions of the
+// mempool through consensus. Before each Sync, a node without pending
+// proposals proposes a batch of its pending transactions and gossips it to its
+// peers. Like the validator set operations, nodes prefer the proposal with the
+// lowest key they know, and the decided proposal is finalized as the next
+// block, which the application of the node executes. A running Sync only
+// changes its preference through votes: a node that switched to a lower
+// proposal while its peers gained confidence in another one could let them
+// decide different blocks. A key shared by two different proposals is never
+// finalized, and before finalizing a proposal the node checks that the
+// validators do not hold another proposal of its key. With a
+// Reconfiguration, the decided validator set operation is proposed for the
+// next epoch boundary, and recorded in the block if the proposal is finalized
+// there.
+type BlockBuilder struct {
+	node   *node.Node
+	engine Consensus
+	pool   *mempool.Mempool
+
+	reconfig  *Reconfiguration          // reconfiguration whose operations are recorded, nil if none
+	proposals map[int]Proposal          // pending proposals by key
+	finalized map[int]finalizedProposal // recently finalized proposals by key
+	conflicts map[int]uint64            // heights at which keys were found shared by different proposals
+	mux       sync.Mutex                // mutual exclusion lock for the proposals
+}
+
+// NewBlockBuilder creates the block builder of a node. The decisions of the
+// engine are finalized as blocks, so the engine must not be used to decide
+// other values.
+func NewBlockBuilder(n *node.Node, engine Consensus, pool *mempool.Mempool) *BlockBuilder {
+	b := &BlockBuilder{
+		node:      n,
+		engine:    engine,
+		pool:      pool,
+		proposals: make(map[int]Proposal),
+		finalized: make(map[int]finalizedProposal),
+		conflicts: make(map[int]uint64),
+	}
+
+	n.MessageManager.RegisterHandler(proto.MessageType_PROPOSAL, b.receive)
+	n.MessageManager.RegisterHandler(proto.MessageType_PROPOSAL_HASH, b.serveHash)
+	n.MessageManager.RegisterHandler(proto.MessageType_PROPOSAL_FETCH, b.serveProposal)
+	n.OnCommit(b.prune)
+	engine.OnDecide(b.decide)
+	engine.SetHeight(func() uint64 {
+		return n.Chain.Height() + 1
+	})
+	return b
+}
+
+// SetReconfiguration records the operations decided by a reconfiguration in
+// the blocks at its epoch boundaries.
+func (b *BlockBuilder) SetReconfiguration(r *Reconfiguration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.reconfig = r
+}
+
+// Propose proposes the pending transactions of highest fees for the next
+// block, unless proposals are pending already, then prefers the lowest
+// proposal known. New proposals are thus made at the start of a height, before
+// the votes settle on one. At an epoch boundary, the decided validator set
+// operation is proposed too unless a pending proposal carries it already.
+func (b *BlockBuilder) Propose(ctx context.Context) {
+	op := b.nextOp()
+	if b.Proposals() > 0 && (op == nil || b.carries(*op)) {
+		b.engine.UpdatePreference(b.preference())
+		return
+	}
+	if txs := b.pool.Reap(maxBlockTxs, maxBlockBytes); len(txs) > 0 || op != nil {
+		p := Proposal{Txs: txs, Op: op}
+		if b.add(p) {
+			b.gossip(ctx, p)
+		}
+	}
+
+	b.engine.UpdatePreference(b.preference())
+}
+
+// Build proposes, then runs a Sync to decide the next block. It returns
+// ErrNotDecided if the Sync does not decide, and the decided block otherwise.
+func (b *BlockBuilder) Build(ctx context.Context) (chain.Block, error) {
+	height := b.node.Chain.Height() + 1
+	b.Propose(ctx)
+	b.engine.Sync()
+
+	if !b.engine.Accepted() {
+		return chain.Block{}, fmt.Errorf("block %d: %w", height, ErrNotDecided)
+	}
+
+	block, ok := b.node.Chain.Get(height)
+	if !ok {
+		return chain.Block{}, fmt.Errorf("block %d: %w: %v", height, ErrUnknownProposal, b.engine.Preference())
+	}
+	return block, nil
+}
+
+// Run builds blocks every interval, while the node is a voter and there are
+// pending transactions or proposals, until ctx is done. A node that fails to
+// build a block catches its chain up with its peers.
+func (b *BlockBuilder) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.node.Clock().After(interval):
+		}
+
+		if !b.node.Voter() || b.pool.Len() == 0 && b.preference() == 0 && b.nextOp() == nil {
+			continue
+		}
+
+		block, err := b.Build(ctx)
+		if err != nil {
+			b.node.Logger().Warn("failed to build block", logger.Err(err))
+			if err := b.node.SyncChain(ctx); err != nil {
+				return
+			}
+			continue
+		}
+		b.node.Logger().Info("built block", logger.F("height", block.Height), logger.F("txs", len(block.Txs)))
+	}
+}
+
+// Proposals returns the number of pending proposals.
+func (b *BlockBuilder) Proposals() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return len(b.proposals)
+}
+
+// nextOp returns the decided validator set operation to propose if the next
+// block ends an epoch, nil otherwise.
+func (b *BlockBuilder) nextOp() *ValidatorOp {
+	b.mux.Lock()
+	r := b.reconfig
+	b.mux.Unlock()
+
+	if r == nil || !r.Boundary(b.node.Chain.Height()+1) {
+		return nil
+	}
+	if op, ok := r.Next(); ok {
+		return &op
+	}
+	return nil
+}
+
+// carries reports whether a pending proposal carries an operation.
+func (b *BlockBuilder) carries(op ValidatorOp) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for _, p := range b.proposals {
+		if p.Op != nil && sameOp(*p.Op, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// preference returns the lowest key of the pending proposals, 0 if there is
+// none. If the next block ends an epoch, the proposals carrying an operation
+// are preferred.
+func (b *BlockBuilder) preference() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.lowest()
+}
+
+// lowest is preference with the lock held.
+func (b *BlockBuilder) lowest() int {
+	boundary := b.reconfig != nil && b.reconfig.Boundary(b.node.Chain.Height()+1)
+
+	preference, withOp := 0, false
+	for key, p := range b.proposals {
+		op := boundary && p.Op != nil
+		if preference == 0 || op && !withOp || op == withOp && key < preference {
+			preference, withOp = key, op
+		}
+	}
+	return preference
+}
+
+// add adds a proposal to the pending proposals, it returns false if the
+// proposal is already known, finalized or of a conflicting key. A pending
+// proposal of the same key but of another hash is dropped with it, and the
+// key is not proposed nor finalized anymore.
+func (b *BlockBuilder) add(p Proposal) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	key := p.Key()
+	if _, ok := b.conflicts[key]; ok {
+		return false
+	}
+	if pending, ok := b.proposals[key]; ok {
+		if pending.Hash() != p.Hash() {
+			b.conflict(key)
+		}
+		return false
+	}
+	if f, ok := b.finalized[key]; ok {
+		if f.hash != p.Hash() {
+			b.node.Logger().Warn("dropped proposal of a finalized key", logger.F("value", key), logger.Err(ErrProposalConflict))
+		}
+		return false
+	}
+
+	b.proposals[key] = p
+	return true
+}
+
+// conflict drops the pending proposal of a key shared by different proposals,
+// and remembers the key. The lock must be held.
+func (b *BlockBuilder) conflict(key int) {
+	delete(b.proposals, key)
+	b.conflicts[key] = b.node.Chain.Height()
+	b.node.Logger().Warn("dropped conflicting proposals", logger.F("value", key), logger.Err(ErrProposalConflict))
+}
+
+// decide finalizes the decided proposal as the next block, then prefers the
+// lowest proposal left. A proposal whose key is the value of one of the last
+// blocks of the chain gives an empty block. The operation of the proposal is
+// only recorded in a block at an epoch boundary. A proposal whose key is
+// shared by another one, locally or at a peer, is not finalized: the node then
+// catches up with the chain of its peers.
+func (b *BlockBuilder) decide(value int) {
+	b.mux.Lock()
+	p, ok := b.proposals[value]
+	_, conflicting := b.conflicts[value]
+	r := b.reconfig
+	b.mux.Unlock()
+
+	if value != 0 {
+		finalized, err := b.committed(value)
+		if err != nil {
+			b.node.Logger().Error("failed to finalize block", logger.F("value", value), logger.Err(err))
+			return
+		}
+		if finalized {
+			value, p = 0, Proposal{}
+		}
+	}
+	if value != 0 && conflicting {
+		b.node.Logger().Error("failed to finalize block", logger.F("value", value), logger.Err(ErrProposalConflict))
+		return
+	}
+	if value != 0 && !ok {
+		b.node.Logger().Error("failed to finalize block", logger.F("value", value), logger.Err(ErrUnknownProposal))
+		return
+	}
+	if value != 0 {
+		if err := b.confirm(value, p.Hash()); err != nil {
+			b.mux.Lock()
+			b.conflict(value)
+			b.mux.Unlock()
+			b.node.Logger().Error("failed to finalize block", logger.F("value", value), logger.Err(err))
+			return
+		}
+	}
+
+	var op []byte
+	if p.Op != nil && r != nil && r.Boundary(b.node.Chain.Height()+1) {
+		op, _ = json.Marshal(p.Op)
+	}
+	if _, err := b.node.FinalizeOp(value, p.Txs, op); err != nil {
+		b.node.Logger().Error("failed to finalize block", logger.F("value", value), logger.Err(err))
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if value != 0 {
+		b.finalized[value] = finalizedProposal{height: b.node.Chain.Height(), hash: p.Hash()}
+	}
+	b.engine.UpdatePreference(b.lowest())
+}
+
+// committed reports whether a key is the value of one of the last
+// finalizedWindow blocks of the chain. It only reads the chain, so the nodes
+// deciding the same height agree on it. It returns ErrMissingBlocks if the
+// chain does not keep these blocks, as after a snapshot restore.
+func (b *BlockBuilder) committed(value int) (bool, error) {
+	from := uint64(1)
+	if height := b.node.Chain.Height(); height > uint64(finalizedWindow) {
+		from = height - uint64(finalizedWindow) + 1
+	}
+	if base := b.node.Chain.Base(); base > from {
+		return false, fmt.Errorf("%w: blocks %d to %d", ErrMissingBlocks, from, base-1)
+	}
+
+	for _, block := range b.node.Chain.Range(from, finalizedWindow) {
+		if block.Value == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// prune drops the pending proposals that share a transaction or the
+// operation with a committed block, and forgets the proposals finalized long
+// ago.
+func (b *BlockBuilder) prune(block chain.Block) {
+	included := make(map[string]bool, len(block.Txs))
+	for _, tx := range block.Txs {
+		included[tx.Hash()] = true
+	}
+	var recorded *ValidatorOp
+	if len(block.Op) > 0 {
+		var op ValidatorOp
+		if err := json.Unmarshal(block.Op, &op); err == nil {
+			recorded = &op
+		}
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for key, p := range b.proposals {
+		if recorded != nil && p.Op != nil && sameOp(*p.Op, *recorded) {
+			delete(b.proposals, key)
+			continue
+		}
+		for _, tx := range p.Txs {
+			if included[tx.Hash()] {
+				delete(b.proposals, key)
+				break
+			}
+		}
+	}
+	for key, f := range b.finalized {
+		if f.height+uint64(finalizedWindow) < block.Height {
+			delete(b.finalized, key)
+		}
+	}
+	for key, height := range b.conflicts {
+		if height+uint64(finalizedWindow) < block.Height {
+			delete(b.conflicts, key)
+		}
+	}
+}
+
+// confirm asks the peers in parallel for the hash of their proposal of a key.
+// Only the answers of validators count, by their weights, and every peer has
+// weight 1 without a validator set. The key is conflicting if the validators
+// answering other hashes hold a quorum of the total weight, or if one of the
+// other proposals, fetched from a validator that answered its hash, is a
+// valid proposal of the key. The peers that do not know the key, do not
+// answer or cannot back their hash are left out.
+func (b *BlockBuilder) confirm(key int, hash string) error {
+	request := &proto.MessageRequest{Type: proto.MessageType_PROPOSAL_HASH, Value: []byte(strconv.Itoa(key))}
+	peers := b.node.PeerManager.GetPeers()
+	weights, total := b.weights(peers)
+
+	var (
+		others = make(map[string][]string) // peers by other hash answered
+		weight uint64                      // weight of the peers answering other hashes
+		mux    sync.Mutex
+		waiter sync.WaitGroup
+	)
+	for _, peer := range peers {
+		if weights[peer] == 0 {
+			continue
+		}
+
+		waiter.Add(1)
+		go func(peer string) {
+			defer waiter.Done()
+
+			response, err := b.request(peer, request)
+			if err != nil || string(response.Value) == hash {
+				return
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+			others[string(response.Value)] = append(others[string(response.Value)], peer)
+			weight += weights[peer]
+		}(peer)
+	}
+	waiter.Wait()
+
+	if conflictQuorum.Reached(weight, total) {
+		return fmt.Errorf("%w: validators of weight %d of %d hold other proposals of %d", ErrProposalConflict, weight, total, key)
+	}
+	for other, holders := range others {
+		for _, peer := range holders {
+			if b.fetch(peer, key, other) {
+				return fmt.Errorf("%w: peer %v holds proposal %v of %d", ErrProposalConflict, peer, other, key)
+			}
+		}
+	}
+	return nil
+}
+
+// weights returns the voting weight of each peer and the total weight, from
+// the validator set of the engine, or 1 per node without one.
+func (b *BlockBuilder) weights(peers []string) (map[string]uint64, uint64) {
+	weights := make(map[string]uint64, len(peers))
+	set := b.engine.Validators()
+	if set == nil {
+		for _, peer := range peers {
+			weights[peer] = 1
+		}
+		return weights, uint64(len(peers)) + 1
+	}
+
+	for _, peer := range peers {
+		if v, ok := set.GetByAddress(peer); ok {
+			weights[peer] = v.Weight
+		}
+	}
+	return weights, set.TotalWeight()
+}
+
+// fetch asks a peer for its proposal of a hash, and reports whether it is a
+// valid proposal of the key with that hash.
+func (b *BlockBuilder) fetch(peer string, key int, hash string) bool {
+	response, err := b.request(peer, &proto.MessageRequest{Type: proto.MessageType_PROPOSAL_FETCH, Value: []byte(hash)})
+	if err != nil {
+		return false
+	}
+
+	var p Proposal
+	if err := json.Unmarshal(response.Value, &p); err != nil {
+		return false
+	}
+	return p.Key() == key && p.Hash() == hash && p.check() == nil
+}
+
+// request sends a request to a peer and returns its response.
+func (b *BlockBuilder) request(peer string, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	conn, err := b.node.PeerManager.GetConnection(peer)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proposalRequestTimeout)
+	defer cancel()
+
+	return proto.NewMessageServiceClient(conn).ReceiveMessage(ctx, request)
+}
+
+// serveHash answers the request of a peer for the hash of the proposal of a
+// key, pending or finalized recently.
+func (b *BlockBuilder) serveHash(_ context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	key, err := strconv.Atoi(string(request.Value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid proposal key: %w", err)
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if p, ok := b.proposals[key]; ok {
+		return &proto.MessageResponse{Type: request.Type, Value: []byte(p.Hash())}, nil
+	}
+	if f, ok := b.finalized[key]; ok {
+		return &proto.MessageResponse{Type: request.Type, Value: []byte(f.hash)}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownProposal, key)
+}
+
+// serveProposal answers the request of a peer for the pending proposal of a
+// hash.
+func (b *BlockBuilder) serveProposal(_ context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	hash := string(request.Value)
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for _, p := range b.proposals {
+		if p.Hash() != hash {
+			continue
+		}
+		value, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.MessageResponse{Type: request.Type, Value: value}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownProposal, hash)
+}
+
+// gossip sends a proposal to all known peers, within the trace of ctx.
+func (b *BlockBuilder) gossip(ctx context.Context, p Proposal) {
+	ctx, span := b.node.Tracer().Start(ctx, "blocks.gossip", tracing.Attr("proposal", p.Key()))
+	defer span.End()
+
+	value, err := json.Marshal(p)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	for _, peer := range b.node.PeerManager.GetPeers() {
+		conn, err := b.node.PeerManager.GetConnection(peer)
+		if err != nil {
+			continue
+		}
+
+		_ = b.node.MessageManager.SendMessage(ctx, conn, &proto.MessageRequest{
+			Type:  proto.MessageType_PROPOSAL,
+			Value: value,
+		})
+	}
+}
+
+// receive handles a proposal gossiped by a peer, prefers it if it is the
+// lowest and no Sync is running, and forwards it if it is new. A proposal
+// with a transaction the mempool rejects, or carrying an operation the node
+// does not know, is rejected.
+func (b *BlockBuilder) receive(ctx context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	var p Proposal
+	if err := json.Unmarshal(request.Value, &p); err != nil {
+		return nil, fmt.Errorf("invalid proposal: %w", err)
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	for _, tx := range p.Txs {
+		if err := b.pool.Check(tx); err != nil {
+			return nil, fmt.Errorf("invalid proposal: %w", err)
+		}
+	}
+	if p.Op != nil {
+		b.mux.Lock()
+		r := b.reconfig
+		b.mux.Unlock()
+		if r == nil || !r.Known(*p.Op) {
+			return nil, fmt.Errorf("invalid proposal: %w: %v", ErrUnknownOp, p.Op.Key())
+		}
+	}
+
+	if b.add(p) {
+		if !b.engine.Status().Running {
+			b.engine.UpdatePreference(b.preference())
+		}
+		go b.gossip(tracing.Detach(ctx), p)
+	}
+	return &proto.MessageResponse{Type: request.Type}, nil
+}
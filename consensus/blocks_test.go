@@ -0,0 +1,216 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/chain"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlockBuilder(t *testing.T) {
+	network := transport.NewMemory()
+	params := SnowParams{K: 2, A: 2, B: 3, MaxStep: 100}
+
+	var (
+		nodes    []*node.Node
+		pools    []*mempool.Mempool
+		builders []*BlockBuilder
+	)
+	for i := 0; i < 3; i++ {
+		engine := NewConsensus(params)
+		n := createNode(network, 9490+int64(i), node.WithConsensus(engine))
+		pool := mempool.New(n)
+		builders = append(builders, NewBlockBuilder(n, engine, pool))
+		nodes = append(nodes, n)
+		pools = append(pools, pool)
+
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+	}
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other != n {
+				n.PeerManager.AddPeers(other.Address)
+			}
+		}
+	}
+
+	// the transactions submitted to a node reach every mempool
+	ctx := context.Background()
+	for i, data := range []string{"a", "b", "c"} {
+		assert.NoError(t, pools[0].Add(ctx, chain.Tx{Data: []byte(data), Fee: uint64(i)}))
+	}
+	assert.Eventually(t, func() bool {
+		return pools[1].Len() == 3 && pools[2].Len() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the nodes decide the same block with the transactions
+	var waiter sync.WaitGroup
+	for _, b := range builders {
+		waiter.Add(1)
+		go func(b *BlockBuilder) {
+			defer waiter.Done()
+			_, err := b.Build(ctx)
+			assert.NoError(t, err)
+		}(b)
+	}
+	waiter.Wait()
+
+	head, _ := nodes[0].Chain.Head()
+	assert.Equal(t, uint64(1), head.Height)
+	assert.Len(t, head.Txs, 3)
+	for i, n := range nodes {
+		other, _ := n.Chain.Head()
+		assert.Equal(t, head, other)
+		assert.Equal(t, 0, pools[i].Len())
+		assert.Equal(t, 0, builders[i].Proposals())
+	}
+}
+
+func TestBlockBuilderPropose(t *testing.T) {
+	network := transport.NewMemory()
+	engine := NewConsensus(SnowParams{K: 1, A: 1, B: 1, MaxStep: 10})
+	n := createNode(network, 9495, node.WithConsensus(engine))
+	pool := mempool.New(n)
+	b := NewBlockBuilder(n, engine, pool)
+
+	ctx := context.Background()
+	assert.NoError(t, pool.Add(ctx, chain.Tx{Data: []byte("a")}))
+	b.Propose(ctx)
+	assert.Equal(t, 1, b.Proposals())
+	preference := engine.Preference()
+	assert.NotZero(t, preference)
+
+	// a node with a pending proposal does not propose again
+	assert.NoError(t, pool.Add(ctx, chain.Tx{Data: []byte("b")}))
+	b.Propose(ctx)
+	assert.Equal(t, 1, b.Proposals())
+	assert.Equal(t, preference, engine.Preference())
+}
+
+// collidingProposals returns two different proposals of the same key.
+func collidingProposals() (Proposal, Proposal) {
+	seen := make(map[int]Proposal)
+	for i := 0; ; i++ {
+		p := Proposal{Txs: []chain.Tx{{Data: []byte(fmt.Sprintf("tx-%d", i))}}}
+		if other, ok := seen[p.Key()]; ok {
+			return other, p
+		}
+		seen[p.Key()] = p
+	}
+}
+
+func TestBlockBuilderConflict(t *testing.T) {
+	network := transport.NewMemory()
+	var (
+		nodes    []*node.Node
+		builders []*BlockBuilder
+	)
+	for i := 0; i < 2; i++ {
+		engine := NewConsensus(SnowParams{K: 1, A: 1, B: 1, MaxStep: 10})
+		n := createNode(network, 9496+int64(i), node.WithConsensus(engine))
+		pool := mempool.New(n, mempool.WithCheckTx(func(tx mempool.Tx) error {
+			if string(tx.Data) == "bad" {
+				return errors.New("bad transaction")
+			}
+			return nil
+		}))
+		builders = append(builders, NewBlockBuilder(n, engine, pool))
+		nodes = append(nodes, n)
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+	}
+	nodes[0].PeerManager.AddPeers(nodes[1].Address)
+
+	// proposals with a transaction the application rejects, or included
+	// twice, are rejected
+	receive := func(b *BlockBuilder, p Proposal) error {
+		value, err := json.Marshal(p)
+		assert.NoError(t, err)
+		_, err = b.receive(context.Background(), &proto.MessageRequest{Type: proto.MessageType_PROPOSAL, Value: value})
+		return err
+	}
+	assert.ErrorIs(t, receive(builders[0], Proposal{Txs: []chain.Tx{{Data: []byte("bad")}}}), mempool.ErrInvalidTx)
+	assert.Error(t, receive(builders[0], Proposal{Txs: []chain.Tx{{Data: []byte("a")}, {Data: []byte("a")}}}))
+	assert.Equal(t, 0, builders[0].Proposals())
+
+	// a key shared by two proposals is dropped and never finalized
+	p1, p2 := collidingProposals()
+	assert.NotEqual(t, p1.Hash(), p2.Hash())
+	b := builders[1]
+	assert.True(t, b.add(p1))
+	assert.False(t, b.add(p2))
+	assert.False(t, b.add(p1))
+	assert.Equal(t, 0, b.Proposals())
+	b.decide(p1.Key())
+	assert.Equal(t, uint64(0), nodes[1].Chain.Height())
+
+	// a node that only knows one of them does not finalize it when a peer
+	// holds the other
+	b.mux.Lock()
+	delete(b.conflicts, p1.Key())
+	b.proposals[p2.Key()] = p2
+	b.mux.Unlock()
+	assert.True(t, builders[0].add(p1))
+	builders[0].decide(p1.Key())
+	assert.Equal(t, uint64(0), nodes[0].Chain.Height())
+	assert.Equal(t, 0, builders[0].Proposals())
+
+	// without a conflict, the proposal is finalized
+	p3 := Proposal{Txs: []chain.Tx{{Data: []byte("c")}}}
+	assert.True(t, builders[0].add(p3))
+	builders[0].decide(p3.Key())
+	head, _ := nodes[0].Chain.Head()
+	assert.Equal(t, uint64(1), head.Height)
+	assert.Equal(t, p3.Txs, head.Txs)
+
+	// a key finalized in the chain gives an empty block, even once the node
+	// forgot it
+	builders[0].mux.Lock()
+	builders[0].finalized = make(map[int]finalizedProposal)
+	builders[0].proposals[p3.Key()] = p3
+	builders[0].mux.Unlock()
+	builders[0].decide(p3.Key())
+	head, _ = nodes[0].Chain.Head()
+	assert.Equal(t, uint64(2), head.Height)
+	assert.Equal(t, 0, head.Value)
+	assert.Empty(t, head.Txs)
+
+	// a peer answering a hash it cannot back, or that is not a validator,
+	// does not stop the finalization
+	p4 := Proposal{Txs: []chain.Tx{{Data: []byte("d")}}}
+	b.mux.Lock()
+	b.finalized[p4.Key()] = finalizedProposal{hash: "forged"}
+	b.mux.Unlock()
+	assert.True(t, builders[0].add(p4))
+	builders[0].decide(p4.Key())
+	assert.Equal(t, uint64(3), nodes[0].Chain.Height())
+
+	builders[0].mux.Lock()
+	delete(builders[0].conflicts, p1.Key())
+	builders[0].mux.Unlock()
+	set, err := NewValidatorSet(Validator{ID: "node-0", Address: nodes[0].Address, Weight: 1})
+	assert.NoError(t, err)
+	builders[0].engine.SetValidators(set)
+	assert.True(t, builders[0].add(p1))
+	builders[0].decide(p1.Key())
+	head, _ = nodes[0].Chain.Head()
+	assert.Equal(t, uint64(4), head.Height)
+	assert.Equal(t, p1.Key(), head.Value)
+
+	// a node that does not keep the last blocks does not finalize
+	block := chain.Block{Height: 500, TxRoot: chain.TxRoot(nil)}
+	block.Hash = block.ComputeHash()
+	assert.NoError(t, nodes[1].Chain.Restore(block))
+	_, err = b.committed(p1.Key())
+	assert.ErrorIs(t, err, ErrMissingBlocks)
+}
@@ -0,0 +1,179 @@
+// Package byzantine provides adversarial consensus nodes and a harness that
+// mixes them with honest nodes to test the Snowball parameters.
+package byzantine
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"simple-p2p/clock"
+	"simple-p2p/proto/proto"
+	"sync"
+	"time"
+)
+
+var ErrNoChoice = errors.New("random vote without choices")
+
+// Behavior decides how a Byzantine node answers a preference query. honest
+// returns the current preferences of the honest nodes, so a behavior can act
+// as an omniscient adversary.
+type Behavior interface {
+	Respond(ctx context.Context, honest func() []int) (int, error)
+}
+
+// FixedVote always votes for the same value.
+type FixedVote int
+
+// Respond returns the fixed value.
+func (v FixedVote) Respond(context.Context, func() []int) (int, error) {
+	return int(v), nil
+}
+
+// RandomVote votes for a random value of the choices on every query.
+type RandomVote []int
+
+// Respond returns a random choice, or ErrNoChoice without choices.
+func (v RandomVote) Respond(context.Context, func() []int) (int, error) {
+	if len(v) == 0 {
+		return 0, ErrNoChoice
+	}
+	return v[rand.Intn(len(v))], nil
+}
+
+// seed returns the behavior drawing its choices from the seeded source.
+func (v RandomVote) seed(seed int64) Behavior {
+	return &seededVote{
+		choices: v,
+		random:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// seededVote is a RandomVote with its own random source.
+type seededVote struct {
+	choices []int      // values to vote for
+	random  *rand.Rand // source of the choices
+	mux     sync.Mutex // mutual exclusion lock for random
+}
+
+// Respond returns a random choice, or ErrNoChoice without choices.
+func (v *seededVote) Respond(context.Context, func() []int) (int, error) {
+	if len(v.choices) == 0 {
+		return 0, ErrNoChoice
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.choices[v.random.Intn(len(v.choices))], nil
+}
+
+// MinorityVote votes for the least preferred value among the honest nodes,
+// trying to keep the network split.
+type MinorityVote struct{}
+
+// Respond returns the least frequent honest preference.
+func (MinorityVote) Respond(_ context.Context, honest func() []int) (int, error) {
+	counts := make(map[int]int)
+	preferences := honest()
+	for _, p := range preferences {
+		counts[p]++
+	}
+
+	var (
+		minority int
+		minCount = len(preferences) + 1
+	)
+	for _, p := range preferences {
+		if counts[p] < minCount || (counts[p] == minCount && p < minority) {
+			minority, minCount = p, counts[p]
+		}
+	}
+	return minority, nil
+}
+
+// DropVote never answers, the query only ends when the caller gives up.
+type DropVote struct{}
+
+// Respond blocks until the query is canceled.
+func (DropVote) Respond(ctx context.Context, _ func() []int) (int, error) {
+	<-ctx.Done()
+	return 0, status.FromContextError(ctx.Err()).Err()
+}
+
+// SlowVote answers like Behavior after Delay, measured by Clock or by the
+// wall clock if Clock is nil.
+type SlowVote struct {
+	Delay    time.Duration
+	Behavior Behavior
+	Clock    clock.Clock
+}
+
+// seed returns the slow behavior with the wrapped behavior seeded.
+func (v SlowVote) seed(seed int64) Behavior {
+	v.Behavior = seeded(v.Behavior, seed)
+	return v
+}
+
+// Respond waits for the delay, then answers like the wrapped behavior.
+func (v SlowVote) Respond(ctx context.Context, honest func() []int) (int, error) {
+	c := v.Clock
+	if c == nil {
+		c = clock.Real{}
+	}
+
+	select {
+	case <-c.After(v.Delay):
+		return v.Behavior.Respond(ctx, honest)
+	case <-ctx.Done():
+		return 0, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// seeder is a behavior that draws random values, seed returns the behavior
+// drawing them from a source with the given seed.
+type seeder interface {
+	seed(seed int64) Behavior
+}
+
+// seeded returns the behavior with its random values drawn from a source with
+// the given seed, or the behavior itself if it is not random.
+func seeded(b Behavior, seed int64) Behavior {
+	if s, ok := b.(seeder); ok {
+		return s.seed(seed)
+	}
+	return b
+}
+
+var _ proto.ConsensusServiceServer = (*server)(nil)
+
+// server is a consensus service that answers queries with a Behavior.
+type server struct {
+	behavior Behavior     // how queries are answered
+	honest   func() []int // preferences of the honest nodes
+}
+
+// NewServer creates a Byzantine consensus service. honest may be nil if the
+// behavior does not observe the honest nodes.
+func NewServer(behavior Behavior, honest func() []int) proto.ConsensusServiceServer {
+	if honest == nil {
+		honest = func() []int { return nil }
+	}
+
+	return &server{
+		behavior: behavior,
+		honest:   honest,
+	}
+}
+
+// GetPreference answers a query with the behavior of the server.
+func (s *server) GetPreference(ctx context.Context, _ *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error) {
+	preference, err := s.behavior.Respond(ctx, s.honest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.GetPreferenceResponse{
+		Preference: int64(preference),
+	}, nil
+}
@@ -0,0 +1,130 @@
+package byzantine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/clock"
+	"simple-p2p/consensus"
+	"testing"
+	"time"
+)
+
+func TestByzantine(t *testing.T) {
+	params := consensus.SnowParams{
+		K:            4,
+		A:            3,
+		B:            10,
+		MaxStep:      200,
+		QueryTimeout: 200 * time.Millisecond,
+	}
+
+	tests := []struct {
+		name      string
+		byzantine []Group
+	}{
+		{"Honest", nil},
+		{"FixedVote", []Group{{Behavior: FixedVote(3), Ratio: 0.2}}},
+		{"RandomVote", []Group{{Behavior: RandomVote{1, 2, 3}, Ratio: 0.2}}},
+		{"MinorityVote", []Group{{Behavior: MinorityVote{}, Ratio: 0.2}}},
+		{"DropVote", []Group{{Behavior: DropVote{}, Ratio: 0.1}}},
+		{"SlowVote", []Group{{Behavior: SlowVote{Delay: 50 * time.Millisecond, Behavior: FixedVote(2)}, Ratio: 0.1}}},
+		{"Mixed", []Group{
+			{Behavior: FixedVote(3), Ratio: 0.1},
+			{Behavior: MinorityVote{}, Ratio: 0.1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Run(Config{
+				Nodes:       10,
+				Params:      params,
+				Preferences: []int{1, 1, 2},
+				Byzantine:   tt.byzantine,
+				Seed:        1,
+			})
+			assert.NoError(t, err)
+			t.Log(report)
+
+			assert.Equal(t, 10, report.Honest+report.Byzantine)
+			assert.Equal(t, 0, report.SafetyViolations)
+			assert.True(t, report.Agreement)
+		})
+	}
+}
+
+func TestAssign(t *testing.T) {
+	behaviors, err := assign(10, []Group{{Behavior: FixedVote(1), Ratio: 0.3}})
+	assert.NoError(t, err)
+
+	byzantine := 0
+	for _, b := range behaviors {
+		if b != nil {
+			byzantine++
+		}
+	}
+	assert.Equal(t, 3, byzantine)
+
+	_, err = assign(10, []Group{{Behavior: FixedVote(1), Ratio: 0.6}, {Behavior: DropVote{}, Ratio: 0.6}})
+	assert.ErrorIs(t, err, ErrTooManyByzantine)
+}
+
+func TestLargeNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a network of 200 nodes for minutes under the race detector")
+	}
+
+	report, err := Run(Config{
+		Nodes:       200,
+		Params:      consensus.SnowParams{K: 10, A: 7, B: 15, MaxStep: 300},
+		Preferences: []int{1, 2},
+		Byzantine:   []Group{{Behavior: RandomVote{1, 2}, Ratio: 0.1}},
+		Seed:        1,
+	})
+	assert.NoError(t, err)
+	t.Log(report)
+
+	assert.Equal(t, 0, report.SafetyViolations)
+	assert.True(t, report.Agreement)
+}
+
+func TestSeeded(t *testing.T) {
+	votes := func(b Behavior) []int {
+		var values []int
+		for i := 0; i < 20; i++ {
+			v, err := b.Respond(context.Background(), nil)
+			assert.NoError(t, err)
+			values = append(values, v)
+		}
+		return values
+	}
+
+	vote := RandomVote{1, 2, 3}
+	assert.Equal(t, votes(seeded(vote, 1)), votes(seeded(vote, 1)))
+	assert.Equal(t, FixedVote(2), seeded(FixedVote(2), 1))
+
+	slow := seeded(SlowVote{Behavior: vote}, 1).(SlowVote)
+	assert.Equal(t, votes(seeded(vote, 1)), votes(slow.Behavior))
+}
+
+func TestBehaviors(t *testing.T) {
+	ctx := context.Background()
+
+	// a random vote without choices fails the query
+	_, err := RandomVote{}.Respond(ctx, nil)
+	assert.ErrorIs(t, err, ErrNoChoice)
+	_, err = seeded(RandomVote{}, 1).Respond(ctx, nil)
+	assert.ErrorIs(t, err, ErrNoChoice)
+
+	// a slow vote waits on its clock
+	fake := clock.NewFake(time.Now())
+	slow := SlowVote{Delay: time.Hour, Behavior: FixedVote(2), Clock: fake}
+	done := make(chan int, 1)
+	go func() {
+		v, _ := slow.Respond(ctx, nil)
+		done <- v
+	}()
+	assert.Eventually(t, func() bool { return fake.Waiters() == 1 }, time.Second, time.Millisecond)
+	fake.Advance(time.Hour)
+	assert.Equal(t, 2, <-done)
+}
@@ -0,0 +1,192 @@
+package byzantine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"simple-p2p/consensus"
+	"simple-p2p/node"
+	"simple-p2p/p2p"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"sort"
+	"sync"
+)
+
+var ErrTooManyByzantine = errors.New("byzantine groups exceed the number of nodes")
+
+// Group is a share of the nodes that behave the same way.
+type Group struct {
+	Behavior Behavior // how the nodes of the group answer queries
+	Ratio    float64  // share of all nodes, rounded to the nearest node
+}
+
+// Config is the setup of a harness run.
+type Config struct {
+	Nodes       int                  // total number of nodes
+	Params      consensus.SnowParams // parameters of the honest nodes
+	Preferences []int                // initial preferences assigned round-robin to honest nodes
+	Byzantine   []Group              // Byzantine groups, the remaining nodes are honest
+	Transport   transport.Transport  // network of the nodes, a new in-memory network if nil
+	Seed        int64                // seed of the peer samples and the random votes
+}
+
+// Report is the outcome of a harness run from the point of view of the honest nodes.
+type Report struct {
+	Honest    int // number of honest nodes
+	Byzantine int // number of Byzantine nodes
+
+	Decided   int         // number of honest nodes that accepted a value
+	Values    map[int]int // number of honest nodes that accepted each value
+	Agreement bool        // all honest nodes accepted the same value
+	Rounds    []int       // rounds to decision of each honest node that accepted, sorted
+
+	// SafetyViolations is the number of accepted values beyond the first,
+	// any value above zero means honest nodes finalized conflicting values.
+	SafetyViolations int
+}
+
+// MeanRounds returns the mean number of rounds to decision.
+func (r Report) MeanRounds() float64 {
+	if len(r.Rounds) == 0 {
+		return math.NaN()
+	}
+
+	total := 0
+	for _, rounds := range r.Rounds {
+		total += rounds
+	}
+	return float64(total) / float64(len(r.Rounds))
+}
+
+// String returns a one line summary of the report.
+func (r Report) String() string {
+	return fmt.Sprintf("honest=%d byzantine=%d decided=%d values=%v agreement=%t mean_rounds=%.1f safety_violations=%d",
+		r.Honest, r.Byzantine, r.Decided, r.Values, r.Agreement, r.MeanRounds(), r.SafetyViolations)
+}
+
+// Run starts a fully connected network of honest and Byzantine nodes, runs one
+// consensus on every honest node and reports the outcome. All nodes are
+// stopped before it returns. The peer samples and the random votes are drawn
+// from cfg.Seed, so the runs only differ by the scheduling of the nodes.
+func Run(cfg Config) (Report, error) {
+	behaviors, err := assign(cfg.Nodes, cfg.Byzantine)
+	if err != nil {
+		return Report{}, err
+	}
+
+	network := cfg.Transport
+	if network == nil {
+		network = transport.NewMemory()
+	}
+
+	var (
+		nodes  []*node.Node
+		honest []consensus.Consensus
+	)
+
+	observe := func() []int {
+		preferences := make([]int, 0, len(honest))
+		for _, c := range honest {
+			preferences = append(preferences, c.Preference())
+		}
+		return preferences
+	}
+
+	defer func() {
+		for _, n := range nodes {
+			n.PeerManager.RemoveAllPeers()
+			n.StopServer()
+		}
+	}()
+
+	for i := 0; i < cfg.Nodes; i++ {
+		seed := cfg.Seed + int64(i)
+		opts := []node.Option{node.WithTransport(network), node.WithPeerOptions(p2p.WithSeed(seed))}
+		if behavior := behaviors[i]; behavior != nil {
+			opts = append(opts, node.WithService(&proto.ConsensusService_ServiceDesc, NewServer(seeded(behavior, seed), observe)))
+		} else {
+			c := consensus.NewConsensus(cfg.Params)
+			if len(cfg.Preferences) > 0 {
+				c.UpdatePreference(cfg.Preferences[len(honest)%len(cfg.Preferences)])
+			}
+
+			opts = append(opts, node.WithConsensus(c))
+			honest = append(honest, c)
+		}
+		n := node.NewNode(fmt.Sprintf("node-%d", i), opts...)
+
+		if err := n.StartServer(); err != nil {
+			return Report{}, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	// connect all nodes
+	for _, n := range nodes {
+		for _, other := range nodes {
+			n.PeerManager.AddPeers(other.Address)
+		}
+	}
+
+	var waiter sync.WaitGroup
+	for _, c := range honest {
+		waiter.Add(1)
+		go func(c consensus.Consensus) {
+			defer waiter.Done()
+			c.Sync()
+		}(c)
+	}
+	waiter.Wait()
+
+	report := Report{
+		Honest:    len(honest),
+		Byzantine: cfg.Nodes - len(honest),
+		Values:    make(map[int]int),
+	}
+
+	for _, c := range honest {
+		status := c.Status()
+		if !status.Accepted {
+			continue
+		}
+
+		report.Decided++
+		report.Values[status.Preference]++
+		report.Rounds = append(report.Rounds, status.Round)
+	}
+
+	sort.Ints(report.Rounds)
+	report.Agreement = report.Decided == report.Honest && len(report.Values) == 1
+	if len(report.Values) > 1 {
+		report.SafetyViolations = len(report.Values) - 1
+	}
+	return report, nil
+}
+
+// assign returns the behavior of each node, nil for honest nodes. Byzantine
+// nodes are spread over the network instead of taking consecutive positions.
+func assign(nodes int, groups []Group) ([]Behavior, error) {
+	var byzantine []Behavior
+	for _, g := range groups {
+		count := int(math.Round(g.Ratio * float64(nodes)))
+		for i := 0; i < count; i++ {
+			byzantine = append(byzantine, g.Behavior)
+		}
+	}
+
+	if len(byzantine) > nodes {
+		return nil, fmt.Errorf("%d of %d nodes: %w", len(byzantine), nodes, ErrTooManyByzantine)
+	}
+
+	behaviors := make([]Behavior, nodes)
+	if len(byzantine) == 0 {
+		return behaviors, nil
+	}
+
+	stride := float64(nodes) / float64(len(byzantine))
+	for i, b := range byzantine {
+		behaviors[int(float64(i)*stride)] = b
+	}
+	return behaviors, nil
+}
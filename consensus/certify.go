@@ -0,0 +1,212 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"simple-p2p/chain"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/tracing"
+	"sort"
+	"sync"
+)
+
+var voteWindow uint64 = 100 // number of heights around the head whose votes are kept
+
+// Certifier makes the decisions of the node provable. Snowball acceptance is
+// local, so once a validator commits a block as a voter it signs a finality
+// vote for the block and gossips it. Every node collects the valid votes of
+// the validators, and once the votes for its own block at a height reach the
+// threshold of the total weight, it stores them as the certificate of the
+// block.
+type Certifier struct {
+	node      *node.Node
+	set       *ValidatorSet
+	key       ed25519.PrivateKey // key of the votes of the node, nil if it does not vote
+	threshold finality.Threshold
+
+	votes map[uint64]map[string]finality.Vote // valid votes by height and validator ID
+	mux   sync.Mutex                          // mutual exclusion lock for votes
+}
+
+// NewCertifier creates the certifier of a node. The votes are verified
+// against the public keys of the validators of the set. A node with a key
+// signs the blocks it commits if it is the validator of its address in the
+// set, with that public key. The node then restores a snapshot only once the
+// block above it is certified.
+func NewCertifier(n *node.Node, set *ValidatorSet, key ed25519.PrivateKey, threshold finality.Threshold) *Certifier {
+	c := &Certifier{
+		node:      n,
+		set:       set,
+		key:       key,
+		threshold: threshold,
+		votes:     make(map[uint64]map[string]finality.Vote),
+	}
+
+	n.MessageManager.RegisterHandler(proto.MessageType_VOTE, c.receive)
+	n.OnCommit(c.commit)
+	n.SetFinality(set, threshold)
+	return c
+}
+
+// Votes returns the valid votes collected for the block at a height, ordered
+// by validator.
+func (c *Certifier) Votes(height uint64) []finality.Vote {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	votes := make([]finality.Vote, 0, len(c.votes[height]))
+	for _, v := range c.votes[height] {
+		votes = append(votes, v)
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Validator < votes[j].Validator })
+	return votes
+}
+
+// commit votes for a committed block if the node is a voting validator, and
+// certifies the block if the votes collected already reach the threshold.
+func (c *Certifier) commit(b chain.Block) {
+	c.prune(b.Height)
+
+	if vote, ok := c.sign(b); ok {
+		c.add(vote)
+		go c.gossip(context.Background(), vote)
+	}
+	c.certify(b.Height)
+}
+
+// sign returns the vote of the node for a block, false if the node does not
+// vote: it has no key, is not a validator with that key, or synced the block
+// instead of deciding it.
+func (c *Certifier) sign(b chain.Block) (finality.Vote, bool) {
+	if c.key == nil || !c.node.Voter() {
+		return finality.Vote{}, false
+	}
+	v, ok := c.set.GetByAddress(c.node.Address)
+	if !ok || !bytes.Equal(v.PubKey, c.key.Public().(ed25519.PublicKey)) {
+		return finality.Vote{}, false
+	}
+	return finality.Sign(c.key, v.ID, c.set.Epoch(), b.Height, b.Hash), true
+}
+
+// add adds a verified vote, it returns false if the validator voted at that
+// height already. Only the first vote of a validator at a height is kept.
+func (c *Certifier) add(vote finality.Vote) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	votes, ok := c.votes[vote.Height]
+	if !ok {
+		votes = make(map[string]finality.Vote)
+		c.votes[vote.Height] = votes
+	}
+	if _, ok := votes[vote.Validator]; ok {
+		return false
+	}
+	votes[vote.Validator] = vote
+	return true
+}
+
+// certify stores the certificate of the block of the chain at a height once
+// the votes for it in the current epoch reach the threshold, unless the block
+// has one already.
+func (c *Certifier) certify(height uint64) {
+	b, ok := c.node.Chain.Get(height)
+	if !ok {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, ok := c.node.Certificate(height); ok {
+		return
+	}
+
+	certificate := finality.Certificate{Epoch: c.set.Epoch(), Height: height, BlockHash: b.Hash, Votes: []finality.Vote{}}
+	var weight uint64
+	for id, vote := range c.votes[height] {
+		if vote.Epoch != certificate.Epoch || vote.BlockHash != b.Hash {
+			continue
+		}
+		if v, ok := c.set.Get(id); ok {
+			weight += v.Weight
+			certificate.Votes = append(certificate.Votes, vote)
+		}
+	}
+	if !c.threshold.Reached(weight, c.set.TotalWeight()) {
+		return
+	}
+
+	sort.Slice(certificate.Votes, func(i, j int) bool { return certificate.Votes[i].Validator < certificate.Votes[j].Validator })
+	if err := c.node.AddCertificate(certificate); err != nil {
+		c.node.Logger().Error("failed to store certificate", logger.F("height", height), logger.Err(err))
+		return
+	}
+	c.node.Logger().Debug("certified block", logger.F("height", height), logger.F("votes", len(certificate.Votes)))
+}
+
+// prune forgets the votes of the heights that left the window below the head.
+func (c *Certifier) prune(head uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for height := range c.votes {
+		if height+voteWindow < head {
+			delete(c.votes, height)
+		}
+	}
+}
+
+// gossip sends a vote to all known peers, within the trace of ctx.
+func (c *Certifier) gossip(ctx context.Context, vote finality.Vote) {
+	ctx, span := c.node.Tracer().Start(ctx, "certifier.gossip", tracing.Attr("height", vote.Height))
+	defer span.End()
+
+	value, err := json.Marshal(vote)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	for _, peer := range c.node.PeerManager.GetPeers() {
+		conn, err := c.node.PeerManager.GetConnection(peer)
+		if err != nil {
+			continue
+		}
+
+		_ = c.node.MessageManager.SendMessage(ctx, conn, &proto.MessageRequest{
+			Type:  proto.MessageType_VOTE,
+			Value: value,
+		})
+	}
+}
+
+// receive handles a vote gossiped by a peer. A valid vote within the window
+// of the head is kept and forwarded if it is new, and may certify its block.
+func (c *Certifier) receive(ctx context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	var vote finality.Vote
+	if err := json.Unmarshal(request.Value, &vote); err != nil {
+		return nil, fmt.Errorf("invalid vote: %w", err)
+	}
+	if _, err := vote.Verify(c.set); err != nil {
+		return nil, err
+	}
+
+	// votes far from the head are dropped, they would not certify a block
+	head := c.node.Chain.Height()
+	if vote.Height+voteWindow < head || vote.Height > head+voteWindow {
+		return &proto.MessageResponse{Type: request.Type}, nil
+	}
+
+	if c.add(vote) {
+		go c.gossip(tracing.Detach(ctx), vote)
+		c.certify(vote.Height)
+	}
+	return &proto.MessageResponse{Type: request.Type}, nil
+}
@@ -0,0 +1,93 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/finality"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+func TestCertifier(t *testing.T) {
+	network := transport.NewMemory()
+
+	// three validators sign, the last one has no key and only collects votes
+	var (
+		nodes      []*node.Node
+		keys       []ed25519.PrivateKey
+		validators []Validator
+	)
+	for i := 0; i < 4; i++ {
+		n := createNode(network, 9520+int64(i))
+		v := Validator{ID: n.Address, Address: n.Address, Weight: 1}
+		var key ed25519.PrivateKey
+		if i < 3 {
+			v.PubKey, key, _ = ed25519.GenerateKey(nil)
+		}
+		nodes = append(nodes, n)
+		keys = append(keys, key)
+		validators = append(validators, v)
+	}
+	set, err := NewValidatorSet(validators...)
+	assert.NoError(t, err)
+
+	var certifiers []*Certifier
+	for i, n := range nodes {
+		certifiers = append(certifiers, NewCertifier(n, set, keys[i], finality.DefaultThreshold))
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+	}
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other != n {
+				n.PeerManager.AddPeers(other.Address)
+			}
+		}
+	}
+
+	// every node certifies the block the signing validators decided
+	for _, n := range nodes {
+		_, err := n.Finalize(7, nil)
+		assert.NoError(t, err)
+	}
+	block, _ := nodes[0].Chain.Get(1)
+	for _, n := range nodes {
+		assert.Eventually(t, func() bool {
+			_, ok := n.Certificate(1)
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+		c, _ := n.Certificate(1)
+		assert.Equal(t, block.Hash, c.BlockHash)
+		assert.Equal(t, set.Epoch(), c.Epoch)
+		assert.Len(t, c.Votes, 3)
+		assert.NoError(t, c.Verify(set, finality.DefaultThreshold))
+	}
+
+	// a block only one validator decided gets no certificate
+	_, err = nodes[2].Finalize(8, nil)
+	assert.NoError(t, err)
+	for _, c := range certifiers {
+		assert.Eventually(t, func() bool {
+			return len(c.Votes(2)) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	_, ok := nodes[2].Certificate(2)
+	assert.False(t, ok)
+
+	// a vote signed with another key is rejected
+	forged, _ := json.Marshal(finality.Sign(keys[0], validators[1].ID, 0, 2, "forged"))
+	_, err = certifiers[3].receive(context.Background(), &proto.MessageRequest{Type: proto.MessageType_VOTE, Value: forged})
+	assert.ErrorIs(t, err, finality.ErrInvalidVote)
+	assert.Len(t, certifiers[3].Votes(2), 1)
+
+	// and so is a vote of another epoch
+	stale, _ := json.Marshal(finality.Sign(keys[1], validators[1].ID, set.Epoch()+1, 2, "stale"))
+	_, err = certifiers[3].receive(context.Background(), &proto.MessageRequest{Type: proto.MessageType_VOTE, Value: stale})
+	assert.ErrorIs(t, err, finality.ErrInvalidVote)
+	assert.Len(t, certifiers[3].Votes(2), 1)
+}
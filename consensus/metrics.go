@@ -0,0 +1,13 @@
+package consensus
+
+import (
+	"simple-p2p/metrics"
+)
+
+var (
+	roundsTotal    = metrics.Default.Counter("snow_rounds_total", "Number of query rounds.", "topic")
+	confidence     = metrics.Default.Gauge("snow_confidence", "Confidence in the current preference.", "topic")
+	decisionsTotal = metrics.Default.Counter("snow_decisions_total", "Number of accepted values.", "topic")
+	failuresTotal  = metrics.Default.Counter("snow_failures_total", "Number of Syncs that reached the maximum number of rounds.", "topic")
+	timeToDecision = metrics.Default.Histogram("snow_time_to_decision_seconds", "Duration of the Syncs that accepted a value.", metrics.ExponentialBuckets(0.01, 2, 12), "topic")
+)
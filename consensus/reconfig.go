@@ -0,0 +1,595 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"simple-p2p/chain"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/tracing"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReconfigTopic is the topic of the consensus instance deciding validator set changes.
+const ReconfigTopic = "reconfig"
+
+var (
+	opRequestTimeout = 5 * time.Second // timeout of a request of a decided operation to a peer
+	maxPendingOps    = 64              // number of pending operations beyond which new ones are rejected
+	maxProposerOps   = 4               // number of pending operations of a single proposer
+	decidedEpochs    = uint64(2)       // number of epochs a decided operation is served to the peers
+)
+
+var (
+	ErrNotDecided  = errors.New("consensus did not decide")
+	ErrUnknownOp   = errors.New("decided operation is unknown")
+	ErrInvalidOp   = errors.New("invalid validator operation")
+	ErrTooManyOps  = errors.New("too many pending validator operations")
+	ErrOpCollision = errors.New("validator operations of the same key differ")
+)
+
+// opDomain prefixes the signed bytes of an operation, so its signature cannot
+// pass for the signature of another message.
+const opDomain = "simple-p2p/op/v1"
+
+// OpKind is the kind of a validator set operation.
+type OpKind int
+
+const (
+	OpAdd OpKind = iota
+	OpRemove
+	OpReweight
+)
+
+// ValidatorOp is a change of the validator set proposed to the network by a
+// validator, who signs it.
+type ValidatorOp struct {
+	Kind      OpKind    `json:"kind"`
+	Validator Validator `json:"validator"`           // only ID is used by OpRemove, ID and Weight by OpReweight
+	Proposer  string    `json:"proposer,omitempty"`  // ID of the validator proposing the operation
+	Signature []byte    `json:"signature,omitempty"` // ed25519 signature of the proposer of SignBytes
+}
+
+// SignBytes returns the bytes signed by the proposer of the operation.
+func (op ValidatorOp) SignBytes() []byte {
+	op.Signature = nil
+	data, _ := json.Marshal(op)
+	return append([]byte(opDomain), data...)
+}
+
+// Sign returns the operation proposed and signed by a validator.
+func (op ValidatorOp) Sign(key ed25519.PrivateKey, proposer string) ValidatorOp {
+	op.Proposer = proposer
+	op.Signature = ed25519.Sign(key, op.SignBytes())
+	return op
+}
+
+// change returns the change of the validator set of the operation.
+func (op ValidatorOp) change() (change, error) {
+	switch op.Kind {
+	case OpAdd:
+		return change{kind: changeAdd, validator: op.Validator}, nil
+	case OpRemove:
+		return change{kind: changeRemove, validator: Validator{ID: op.Validator.ID}}, nil
+	case OpReweight:
+		return change{kind: changeReweight, validator: Validator{ID: op.Validator.ID, Weight: op.Validator.Weight}}, nil
+	}
+	return change{}, fmt.Errorf("%w: unknown kind %v", ErrInvalidOp, op.Kind)
+}
+
+// Key returns the positive consensus value identifying the operation. Zero is
+// reserved for "no change".
+func (op ValidatorOp) Key() int {
+	data, _ := json.Marshal(op)
+	sum := sha256.Sum256(data)
+
+	key := int(binary.BigEndian.Uint32(sum[:4]) & math.MaxInt32)
+	if key == 0 {
+		key = 1
+	}
+	return key
+}
+
+// Reconfiguration changes the validator set through consensus. Operations
+// signed by a validator are gossiped to all peers, then at each epoch boundary
+// the validators decide which pending operation, if any, is applied to the
+// next epoch. An operation is only kept if it applies to the current set.
+type Reconfiguration struct {
+	node    *node.Node
+	set     *ValidatorSet
+	engine  Consensus           // consensus instance of ReconfigTopic
+	key     ed25519.PrivateKey  // key signing the operations proposed by the node, nil if it does not propose
+	pending map[int]ValidatorOp // proposed operations by key
+	decided map[int]decidedOp   // decided operations by key, served to the peers that miss them
+	missing int                 // key of a decided operation not fetched yet, 0 if none
+	blocks  uint64              // number of blocks of an epoch with AtBlocks, 0 otherwise
+	next    *ValidatorOp        // decided operation waiting to be recorded in a boundary block
+	mux     sync.Mutex          // mutual exclusion lock for pending, decided, missing and next
+
+	deciding sync.Mutex // held while a decision runs, one at a time
+}
+
+// decidedOp is a decided operation and the epoch it was decided in.
+type decidedOp struct {
+	op    ValidatorOp
+	epoch uint64
+}
+
+// NewReconfiguration creates the reconfiguration of a validator set on a node.
+// The consensus instance returned by Consensus must be served by the node,
+// usually together with the default instance via NewService.
+func NewReconfiguration(n *node.Node, set *ValidatorSet, params SnowParams) *Reconfiguration {
+	engine := NewTopicConsensus(ReconfigTopic, params)
+	engine.AddNode(n)
+	engine.SetValidators(set)
+
+	r := &Reconfiguration{
+		node:    n,
+		set:     set,
+		engine:  engine,
+		pending: make(map[int]ValidatorOp),
+		decided: make(map[int]decidedOp),
+	}
+
+	n.MessageManager.RegisterHandler(proto.MessageType_RECONFIG, r.receive)
+	n.MessageManager.RegisterHandler(proto.MessageType_OPERATION, r.serveOp)
+	return r
+}
+
+// Consensus returns the consensus instance deciding the operations.
+func (r *Reconfiguration) Consensus() Consensus {
+	return r.engine
+}
+
+// SetKey sets the key signing the operations proposed by the node, the one of
+// the validator of its address.
+func (r *Reconfiguration) SetKey(key ed25519.PrivateKey) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.key = key
+}
+
+// Propose signs an operation with the key of the node and proposes it to the
+// network. The node must be a validator of the current set.
+func (r *Reconfiguration) Propose(op ValidatorOp) error {
+	r.mux.Lock()
+	key := r.key
+	r.mux.Unlock()
+
+	v, ok := r.set.GetByAddress(r.node.Address)
+	if key == nil || !ok {
+		return fmt.Errorf("%w: %v is not a validator with a key", ErrInvalidOp, r.node.Address)
+	}
+	op = op.Sign(key, v.ID)
+	if err := r.check(op); err != nil {
+		return err
+	}
+
+	added, err := r.addPending(op)
+	if added {
+		r.gossip(context.Background(), op)
+	}
+	return err
+}
+
+// Pending returns the proposed operations that are not applied yet, ordered by key.
+func (r *Reconfiguration) Pending() []ValidatorOp {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	keys := make([]int, 0, len(r.pending))
+	for key := range r.pending {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	ops := make([]ValidatorOp, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, r.pending[key])
+	}
+	return ops
+}
+
+// Reconfigure runs consensus on the next operation and moves the validator set
+// to the next epoch with the decided operation applied. Every validator must
+// call it at the same epoch boundary. The epoch does not change when the
+// validators do not decide, nor when the decided operation is unknown to the
+// node and its peers: the node stays in the epoch and fetches it again on the
+// next call instead of deciding another operation, so it never moves to an
+// epoch without the operation its peers apply.
+func (r *Reconfiguration) Reconfigure() (uint64, error) {
+	op, ok, err := r.decide(context.Background())
+	if err != nil {
+		return r.set.Epoch(), err
+	}
+	if ok {
+		err = r.schedule(op)
+	}
+
+	epoch, errs := r.set.NextEpoch()
+	r.prune(epoch)
+	if err == nil && len(errs) > 0 {
+		err = errs[0]
+	}
+	return epoch, err
+}
+
+// AtBlocks ties the epochs to the chain of the node instead of the calls to
+// Reconfigure: at the commit of every block whose height is a multiple of
+// blocks, the validator set moves to the next epoch with the operation
+// recorded in that block applied, then a voter starts deciding the operation
+// of a later boundary in the background. The decided operation is recorded by
+// the BlockBuilder of the node in the next boundary block it finalizes. Since
+// the operations are read from the blocks, nodes that catch up by syncing
+// change their set at the same heights as the voters. Operations recorded in
+// other blocks are ignored.
+func (r *Reconfiguration) AtBlocks(blocks uint64) {
+	if blocks == 0 {
+		return
+	}
+
+	r.mux.Lock()
+	r.blocks = blocks
+	r.mux.Unlock()
+
+	r.node.OnCommit(func(b chain.Block) {
+		if b.Height%blocks != 0 {
+			return
+		}
+
+		if len(b.Op) > 0 {
+			if err := r.record(b.Op); err != nil {
+				r.node.Logger().Warn("failed to apply validator operation", logger.F("height", b.Height), logger.Err(err))
+			}
+		}
+		epoch, errs := r.set.NextEpoch()
+		for _, err := range errs {
+			r.node.Logger().Warn("failed to apply validator operation", logger.F("epoch", epoch), logger.Err(err))
+		}
+		r.prune(epoch)
+		r.node.Logger().Info("moved to next epoch", logger.F("epoch", epoch), logger.F("height", b.Height))
+
+		if r.node.Voter() {
+			go func() {
+				if err := r.decideNext(context.Background()); err != nil {
+					r.node.Logger().Warn("failed to decide validator operation", logger.F("height", b.Height), logger.Err(err))
+				}
+			}()
+		}
+	})
+}
+
+// Boundary reports whether the block at a height ends an epoch, so records
+// the decided operation. It is false unless AtBlocks is set.
+func (r *Reconfiguration) Boundary(height uint64) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.blocks > 0 && height%r.blocks == 0
+}
+
+// Next returns the decided operation waiting to be recorded in a boundary
+// block, if any.
+func (r *Reconfiguration) Next() (ValidatorOp, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.next == nil {
+		return ValidatorOp{}, false
+	}
+	return *r.next, true
+}
+
+// Known reports whether an operation was proposed to or decided by the node.
+// Operations a node never heard of are not recorded in its blocks.
+func (r *Reconfiguration) Known(op ValidatorOp) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	key := op.Key()
+	if known, ok := r.decided[key]; ok && sameOp(known.op, op) {
+		return true
+	}
+	known, ok := r.pending[key]
+	return ok && sameOp(known, op)
+}
+
+// decideNext decides the operation recorded in a later boundary block, unless
+// a decided one is still waiting to be recorded.
+func (r *Reconfiguration) decideNext(ctx context.Context) error {
+	if _, ok := r.Next(); ok {
+		return nil
+	}
+
+	op, ok, err := r.decide(ctx)
+	if err != nil || !ok {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.next = &op
+	return nil
+}
+
+// record queues the operation recorded in a boundary block to the validator
+// set for the next epoch. It is no longer pending nor waiting to be recorded.
+// The block is final, so the operation is not checked against the set.
+func (r *Reconfiguration) record(data []byte) error {
+	var op ValidatorOp
+	if err := json.Unmarshal(data, &op); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOp, err)
+	}
+
+	r.mux.Lock()
+	key := op.Key()
+	delete(r.pending, key)
+	r.decided[key] = decidedOp{op: op, epoch: r.set.Epoch()}
+	if r.next != nil && sameOp(*r.next, op) {
+		r.next = nil
+	}
+	r.mux.Unlock()
+	return r.schedule(op)
+}
+
+// decide runs consensus on the next operation and returns the decided one,
+// false for "no change". A decided operation the node does not know is
+// fetched from the peers. If none has it, ErrUnknownOp is returned and the
+// next call fetches it again instead of running consensus.
+func (r *Reconfiguration) decide(ctx context.Context) (ValidatorOp, bool, error) {
+	r.deciding.Lock()
+	defer r.deciding.Unlock()
+
+	r.mux.Lock()
+	decided := r.missing
+	r.mux.Unlock()
+
+	if decided == 0 {
+		// prefer the pending operation with the lowest key, so validators
+		// that know the same operations start with the same preference
+		preference := 0
+		if ops := r.Pending(); len(ops) > 0 {
+			preference = ops[0].Key()
+		}
+
+		r.engine.UpdatePreference(preference)
+		r.engine.Sync()
+
+		if !r.engine.Accepted() {
+			return ValidatorOp{}, false, fmt.Errorf("epoch %v: %w", r.set.Epoch(), ErrNotDecided)
+		}
+
+		decided = r.engine.Preference()
+		if decided == 0 {
+			return ValidatorOp{}, false, nil
+		}
+	}
+
+	r.mux.Lock()
+	op, ok := r.pending[decided]
+	r.mux.Unlock()
+	if !ok {
+		var err error
+		if op, err = r.fetch(ctx, decided); err != nil {
+			r.mux.Lock()
+			r.missing = decided
+			r.mux.Unlock()
+			return ValidatorOp{}, false, fmt.Errorf("epoch %v: %w", r.set.Epoch(), err)
+		}
+	}
+
+	r.mux.Lock()
+	r.missing = 0
+	delete(r.pending, decided)
+	r.decided[decided] = decidedOp{op: op, epoch: r.set.Epoch()}
+	r.mux.Unlock()
+	return op, true, nil
+}
+
+// fetch asks the peers for the operation of a key decided by consensus. The
+// operation must be signed by a validator, as the key is short enough to be
+// forged.
+func (r *Reconfiguration) fetch(ctx context.Context, key int) (ValidatorOp, error) {
+	request := &proto.MessageRequest{Type: proto.MessageType_OPERATION, Value: []byte(strconv.Itoa(key))}
+	for _, peer := range r.node.PeerManager.GetPeers() {
+		conn, err := r.node.PeerManager.GetConnection(peer)
+		if err != nil {
+			continue
+		}
+
+		rctx, cancel := context.WithTimeout(ctx, opRequestTimeout)
+		response, err := proto.NewMessageServiceClient(conn).ReceiveMessage(rctx, request)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		var op ValidatorOp
+		if err := json.Unmarshal(response.Value, &op); err == nil && op.Key() == key && r.verify(op) == nil {
+			return op, nil
+		}
+	}
+	return ValidatorOp{}, fmt.Errorf("%w: %v", ErrUnknownOp, key)
+}
+
+// serveOp answers the request of a peer for the operation of a key, pending
+// or decided.
+func (r *Reconfiguration) serveOp(_ context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	key, err := strconv.Atoi(string(request.Value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid operation key: %w", err)
+	}
+
+	r.mux.Lock()
+	d, ok := r.decided[key]
+	op := d.op
+	if !ok {
+		op, ok = r.pending[key]
+	}
+	r.mux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownOp, key)
+	}
+
+	value, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.MessageResponse{Type: request.Type, Value: value}, nil
+}
+
+// schedule queues an operation to the validator set for the next epoch.
+func (r *Reconfiguration) schedule(op ValidatorOp) error {
+	switch op.Kind {
+	case OpAdd:
+		return r.set.Add(op.Validator)
+	case OpRemove:
+		return r.set.Remove(op.Validator.ID)
+	case OpReweight:
+		return r.set.Reweight(op.Validator.ID, op.Validator.Weight)
+	}
+	return fmt.Errorf("unknown operation kind: %v", op.Kind)
+}
+
+// sameOp reports whether two operations are the same, not only their keys.
+func sameOp(a, b ValidatorOp) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+// verify checks that an operation is signed by a validator of the current set.
+func (r *Reconfiguration) verify(op ValidatorOp) error {
+	v, ok := r.set.Get(op.Proposer)
+	if !ok {
+		return fmt.Errorf("%w: proposer %v is not a validator", ErrInvalidOp, op.Proposer)
+	}
+	if len(v.PubKey) != ed25519.PublicKeySize || !ed25519.Verify(v.PubKey, op.SignBytes(), op.Signature) {
+		return fmt.Errorf("%w: bad signature of %v", ErrInvalidOp, op.Proposer)
+	}
+	return nil
+}
+
+// check checks that an operation is signed by a validator and applies to the
+// current validator set.
+func (r *Reconfiguration) check(op ValidatorOp) error {
+	if err := r.verify(op); err != nil {
+		return err
+	}
+	c, err := op.change()
+	if err != nil {
+		return err
+	}
+	if err := r.set.check(c); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOp, err)
+	}
+	return nil
+}
+
+// addPending adds a checked operation to the pending operations, it returns
+// false if the operation is already known. An operation of the key of another
+// one, or beyond the limits of pending operations, is rejected.
+func (r *Reconfiguration) addPending(op ValidatorOp) (bool, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	key := op.Key()
+	if known, ok := r.pending[key]; ok {
+		if !sameOp(known, op) {
+			r.node.Logger().Warn("validator operation key collision", logger.F("key", key), logger.F("proposer", op.Proposer))
+			return false, fmt.Errorf("%w: %v", ErrOpCollision, key)
+		}
+		return false, nil
+	}
+	if known, ok := r.decided[key]; ok && sameOp(known.op, op) {
+		return false, nil
+	}
+
+	proposed := 0
+	for _, p := range r.pending {
+		if p.Proposer == op.Proposer {
+			proposed++
+		}
+	}
+	if len(r.pending) >= maxPendingOps || proposed >= maxProposerOps {
+		return false, fmt.Errorf("%w: %d pending, %d of %v", ErrTooManyOps, len(r.pending), proposed, op.Proposer)
+	}
+
+	r.pending[key] = op
+	return true, nil
+}
+
+// prune forgets the operations decided more than decidedEpochs ago, and the
+// pending operations that no longer apply to the validator set of an epoch.
+func (r *Reconfiguration) prune(epoch uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for key, d := range r.decided {
+		if d.epoch+decidedEpochs < epoch {
+			delete(r.decided, key)
+		}
+	}
+	for key, op := range r.pending {
+		if err := r.check(op); err != nil {
+			delete(r.pending, key)
+		}
+	}
+}
+
+// gossip sends an operation to all known peers, within the trace of ctx.
+func (r *Reconfiguration) gossip(ctx context.Context, op ValidatorOp) {
+	ctx, span := r.node.Tracer().Start(ctx, "reconfig.gossip", tracing.Attr("op", op.Key()))
+	defer span.End()
+
+	value, err := json.Marshal(op)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	for _, peer := range r.node.PeerManager.GetPeers() {
+		conn, err := r.node.PeerManager.GetConnection(peer)
+		if err != nil {
+			continue
+		}
+
+		_ = r.node.MessageManager.SendMessage(ctx, conn, &proto.MessageRequest{
+			Type:  proto.MessageType_RECONFIG,
+			Value: value,
+		})
+	}
+}
+
+// receive handles an operation gossiped by a peer and forwards it if it is new
+// and valid.
+func (r *Reconfiguration) receive(ctx context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	var op ValidatorOp
+	if err := json.Unmarshal(request.Value, &op); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOp, err)
+	}
+	if err := r.check(op); err != nil {
+		return nil, err
+	}
+
+	added, err := r.addPending(op)
+	if added {
+		go r.gossip(tracing.Detach(ctx), op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proto.MessageResponse{Type: request.Type}, nil
+}
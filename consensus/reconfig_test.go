@@ -0,0 +1,445 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/tracing"
+	"simple-p2p/transport"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signingValidators returns validators node-0, node-1... of weight 1 at
+// consecutive ports, and their keys.
+func signingValidators(numNode int, port int) ([]Validator, []ed25519.PrivateKey) {
+	var (
+		validators []Validator
+		keys       []ed25519.PrivateKey
+	)
+	for i := 0; i < numNode; i++ {
+		pub, key, _ := ed25519.GenerateKey(nil)
+		validators = append(validators, Validator{
+			ID:      fmt.Sprintf("node-%d", i),
+			Address: fmt.Sprintf("%v:%d", host, port+i),
+			Weight:  1,
+			PubKey:  pub,
+		})
+		keys = append(keys, key)
+	}
+	return validators, keys
+}
+
+func TestReconfiguration(t *testing.T) {
+	numNode := 4
+	params := SnowParams{K: 2, A: 2, B: 5, MaxStep: 100}
+	validators, keys := signingValidators(numNode, 9470)
+
+	network := transport.NewMemory()
+	reconfigs := make([]*Reconfiguration, numNode)
+	for i := 0; i < numNode; i++ {
+		newNode := createNode(network, 9470+int64(i))
+
+		set, err := NewValidatorSet(validators...)
+		assert.NoError(t, err)
+
+		reconfigs[i] = NewReconfiguration(newNode, set, params)
+		reconfigs[i].SetKey(keys[i])
+		proto.RegisterConsensusServiceServer(newNode.Server, NewService(reconfigs[i].Consensus()))
+
+		newNode.StartServer()
+	}
+
+	// connect all nodes
+	for i := 0; i < numNode; i++ {
+		for _, v := range validators {
+			reconfigs[i].node.PeerManager.AddPeers(v.Address)
+		}
+	}
+
+	// node-3 leaves and node-0 gets more weight, one operation per epoch
+	assert.NoError(t, reconfigs[0].Propose(ValidatorOp{Kind: OpRemove, Validator: Validator{ID: "node-3"}}))
+	assert.NoError(t, reconfigs[1].Propose(ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: 3}}))
+
+	// wait for the operations to be gossiped
+	assert.Eventually(t, func() bool {
+		for i := 0; i < numNode; i++ {
+			if len(reconfigs[i].Pending()) != 2 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		var wg sync.WaitGroup
+		for i := 0; i < numNode; i++ {
+			wg.Add(1)
+			go func(r *Reconfiguration) {
+				defer wg.Done()
+				got, err := r.Reconfigure()
+				assert.NoError(t, err)
+				assert.Equal(t, epoch, got)
+			}(reconfigs[i])
+		}
+		wg.Wait()
+	}
+
+	// every node ends up with the same validator set
+	for i := 0; i < numNode; i++ {
+		assert.Empty(t, reconfigs[i].Pending())
+		assert.Equal(t, map[string]uint64{
+			validators[0].Address: 3,
+			validators[1].Address: 1,
+			validators[2].Address: 1,
+		}, reconfigs[i].set.Weights())
+	}
+}
+
+func TestReconfigurationTracing(t *testing.T) {
+	network := transport.NewMemory()
+	recorder := tracing.NewRecorder()
+	tracer := tracing.NewTracer("reconfig", recorder)
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	set, err := NewValidatorSet(Validator{ID: "node-0", Address: "node-0", Weight: 1, PubKey: pub})
+	assert.NoError(t, err)
+
+	var reconfigs []*Reconfiguration
+	for i := 0; i < 3; i++ {
+		n := node.NewNode(fmt.Sprintf("node-%d", i), node.WithTransport(network), node.WithTracer(tracer))
+		reconfigs = append(reconfigs, NewReconfiguration(n, set, SnowParams{K: 1, A: 1, B: 1, MaxStep: 10}))
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+	}
+
+	reconfigs[0].SetKey(key)
+
+	// a line: node-0 only knows node-1, which only knows node-2
+	reconfigs[0].node.PeerManager.AddPeers("node-1")
+	reconfigs[1].node.PeerManager.AddPeers("node-2")
+
+	assert.NoError(t, reconfigs[0].Propose(ValidatorOp{Kind: OpRemove, Validator: Validator{ID: "node-0"}}))
+	assert.Eventually(t, func() bool {
+		return len(reconfigs[2].Pending()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the message is followed hop by hop in a single trace
+	var last tracing.SpanData
+	assert.Eventually(t, func() bool {
+		for _, s := range recorder.Spans() {
+			if s.Kind == tracing.KindServer && s.Attribute("node") == "node-2" {
+				last = s
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	byID := make(map[tracing.SpanID]tracing.SpanData)
+	for _, s := range recorder.Spans() {
+		byID[s.Context.SpanID] = s
+	}
+
+	var hops []string
+	for s, ok := last, true; ok; s, ok = byID[s.Parent] {
+		assert.Equal(t, last.Context.TraceID, s.Context.TraceID)
+		hops = append(hops, fmt.Sprintf("%v %v", s.Attribute("node"), s.Name))
+	}
+	assert.Equal(t, []string{
+		"node-2 /p2p.MessageService/ReceiveMessage",
+		"node-1 /p2p.MessageService/ReceiveMessage",
+		"node-1 reconfig.gossip",
+		"node-1 /p2p.MessageService/ReceiveMessage",
+		"node-0 /p2p.MessageService/ReceiveMessage",
+		"node-0 reconfig.gossip",
+	}, hops)
+}
+
+// newReconfigurations creates connected nodes with the reconfiguration of a
+// validator set of all of them, of weight 1 each.
+func newReconfigurations(t *testing.T, numNode int, port int) []*Reconfiguration {
+	return newNetworkReconfigurations(t, transport.NewMemory(), numNode, port)
+}
+
+// newNetworkReconfigurations is newReconfigurations on a given network.
+func newNetworkReconfigurations(t *testing.T, network *transport.Memory, numNode int, port int) []*Reconfiguration {
+	params := SnowParams{K: 2, A: 2, B: 3, MaxStep: 100}
+	validators, keys := signingValidators(numNode, port)
+
+	var reconfigs []*Reconfiguration
+	for i := 0; i < numNode; i++ {
+		n := createNode(network, int64(port+i))
+		set, err := NewValidatorSet(validators...)
+		assert.NoError(t, err)
+
+		r := NewReconfiguration(n, set, params)
+		r.SetKey(keys[i])
+		proto.RegisterConsensusServiceServer(n.Server, NewService(r.Consensus()))
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		reconfigs = append(reconfigs, r)
+	}
+	for _, r := range reconfigs {
+		for _, v := range validators {
+			r.node.PeerManager.AddPeers(v.Address)
+		}
+	}
+	return reconfigs
+}
+
+func TestReconfigurationUnknownOp(t *testing.T) {
+	reconfigs := newReconfigurations(t, 4, 9540)
+
+	// the operation does not reach the last node, which fetches it once decided
+	op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-1", Weight: 4}}.Sign(reconfigs[0].key, "node-0")
+	for _, r := range reconfigs[:3] {
+		added, err := r.addPending(op)
+		assert.True(t, added)
+		assert.NoError(t, err)
+	}
+	fetched, err := reconfigs[3].fetch(context.Background(), op.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, op, fetched)
+	_, err = reconfigs[3].fetch(context.Background(), op.Key()+1)
+	assert.ErrorIs(t, err, ErrUnknownOp)
+
+	// whatever is decided, every node moves to the same epoch and set
+	var wg sync.WaitGroup
+	for _, r := range reconfigs {
+		wg.Add(1)
+		go func(r *Reconfiguration) {
+			defer wg.Done()
+			epoch, err := r.Reconfigure()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(1), epoch)
+		}(r)
+	}
+	wg.Wait()
+	for _, r := range reconfigs[1:] {
+		assert.Equal(t, reconfigs[0].set.Weights(), r.set.Weights())
+	}
+}
+
+func TestReconfigurationMissingOp(t *testing.T) {
+	reconfigs := newReconfigurations(t, 2, 9545)
+
+	// the node that decides an operation nobody serves stays in its epoch,
+	// then fetches the same operation once a peer has it
+	op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-1", Weight: 4}}.Sign(reconfigs[1].key, "node-1")
+	r := reconfigs[0]
+	r.missing = op.Key()
+	epoch, err := r.Reconfigure()
+	assert.ErrorIs(t, err, ErrUnknownOp)
+	assert.Equal(t, uint64(0), epoch)
+	assert.Equal(t, uint64(2), r.set.TotalWeight())
+
+	_, err = reconfigs[1].addPending(op)
+	assert.NoError(t, err)
+	epoch, err = r.Reconfigure()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, uint64(5), r.set.TotalWeight())
+}
+
+func TestReconfigurationAtBlocks(t *testing.T) {
+	network := transport.NewMemory()
+	reconfigs := newNetworkReconfigurations(t, network, 3, 9550)
+	for _, r := range reconfigs {
+		r.AtBlocks(2)
+	}
+	op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: 3}}.Sign(reconfigs[0].key, "node-0")
+	assert.NoError(t, reconfigs[0].Propose(op))
+	assert.Eventually(t, func() bool {
+		for _, r := range reconfigs {
+			if len(r.Pending()) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	finalize := func(height int, op []byte) {
+		for _, r := range reconfigs {
+			_, err := r.node.FinalizeOp(height, nil, op)
+			assert.NoError(t, err)
+		}
+	}
+
+	// the operation is decided during the first epoch
+	finalize(1, nil)
+	finalize(2, nil)
+	assert.Eventually(t, func() bool {
+		for _, r := range reconfigs {
+			if next, ok := r.Next(); !ok || !sameOp(next, op) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, r := range reconfigs {
+		assert.Equal(t, uint64(1), r.set.Epoch())
+		assert.Equal(t, uint64(3), r.set.TotalWeight())
+		assert.True(t, r.Boundary(4))
+		assert.False(t, r.Boundary(3))
+	}
+
+	// a boundary block that does not record it changes nothing
+	finalize(3, nil)
+	finalize(4, nil)
+	for _, r := range reconfigs {
+		assert.Equal(t, uint64(2), r.set.Epoch())
+		assert.Equal(t, uint64(3), r.set.TotalWeight())
+		_, ok := r.Next()
+		assert.True(t, ok)
+	}
+
+	// the operation recorded in the next boundary block is applied by every node
+	recorded, err := json.Marshal(op)
+	assert.NoError(t, err)
+	finalize(5, nil)
+	finalize(6, recorded)
+	for _, r := range reconfigs {
+		assert.Equal(t, uint64(3), r.set.Epoch())
+		assert.Equal(t, uint64(5), r.set.TotalWeight())
+		_, ok := r.Next()
+		assert.False(t, ok)
+	}
+
+	// a node that syncs the blocks never decides, and applies it too
+	n := createNode(network, 9560)
+	set, err := NewValidatorSet(reconfigs[0].set.Validators()...)
+	assert.NoError(t, err)
+	synced := NewReconfiguration(n, set, SnowParams{K: 2, A: 2, B: 3, MaxStep: 100})
+	synced.AtBlocks(2)
+	synced.node.PeerManager.AddPeers(reconfigs[0].node.Address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, synced.node.SyncChain(ctx))
+	assert.Equal(t, uint64(6), synced.node.Chain.Height())
+	assert.Equal(t, uint64(3), synced.set.Epoch())
+	assert.Equal(t, reconfigs[0].set.Weights(), synced.set.Weights())
+}
+
+func TestBlockBuilderRecordsOp(t *testing.T) {
+	reconfigs := newReconfigurations(t, 1, 9570)
+	r := reconfigs[0]
+	r.AtBlocks(2)
+
+	engine := NewConsensus(SnowParams{K: 1, A: 1, B: 1, MaxStep: 10})
+	engine.AddNode(r.node)
+	b := NewBlockBuilder(r.node, engine, mempool.New(r.node))
+	b.SetReconfiguration(r)
+
+	// a proposal with an operation the node never heard of is rejected
+	op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: 3}}.Sign(r.key, "node-0")
+	value, err := json.Marshal(Proposal{Op: &op})
+	assert.NoError(t, err)
+	_, err = b.receive(context.Background(), &proto.MessageRequest{Type: proto.MessageType_PROPOSAL, Value: value})
+	assert.ErrorIs(t, err, ErrUnknownOp)
+
+	// the decided operation is proposed for the boundary block only
+	r.mux.Lock()
+	r.decided[op.Key()] = decidedOp{op: op}
+	r.next = &op
+	r.mux.Unlock()
+	assert.Nil(t, b.nextOp())
+	_, err = r.node.Finalize(1, nil)
+	assert.NoError(t, err)
+
+	b.Propose(context.Background())
+	assert.Equal(t, 1, b.Proposals())
+	p := Proposal{Op: &op}
+	assert.Equal(t, p.Key(), engine.Preference())
+
+	b.decide(p.Key())
+	block, ok := r.node.Chain.Get(2)
+	assert.True(t, ok)
+	recorded, err := json.Marshal(op)
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, block.Op)
+	assert.Equal(t, uint64(1), r.set.Epoch())
+	assert.Equal(t, uint64(3), r.set.TotalWeight())
+	assert.Equal(t, 0, b.Proposals())
+}
+
+// collidingOps returns two different operations of the same key.
+func collidingOps() (ValidatorOp, ValidatorOp) {
+	seen := make(map[int]ValidatorOp)
+	for i := uint64(1); ; i++ {
+		op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-1", Weight: i}, Proposer: "node-0"}
+		if other, ok := seen[op.Key()]; ok {
+			return other, op
+		}
+		seen[op.Key()] = op
+	}
+}
+
+func TestReconfigurationChecks(t *testing.T) {
+	reconfigs := newReconfigurations(t, 2, 9580)
+	r := reconfigs[0]
+	receive := func(op ValidatorOp) error {
+		value, err := json.Marshal(op)
+		assert.NoError(t, err)
+		_, err = r.receive(context.Background(), &proto.MessageRequest{Type: proto.MessageType_RECONFIG, Value: value})
+		return err
+	}
+
+	// only operations signed by a validator that apply to the set are kept
+	op := ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-1", Weight: 2}}
+	assert.ErrorIs(t, receive(op), ErrInvalidOp)
+	_, outsider, _ := ed25519.GenerateKey(nil)
+	assert.ErrorIs(t, receive(op.Sign(outsider, "node-1")), ErrInvalidOp)
+	forged := op.Sign(reconfigs[1].key, "node-1")
+	forged.Validator.Weight = 20
+	assert.ErrorIs(t, receive(forged), ErrInvalidOp)
+	for _, invalid := range []ValidatorOp{
+		{Kind: OpRemove, Validator: Validator{ID: "node-9"}},
+		{Kind: OpReweight, Validator: Validator{ID: "node-0"}},
+		{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: MaxTotalWeight}},
+		{Kind: OpAdd, Validator: Validator{ID: "node-9", Address: r.node.Address, Weight: 1}},
+		{Kind: OpAdd, Validator: Validator{ID: "node-1", Address: "elsewhere", Weight: 1}},
+		{Kind: 7, Validator: Validator{ID: "node-1"}},
+	} {
+		assert.ErrorIs(t, receive(invalid.Sign(reconfigs[1].key, "node-1")), ErrInvalidOp)
+	}
+	assert.Empty(t, r.Pending())
+	assert.NoError(t, receive(op.Sign(reconfigs[1].key, "node-1")))
+	assert.Len(t, r.Pending(), 1)
+
+	// a proposer only has a few pending operations
+	for i := 2; i <= maxProposerOps; i++ {
+		assert.NoError(t, receive(ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: uint64(i)}}.Sign(reconfigs[1].key, "node-1")))
+	}
+	err := receive(ValidatorOp{Kind: OpReweight, Validator: Validator{ID: "node-0", Weight: 99}}.Sign(reconfigs[1].key, "node-1"))
+	assert.ErrorIs(t, err, ErrTooManyOps)
+	assert.Len(t, r.Pending(), maxProposerOps)
+
+	// an operation of the key of another one is rejected
+	a, b := collidingOps()
+	added, err := reconfigs[1].addPending(a)
+	assert.True(t, added)
+	assert.NoError(t, err)
+	added, err = reconfigs[1].addPending(b)
+	assert.False(t, added)
+	assert.ErrorIs(t, err, ErrOpCollision)
+
+	// the decided operations are forgotten after a few epochs, and the
+	// pending ones once they no longer apply
+	r.mux.Lock()
+	r.decided[op.Key()] = decidedOp{op: op, epoch: 0}
+	r.mux.Unlock()
+	r.prune(decidedEpochs)
+	assert.True(t, r.Known(op))
+	assert.NoError(t, r.set.Remove("node-1"))
+	r.set.NextEpoch()
+	r.prune(decidedEpochs + 1)
+	assert.False(t, r.Known(op))
+	assert.Empty(t, r.Pending())
+}
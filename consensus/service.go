@@ -0,0 +1,20 @@
+package consensus
+
+import (
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+)
+
+var _ node.Engine = (Consensus)(nil)
+
+// NewService creates a consensus service for the given instances. Only one
+// consensus service can be registered to a gRPC server, so instances with
+// different topics must share it. Nodes created with node.WithConsensus
+// register it themselves.
+func NewService(engines ...Consensus) proto.ConsensusServiceServer {
+	nodeEngines := make([]node.Engine, 0, len(engines))
+	for _, engine := range engines {
+		nodeEngines = append(nodeEngines, engine)
+	}
+	return node.NewConsensusService(nodeEngines...)
+}
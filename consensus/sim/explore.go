@@ -0,0 +1,111 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Distribution summarizes samples of a quantity.
+type Distribution struct {
+	Count int
+	Mean  float64
+	Min   float64
+	P50   float64
+	P90   float64
+	P99   float64
+	Max   float64
+}
+
+// newDistribution summarizes the samples.
+func newDistribution(samples []float64) Distribution {
+	if len(samples) == 0 {
+		return Distribution{}
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	total := 0.0
+	for _, v := range sorted {
+		total += v
+	}
+
+	percentile := func(p float64) float64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+
+	return Distribution{
+		Count: len(sorted),
+		Mean:  total / float64(len(sorted)),
+		Min:   sorted[0],
+		P50:   percentile(0.50),
+		P90:   percentile(0.90),
+		P99:   percentile(0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// String returns the distribution on one line.
+func (d Distribution) String() string {
+	return fmt.Sprintf("mean=%.1f p50=%.1f p90=%.1f p99=%.1f max=%.1f", d.Mean, d.P50, d.P90, d.P99, d.Max)
+}
+
+// Summary aggregates the results of many simulations of the same config.
+type Summary struct {
+	Trials int
+
+	SafetyFailures   int // trials where honest nodes accepted different values
+	LivenessFailures int // trials where some honest node gave up
+
+	TimeToDecision Distribution // virtual milliseconds until an honest node accepts
+	Rounds         Distribution // rounds until an honest node accepts
+}
+
+// SafetyFailureProbability returns the share of trials that violated safety.
+func (s Summary) SafetyFailureProbability() float64 {
+	if s.Trials == 0 {
+		return 0
+	}
+	return float64(s.SafetyFailures) / float64(s.Trials)
+}
+
+// LivenessFailureProbability returns the share of trials where some honest node did not decide.
+func (s Summary) LivenessFailureProbability() float64 {
+	if s.Trials == 0 {
+		return 0
+	}
+	return float64(s.LivenessFailures) / float64(s.Trials)
+}
+
+// Explore runs the config for a number of trials, each with its own seed
+// derived from cfg.Seed, and summarizes the results.
+func Explore(cfg Config, trials int) Summary {
+	summary := Summary{Trials: trials}
+
+	var times, rounds []float64
+	for i := 0; i < trials; i++ {
+		trial := cfg
+		trial.Seed = cfg.Seed + int64(i)
+
+		result := Run(trial)
+		if !result.Safe {
+			summary.SafetyFailures++
+		}
+		if result.Decided < result.Honest {
+			summary.LivenessFailures++
+		}
+
+		for _, d := range result.Decisions {
+			times = append(times, float64(d)/float64(time.Millisecond))
+		}
+		for _, r := range result.Rounds {
+			rounds = append(rounds, float64(r))
+		}
+	}
+
+	summary.TimeToDecision = newDistribution(times)
+	summary.Rounds = newDistribution(rounds)
+	return summary
+}
@@ -0,0 +1,354 @@
+// Package sim is a discrete-event simulator of Snowball. It runs the same
+// step logic as the consensus package against a simulated population with a
+// virtual clock, so parameters can be explored without real nodes.
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"simple-p2p/consensus"
+	"simple-p2p/consensus/byzantine"
+	"sort"
+	"time"
+)
+
+// Latency returns the one-way delay of a message.
+type Latency func(r *rand.Rand) time.Duration
+
+// Constant is a latency that never changes.
+func Constant(d time.Duration) Latency {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Uniform is a latency uniformly distributed in [min, max).
+func Uniform(min, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// Exponential is an exponentially distributed latency with the given mean.
+func Exponential(mean time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// Config is the setup of a simulation.
+type Config struct {
+	Nodes       int                  // total number of nodes
+	Params      consensus.SnowParams // parameters of the honest nodes
+	Preferences []int                // initial preferences assigned round-robin to honest nodes
+
+	Byzantine float64            // fraction of Byzantine nodes
+	Behavior  byzantine.Behavior // behavior of Byzantine nodes, MinorityVote if nil
+
+	Latency  Latency // one-way delay of a message, 10ms if nil
+	DropRate float64 // probability that a message is lost
+
+	Seed int64 // seed of the random source
+}
+
+// Result is the outcome of a simulation from the point of view of the honest nodes.
+type Result struct {
+	Honest    int             // number of honest nodes
+	Decided   int             // number of honest nodes that accepted a value
+	Values    map[int]int     // number of honest nodes that accepted each value
+	Safe      bool            // no two honest nodes accepted different values
+	Decisions []time.Duration // virtual time of decision of each honest node that accepted, sorted
+	Rounds    []int           // rounds to decision of each honest node that accepted, sorted
+	Duration  time.Duration   // virtual time until every honest node stopped
+}
+
+// node is a simulated node.
+type node struct {
+	byzantine bool
+	state     consensus.Snowball
+
+	round         int      // rounds finished
+	done          bool     // accepted or gave up
+	pending       int      // queries of the current round without answer
+	votes         []int    // answers of the current round
+	voteWeights   []uint64 // weights of the answers of the current round
+	sampledWeight uint64   // K, the simulated peers are not weighted
+}
+
+// eventKind is the kind of a simulation event.
+type eventKind int
+
+const (
+	queryArrived eventKind = iota
+	answerArrived
+	roundTimeout
+)
+
+// event is something that happens at a virtual time.
+type event struct {
+	at    time.Duration
+	seq   int // keeps events at the same time in schedule order
+	kind  eventKind
+	from  int // querying node
+	to    int // queried node
+	round int // round of the querying node
+	value int // answer
+}
+
+// queue is a priority queue of events ordered by time.
+type queue []*event
+
+func (q queue) Len() int { return len(q) }
+func (q queue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// simulation is the state of a single run.
+type simulation struct {
+	cfg    Config
+	random *rand.Rand
+	nodes  []*node
+	events queue
+	now    time.Duration
+	seq    int
+
+	honestCache []int // preferences of the honest nodes, nil when stale
+	canceled    context.Context
+	result      Result
+}
+
+// Run runs one simulation until every honest node accepted a value or gave up.
+func Run(cfg Config) Result {
+	if cfg.Latency == nil {
+		cfg.Latency = Constant(10 * time.Millisecond)
+	}
+	if cfg.Behavior == nil {
+		cfg.Behavior = byzantine.MinorityVote{}
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := &simulation{
+		cfg:      cfg,
+		random:   rand.New(rand.NewSource(cfg.Seed)),
+		canceled: canceled,
+		result: Result{
+			Values: make(map[int]int),
+		},
+	}
+	s.init()
+
+	for i, n := range s.nodes {
+		if !n.byzantine {
+			s.startRound(i)
+		}
+	}
+
+	for s.events.Len() > 0 {
+		e := heap.Pop(&s.events).(*event)
+		s.now = e.at
+		s.handle(e)
+	}
+
+	sort.Slice(s.result.Decisions, func(i, j int) bool {
+		return s.result.Decisions[i] < s.result.Decisions[j]
+	})
+	sort.Ints(s.result.Rounds)
+	s.result.Safe = len(s.result.Values) <= 1
+	return s.result
+}
+
+// init creates the population, Byzantine nodes are picked at random.
+func (s *simulation) init() {
+	s.nodes = make([]*node, s.cfg.Nodes)
+
+	byzantines := int(s.cfg.Byzantine*float64(s.cfg.Nodes) + 0.5)
+	perm := s.random.Perm(s.cfg.Nodes)
+
+	honest := 0
+	for rank, i := range perm {
+		n := &node{byzantine: rank < byzantines}
+		s.nodes[i] = n
+	}
+
+	for _, n := range s.nodes {
+		if n.byzantine {
+			continue
+		}
+		if len(s.cfg.Preferences) > 0 {
+			n.state.Preference = s.cfg.Preferences[honest%len(s.cfg.Preferences)]
+		}
+		// every Sync starts with a confidence of 1
+		n.state.Confidence = 1
+		honest++
+	}
+	s.result.Honest = honest
+}
+
+// schedule adds an event after the given delay.
+func (s *simulation) schedule(delay time.Duration, e event) {
+	e.at = s.now + delay
+	e.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, &e)
+}
+
+// lost decides whether a message is dropped.
+func (s *simulation) lost() bool {
+	return s.cfg.DropRate > 0 && s.random.Float64() < s.cfg.DropRate
+}
+
+// sample returns up to K distinct peers of a node chosen uniformly.
+func (s *simulation) sample(self int) []int {
+	k := s.cfg.Params.K
+	if k > len(s.nodes)-1 {
+		k = len(s.nodes) - 1
+	}
+
+	chosen := make(map[int]bool, k)
+	peers := make([]int, 0, k)
+	for len(peers) < k {
+		p := s.random.Intn(len(s.nodes))
+		if p == self || chosen[p] {
+			continue
+		}
+		chosen[p] = true
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// startRound sends the queries of a new round of a node.
+func (s *simulation) startRound(i int) {
+	n := s.nodes[i]
+	peers := s.sample(i)
+
+	n.pending = len(peers)
+	n.votes = n.votes[:0]
+	n.voteWeights = n.voteWeights[:0]
+	n.sampledWeight = uint64(s.cfg.Params.K)
+
+	for _, p := range peers {
+		if s.lost() {
+			continue
+		}
+		s.schedule(s.cfg.Latency(s.random), event{kind: queryArrived, from: i, to: p, round: n.round})
+	}
+	s.schedule(s.cfg.Params.Timeout(), event{kind: roundTimeout, from: i, round: n.round})
+}
+
+// handle processes an event.
+func (s *simulation) handle(e *event) {
+	switch e.kind {
+	case queryArrived:
+		value, delay, ok := s.answer(e.to)
+		if !ok || s.lost() {
+			return
+		}
+		s.schedule(delay+s.cfg.Latency(s.random), event{kind: answerArrived, from: e.from, to: e.to, round: e.round, value: value})
+
+	case answerArrived:
+		n := s.nodes[e.from]
+		if n.done || n.round != e.round {
+			return
+		}
+
+		n.votes = append(n.votes, e.value)
+		n.voteWeights = append(n.voteWeights, 1)
+		n.pending--
+		if n.pending == 0 {
+			s.finishRound(e.from)
+		}
+
+	case roundTimeout:
+		n := s.nodes[e.from]
+		if n.done || n.round != e.round {
+			return
+		}
+		s.finishRound(e.from)
+	}
+}
+
+// answer returns the answer of a node to a query and its processing delay.
+func (s *simulation) answer(i int) (int, time.Duration, bool) {
+	n := s.nodes[i]
+	if !n.byzantine {
+		return n.state.Preference, 0, true
+	}
+
+	behavior, delay := s.cfg.Behavior, time.Duration(0)
+	for {
+		slow, ok := behavior.(byzantine.SlowVote)
+		if !ok {
+			break
+		}
+		behavior, delay = slow.Behavior, delay+slow.Delay
+	}
+
+	// behaviors that wait for the caller to give up, like DropVote, see a
+	// canceled context and never answer
+	value, err := behavior.Respond(s.canceled, s.honest)
+	if err != nil {
+		return 0, 0, false
+	}
+	return value, delay, true
+}
+
+// honest returns the current preferences of the honest nodes.
+func (s *simulation) honest() []int {
+	if s.honestCache == nil {
+		s.honestCache = make([]int, 0, s.result.Honest)
+		for _, n := range s.nodes {
+			if !n.byzantine {
+				s.honestCache = append(s.honestCache, n.state.Preference)
+			}
+		}
+	}
+	return s.honestCache
+}
+
+// finishRound applies the answers of the current round of a node, the same
+// way as a consensus step does, and starts the next round if needed.
+func (s *simulation) finishRound(i int) {
+	n := s.nodes[i]
+
+	before := n.state.Preference
+	n.state.Record(s.cfg.Params, n.votes, n.voteWeights, n.sampledWeight)
+	n.round++
+
+	if n.state.Preference != before {
+		s.honestCache = nil
+	}
+
+	switch {
+	case n.state.Accepted:
+		n.done = true
+		s.result.Decided++
+		s.result.Values[n.state.Preference]++
+		s.result.Decisions = append(s.result.Decisions, s.now)
+		s.result.Rounds = append(s.result.Rounds, n.round)
+	case n.round > s.cfg.Params.MaxStep:
+		n.done = true
+	default:
+		s.startRound(i)
+		return
+	}
+
+	if s.now > s.result.Duration {
+		s.result.Duration = s.now
+	}
+}
@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/consensus"
+	"simple-p2p/consensus/byzantine"
+	"testing"
+	"time"
+)
+
+var params = consensus.SnowParams{K: 10, A: 7, B: 15, MaxStep: 200, QueryTimeout: 100 * time.Millisecond}
+
+func TestRun(t *testing.T) {
+	cfg := Config{
+		Nodes:       2000,
+		Params:      params,
+		Preferences: []int{1, 2},
+		Byzantine:   0.1,
+		Latency:     Uniform(5*time.Millisecond, 50*time.Millisecond),
+		DropRate:    0.01,
+		Seed:        1,
+	}
+
+	result := Run(cfg)
+	assert.Equal(t, 1800, result.Honest)
+	assert.Equal(t, result.Honest, result.Decided)
+	assert.True(t, result.Safe)
+	assert.Len(t, result.Decisions, result.Decided)
+	assert.GreaterOrEqual(t, result.Rounds[0], params.B-1)
+
+	// the same seed replays the same run
+	assert.Equal(t, result, Run(cfg))
+}
+
+func TestBehaviors(t *testing.T) {
+	behaviors := []byzantine.Behavior{
+		byzantine.FixedVote(3),
+		byzantine.RandomVote{1, 2, 3},
+		byzantine.DropVote{},
+		byzantine.SlowVote{Delay: 20 * time.Millisecond, Behavior: byzantine.FixedVote(2)},
+	}
+
+	for _, behavior := range behaviors {
+		result := Run(Config{
+			Nodes:       500,
+			Params:      params,
+			Preferences: []int{1, 2},
+			Byzantine:   0.1,
+			Behavior:    behavior,
+			Seed:        1,
+		})
+		assert.True(t, result.Safe)
+		assert.Equal(t, result.Honest, result.Decided)
+	}
+}
+
+func TestExplore(t *testing.T) {
+	summary := Explore(Config{
+		Nodes:       200,
+		Params:      consensus.SnowParams{K: 4, A: 3, B: 2, MaxStep: 50},
+		Preferences: []int{1, 2},
+		Byzantine:   0.2,
+		Seed:        1,
+	}, 20)
+
+	assert.Equal(t, 20, summary.Trials)
+	assert.Greater(t, summary.TimeToDecision.Count, 0)
+	assert.LessOrEqual(t, summary.TimeToDecision.P50, summary.TimeToDecision.P99)
+
+	// a low decision threshold against a splitting adversary is unsafe
+	assert.Greater(t, summary.SafetyFailureProbability(), 0.0)
+}
@@ -2,11 +2,18 @@ package consensus
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-p2p/logger"
 	"simple-p2p/node"
 	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/tracing"
 	"simple-p2p/utils"
 	"sync"
+	"time"
 )
 
 type Consensus interface {
@@ -17,8 +24,20 @@ type Consensus interface {
 	// Sync starts the consensus process.
 	Sync()
 
+	// Stop stops the running Sync after its current round.
+	Stop()
+
+	// Accepted reports whether the last Sync accepted the preference.
+	Accepted() bool
+
+	// Topic returns the topic that identifies the consensus instance.
+	Topic() string
+
+	// Status returns a snapshot of the consensus state.
+	Status() Status
+
 	// GetPreference is internal call to perform a single step of the consensus
-	GetPreference(context.Context, *proto.Empty) (*proto.GetPreferenceResponse, error)
+	GetPreference(context.Context, *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error)
 
 	// AddNode adds a node to the consensus.
 	AddNode(*node.Node)
@@ -28,126 +47,430 @@ type Consensus interface {
 
 	// UpdatePreference updates the preference of the node.
 	UpdatePreference(int)
+
+	// SetValidators restricts sampling to the given validators and counts
+	// votes by their weights. Without validators every peer has weight 1.
+	SetValidators(*ValidatorSet)
+
+	// Validators returns the validator set of the consensus, nil if not set.
+	Validators() *ValidatorSet
+
+	// OnDecide registers a function called with the accepted value each time
+	// a Sync accepts a value.
+	OnDecide(func(value int))
+
+	// SetHeight scopes the queries to the blocks of the chain, height returns
+	// the height being decided. A peer answers a query for a lower height with
+	// the value of its block at that height, and does not vote for a higher
+	// one, so nodes at different heights do not mix their decisions.
+	SetHeight(height func() uint64)
 }
 
 var _ Consensus = (*consensus)(nil)
 
 type consensus struct {
 	SnowParams
-	Node *node.Node
-
-	preference int          // preference of the node
-	confident  int          // confidence of the node
-	accepted   bool         // accepted value of the node
-	isRunning  bool         // consensus is running
-	mux        sync.RWMutex // mutual exclusion lock for peers
+	Node       *node.Node
+	topic      string        // topic of the consensus instance
+	validators *ValidatorSet // voters of the consensus, nil means all peers
+
+	state     Snowball      // decision state of the node
+	isRunning bool          // consensus is running
+	stopped   bool          // the running Sync must stop
+	round     int           // round of the current or last Sync
+	onDecide  []func(int)   // called when a Sync accepts a value
+	height    func() uint64 // height being decided, nil if the instance does not decide blocks
+	mux       sync.RWMutex  // mutual exclusion lock for the state above
+	syncMux   sync.Mutex    // only one Sync runs at a time
+
+	logger logger.Logger   // logger of the node, with the topic
+	tracer *tracing.Tracer // tracer of the node, with the topic
+	store  storage.Store   // store of the node, nil if the state is kept in memory only
 }
 
 type SnowParams struct {
 	K       int // K sample K of each round of query. K < number_of_peers
-	A       int // A is quorum size. A < K
+	A       int // A is quorum size. A < K. With weights, A/K of the sampled weight
 	B       int // B is decision threshold
 	MaxStep int // MaxStep is the maximum number of rounds of query
+
+	QueryTimeout time.Duration // QueryTimeout bounds a single query, zero means defaultQueryTimeout
+}
+
+// Timeout returns the timeout of a single query.
+func (p SnowParams) Timeout() time.Duration {
+	if p.QueryTimeout == 0 {
+		return defaultQueryTimeout
+	}
+	return p.QueryTimeout
 }
 
+// Snowball is the decision state of a Snowball instance. The consensus and
+// the simulator share it, so both run the same step logic.
+type Snowball struct {
+	Preference int  // preferred value
+	Confidence int  // consecutive successful rounds for the preference
+	Accepted   bool // the preference is accepted
+}
+
+// Record updates the state with the votes of one round. votes and weights are
+// the answers received and the weights of their voters, sampledWeight is the
+// weight of all sampled peers including those that did not answer. Without
+// weights, sampledWeight is K, so the slots of the peers that were not
+// sampled count as votes against and a value needs A votes.
+func (s *Snowball) Record(params SnowParams, votes []int, weights []uint64, sampledWeight uint64) {
+	// get the value with the most weight from responses
+	value, weight := utils.GetMostWeightedValue(votes, weights)
+
+	// check if the weight of the value reaches A out of K of the sampled weight
+	if weight > 0 && weight*uint64(params.K) >= uint64(params.A)*sampledWeight {
+		oldPreference := s.Preference
+
+		s.Preference = value
+
+		// check if preference is changed, the confidence is reset to 1
+		// otherwise, the confidence is increased by 1
+		if oldPreference != s.Preference {
+			s.Confidence = 1
+		} else {
+			s.Confidence++
+
+			// check if confidence is greater than B, the value is accepted
+			if s.Confidence >= params.B {
+				s.Accepted = true
+			}
+		}
+	} else {
+		s.Confidence = 0
+	}
+}
+
+// Status is a snapshot of the state of a consensus instance.
+type Status struct {
+	Topic      string `json:"topic"`
+	Preference int    `json:"preference"`
+	Confidence int    `json:"confidence"`
+	Round      int    `json:"round"`
+	Accepted   bool   `json:"accepted"`
+	Running    bool   `json:"running"`
+}
+
+var defaultQueryTimeout = 5 * time.Second // timeout of a query when SnowParams.QueryTimeout is not set
+
 // NewConsensus creates a new consensus instance.
 func NewConsensus(params SnowParams) Consensus {
+	return NewTopicConsensus("", params)
+}
+
+// NewTopicConsensus creates a new consensus instance for a topic. Instances
+// with different topics run independently on the same node.
+func NewTopicConsensus(topic string, params SnowParams) Consensus {
 	return &consensus{
 		SnowParams: params,
+		topic:      topic,
+		logger:     logger.Nop(),
 	}
 }
 
 // Preference returns the preference of the node.
 func (c *consensus) Preference() int {
-	return c.preference
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.state.Preference
 }
 
-// Sync starts the consensus process.
-func (c *consensus) Sync() {
-	c.mux.Lock()
-	defer c.mux.Unlock()
+// Accepted reports whether the last Sync accepted the preference.
+func (c *consensus) Accepted() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.state.Accepted
+}
+
+// Status returns a snapshot of the consensus state.
+func (c *consensus) Status() Status {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return Status{
+		Topic:      c.topic,
+		Preference: c.state.Preference,
+		Confidence: c.state.Confidence,
+		Round:      c.round,
+		Accepted:   c.state.Accepted,
+		Running:    c.isRunning,
+	}
+}
+
+// Topic returns the topic of the consensus instance.
+func (c *consensus) Topic() string {
+	return c.topic
+}
 
-	c.confident = 1
-	c.accepted = false
+// Sync starts the consensus process, unless the node is not a voter yet.
+func (c *consensus) Sync() {
+	if c.Node != nil && !c.Node.Voter() {
+		c.logger.Warn("consensus not started, node is syncing its chain")
+		return
+	}
 
-	i := 0
-	for ; c.accepted == false; i++ {
-		fmt.Printf("Node %v: Round %d: preference = %d, confident = %d, accepted = %t \n", c.Node.Address, i, c.preference, c.confident, c.accepted)
+	c.syncMux.Lock()
+	defer c.syncMux.Unlock()
 
-		c.step()
+	c.mux.Lock()
+	c.state.Confidence = 1
+	c.state.Accepted = false
+	c.isRunning = true
+	c.stopped = false
+	c.round = 0
+	c.mux.Unlock()
+
+	defer func() {
+		c.mux.Lock()
+		c.isRunning = false
+		c.mux.Unlock()
+	}()
+
+	ctx, span := c.tracer.Start(context.Background(), "consensus.sync")
+	defer span.End()
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		status := c.Status()
+		span.SetAttributes(tracing.Attr("rounds", i), tracing.Attr("preference", status.Preference), tracing.Attr("accepted", status.Accepted))
+		if status.Accepted {
+			c.logger.Info("consensus succeeded", logger.F("round", i), logger.F("preference", status.Preference))
+			decisionsTotal.With(c.topic).Inc()
+			timeToDecision.With(c.topic).Observe(time.Since(start).Seconds())
+			c.decide(status.Preference)
+			return
+		}
 
 		if i > c.MaxStep {
-			fmt.Printf("Node %v: Consensus failed \n", c.Node.Address)
-			break
+			c.logger.Warn("consensus failed", logger.F("round", i), logger.F("preference", status.Preference))
+			failuresTotal.With(c.topic).Inc()
+			span.RecordError(fmt.Errorf("no decision after %d rounds", i))
+			return
 		}
+
+		if c.isStopped() {
+			c.logger.Info("consensus stopped", logger.F("round", i))
+			return
+		}
+
+		c.logger.Debug("round", logger.F("round", i), logger.F("preference", status.Preference), logger.F("confidence", status.Confidence), logger.F("accepted", status.Accepted))
+
+		c.step(ctx, i)
+	}
+}
+
+// Stop stops the running Sync after its current round.
+func (c *consensus) Stop() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.isRunning {
+		c.stopped = true
 	}
+}
+
+// isStopped reports whether the running Sync must stop.
+func (c *consensus) isStopped() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
 
-	fmt.Printf("Node %v: Consensus succeeded after %v rounds \n", c.Node.Address, i)
+	return c.stopped
+}
+
+// decide calls the decision hooks with the accepted value.
+func (c *consensus) decide(value int) {
+	c.mux.RLock()
+	hooks := append([]func(int){}, c.onDecide...)
+	c.mux.RUnlock()
+
+	for _, hook := range hooks {
+		hook(value)
+	}
 }
 
 // step performs a single step of the consensus.
-func (c *consensus) step() {
-	// get K peers from the peer manager
-	kPeers := c.Node.PeerManager.GetSamplePeers(c.K)
-
-	// send query to each peer
-	responses := make([]int, c.K)
-	for i, peer := range kPeers {
-		// get connection of the peer
-		conn, err := c.Node.PeerManager.GetConnection(peer)
-		if err != nil {
-			continue
+func (c *consensus) step(ctx context.Context, round int) {
+	ctx, span := c.tracer.Start(ctx, "consensus.round", tracing.Attr("round", round))
+	defer span.End()
+
+	// get K peers and their voting weights
+	kPeers, weights := c.samplePeers()
+	weighted := c.Validators() != nil
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	// send query to each peer in parallel, a peer that does not answer in
+	// time does not vote
+	var (
+		votes         = make([]int, 0, len(kPeers))
+		voteWeights   = make([]uint64, 0, len(kPeers))
+		sampledWeight uint64
+		votesMux      sync.Mutex
+		waiter        sync.WaitGroup
+	)
+	if !weighted {
+		sampledWeight = uint64(c.K)
+	}
+	for _, peer := range kPeers {
+		if weighted {
+			sampledWeight += weights[peer]
 		}
 
-		// create a proto client
-		client := proto.NewConsensusServiceClient(conn)
+		waiter.Add(1)
+		go func(peer string) {
+			defer waiter.Done()
 
-		// send query
-		response, err := client.GetPreference(context.Background(), &proto.Empty{})
-		if err != nil {
-			continue
-		}
+			preference, err := c.query(ctx, peer)
+			if err != nil {
+				return
+			}
 
-		responses[i] = int(response.Preference)
+			votesMux.Lock()
+			votes = append(votes, preference)
+			voteWeights = append(voteWeights, weights[peer])
+			votesMux.Unlock()
+		}(peer)
 	}
+	waiter.Wait()
 
-	// get most frequent value from responses
-	value, count := utils.GetMostFrequentValue(responses)
+	c.mux.Lock()
+	defer c.mux.Unlock()
 
-	// check if frequency is greater than A
-	if count >= c.A {
-		oldPreference := c.preference
+	c.round++
+	c.state.Record(c.SnowParams, votes, voteWeights, sampledWeight)
+	c.save()
+	span.SetAttributes(tracing.Attr("sampled", len(kPeers)), tracing.Attr("votes", len(votes)), tracing.Attr("confidence", c.state.Confidence))
 
-		c.preference = value
+	roundsTotal.With(c.topic).Inc()
+	confidence.With(c.topic).Set(float64(c.state.Confidence))
+}
 
-		// check if preference is changed, the confidence is reset to 1
-		// otherwise, the confidence is increased by 1
-		if oldPreference != c.preference {
-			c.confident = 1
-		} else {
-			c.confident++
+// query asks a peer for its preference.
+func (c *consensus) query(ctx context.Context, peer string) (int, error) {
+	ctx, span := c.tracer.Start(ctx, "consensus.query", tracing.Attr("peer", peer))
+	defer span.End()
 
-			// check if confidence is greater than B, the value is accepted
-			if c.confident >= c.B {
-				c.accepted = true
-			}
+	// get connection of the peer
+	conn, err := c.Node.PeerManager.GetConnection(peer)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	// create a proto client
+	client := proto.NewConsensusServiceClient(conn)
+
+	// send query
+	response, err := client.GetPreference(ctx, &proto.GetPreferenceRequest{Topic: c.topic, Height: c.blockHeight()})
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	span.SetAttributes(tracing.Attr("preference", response.Preference))
+	return int(response.Preference), nil
+}
+
+// samplePeers returns K peers to query and the voting weight of each of them.
+// Only validators are sampled when a validator set is configured.
+func (c *consensus) samplePeers() ([]string, map[string]uint64) {
+	validators := c.Validators()
+	if validators == nil {
+		peers := c.Node.PeerManager.GetSamplePeers(c.K)
+
+		weights := make(map[string]uint64, len(peers))
+		for _, peer := range peers {
+			weights[peer] = 1
 		}
-	} else {
-		c.confident = 0
+		return peers, weights
 	}
 
+	weights := validators.Weights()
+	return c.Node.PeerManager.GetWeightedSamplePeers(c.K, weights), weights
 }
 
-// GetPreference returns the preference of the node.
-func (c *consensus) GetPreference(context.Context, *proto.Empty) (*proto.GetPreferenceResponse, error) {
+// GetPreference returns the preference of the node, unless the node is not a
+// voter yet. For an instance deciding blocks, it returns the value of the
+// block of a lower height, and fails for a higher one.
+func (c *consensus) GetPreference(_ context.Context, request *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error) {
+	if c.Node != nil && !c.Node.Voter() {
+		return nil, status.Error(codes.Unavailable, "node is syncing its chain")
+	}
+
+	if height := c.blockHeight(); height > 0 && request.Height > 0 && request.Height != height {
+		if request.Height > height {
+			return nil, status.Errorf(codes.Unavailable, "node is deciding block %d", height)
+		}
+		if block, ok := c.Node.Chain.Get(request.Height); ok {
+			return &proto.GetPreferenceResponse{Preference: int64(block.Value)}, nil
+		}
+		return nil, status.Errorf(codes.NotFound, "block %d not found", request.Height)
+	}
+
 	return &proto.GetPreferenceResponse{
-		Preference: int64(c.preference),
+		Preference: int64(c.Preference()),
 	}, nil
 }
 
-// AddNode adds a node to the consensus.
+// AddNode adds a node to the consensus. The consensus logs and traces with
+// the logger and tracer of the node, stops when the node shuts down, and
+// resumes from the state saved in the store of the node, if any.
 func (c *consensus) AddNode(n *node.Node) {
 	c.Node = n
+	c.logger = n.Logger().With(logger.F("topic", c.topic))
+	c.tracer = n.Tracer().With(tracing.Attr("topic", c.topic))
+	n.OnShutdown(c.Stop)
+
+	c.store = n.Storage("consensus")
+	c.load()
+}
+
+// stateKey returns the key of the decision state of the topic.
+func (c *consensus) stateKey() []byte {
+	return []byte("snowball/" + c.topic)
+}
+
+// load loads the decision state from the store of the node.
+func (c *consensus) load() {
+	if c.store == nil {
+		return
+	}
+
+	data, err := c.store.Get(c.stateKey())
+	if err == storage.ErrNotFound {
+		return
+	}
+	if err == nil {
+		c.mux.Lock()
+		err = json.Unmarshal(data, &c.state)
+		c.mux.Unlock()
+	}
+	if err != nil {
+		c.logger.Warn("failed to load consensus state", logger.Err(err))
+	}
+}
+
+// save saves the decision state to the store of the node, the lock must be
+// held. A failure is only logged, the state in memory stays right.
+func (c *consensus) save() {
+	if c.store == nil {
+		return
+	}
+
+	data, err := json.Marshal(c.state)
+	if err == nil {
+		err = c.store.Set(c.stateKey(), data)
+	}
+	if err != nil {
+		c.logger.Warn("failed to save consensus state", logger.Err(err))
+	}
 }
 
 // GetNode returns the node of the consensus.
@@ -160,5 +483,51 @@ func (c *consensus) UpdatePreference(p int) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	c.preference = p
+	c.state.Preference = p
+	c.save()
+}
+
+// SetValidators sets the validator set used to sample peers and weight votes.
+func (c *consensus) SetValidators(validators *ValidatorSet) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.validators = validators
+}
+
+// Validators returns the validator set of the consensus.
+func (c *consensus) Validators() *ValidatorSet {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.validators
+}
+
+// SetHeight scopes the queries to the height being decided.
+func (c *consensus) SetHeight(height func() uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.height = height
+}
+
+// blockHeight returns the height being decided, 0 if the instance does not
+// decide blocks.
+func (c *consensus) blockHeight() uint64 {
+	c.mux.RLock()
+	height := c.height
+	c.mux.RUnlock()
+
+	if height == nil {
+		return 0
+	}
+	return height()
+}
+
+// OnDecide registers a function called with each accepted value.
+func (c *consensus) OnDecide(hook func(value int)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.onDecide = append(c.onDecide, hook)
 }
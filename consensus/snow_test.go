@@ -2,8 +2,14 @@ package consensus
 
 import (
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/clock"
 	"simple-p2p/node"
 	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/tracing"
+	"simple-p2p/transport"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,9 +21,12 @@ func TestSnow(t *testing.T) {
 
 	t.Run("TestSnow", func(t *testing.T) {
 		numNode := 5
+		network := transport.NewMemory()
+		clk := clock.NewFake(time.Now())
+
 		listConsensus := make([]Consensus, numNode)
 		for i := 0; i < numNode; i++ {
-			newNode := createNode(9447 + int64(i))
+			newNode := node.NewNode(fmt.Sprintf("%v:%d", host, 9447+i), node.WithTransport(network), node.WithClock(clk))
 
 			consensus := NewConsensus(SnowParams{
 				K:       3,
@@ -43,20 +52,27 @@ func TestSnow(t *testing.T) {
 			listConsensus[i].GetNode().PeerManager.StartDiscoverPeers(listConsensus[i-1].GetNode().Address)
 		}
 
-		// wait for each node to discover all others nodes
-		time.Sleep(15 * time.Second)
-
-		for i := 0; i < numNode; i++ {
-			fmt.Printf("Node %v: %v\n", listConsensus[i].GetNode().Address, len(listConsensus[i].GetNode().PeerManager.GetPeers()))
-		}
+		// advance the discovery loops until each node discovers all others nodes
+		assert.Eventually(t, func() bool {
+			clk.Advance(time.Second)
+			for i := 0; i < numNode; i++ {
+				if len(listConsensus[i].GetNode().PeerManager.GetPeers()) != numNode-1 {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
 
-		// Start the consensus
+		// Start the consensus and wait for it to finish
+		var wg sync.WaitGroup
 		for i := 0; i < numNode; i++ {
-			go listConsensus[i].Sync()
+			wg.Add(1)
+			go func(c Consensus) {
+				defer wg.Done()
+				c.Sync()
+			}(listConsensus[i])
 		}
-
-		// wait for the consensus to finish
-		time.Sleep(10 * time.Second)
+		wg.Wait()
 
 		// check if the consensus has the same preference
 		for i := 1; i < numNode; i++ {
@@ -67,6 +83,114 @@ func TestSnow(t *testing.T) {
 	})
 }
 
-func createNode(port int64) *node.Node {
-	return node.NewNode(fmt.Sprintf("%v:%d", host, port))
+func TestSnowQuorum(t *testing.T) {
+	params := SnowParams{K: 3, A: 2, B: 2, MaxStep: 10}
+
+	// without weights, a value needs A votes however few peers are sampled
+	var s Snowball
+	s.Record(params, []int{1}, []uint64{1}, uint64(params.K))
+	assert.Equal(t, 0, s.Confidence)
+	s.Record(params, []int{1, 1}, []uint64{1, 1}, uint64(params.K))
+	assert.Equal(t, 1, s.Preference)
+	assert.Equal(t, 1, s.Confidence)
+
+	// a node with a single peer never accepts
+	network := transport.NewMemory()
+	var engines []Consensus
+	for i := 0; i < 2; i++ {
+		n := node.NewNode(fmt.Sprintf("quorum-%d", i), node.WithTransport(network))
+		c := NewConsensus(params)
+		c.AddNode(n)
+		c.UpdatePreference(1)
+		proto.RegisterConsensusServiceServer(n.Server, c)
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+		engines = append(engines, c)
+	}
+	engines[0].GetNode().PeerManager.AddPeers(engines[1].GetNode().Address)
+	engines[0].Sync()
+	assert.False(t, engines[0].Accepted())
+}
+
+func TestSnowTracing(t *testing.T) {
+	network := transport.NewMemory()
+	recorder := tracing.NewRecorder()
+	tracer := tracing.NewTracer("snow", recorder)
+
+	var nodes []*node.Node
+	var engines []Consensus
+	for i := 0; i < 3; i++ {
+		n := node.NewNode(fmt.Sprintf("node-%d", i), node.WithTransport(network), node.WithTracer(tracer))
+		c := NewConsensus(SnowParams{K: 2, A: 2, B: 2, MaxStep: 10})
+		c.AddNode(n)
+		c.UpdatePreference(1)
+		proto.RegisterConsensusServiceServer(n.Server, c)
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+
+		nodes = append(nodes, n)
+		engines = append(engines, c)
+	}
+	for _, n := range nodes {
+		for _, other := range nodes {
+			n.PeerManager.AddPeers(other.Address)
+		}
+	}
+
+	engines[0].Sync()
+	assert.True(t, engines[0].Accepted())
+
+	byID := make(map[tracing.SpanID]tracing.SpanData)
+	var sync tracing.SpanData
+	for _, s := range recorder.Spans() {
+		byID[s.Context.SpanID] = s
+		if s.Name == "consensus.sync" {
+			sync = s
+		}
+	}
+	assert.Equal(t, "node-0", sync.Attribute("node"))
+	assert.Equal(t, true, sync.Attribute("accepted"))
+	rounds, _ := sync.Attribute("rounds").(int)
+	assert.Positive(t, rounds)
+
+	// every query is answered within the trace of the Sync:
+	// sync -> round -> query -> client call -> server call on the peer
+	servers := 0
+	for _, s := range recorder.Spans() {
+		if s.Kind != tracing.KindServer {
+			continue
+		}
+		servers++
+
+		client := byID[s.Parent]
+		query := byID[client.Parent]
+		round := byID[query.Parent]
+		assert.Equal(t, tracing.KindClient, client.Kind)
+		assert.Equal(t, "consensus.query", query.Name)
+		assert.Equal(t, s.Attribute("node"), query.Attribute("peer"))
+		assert.Equal(t, "consensus.round", round.Name)
+		assert.Equal(t, sync.Context.SpanID, round.Parent)
+		assert.Equal(t, sync.Context.TraceID, s.Context.TraceID)
+	}
+	assert.Equal(t, 2*rounds, servers)
+}
+
+func TestSnowStorage(t *testing.T) {
+	store := storage.NewMemory()
+	params := SnowParams{K: 1, A: 1, B: 1, MaxStep: 1}
+
+	c := NewTopicConsensus("reconfig", params)
+	node.NewNode("node-1", node.WithStorage(store), node.WithConsensus(c))
+	c.UpdatePreference(7)
+
+	// a restarted instance resumes with its preference, other topics do not
+	restarted := NewTopicConsensus("reconfig", params)
+	other := NewConsensus(params)
+	node.NewNode("node-1", node.WithStorage(store), node.WithConsensus(restarted, other))
+	assert.Equal(t, 7, restarted.Preference())
+	assert.Equal(t, 0, other.Preference())
+}
+
+func createNode(network transport.Transport, port int64, opts ...node.Option) *node.Node {
+	return node.NewNode(fmt.Sprintf("%v:%d", host, port), append([]node.Option{node.WithTransport(network)}, opts...)...)
 }
@@ -0,0 +1,294 @@
+package consensus
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrValidatorExists   = errors.New("validator already exists")
+	ErrValidatorNotFound = errors.New("validator not found")
+	ErrInvalidWeight     = errors.New("validator weight must be positive")
+	ErrAddressInUse      = errors.New("validator address already in use")
+	ErrWeightOverflow    = errors.New("total weight of the validators is too large")
+)
+
+// MaxTotalWeight is the largest total weight of a validator set.
+const MaxTotalWeight = math.MaxInt64
+
+// Validator is a node that is allowed to vote in consensus.
+type Validator struct {
+	ID      string            // unique identifier of the node
+	Address string            // network address of the node
+	Weight  uint64            // voting weight of the node
+	PubKey  ed25519.PublicKey // key of the finality votes of the node, nil if it does not sign
+}
+
+// changeKind is the kind of membership change of a validator set.
+type changeKind int
+
+const (
+	changeAdd changeKind = iota
+	changeRemove
+	changeReweight
+)
+
+// change is a membership change waiting for the next epoch boundary.
+type change struct {
+	kind      changeKind
+	validator Validator
+}
+
+// ValidatorSet is the set of validators of the current epoch. Membership
+// changes are queued and only take effect at the next epoch boundary, so the
+// set stays the same during an epoch.
+type ValidatorSet struct {
+	epoch      uint64               // current epoch
+	validators map[string]Validator // validators of the current epoch by ID
+	pending    []change             // changes applied at the next epoch
+	mux        sync.RWMutex         // mutual exclusion lock for validators
+}
+
+// NewValidatorSet creates a validator set with the given validators at epoch 0.
+func NewValidatorSet(validators ...Validator) (*ValidatorSet, error) {
+	s := &ValidatorSet{
+		validators: make(map[string]Validator),
+	}
+
+	for _, v := range validators {
+		if v.Weight == 0 {
+			return nil, fmt.Errorf("%v: %w", v.ID, ErrInvalidWeight)
+		}
+		if _, ok := s.validators[v.ID]; ok {
+			return nil, fmt.Errorf("%v: %w", v.ID, ErrValidatorExists)
+		}
+		if s.addressInUse(v.Address) {
+			return nil, fmt.Errorf("%v: %w: %v", v.ID, ErrAddressInUse, v.Address)
+		}
+		if v.Weight > MaxTotalWeight-s.totalWeight() {
+			return nil, fmt.Errorf("%v: %w", v.ID, ErrWeightOverflow)
+		}
+		s.validators[v.ID] = v
+	}
+	return s, nil
+}
+
+// Epoch returns the current epoch of the validator set.
+func (s *ValidatorSet) Epoch() uint64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.epoch
+}
+
+// Validators returns the validators of the current epoch ordered by ID.
+func (s *ValidatorSet) Validators() []Validator {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	validators := make([]Validator, 0, len(s.validators))
+	for _, v := range s.validators {
+		validators = append(validators, v)
+	}
+
+	sort.Slice(validators, func(i, j int) bool {
+		return validators[i].ID < validators[j].ID
+	})
+	return validators
+}
+
+// Get returns the validator with the given ID.
+func (s *ValidatorSet) Get(id string) (Validator, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	v, ok := s.validators[id]
+	return v, ok
+}
+
+// GetByAddress returns the validator with the given network address.
+func (s *ValidatorSet) GetByAddress(addr string) (Validator, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	for _, v := range s.validators {
+		if v.Address == addr {
+			return v, true
+		}
+	}
+	return Validator{}, false
+}
+
+// TotalWeight returns the sum of weights of the current validators, at most
+// MaxTotalWeight.
+func (s *ValidatorSet) TotalWeight() uint64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.totalWeight()
+}
+
+// totalWeight returns the sum of weights of the current validators, the lock
+// must be held.
+func (s *ValidatorSet) totalWeight() uint64 {
+	var total uint64
+	for _, v := range s.validators {
+		total += v.Weight
+	}
+	return total
+}
+
+// Signer returns the public key and the weight of the validator with the
+// given ID, so that the finality votes and certificates are verified against
+// the current validators.
+func (s *ValidatorSet) Signer(id string) (ed25519.PublicKey, uint64, bool) {
+	v, ok := s.Get(id)
+	return v.PubKey, v.Weight, ok
+}
+
+// addressInUse reports whether a validator has the given network address, the
+// lock must be held. Addresses are unique, the peers are sampled by address.
+func (s *ValidatorSet) addressInUse(addr string) bool {
+	for _, v := range s.validators {
+		if v.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Weights returns the weights of the current validators by network address,
+// which is unique in the set.
+func (s *ValidatorSet) Weights() map[string]uint64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	weights := make(map[string]uint64, len(s.validators))
+	for _, v := range s.validators {
+		weights[v.Address] = v.Weight
+	}
+	return weights
+}
+
+// Add queues a new validator to join at the next epoch.
+func (s *ValidatorSet) Add(v Validator) error {
+	if v.Weight == 0 {
+		return fmt.Errorf("%v: %w", v.ID, ErrInvalidWeight)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.pending = append(s.pending, change{kind: changeAdd, validator: v})
+	return nil
+}
+
+// Remove queues a validator to leave at the next epoch.
+func (s *ValidatorSet) Remove(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.pending = append(s.pending, change{kind: changeRemove, validator: Validator{ID: id}})
+	return nil
+}
+
+// Reweight queues a weight change of a validator for the next epoch.
+func (s *ValidatorSet) Reweight(id string, weight uint64) error {
+	if weight == 0 {
+		return fmt.Errorf("%v: %w", id, ErrInvalidWeight)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.pending = append(s.pending, change{kind: changeReweight, validator: Validator{ID: id, Weight: weight}})
+	return nil
+}
+
+// NextEpoch moves the set to the next epoch and applies the queued changes in
+// order. Changes that do not apply (e.g. removing an unknown validator) are
+// skipped and returned as errors, the epoch is advanced regardless.
+func (s *ValidatorSet) NextEpoch() (uint64, []error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var errs []error
+	for _, c := range s.pending {
+		if err := s.apply(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.pending = nil
+	s.epoch++
+	return s.epoch, errs
+}
+
+// check checks that a change applies to the current validators.
+func (s *ValidatorSet) check(c change) error {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.checkLocked(c)
+}
+
+// checkLocked is check with the lock held.
+func (s *ValidatorSet) checkLocked(c change) error {
+	id := c.validator.ID
+	v, ok := s.validators[id]
+
+	switch c.kind {
+	case changeAdd:
+		if ok {
+			return fmt.Errorf("%v: %w", id, ErrValidatorExists)
+		}
+		if s.addressInUse(c.validator.Address) {
+			return fmt.Errorf("%v: %w: %v", id, ErrAddressInUse, c.validator.Address)
+		}
+		if c.validator.Weight == 0 {
+			return fmt.Errorf("%v: %w", id, ErrInvalidWeight)
+		}
+		if c.validator.Weight > MaxTotalWeight-s.totalWeight() {
+			return fmt.Errorf("%v: %w", id, ErrWeightOverflow)
+		}
+	case changeRemove:
+		if !ok {
+			return fmt.Errorf("%v: %w", id, ErrValidatorNotFound)
+		}
+	case changeReweight:
+		if !ok {
+			return fmt.Errorf("%v: %w", id, ErrValidatorNotFound)
+		}
+		if c.validator.Weight == 0 {
+			return fmt.Errorf("%v: %w", id, ErrInvalidWeight)
+		}
+		if c.validator.Weight > MaxTotalWeight-(s.totalWeight()-v.Weight) {
+			return fmt.Errorf("%v: %w", id, ErrWeightOverflow)
+		}
+	}
+	return nil
+}
+
+// apply applies a single change to the current validators.
+func (s *ValidatorSet) apply(c change) error {
+	if err := s.checkLocked(c); err != nil {
+		return err
+	}
+
+	id := c.validator.ID
+	switch c.kind {
+	case changeAdd:
+		s.validators[id] = c.validator
+	case changeRemove:
+		delete(s.validators, id)
+	case changeReweight:
+		v := s.validators[id]
+		v.Weight = c.validator.Weight
+		s.validators[id] = v
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package consensus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/p2p"
+	"simple-p2p/utils"
+	"testing"
+)
+
+func TestValidatorSet(t *testing.T) {
+	set, err := NewValidatorSet(
+		Validator{ID: "a", Address: "127.0.0.1:1", Weight: 1},
+		Validator{ID: "b", Address: "127.0.0.1:2", Weight: 2},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), set.TotalWeight())
+
+	// changes are queued until the next epoch
+	assert.NoError(t, set.Add(Validator{ID: "c", Address: "127.0.0.1:3", Weight: 3}))
+	assert.NoError(t, set.Remove("a"))
+	assert.NoError(t, set.Reweight("b", 5))
+	assert.Equal(t, uint64(3), set.TotalWeight())
+
+	epoch, errs := set.NextEpoch()
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, uint64(8), set.TotalWeight())
+	assert.Equal(t, map[string]uint64{"127.0.0.1:2": 5, "127.0.0.1:3": 3}, set.Weights())
+
+	// invalid changes are skipped but the epoch still advances
+	assert.NoError(t, set.Remove("a"))
+	epoch, errs = set.NextEpoch()
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrValidatorNotFound)
+	assert.Equal(t, uint64(2), epoch)
+
+	_, err = NewValidatorSet(Validator{ID: "a", Weight: 0})
+	assert.ErrorIs(t, err, ErrInvalidWeight)
+
+	// two validators cannot share an address, their weights would collapse
+	_, err = NewValidatorSet(
+		Validator{ID: "a", Address: "127.0.0.1:1", Weight: 1},
+		Validator{ID: "b", Address: "127.0.0.1:1", Weight: 2},
+	)
+	assert.ErrorIs(t, err, ErrAddressInUse)
+	assert.NoError(t, set.Add(Validator{ID: "d", Address: "127.0.0.1:2", Weight: 1}))
+	_, errs = set.NextEpoch()
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrAddressInUse)
+}
+
+func TestWeightedSample(t *testing.T) {
+	pm := p2p.NewPeerManager("127.0.0.1:0")
+	pm.AddPeers("127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+
+	// peers that are not validators are never sampled
+	weights := map[string]uint64{"127.0.0.1:1": 1, "127.0.0.1:2": 100}
+	for i := 0; i < 100; i++ {
+		peers := pm.GetWeightedSamplePeers(3, weights)
+		assert.ElementsMatch(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, peers)
+	}
+
+	// the heavier validator is picked first most of the time
+	heavy := 0
+	for i := 0; i < 1000; i++ {
+		if pm.GetWeightedSamplePeers(1, weights)[0] == "127.0.0.1:2" {
+			heavy++
+		}
+	}
+	assert.Greater(t, heavy, 900)
+
+	value, weight := utils.GetMostWeightedValue([]int{1, 2, 2}, []uint64{5, 1, 1})
+	assert.Equal(t, 1, value)
+	assert.Equal(t, uint64(5), weight)
+}
@@ -0,0 +1,170 @@
+// Package fault injects network faults into the connections between nodes.
+// A Controller holds the faults of the whole network, and each node installs
+// its client interceptor so every outgoing call goes through the controller.
+package fault
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"simple-p2p/clock"
+	"sync"
+	"time"
+)
+
+// Link is the fault of the directed link between two nodes.
+type Link struct {
+	Delay    time.Duration // fixed delay added to every call
+	Jitter   time.Duration // random extra delay in [0, Jitter)
+	DropRate float64       // probability in [0, 1] that a call is lost
+}
+
+// link is a directed link between two nodes.
+type link struct {
+	from string
+	to   string
+}
+
+// Controller holds the faults of a network. All methods are safe to call
+// while nodes are running, so tests can script faults step by step.
+type Controller struct {
+	partitions  map[string]int // partition of each partitioned node
+	links       map[link]Link  // faults of specific links
+	defaultLink Link           // fault of links without a specific fault
+	random      *rand.Rand     // source of drops and jitter
+	clock       clock.Clock    // clock of the delays
+	mux         sync.Mutex     // mutual exclusion lock for the fields above
+}
+
+// NewController creates a controller without faults.
+func NewController() *Controller {
+	return &Controller{
+		partitions: make(map[string]int),
+		links:      make(map[link]Link),
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock:      clock.Real{},
+	}
+}
+
+// SetClock sets the clock measuring the delays, the wall clock by default.
+func (c *Controller) SetClock(clk clock.Clock) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.clock = clk
+}
+
+// Partition splits the listed nodes into groups that cannot reach each other.
+// Nodes that are not listed can still reach every node. It replaces any
+// previous partition.
+func (c *Controller) Partition(groups ...[]string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			c.partitions[addr] = i
+		}
+	}
+}
+
+// Heal removes the partition.
+func (c *Controller) Heal() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.partitions = make(map[string]int)
+}
+
+// SetLink sets the fault of the directed link from one node to another.
+func (c *Controller) SetLink(from, to string, l Link) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.links[link{from: from, to: to}] = l
+}
+
+// SetDefault sets the fault of every link without a specific fault.
+func (c *Controller) SetDefault(l Link) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.defaultLink = l
+}
+
+// Reset removes all faults, including the partition.
+func (c *Controller) Reset() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.partitions = make(map[string]int)
+	c.links = make(map[link]Link)
+	c.defaultLink = Link{}
+}
+
+// decide returns whether a call from one node to another is lost and how long
+// it is delayed.
+func (c *Controller) decide(from, to string) (bool, time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	fromPartition, fromOk := c.partitions[from]
+	toPartition, toOk := c.partitions[to]
+	if fromOk && toOk && fromPartition != toPartition {
+		return true, 0
+	}
+
+	l, ok := c.links[link{from: from, to: to}]
+	if !ok {
+		l = c.defaultLink
+	}
+
+	if l.DropRate > 0 && c.random.Float64() < l.DropRate {
+		return true, 0
+	}
+
+	delay := l.Delay
+	if l.Jitter > 0 {
+		delay += time.Duration(c.random.Int63n(int64(l.Jitter)))
+	}
+	return false, delay
+}
+
+// UnaryClientInterceptor returns the interceptor of the node with the given
+// address. A lost call blocks until its context is done, like a request that
+// never gets an answer, or fails at once if the context has no deadline.
+func (c *Controller) UnaryClientInterceptor(local string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		lost, delay := c.decide(local, cc.Target())
+		c.mux.Lock()
+		clk := c.clock
+		c.mux.Unlock()
+
+		if lost {
+			if _, ok := ctx.Deadline(); !ok {
+				return status.Errorf(codes.Unavailable, "fault: call from %v to %v is lost", local, cc.Target())
+			}
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		if delay > 0 {
+			select {
+			case <-clk.After(delay):
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			}
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// DialOption returns the dial option that installs the interceptor of the
+// node with the given address.
+func (c *Controller) DialOption(local string) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(c.UnaryClientInterceptor(local))
+}
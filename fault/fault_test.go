@@ -0,0 +1,165 @@
+package fault
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"simple-p2p/clock"
+	"simple-p2p/consensus"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestController(t *testing.T) {
+	c := NewController()
+
+	c.Partition([]string{"a", "b"}, []string{"c"})
+	lost, _ := c.decide("a", "b")
+	assert.False(t, lost)
+	lost, _ = c.decide("a", "c")
+	assert.True(t, lost)
+	lost, _ = c.decide("d", "c")
+	assert.False(t, lost)
+
+	c.Heal()
+	lost, _ = c.decide("a", "c")
+	assert.False(t, lost)
+
+	c.SetLink("a", "b", Link{Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	_, delay := c.decide("a", "b")
+	assert.GreaterOrEqual(t, delay, 10*time.Millisecond)
+	assert.Less(t, delay, 15*time.Millisecond)
+	_, delay = c.decide("b", "a")
+	assert.Zero(t, delay)
+
+	c.SetDefault(Link{DropRate: 1})
+	lost, _ = c.decide("b", "a")
+	assert.True(t, lost)
+
+	c.Reset()
+	lost, _ = c.decide("b", "a")
+	assert.False(t, lost)
+}
+
+func TestControllerClock(t *testing.T) {
+	c := NewController()
+	fake := clock.NewFake(time.Now())
+	c.SetClock(fake)
+	c.SetLink("a", "b", Link{Delay: time.Hour})
+
+	cc, err := transport.NewTCP().Dial("b")
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	// a delayed call waits on the clock of the controller
+	done := make(chan error, 1)
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+	go func() {
+		done <- c.UnaryClientInterceptor("a")(context.Background(), "/test", nil, nil, cc, invoker)
+	}()
+	assert.Eventually(t, func() bool { return fake.Waiters() == 1 }, time.Second, time.Millisecond)
+	fake.Advance(time.Hour)
+	assert.NoError(t, <-done)
+}
+
+func TestPartition(t *testing.T) {
+	controller := NewController()
+	params := consensus.SnowParams{K: 4, A: 3, B: 5, MaxStep: 100, QueryTimeout: 20 * time.Millisecond}
+
+	// each side of the partition prefers a different value
+	engines := createCluster(6, params, controller, []int{1, 1, 1, 2, 2, 2})
+	controller.Partition(addresses(engines[:3]), addresses(engines[3:]))
+
+	// no side can reach a quorum, every Sync gives up after MaxStep
+	syncAll(t, engines)
+	for _, e := range engines {
+		assert.False(t, e.Accepted())
+	}
+
+	// once healed the network decides a single value
+	controller.Heal()
+	syncAll(t, engines)
+	assertAgreement(t, engines)
+}
+
+func TestLinkFaults(t *testing.T) {
+	controller := NewController()
+	controller.SetDefault(Link{Delay: 2 * time.Millisecond, Jitter: 3 * time.Millisecond, DropRate: 0.2})
+
+	params := consensus.SnowParams{K: 4, A: 3, B: 8, MaxStep: 300, QueryTimeout: 20 * time.Millisecond}
+	engines := createCluster(8, params, controller, []int{1, 2})
+
+	syncAll(t, engines)
+	assertAgreement(t, engines)
+}
+
+// createCluster creates a fully connected cluster whose connections go
+// through the controller, preferences are assigned round-robin.
+func createCluster(numNode int, params consensus.SnowParams, controller *Controller, preferences []int) []consensus.Consensus {
+	network := transport.NewMemory()
+
+	engines := make([]consensus.Consensus, numNode)
+	for i := 0; i < numNode; i++ {
+		addr := fmt.Sprintf("node-%d", i)
+		n := node.NewNode(addr, node.WithTransport(network), node.WithDialOptions(controller.DialOption(addr)))
+
+		engines[i] = consensus.NewConsensus(params)
+		engines[i].AddNode(n)
+		engines[i].UpdatePreference(preferences[i%len(preferences)])
+		proto.RegisterConsensusServiceServer(n.Server, engines[i])
+
+		n.StartServer()
+	}
+
+	for _, e := range engines {
+		e.GetNode().PeerManager.AddPeers(addresses(engines)...)
+	}
+	return engines
+}
+
+// syncAll runs Sync on all engines and fails if any of them hangs.
+func syncAll(t *testing.T, engines []consensus.Consensus) {
+	var wg sync.WaitGroup
+	for _, e := range engines {
+		wg.Add(1)
+		go func(e consensus.Consensus) {
+			defer wg.Done()
+			e.Sync()
+		}(e)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("consensus hangs")
+	}
+}
+
+// assertAgreement checks that all engines accepted the same value.
+func assertAgreement(t *testing.T, engines []consensus.Consensus) {
+	for _, e := range engines {
+		assert.True(t, e.Accepted())
+		assert.Equal(t, engines[0].Preference(), e.Preference())
+	}
+}
+
+func addresses(engines []consensus.Consensus) []string {
+	var addrs []string
+	for _, e := range engines {
+		addrs = append(addrs, e.GetNode().Address)
+	}
+	return addrs
+}
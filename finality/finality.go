@@ -0,0 +1,185 @@
+// Package finality proves the decisions of the network with signatures. Once
+// a validator commits a block, it signs a vote for the hash of the block at
+// its height, in the epoch of the validator set. Votes of validators holding
+// a threshold of the total weight form a certificate, which anyone knowing
+// the validators and their public keys verifies without trusting the node
+// serving it.
+package finality
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidVote        = errors.New("invalid vote")
+	ErrInvalidCertificate = errors.New("invalid certificate")
+)
+
+// voteDomain prefixes the signed bytes of a vote, so a vote signature cannot
+// pass for the signature of another message.
+const voteDomain = "simple-p2p/vote/v1"
+
+// Validators are the validators votes are verified against.
+type Validators interface {
+	// Signer returns the public key and the weight of the validator of an ID,
+	// false if it is not a validator.
+	Signer(id string) (ed25519.PublicKey, uint64, bool)
+
+	// TotalWeight returns the sum of the weights of the validators.
+	TotalWeight() uint64
+
+	// Epoch returns the epoch of the validators, the votes of other epochs
+	// are signed by other validators.
+	Epoch() uint64
+}
+
+// Threshold is the fraction Num/Den of the total weight that the votes of a
+// certificate must hold.
+type Threshold struct {
+	Num uint64 `json:"num"`
+	Den uint64 `json:"den"`
+}
+
+// DefaultThreshold is two thirds of the total weight.
+var DefaultThreshold = Threshold{Num: 2, Den: 3}
+
+// Reached reports whether a weight reaches the threshold of a total weight.
+// The products are compared on 128 bits, so large weights do not overflow.
+func (t Threshold) Reached(weight uint64, total uint64) bool {
+	hi, lo := bits.Mul64(weight, t.Den)
+	thi, tlo := bits.Mul64(total, t.Num)
+	return total > 0 && (hi > thi || hi == thi && lo >= tlo)
+}
+
+// String returns the threshold as a fraction.
+func (t Threshold) String() string {
+	return fmt.Sprintf("%d/%d", t.Num, t.Den)
+}
+
+// Majority reports whether the threshold is above half of the total weight,
+// so that two certificates of conflicting blocks need a common validator to
+// vote twice.
+func (t Threshold) Majority() bool {
+	return t.Den > 0 && t.Num <= t.Den && 2*t.Num > t.Den
+}
+
+// ParseThreshold parses a fraction written like String, e.g. "2/3".
+func ParseThreshold(s string) (Threshold, error) {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return Threshold{}, fmt.Errorf("threshold %q is not a fraction num/den", s)
+	}
+
+	var (
+		t   Threshold
+		err error
+	)
+	if t.Num, err = strconv.ParseUint(strings.TrimSpace(num), 10, 64); err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: %w", s, err)
+	}
+	if t.Den, err = strconv.ParseUint(strings.TrimSpace(den), 10, 64); err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// Vote is the signature of a validator on the block of a hash at a height.
+type Vote struct {
+	Epoch     uint64 `json:"epoch"` // epoch of the validator set of the validator
+	Height    uint64 `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Validator string `json:"validator"` // ID of the validator
+	Signature []byte `json:"signature"` // ed25519 signature of SignBytes
+}
+
+// SignBytes returns the bytes signed by a vote for the block of a hash at a
+// height in an epoch. The epoch is signed so that a vote does not count for
+// the validator set of another epoch.
+func SignBytes(epoch uint64, height uint64, blockHash string) []byte {
+	data := make([]byte, 0, len(voteDomain)+16+len(blockHash))
+	data = append(data, voteDomain...)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], epoch)
+	data = append(data, buf[:]...)
+	binary.BigEndian.PutUint64(buf[:], height)
+	data = append(data, buf[:]...)
+	return append(data, blockHash...)
+}
+
+// Sign returns the vote of a validator for the block of a hash at a height in
+// an epoch.
+func Sign(key ed25519.PrivateKey, validator string, epoch uint64, height uint64, blockHash string) Vote {
+	return Vote{
+		Epoch:     epoch,
+		Height:    height,
+		BlockHash: blockHash,
+		Validator: validator,
+		Signature: ed25519.Sign(key, SignBytes(epoch, height, blockHash)),
+	}
+}
+
+// Verify checks that the vote is signed by a validator in the epoch of the
+// validators, and returns its weight. It returns ErrInvalidVote otherwise.
+func (v Vote) Verify(validators Validators) (uint64, error) {
+	if epoch := validators.Epoch(); v.Epoch != epoch {
+		return 0, fmt.Errorf("%w: vote of %v in epoch %d, the validators are of epoch %d", ErrInvalidVote, v.Validator, v.Epoch, epoch)
+	}
+	pub, weight, ok := validators.Signer(v.Validator)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v is not a validator", ErrInvalidVote, v.Validator)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return 0, fmt.Errorf("%w: validator %v has no public key", ErrInvalidVote, v.Validator)
+	}
+	if !ed25519.Verify(pub, SignBytes(v.Epoch, v.Height, v.BlockHash), v.Signature) {
+		return 0, fmt.Errorf("%w: bad signature of %v at height %d", ErrInvalidVote, v.Validator, v.Height)
+	}
+	return weight, nil
+}
+
+// Certificate proves that the block of a hash is final at a height: it holds
+// the votes of the validators of an epoch reaching a threshold of their total
+// weight.
+type Certificate struct {
+	Epoch     uint64 `json:"epoch"` // epoch of the validator set of the votes
+	Height    uint64 `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Votes     []Vote `json:"votes"` // votes ordered by validator
+}
+
+// Weight returns the weight of the valid votes of the certificate for its
+// block, each validator counted once.
+func (c Certificate) Weight(validators Validators) uint64 {
+	var weight uint64
+	seen := make(map[string]bool, len(c.Votes))
+	for _, v := range c.Votes {
+		if seen[v.Validator] || v.Epoch != c.Epoch || v.Height != c.Height || v.BlockHash != c.BlockHash {
+			continue
+		}
+		if w, err := v.Verify(validators); err == nil {
+			seen[v.Validator] = true
+			weight += w
+		}
+	}
+	return weight
+}
+
+// Verify checks that the certificate is of the epoch of the validators and
+// that its votes reach a threshold of their total weight. It returns
+// ErrInvalidCertificate otherwise.
+func (c Certificate) Verify(validators Validators, threshold Threshold) error {
+	if epoch := validators.Epoch(); c.Epoch != epoch {
+		return fmt.Errorf("%w: certificate of block %d in epoch %d, the validators are of epoch %d", ErrInvalidCertificate, c.Height, c.Epoch, epoch)
+	}
+	weight, total := c.Weight(validators), validators.TotalWeight()
+	if !threshold.Reached(weight, total) {
+		return fmt.Errorf("%w: votes of weight %d of %d for block %d, below %v", ErrInvalidCertificate, weight, total, c.Height, threshold)
+	}
+	return nil
+}
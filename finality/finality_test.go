@@ -0,0 +1,148 @@
+package finality
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// validators is a set of validators with a key and a weight each.
+type validators map[string]struct {
+	key    ed25519.PrivateKey
+	weight uint64
+}
+
+func (s validators) Signer(id string) (ed25519.PublicKey, uint64, bool) {
+	v, ok := s[id]
+	if !ok {
+		return nil, 0, false
+	}
+	return v.key.Public().(ed25519.PublicKey), v.weight, true
+}
+
+func (s validators) TotalWeight() uint64 {
+	var total uint64
+	for _, v := range s {
+		total += v.weight
+	}
+	return total
+}
+
+func (s validators) Epoch() uint64 {
+	return 0
+}
+
+// nextEpoch is the set of validators in the next epoch.
+type nextEpoch struct {
+	validators
+}
+
+func (s nextEpoch) Epoch() uint64 {
+	return 1
+}
+
+// newValidators returns validators v0, v1... of weights.
+func newValidators(weights ...uint64) validators {
+	s := make(validators)
+	for i, w := range weights {
+		_, key, _ := ed25519.GenerateKey(nil)
+		s[fmt.Sprintf("v%d", i)] = struct {
+			key    ed25519.PrivateKey
+			weight uint64
+		}{key, w}
+	}
+	return s
+}
+
+func TestVote(t *testing.T) {
+	set := newValidators(1, 2)
+	vote := Sign(set["v1"].key, "v1", 0, 7, "abc")
+	weight, err := vote.Verify(set)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), weight)
+
+	// the signature covers the height and the hash
+	moved := vote
+	moved.Height = 8
+	_, err = moved.Verify(set)
+	assert.ErrorIs(t, err, ErrInvalidVote)
+	moved = vote
+	moved.BlockHash = "abd"
+	_, err = moved.Verify(set)
+	assert.ErrorIs(t, err, ErrInvalidVote)
+
+	// and the epoch
+	_, err = vote.Verify(nextEpoch{set})
+	assert.ErrorIs(t, err, ErrInvalidVote)
+	next := Sign(set["v1"].key, "v1", 1, 7, "abc")
+	_, err = next.Verify(nextEpoch{set})
+	assert.NoError(t, err)
+	next.Epoch = 0
+	_, err = next.Verify(set)
+	assert.ErrorIs(t, err, ErrInvalidVote)
+
+	// and is the one of the validator voting
+	forged := Sign(set["v0"].key, "v1", 0, 7, "abc")
+	_, err = forged.Verify(set)
+	assert.ErrorIs(t, err, ErrInvalidVote)
+	_, err = Sign(set["v0"].key, "v9", 0, 7, "abc").Verify(set)
+	assert.ErrorIs(t, err, ErrInvalidVote)
+}
+
+func TestCertificate(t *testing.T) {
+	set := newValidators(1, 1, 1, 3)
+	c := Certificate{Height: 5, BlockHash: "abc"}
+	for _, id := range []string{"v0", "v1", "v2"} {
+		c.Votes = append(c.Votes, Sign(set[id].key, id, 0, 5, "abc"))
+	}
+	assert.Equal(t, uint64(3), c.Weight(set))
+	assert.ErrorIs(t, c.Verify(set, DefaultThreshold), ErrInvalidCertificate)
+	assert.NoError(t, c.Verify(set, Threshold{Num: 1, Den: 2}))
+
+	// votes for another block, repeated or forged do not count
+	other := c
+	other.Votes = append([]Vote{}, c.Votes...)
+	other.Votes = append(other.Votes, Sign(set["v3"].key, "v3", 0, 5, "abd"), c.Votes[0], Sign(set["v0"].key, "v3", 0, 5, "abc"))
+	assert.Equal(t, uint64(3), other.Weight(set))
+
+	c.Votes = append(c.Votes, Sign(set["v3"].key, "v3", 0, 5, "abc"))
+	assert.NoError(t, c.Verify(set, DefaultThreshold))
+	c.BlockHash = "abd"
+	assert.ErrorIs(t, c.Verify(set, Threshold{Num: 1, Den: 100}), ErrInvalidCertificate)
+
+	// a certificate only holds for the validators of its epoch
+	c.BlockHash = "abc"
+	assert.ErrorIs(t, c.Verify(nextEpoch{set}, DefaultThreshold), ErrInvalidCertificate)
+	c.Epoch = 1
+	assert.ErrorIs(t, c.Verify(nextEpoch{set}, DefaultThreshold), ErrInvalidCertificate)
+	assert.Equal(t, uint64(0), c.Weight(nextEpoch{set}))
+
+	assert.True(t, DefaultThreshold.Reached(2, 3))
+	assert.False(t, DefaultThreshold.Reached(0, 0))
+}
+
+func TestThreshold(t *testing.T) {
+	threshold, err := ParseThreshold(DefaultThreshold.String())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultThreshold, threshold)
+	assert.True(t, threshold.Majority())
+
+	for _, s := range []string{"1/2", "0/3", "4/3", "1/0"} {
+		threshold, err := ParseThreshold(s)
+		assert.NoError(t, err)
+		assert.False(t, threshold.Majority(), s)
+	}
+	for _, s := range []string{"67", "2/x", "-1/2", ""} {
+		_, err := ParseThreshold(s)
+		assert.Error(t, err, s)
+	}
+
+	// large weights do not overflow
+	large := Threshold{Num: math.MaxUint64 - 1, Den: math.MaxUint64}
+	assert.True(t, large.Reached(math.MaxUint64, math.MaxUint64))
+	assert.False(t, large.Reached(math.MaxUint64/2, math.MaxUint64))
+	assert.False(t, DefaultThreshold.Reached(math.MaxUint64/2, math.MaxUint64))
+	assert.True(t, DefaultThreshold.Reached(math.MaxUint64/3*2, math.MaxUint64/3*3))
+}
@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds the wait for the block of an operation.
+var requestTimeout = 10 * time.Second
+
+// PutRequest is the body of a write.
+type PutRequest struct {
+	Value string `json:"value"`
+}
+
+// KV is a key, its value and the height of the state it was read from.
+type KV struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Height uint64 `json:"height"`
+}
+
+// api serves the key-value endpoints of the node API.
+type api struct {
+	client *Client
+}
+
+// RegisterAPI adds the endpoints of the store to the node API:
+//
+//	GET    /kv/{key}  value of a key, ?consistency=linearizable orders the read through consensus
+//	PUT    /kv/{key}  set the value of a key, body {"value": "..."}
+//	DELETE /kv/{key}  delete a key
+//
+// Writes and linearizable reads answer once the node commits them.
+func RegisterAPI(a *node.API, c *Client) {
+	s := &api{client: c}
+
+	a.Handle("/kv/", http.HandlerFunc(s.serveKey))
+}
+
+// serveKey serves the key of the path /kv/{key}.
+func (s *api) serveKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	if key == "" {
+		node.WriteError(w, http.StatusBadRequest, fmt.Errorf("empty key: %w", node.ErrBadRequest))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		s.get(ctx, w, r, key)
+	case http.MethodPut:
+		s.put(ctx, w, r, key)
+	case http.MethodDelete:
+		s.delete(ctx, w, key)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ", "))
+		node.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	}
+}
+
+// get returns the value of a key.
+func (s *api) get(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	consistency := Consistency(r.URL.Query().Get("consistency"))
+	if consistency == "" {
+		consistency = Local
+	}
+
+	result, err := s.client.Get(ctx, key, consistency)
+	if err != nil {
+		node.WriteError(w, statusCode(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, KV{Key: key, Value: result.Value, Height: result.Height})
+}
+
+// put sets the value of a key.
+func (s *api) put(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	var request PutRequest
+	if err := node.ReadJSON(r, &request); err != nil {
+		node.WriteError(w, node.BodyStatus(err), err)
+		return
+	}
+
+	result, err := s.client.Put(ctx, key, request.Value)
+	if err != nil {
+		node.WriteError(w, statusCode(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, KV{Key: key, Value: request.Value, Height: result.Height})
+}
+
+// delete deletes a key.
+func (s *api) delete(ctx context.Context, w http.ResponseWriter, key string) {
+	result, err := s.client.Delete(ctx, key)
+	if err != nil {
+		node.WriteError(w, statusCode(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, KV{Key: key, Height: result.Height})
+}
+
+// statusCode returns the HTTP status of an error of the client.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, mempool.ErrMempoolFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, mempool.ErrTxTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
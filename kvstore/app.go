@@ -0,0 +1,310 @@
+// Package kvstore is a replicated key-value store built on the node, and the
+// reference application of the stack. Clients submit put and delete
+// operations as transactions, consensus orders them into blocks and every
+// node applies them to its copy of the store. Reads are served from the local
+// copy, or ordered through consensus like the writes to be linearizable.
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"simple-p2p/chain"
+	"simple-p2p/merkle"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var ErrInvalidOp = errors.New("invalid operation")
+
+var (
+	maxKeySize   = 1 << 10  // size of the largest key
+	maxValueSize = 64 << 10 // size of the largest value
+)
+
+// Types of operations.
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+	OpRead   = "read" // orders a linearizable read, it does not change the state
+)
+
+// Query paths of the application.
+const (
+	QueryGet = "get" // value of the key given as data
+)
+
+var (
+	dataPrefix = []byte("d/") // keys of the store
+	heightKey  = []byte("m/height")
+	hashKey    = []byte("m/hash")
+)
+
+// Op is an operation on the store, the data of a transaction.
+type Op struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Nonce string `json:"nonce,omitempty"` // tells apart identical operations
+}
+
+// check checks that the operation is well formed.
+func (o Op) check() error {
+	switch o.Op {
+	case OpPut, OpDelete, OpRead:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidOp, o.Op)
+	}
+	if o.Key == "" || len(o.Key) > maxKeySize {
+		return fmt.Errorf("%w: key of %d bytes", ErrInvalidOp, len(o.Key))
+	}
+	if len(o.Value) > maxValueSize {
+		return fmt.Errorf("%w: value of %d bytes", ErrInvalidOp, len(o.Value))
+	}
+	return nil
+}
+
+// decodeOp decodes the operation of a transaction.
+func decodeOp(tx chain.Tx) (Op, error) {
+	var o Op
+	if err := json.Unmarshal(tx.Data, &o); err != nil {
+		return Op{}, fmt.Errorf("%w: %v", ErrInvalidOp, err)
+	}
+	return o, o.check()
+}
+
+// App is the key-value store replicated by the nodes, a node.Application. The
+// keys are kept in memory and written to the store with the height and state
+// hash of each committed block, in one batch, so a restarted node resumes
+// from its last committed block.
+type App struct {
+	store storage.Store
+
+	data    map[string]string // keys of the committed and executed blocks
+	batch   *storage.Batch    // writes of the executed block, until its commit
+	height  uint64            // height of the last executed block
+	commits uint64            // height of the last committed block
+	hash    string            // state hash after the last committed block
+	mux     sync.RWMutex      // mutual exclusion lock for the fields above
+}
+
+var (
+	_ node.Application = (*App)(nil)
+	_ node.Snapshotter = (*App)(nil)
+	_ node.StateProver = (*App)(nil)
+)
+
+// NewApp creates the store, and loads the state committed in store.
+func NewApp(store storage.Store) (*App, error) {
+	a := &App{
+		store: store,
+		data:  make(map[string]string),
+		batch: storage.NewBatch(),
+	}
+
+	it := store.Iterator(dataPrefix)
+	defer it.Release()
+	for it.Next() {
+		a.data[string(it.Key()[len(dataPrefix):])] = string(it.Value())
+	}
+
+	if value, err := store.Get(heightKey); err == nil {
+		if a.commits, err = strconv.ParseUint(string(value), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid height: %w", err)
+		}
+		a.height = a.commits
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if value, err := store.Get(hashKey); err == nil {
+		a.hash = string(value)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Info returns the height of the last committed block and the state hash
+// after it.
+func (a *App) Info() (uint64, string) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return a.commits, a.hash
+}
+
+// CheckTx checks that a transaction is a well formed operation.
+func (a *App) CheckTx(tx chain.Tx) error {
+	_, err := decodeOp(tx)
+	return err
+}
+
+// Execute applies the operations of a block in order, skipping the invalid
+// ones.
+func (a *App) Execute(b chain.Block) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, tx := range b.Txs {
+		o, err := decodeOp(tx)
+		if err != nil {
+			continue
+		}
+
+		key := append(append([]byte{}, dataPrefix...), o.Key...)
+		switch o.Op {
+		case OpPut:
+			a.data[o.Key] = o.Value
+			a.batch.Set(key, []byte(o.Value))
+		case OpDelete:
+			delete(a.data, o.Key)
+			a.batch.Delete(key)
+		}
+	}
+	a.height = b.Height
+	return nil
+}
+
+// Commit writes the executed block and returns the state hash.
+func (a *App) Commit() (string, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	hash := a.stateHash()
+	a.batch.Set(heightKey, []byte(strconv.FormatUint(a.height, 10)))
+	a.batch.Set(hashKey, []byte(hash))
+	if err := a.store.Write(a.batch); err != nil {
+		return "", err
+	}
+	a.batch.Reset()
+
+	a.commits, a.hash = a.height, hash
+	return hash, nil
+}
+
+// Snapshot returns the committed keys and values as a JSON object.
+func (a *App) Snapshot() ([]byte, error) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return json.Marshal(a.data)
+}
+
+// Restore replaces the store with the keys and values of a snapshot taken
+// after the block at height, if they have the state hash appHash.
+func (a *App) Restore(height uint64, data []byte, appHash string) error {
+	restored := make(map[string]string)
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return fmt.Errorf("%w: %v", node.ErrSnapshot, err)
+	}
+	hash := merkle.Root(dataLeaves(restored, sortedKeys(restored)))
+	if hash != appHash {
+		return fmt.Errorf("%w: state hash %v instead of %v", node.ErrSnapshot, hash, appHash)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	batch := storage.NewBatch()
+	for key := range a.data {
+		batch.Delete(append(append([]byte{}, dataPrefix...), key...))
+	}
+	for key, value := range restored {
+		batch.Set(append(append([]byte{}, dataPrefix...), key...), []byte(value))
+	}
+	batch.Set(heightKey, []byte(strconv.FormatUint(height, 10)))
+	batch.Set(hashKey, []byte(hash))
+	if err := a.store.Write(batch); err != nil {
+		return err
+	}
+
+	a.data = restored
+	a.batch.Reset()
+	a.height, a.commits, a.hash = height, height, hash
+	return nil
+}
+
+// Query answers the QueryGet path with the value of the key given as data.
+func (a *App) Query(path string, data []byte) ([]byte, error) {
+	if path != QueryGet {
+		return nil, fmt.Errorf("query path %q: %w", path, node.ErrNotFound)
+	}
+
+	value, ok := a.Get(string(data))
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", data, node.ErrNotFound)
+	}
+	return []byte(value), nil
+}
+
+// Get returns the value of a key in the local state.
+func (a *App) Get(key string) (string, bool) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	value, ok := a.data[key]
+	return value, ok
+}
+
+// Len returns the number of keys.
+func (a *App) Len() int {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return len(a.data)
+}
+
+// ProveKey returns the committed value of a key and the proof of its leaf
+// against the state hash.
+func (a *App) ProveKey(key []byte) ([]byte, merkle.Proof, error) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	value, ok := a.data[string(key)]
+	if !ok {
+		return nil, merkle.Proof{}, fmt.Errorf("key %q: %w", key, node.ErrNotFound)
+	}
+
+	keys := a.sortedKeys()
+	proof, err := merkle.Prove(a.leaves(keys), sort.SearchStrings(keys, string(key)))
+	return []byte(value), proof, err
+}
+
+// sortedKeys returns the keys in order. The lock must be held.
+func (a *App) sortedKeys() []string {
+	return sortedKeys(a.data)
+}
+
+// leaves returns the Merkle leaves of keys and their values. The lock must be
+// held.
+func (a *App) leaves(keys []string) [][]byte {
+	return dataLeaves(a.data, keys)
+}
+
+// sortedKeys returns the keys of data in order.
+func sortedKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dataLeaves returns the Merkle leaves of keys and their values in data.
+func dataLeaves(data map[string]string, keys []string) [][]byte {
+	leaves := make([][]byte, len(keys))
+	for i, key := range keys {
+		leaves[i] = merkle.KeyValueLeaf([]byte(key), []byte(data[key]))
+	}
+	return leaves
+}
+
+// stateHash returns the Merkle root of the keys and values in key order. The
+// lock must be held.
+func (a *App) stateHash() string {
+	return merkle.Root(a.leaves(a.sortedKeys()))
+}
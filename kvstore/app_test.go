@@ -0,0 +1,128 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/chain"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"testing"
+)
+
+// ops returns the transactions of operations.
+func ops(t *testing.T, list ...Op) []chain.Tx {
+	var txs []chain.Tx
+	for _, o := range list {
+		data, err := json.Marshal(o)
+		assert.NoError(t, err)
+		txs = append(txs, chain.Tx{Data: data})
+	}
+	return txs
+}
+
+func TestApp(t *testing.T) {
+	store := storage.NewMemory()
+	app, err := NewApp(store)
+	assert.NoError(t, err)
+
+	assert.NoError(t, app.CheckTx(ops(t, Op{Op: OpPut, Key: "a", Value: "1"})[0]))
+	assert.ErrorIs(t, app.CheckTx(ops(t, Op{Op: "incr", Key: "a"})[0]), ErrInvalidOp)
+	assert.ErrorIs(t, app.CheckTx(ops(t, Op{Op: OpPut})[0]), ErrInvalidOp)
+	assert.ErrorIs(t, app.CheckTx(chain.Tx{Data: []byte("put a 1")}), ErrInvalidOp)
+
+	// operations are applied in order, invalid ones and reads are skipped
+	txs := ops(t,
+		Op{Op: OpPut, Key: "a", Value: "1"},
+		Op{Op: OpPut, Key: "b", Value: "2"},
+		Op{Op: OpRead, Key: "b"},
+		Op{Op: OpDelete, Key: "b"},
+		Op{Op: OpPut, Key: "a", Value: "3"},
+	)
+	txs = append(txs, chain.Tx{Data: []byte("invalid")})
+	assert.NoError(t, app.Execute(chain.Block{Height: 1, Txs: txs}))
+	hash, err := app.Commit()
+	assert.NoError(t, err)
+
+	height, infoHash := app.Info()
+	assert.Equal(t, uint64(1), height)
+	assert.Equal(t, hash, infoHash)
+	value, ok := app.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "3", value)
+	_, ok = app.Get("b")
+	assert.False(t, ok)
+
+	data, err := app.Query(QueryGet, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("3"), data)
+	_, err = app.Query(QueryGet, []byte("b"))
+	assert.ErrorIs(t, err, node.ErrNotFound)
+
+	// the same state has the same hash, whatever the operations
+	other, _ := NewApp(storage.NewMemory())
+	assert.NoError(t, other.Execute(chain.Block{Height: 1, Txs: ops(t, Op{Op: OpPut, Key: "a", Value: "3"})}))
+	otherHash, _ := other.Commit()
+	assert.Equal(t, hash, otherHash)
+
+	// a reopened store resumes from its last committed block
+	assert.NoError(t, app.Execute(chain.Block{Height: 2, Txs: ops(t, Op{Op: OpPut, Key: "c", Value: "4"})}))
+	reopened, err := NewApp(store)
+	assert.NoError(t, err)
+	height, infoHash = reopened.Info()
+	assert.Equal(t, uint64(1), height)
+	assert.Equal(t, hash, infoHash)
+	assert.Equal(t, 1, reopened.Len())
+}
+
+func TestAppSnapshot(t *testing.T) {
+	app, _ := NewApp(storage.NewMemory())
+	assert.NoError(t, app.Execute(chain.Block{Height: 1, Txs: ops(t, Op{Op: OpPut, Key: "a", Value: "1"}, Op{Op: OpPut, Key: "b", Value: "2"})}))
+	hash, _ := app.Commit()
+	data, err := app.Snapshot()
+	assert.NoError(t, err)
+
+	// the restored store replaces the previous keys and persists the state
+	store := storage.NewMemory()
+	restored, _ := NewApp(store)
+	assert.NoError(t, restored.Execute(chain.Block{Height: 1, Txs: ops(t, Op{Op: OpPut, Key: "c", Value: "3"})}))
+	_, _ = restored.Commit()
+	assert.NoError(t, restored.Restore(7, data, hash))
+
+	reopened, err := NewApp(store)
+	assert.NoError(t, err)
+	height, infoHash := reopened.Info()
+	assert.Equal(t, uint64(7), height)
+	assert.Equal(t, hash, infoHash)
+	assert.Equal(t, 2, reopened.Len())
+	_, ok := reopened.Get("c")
+	assert.False(t, ok)
+
+	// invalid data or data of another state is rejected before any change
+	assert.ErrorIs(t, restored.Restore(8, []byte("not a snapshot"), hash), node.ErrSnapshot)
+	assert.ErrorIs(t, restored.Restore(8, []byte(`{"a":"2"}`), hash), node.ErrSnapshot)
+	height, infoHash = restored.Info()
+	assert.Equal(t, uint64(7), height)
+	assert.Equal(t, hash, infoHash)
+	assert.Equal(t, 2, restored.Len())
+}
+
+func TestAppProof(t *testing.T) {
+	app, _ := NewApp(storage.NewMemory())
+	assert.NoError(t, app.Execute(chain.Block{Height: 1, Txs: ops(t,
+		Op{Op: OpPut, Key: "a", Value: "1"},
+		Op{Op: OpPut, Key: "b", Value: "2"},
+		Op{Op: OpPut, Key: "c", Value: "3"},
+	)}))
+	hash, _ := app.Commit()
+
+	// the value of each key is proved against the state hash
+	for _, key := range []string{"a", "b", "c"} {
+		value, proof, err := app.ProveKey([]byte(key))
+		assert.NoError(t, err)
+		assert.NoError(t, node.StateProof{Key: []byte(key), Value: value, Proof: proof}.Verify(hash))
+		assert.Error(t, node.StateProof{Key: []byte(key), Value: []byte("4"), Proof: proof}.Verify(hash))
+	}
+
+	_, _, err := app.ProveKey([]byte("d"))
+	assert.ErrorIs(t, err, node.ErrNotFound)
+}
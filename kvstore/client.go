@@ -0,0 +1,162 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"simple-p2p/chain"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"sync"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// Consistency is the consistency of a read.
+type Consistency string
+
+const (
+	// Local reads the state of the node, which may lag behind the other nodes.
+	Local Consistency = "local"
+
+	// Linearizable orders the read through consensus like a write, so it sees
+	// every write completed before it started, on any node.
+	Linearizable Consistency = "linearizable"
+)
+
+// Result is the outcome of a committed operation.
+type Result struct {
+	Height uint64 `json:"height"`          // height of the block of the operation
+	Value  string `json:"value,omitempty"` // value read by a read
+	Found  bool   `json:"found"`           // whether the key of a read exists
+}
+
+// Client submits the operations of the clients of a node and waits for the
+// blocks that commit them.
+type Client struct {
+	node *node.Node
+	app  *App
+	pool *mempool.Mempool
+
+	waiters map[string]chan Result // operations waiting for their block by transaction hash
+	mux     sync.Mutex             // mutual exclusion lock for the waiters
+}
+
+// NewClient creates the client of a node running app, submitting the
+// operations to pool.
+func NewClient(n *node.Node, app *App, pool *mempool.Mempool) *Client {
+	c := &Client{
+		node:    n,
+		app:     app,
+		pool:    pool,
+		waiters: make(map[string]chan Result),
+	}
+
+	n.OnCommit(c.committed)
+	return c
+}
+
+// Put sets the value of a key, and returns once the write is committed by
+// the node.
+func (c *Client) Put(ctx context.Context, key string, value string) (Result, error) {
+	return c.submit(ctx, Op{Op: OpPut, Key: key, Value: value})
+}
+
+// Delete deletes a key, and returns once the deletion is committed by the
+// node. Deleting a missing key is not an error.
+func (c *Client) Delete(ctx context.Context, key string) (Result, error) {
+	return c.submit(ctx, Op{Op: OpDelete, Key: key})
+}
+
+// Get returns the value of a key with the given consistency, or
+// ErrKeyNotFound.
+func (c *Client) Get(ctx context.Context, key string, consistency Consistency) (Result, error) {
+	var r Result
+	switch consistency {
+	case Local:
+		r.Height, _ = c.node.AppInfo()
+		r.Value, r.Found = c.app.Get(key)
+	case Linearizable:
+		var err error
+		if r, err = c.submit(ctx, Op{Op: OpRead, Key: key}); err != nil {
+			return Result{}, err
+		}
+	default:
+		return Result{}, fmt.Errorf("%w: unknown consistency %q", ErrInvalidOp, consistency)
+	}
+
+	if !r.Found {
+		return r, fmt.Errorf("key %q: %w", key, ErrKeyNotFound)
+	}
+	return r, nil
+}
+
+// submit adds an operation to the mempool and waits for the node to commit
+// it, until ctx is done.
+func (c *Client) submit(ctx context.Context, o Op) (Result, error) {
+	if err := o.check(); err != nil {
+		return Result{}, err
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return Result{}, err
+	}
+	o.Nonce = hex.EncodeToString(nonce)
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return Result{}, err
+	}
+	tx := chain.Tx{Data: data}
+	hash := tx.Hash()
+
+	// wait before adding, the block may be committed before Add returns
+	done := make(chan Result, 1)
+	c.mux.Lock()
+	c.waiters[hash] = done
+	c.mux.Unlock()
+	defer func() {
+		c.mux.Lock()
+		delete(c.waiters, hash)
+		c.mux.Unlock()
+	}()
+
+	if err := c.pool.Add(ctx, tx); err != nil {
+		return Result{}, err
+	}
+
+	select {
+	case r := <-done:
+		return r, nil
+	case <-ctx.Done():
+		return Result{}, fmt.Errorf("operation %v: %w", hash, ctx.Err())
+	}
+}
+
+// committed completes the operations of a block committed by the node. Reads
+// see the state after the block.
+func (c *Client) committed(b chain.Block) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if len(c.waiters) == 0 {
+		return
+	}
+
+	for _, tx := range b.Txs {
+		done, ok := c.waiters[tx.Hash()]
+		if !ok {
+			continue
+		}
+
+		r := Result{Height: b.Height}
+		if o, err := decodeOp(tx); err == nil && o.Op == OpRead {
+			r.Value, r.Found = c.app.Get(o.Key)
+		}
+		done <- r
+	}
+}
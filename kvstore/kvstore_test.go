@@ -0,0 +1,169 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/consensus"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockInterval is the block interval of the test clusters.
+var blockInterval = 20 * time.Millisecond
+
+// member is a node of a test cluster.
+type member struct {
+	node   *node.Node
+	app    *App
+	client *Client
+}
+
+// newCluster starts size nodes running the store, connected to each other
+// and building blocks until the test ends.
+func newCluster(t *testing.T, size int) []member {
+	network := transport.NewMemory()
+	params := consensus.SnowParams{K: 2, A: 2, B: 3, MaxStep: 100}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var members []member
+	var waiter sync.WaitGroup
+	for i := 0; i < size; i++ {
+		app, err := NewApp(storage.NewMemory())
+		assert.NoError(t, err)
+
+		engine := consensus.NewConsensus(params)
+		n := node.NewNode(fmt.Sprintf("kv-%d", i), node.WithTransport(network), node.WithApplication(app), node.WithConsensus(engine))
+		pool := mempool.New(n)
+		builder := consensus.NewBlockBuilder(n, engine, pool)
+		members = append(members, member{node: n, app: app, client: NewClient(n, app, pool)})
+
+		assert.NoError(t, n.StartServer())
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			builder.Run(ctx, blockInterval)
+		}()
+	}
+	for _, m := range members {
+		for _, other := range members {
+			if other.node != m.node {
+				m.node.PeerManager.AddPeers(other.node.Address)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		waiter.Wait()
+		for _, m := range members {
+			m.node.StopServer()
+		}
+	})
+	return members
+}
+
+func TestCluster(t *testing.T) {
+	members := newCluster(t, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// a write completed on a node is seen by the linearizable reads of the others
+	put, err := members[0].client.Put(ctx, "a", "1")
+	assert.NoError(t, err)
+	assert.Positive(t, put.Height)
+	for _, m := range members[1:] {
+		get, err := m.client.Get(ctx, "a", Linearizable)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", get.Value)
+		assert.Greater(t, get.Height, put.Height)
+	}
+
+	_, err = members[1].client.Delete(ctx, "a")
+	assert.NoError(t, err)
+	_, err = members[2].client.Get(ctx, "a", Linearizable)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// concurrent writes on every node reach the same state
+	var waiter sync.WaitGroup
+	for i, m := range members {
+		for j := 0; j < 5; j++ {
+			waiter.Add(1)
+			go func(c *Client, key string) {
+				defer waiter.Done()
+				_, err := c.Put(ctx, key, key)
+				assert.NoError(t, err)
+			}(m.client, fmt.Sprintf("%d-%d", i, j))
+		}
+	}
+	waiter.Wait()
+
+	assert.Eventually(t, func() bool {
+		height, hash := members[0].node.AppInfo()
+		for _, m := range members[1:] {
+			if otherHeight, otherHash := m.node.AppInfo(); otherHeight != height || otherHash != hash {
+				return false
+			}
+		}
+		return members[0].app.Len() == 15
+	}, 10*time.Second, 10*time.Millisecond)
+
+	for _, m := range members {
+		get, err := m.client.Get(ctx, "2-4", Local)
+		assert.NoError(t, err)
+		assert.Equal(t, "2-4", get.Value)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	members := newCluster(t, 3)
+	api := node.NewAPI(members[0].node)
+	RegisterAPI(api, members[0].client)
+	other := node.NewAPI(members[1].node)
+	RegisterAPI(other, members[1].client)
+
+	serve := func(api *node.API, method string, target string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := serve(api, http.MethodPut, "/kv/greeting", `{"value": "hello"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"value":"hello"`)
+
+	w = serve(other, http.MethodGet, "/kv/greeting?consistency=linearizable", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"value":"hello"`)
+
+	// the state proof of a key verifies against the app_hash of the next block
+	w = serve(other, http.MethodGet, "/proofs/state/greeting", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var proof node.StateProof
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&proof))
+	assert.Equal(t, "hello", string(proof.Value))
+	assert.Equal(t, http.StatusOK, serve(api, http.MethodPut, "/kv/other", `{"value": "next block"}`).Code)
+	next, ok := members[0].node.Chain.Get(proof.Height + 1)
+	if assert.True(t, ok) {
+		assert.NoError(t, proof.Verify(next.AppHash))
+	}
+
+	w = serve(other, http.MethodDelete, "/kv/greeting", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Eventually(t, func() bool {
+		return serve(api, http.MethodGet, "/kv/greeting", "").Code == http.StatusNotFound
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, http.StatusBadRequest, serve(api, http.MethodGet, "/kv/greeting?consistency=eventual", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(api, http.MethodPut, "/kv/greeting", "hello").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(api, http.MethodPost, "/kv/greeting", "").Code)
+}
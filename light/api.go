@@ -0,0 +1,154 @@
+package light
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API is the HTTP/JSON API of a light client. It serves the final headers,
+// and the transactions and the state keys checked against them.
+type API struct {
+	client *Client
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+// NewAPI creates the API of a light client.
+func NewAPI(c *Client) *API {
+	a := &API{
+		client: c,
+		mux:    http.NewServeMux(),
+	}
+
+	a.handle("/chain/head", a.getHead)
+	a.handle("/chain/headers/", a.getHeader)
+	a.handle("/txs/", a.getTx)
+	a.handle("/state/", a.getKey)
+	return a
+}
+
+// handle registers the handler of a path pattern that only accepts GET.
+func (a *API) handle(pattern string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			node.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// ServeHTTP serves an API request.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Start starts serving the API on a TCP address in background.
+func (a *API) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	a.server = &http.Server{Handler: a, ReadHeaderTimeout: 5 * time.Second}
+	a.client.logger.Info("api is listening", logger.F("addr", lis.Addr()))
+
+	go func() {
+		if err := a.server.Serve(lis); err != nil && err != http.ErrServerClosed {
+			a.client.logger.Error("api stopped", logger.Err(err))
+		}
+	}()
+	return nil
+}
+
+// Stop stops the API server.
+func (a *API) Stop(ctx context.Context) error {
+	if a.server == nil {
+		return nil
+	}
+	return a.server.Shutdown(ctx)
+}
+
+// getHead returns the last final header.
+func (a *API) getHead(w http.ResponseWriter, _ *http.Request) {
+	head, ok := a.client.Head()
+	if !ok {
+		node.WriteError(w, http.StatusNotFound, fmt.Errorf("no final header: %w", node.ErrNotFound))
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, head)
+}
+
+// getHeader returns the final header at the height of the path
+// /chain/headers/{height}.
+func (a *API) getHeader(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/chain/headers/"), 10, 64)
+	if err != nil {
+		node.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid height: %w", node.ErrBadRequest))
+		return
+	}
+
+	h, ok := a.client.Header(height)
+	if !ok {
+		node.WriteError(w, http.StatusNotFound, fmt.Errorf("header %d: %w", height, node.ErrNotFound))
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, h)
+}
+
+// getTx returns the transaction of the path /txs/{hash} in the block at the
+// height of the query parameter, once checked.
+func (a *API) getTx(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/txs/")
+	height, err := node.QueryUint(r, "height", 0)
+	if err == nil && height == 0 {
+		err = fmt.Errorf("height is required: %w", node.ErrBadRequest)
+	}
+	if err != nil {
+		node.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := a.client.Tx(r.Context(), height, hash)
+	if err != nil {
+		node.WriteError(w, errorStatus(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, tx)
+}
+
+// getKey returns the proof of the key of the path /state/{key}, once checked.
+func (a *API) getKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/state/")
+	if key == "" {
+		node.WriteError(w, http.StatusBadRequest, fmt.Errorf("key is required: %w", node.ErrBadRequest))
+		return
+	}
+
+	proof, err := a.client.Key(r.Context(), []byte(key))
+	if err != nil {
+		node.WriteError(w, errorStatus(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, proof)
+}
+
+// errorStatus returns the HTTP status of a failed check: not found if the
+// data is not final yet or unknown to the validators, bad gateway otherwise.
+func errorStatus(err error) int {
+	if errors.Is(err, ErrNotFinal) || status.Code(err) == codes.NotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
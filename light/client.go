@@ -0,0 +1,563 @@
+// Package light is a light client of the network. It follows the finalized
+// headers without replicating the blocks nor joining consensus: a header is
+// final once a finality certificate of the validators holding a quorum of the
+// weight of the validator set signs it, and the headers below it are verified
+// by their hash links. With WithUnsignedQuorum, a header that validators
+// holding a quorum serve is final without a certificate. The
+// transactions and the application state the client cares about are then
+// checked with the Merkle proofs of any single node.
+package light
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"simple-p2p/chain"
+	"simple-p2p/consensus"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoQuorum = errors.New("no quorum of validators")
+	ErrNotFinal = errors.New("not final yet")
+)
+
+var (
+	headerBatchSize = 128                    // number of headers of a request
+	requestTimeout  = 5 * time.Second        // timeout of a request to a validator
+	pollInterval    = 100 * time.Millisecond // interval of the syncs while waiting for a header
+)
+
+// Client is a light client following the finalized headers of the validators
+// of a validator set.
+type Client struct {
+	validators  *consensus.ValidatorSet
+	threshold   finality.Threshold  // quorum of the total weight
+	unsigned    bool                // a header served by a quorum is final without a certificate
+	transport   transport.Transport // transport to dial the validators on
+	dialOptions []grpc.DialOption   // extra options of the connections
+	logger      logger.Logger
+
+	conns map[string]*grpc.ClientConn // connections to the validators by address
+	base  uint64                      // height of the header before the first one kept
+	chain []chain.Header              // verified headers by height - base - 1
+	mux   sync.RWMutex                // mutual exclusion lock for the fields above
+}
+
+// Option configures a light client.
+type Option func(*Client)
+
+// WithQuorum sets the fraction num/den of the total weight of the validators
+// that must sign or serve a header for it to be final. The default is 2/3. A
+// fraction that is not a majority is ignored, as two quorums of it could make
+// conflicting headers final.
+func WithQuorum(num, den uint64) Option {
+	return func(c *Client) {
+		if t := (finality.Threshold{Num: num, Den: den}); t.Majority() {
+			c.threshold = t
+		}
+	}
+}
+
+// WithUnsignedQuorum makes a header without a valid certificate final once
+// validators holding a quorum of the weight serve it. The serving validators
+// sign nothing, so the client trusts them not to collude, and the validator
+// set needs no public keys. By default only a certificate makes a header
+// final.
+func WithUnsignedQuorum() Option {
+	return func(c *Client) {
+		c.unsigned = true
+	}
+}
+
+// WithTransport sets the transport to dial the validators on. The default is
+// TCP.
+func WithTransport(t transport.Transport) Option {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithDialOptions adds options to the connections to the validators.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.dialOptions = append(c.dialOptions, opts...)
+	}
+}
+
+// WithTrustedHeader starts the client from a header trusted out of band, e.g.
+// the first header of the chain. The headers synced afterward must link to
+// it. By default the client trusts the first header a quorum serves.
+func WithTrustedHeader(h chain.Header) Option {
+	return func(c *Client) {
+		c.base, c.chain = h.Height-1, []chain.Header{h}
+	}
+}
+
+// WithLogger sets the logger of the client. The default discards logs.
+func WithLogger(l logger.Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// New creates a light client of the validators of a set, reached at their
+// addresses.
+func New(validators *consensus.ValidatorSet, opts ...Option) *Client {
+	c := &Client{
+		validators: validators,
+		threshold:  finality.DefaultThreshold,
+		transport:  transport.NewTCP(),
+		logger:     logger.Nop(),
+		conns:      make(map[string]*grpc.ClientConn),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes the connections to the validators.
+func (c *Client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var err error
+	for addr, conn := range c.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(c.conns, addr)
+	}
+	return err
+}
+
+// Head returns the last final header.
+func (c *Client) Head() (chain.Header, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if len(c.chain) == 0 {
+		return chain.Header{}, false
+	}
+	return c.chain[len(c.chain)-1], true
+}
+
+// Header returns the final header at a height, if the client synced it.
+func (c *Client) Header(height uint64) (chain.Header, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if height <= c.base || height > c.base+uint64(len(c.chain)) {
+		return chain.Header{}, false
+	}
+	return c.chain[height-c.base-1], true
+}
+
+// validatorStatus is the chain status of a validator.
+type validatorStatus struct {
+	validator consensus.Validator
+	height    uint64
+}
+
+// Sync downloads the headers up to the highest height served by a quorum of
+// the validators, and returns the number of headers added. The highest header
+// must be served by the quorum, the others must link to it.
+func (c *Client) Sync(ctx context.Context) (int, error) {
+	statuses, total := c.statuses(ctx)
+	if total == 0 {
+		return 0, fmt.Errorf("%w: the validator set is empty", ErrNoQuorum)
+	}
+
+	// the target is the highest height that a quorum reached
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].height > statuses[j].height })
+	var target, weight uint64
+	for _, st := range statuses {
+		weight += st.validator.Weight
+		if c.quorum(weight, total) {
+			target = st.height
+			break
+		}
+	}
+	if target == 0 {
+		return 0, fmt.Errorf("%w: %d of %d answered", ErrNoQuorum, weight, total)
+	}
+
+	head, ok := c.Head()
+	if ok && target <= head.Height {
+		return 0, nil
+	}
+	from := target
+	if ok {
+		from = head.Height + 1
+	}
+
+	var err error
+	for _, source := range statuses {
+		if source.height < target {
+			break
+		}
+
+		var headers []chain.Header
+		headers, err = c.fetchHeaders(ctx, source.validator.Address, from, target, head)
+		if err == nil {
+			err = c.checkFinal(ctx, headers[len(headers)-1], source, statuses, total)
+		}
+		if err == nil {
+			return c.append(headers)
+		}
+		c.logger.Warn("failed to sync headers", logger.F("validator", source.validator.Address), logger.Err(err))
+	}
+	return 0, err
+}
+
+// Follow syncs the headers every interval until ctx is done.
+func (c *Client) Follow(ctx context.Context, interval time.Duration) {
+	for {
+		if n, err := c.Sync(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("failed to sync headers", logger.Err(err))
+		} else if n > 0 {
+			head, _ := c.Head()
+			c.logger.Debug("synced headers", logger.F("height", head.Height), logger.F("headers", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// quorum reports whether a weight is a quorum of the total weight.
+func (c *Client) quorum(weight uint64, total uint64) bool {
+	return c.threshold.Reached(weight, total)
+}
+
+// statuses asks the validators for their chain status in parallel, and
+// returns the statuses of those that answered and the total weight of the
+// set.
+func (c *Client) statuses(ctx context.Context) ([]validatorStatus, uint64) {
+	validators := c.validators.Validators()
+
+	var (
+		statuses []validatorStatus
+		total    uint64
+		mux      sync.Mutex
+		waiter   sync.WaitGroup
+	)
+	for _, v := range validators {
+		total += v.Weight
+		waiter.Add(1)
+		go func(v consensus.Validator) {
+			defer waiter.Done()
+
+			client, err := c.client(v.Address)
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+
+			st, err := client.GetChainStatus(ctx, &proto.Empty{})
+			if err != nil {
+				return
+			}
+
+			mux.Lock()
+			statuses = append(statuses, validatorStatus{validator: v, height: st.Height})
+			mux.Unlock()
+		}(v)
+	}
+	waiter.Wait()
+	return statuses, total
+}
+
+// fetchHeaders downloads the headers from a height to a target from a
+// validator, and checks their hashes and that they link to each other and to
+// the local head, if any.
+func (c *Client) fetchHeaders(ctx context.Context, addr string, from uint64, target uint64, head chain.Header) ([]chain.Header, error) {
+	client, err := c.client(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]chain.Header, 0, target-from+1)
+	for height := from; height <= target; {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		response, err := client.GetHeaders(ctx, &proto.RangeRequest{From: height, Limit: int64(headerBatchSize)})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Headers) == 0 {
+			return nil, fmt.Errorf("no header at height %d", height)
+		}
+
+		for _, pb := range response.Headers {
+			h := node.HeaderFromProto(pb)
+			if h.Height != height || h.Hash != h.ComputeHash() {
+				return nil, fmt.Errorf("%w: header at height %d does not match its hash", chain.ErrInvalidBlock, height)
+			}
+			if prev := previous(headers, head); prev != nil && h.PrevHash != prev.Hash {
+				return nil, fmt.Errorf("%w: header at height %d does not link", chain.ErrInvalidBlock, height)
+			}
+			headers = append(headers, h)
+			if height++; height > target {
+				break
+			}
+		}
+	}
+	return headers, nil
+}
+
+// previous returns the header before the next one of headers, the last of
+// headers or the local head, nil if there is none.
+func previous(headers []chain.Header, head chain.Header) *chain.Header {
+	if len(headers) > 0 {
+		return &headers[len(headers)-1]
+	}
+	if head.Height > 0 {
+		return &head
+	}
+	return nil
+}
+
+// checkFinal checks that the certificate of a header served by its source
+// holds the votes of a quorum of the weight, or else, with an unsigned quorum,
+// that validators holding a quorum of the weight serve the header.
+func (c *Client) checkFinal(ctx context.Context, h chain.Header, source validatorStatus, statuses []validatorStatus, total uint64) error {
+	err := c.checkCertificate(ctx, source.validator.Address, h)
+	if err == nil {
+		return nil
+	}
+	if !c.unsigned {
+		return fmt.Errorf("header %d: %w: %v", h.Height, ErrNotFinal, err)
+	}
+	c.logger.Debug("no valid certificate", logger.F("validator", source.validator.Address), logger.F("height", h.Height), logger.Err(err))
+
+	var (
+		weight uint64
+		mux    sync.Mutex
+		waiter sync.WaitGroup
+	)
+	for _, st := range statuses {
+		if st.height < h.Height {
+			continue
+		}
+		waiter.Add(1)
+		go func(st validatorStatus) {
+			defer waiter.Done()
+
+			client, err := c.client(st.validator.Address)
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+
+			response, err := client.GetHeaders(ctx, &proto.RangeRequest{From: h.Height, Limit: 1})
+			if err != nil || len(response.Headers) != 1 || response.Headers[0].Hash != h.Hash {
+				return
+			}
+
+			mux.Lock()
+			weight += st.validator.Weight
+			mux.Unlock()
+		}(st)
+	}
+	waiter.Wait()
+
+	if !c.quorum(weight, total) {
+		return fmt.Errorf("%w: %d of %d serve header %d", ErrNoQuorum, weight, total, h.Height)
+	}
+	return nil
+}
+
+// checkCertificate checks the finality certificate of a header served by a
+// validator against the public keys of the validators.
+func (c *Client) checkCertificate(ctx context.Context, addr string, h chain.Header) error {
+	client, err := c.client(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	pb, err := client.GetCertificate(ctx, &proto.BlockRequest{Height: h.Height})
+	if err != nil {
+		return err
+	}
+	certificate := node.CertificateFromProto(pb)
+	if certificate.Height != h.Height || certificate.BlockHash != h.Hash {
+		return fmt.Errorf("%w: certificate of block %v at height %d", finality.ErrInvalidCertificate, certificate.BlockHash, certificate.Height)
+	}
+	return certificate.Verify(c.validators, c.threshold)
+}
+
+// append appends verified headers, unless another sync appended headers
+// meanwhile.
+func (c *Client) append(headers []chain.Header) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	first := headers[0]
+	if len(c.chain) == 0 {
+		c.base = first.Height - 1
+	} else if last := c.chain[len(c.chain)-1]; first.Height != last.Height+1 || first.PrevHash != last.Hash {
+		return 0, fmt.Errorf("%w: header at height %d does not extend the chain", chain.ErrInvalidBlock, first.Height)
+	}
+	c.chain = append(c.chain, headers...)
+	return len(headers), nil
+}
+
+// client returns a chain service client of a validator.
+func (c *Client) client(addr string) (proto.ChainServiceClient, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	conn, ok := c.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = c.transport.Dial(addr, c.dialOptions...); err != nil {
+			return nil, err
+		}
+		c.conns[addr] = conn
+	}
+	return proto.NewChainServiceClient(conn), nil
+}
+
+// Tx returns the transaction of a hash in the block at a height, checked with
+// its proof against the final header of the block. It returns ErrNotFinal if
+// the header is not final yet.
+func (c *Client) Tx(ctx context.Context, height uint64, hash string) (chain.Tx, error) {
+	var proof node.TxProof
+	err := c.request(ctx, func(ctx context.Context, client proto.ChainServiceClient) error {
+		pb, err := client.GetTxProof(ctx, &proto.TxProofRequest{Height: height, Hash: hash})
+		if err != nil {
+			return err
+		}
+		proof = node.TxProofFromProto(pb)
+		if proof.Tx.Hash() != hash {
+			return fmt.Errorf("got transaction %v", proof.Tx.Hash())
+		}
+		return c.VerifyTx(ctx, proof)
+	})
+	return proof.Tx, err
+}
+
+// Key returns the proof of the value of a key in the application state,
+// checked against the final header of the next block. The state of a node is
+// the one of its last block, so it waits for the next block to be final, and
+// returns ErrNotFinal if it is not before the request times out.
+func (c *Client) Key(ctx context.Context, key []byte) (node.StateProof, error) {
+	var proof node.StateProof
+	err := c.request(ctx, func(ctx context.Context, client proto.ChainServiceClient) error {
+		pb, err := client.GetStateProof(ctx, &proto.StateProofRequest{Key: key})
+		if err != nil {
+			return err
+		}
+		proof = node.StateProofFromProto(pb)
+		if string(proof.Key) != string(key) {
+			return fmt.Errorf("got key %q", proof.Key)
+		}
+		h, err := c.waitFinal(ctx, proof.Height+1)
+		if err != nil {
+			return err
+		}
+		return proof.Verify(h.AppHash)
+	})
+	return proof, err
+}
+
+// request calls the validators in turn until a call succeeds, or fails with
+// ErrNotFinal or ctx.Err().
+func (c *Client) request(ctx context.Context, call func(context.Context, proto.ChainServiceClient) error) error {
+	err := fmt.Errorf("%w: no validator", ErrNoQuorum)
+	for _, v := range c.validators.Validators() {
+		var client proto.ChainServiceClient
+		if client, err = c.client(v.Address); err == nil {
+			rctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			err = call(rctx, client)
+			cancel()
+		}
+		if err == nil || errors.Is(err, ErrNotFinal) || ctx.Err() != nil {
+			return err
+		}
+		c.logger.Warn("failed to get proof", logger.F("validator", v.Address), logger.Err(err))
+	}
+	return err
+}
+
+// VerifyTx checks a transaction proof against the final header of its block,
+// syncing the headers first if needed.
+func (c *Client) VerifyTx(ctx context.Context, proof node.TxProof) error {
+	h, err := c.final(ctx, proof.Height)
+	if err != nil {
+		return err
+	}
+	if h.Hash != proof.BlockHash {
+		return fmt.Errorf("%w: block %d is %v, not %v", chain.ErrInvalidBlock, h.Height, h.Hash, proof.BlockHash)
+	}
+	return proof.Verify(h.TxRoot)
+}
+
+// VerifyState checks a state proof against the final header of the block
+// after the state, syncing the headers first if needed.
+func (c *Client) VerifyState(ctx context.Context, proof node.StateProof) error {
+	h, err := c.final(ctx, proof.Height+1)
+	if err != nil {
+		return err
+	}
+	return proof.Verify(h.AppHash)
+}
+
+// waitFinal returns the final header at a height, syncing the headers until it
+// is final or ctx is done.
+func (c *Client) waitFinal(ctx context.Context, height uint64) (chain.Header, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		h, err := c.final(ctx, height)
+		switch {
+		case err == nil:
+			return h, nil
+		case ctx.Err() != nil:
+			return chain.Header{}, fmt.Errorf("header %d: %w", height, ErrNotFinal)
+		case !errors.Is(err, ErrNotFinal):
+			return chain.Header{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return chain.Header{}, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// final returns the final header at a height, syncing the headers if the
+// client has not reached it. It returns ErrNotFinal if no quorum serves it.
+func (c *Client) final(ctx context.Context, height uint64) (chain.Header, error) {
+	if h, ok := c.Header(height); ok {
+		return h, nil
+	}
+	if head, ok := c.Head(); ok && height <= head.Height {
+		return chain.Header{}, fmt.Errorf("header %d is below the headers kept: %w", height, chain.ErrInvalidBlock)
+	}
+
+	if _, err := c.Sync(ctx); err != nil {
+		return chain.Header{}, err
+	}
+	if h, ok := c.Header(height); ok {
+		return h, nil
+	}
+	return chain.Header{}, fmt.Errorf("header %d: %w", height, ErrNotFinal)
+}
@@ -0,0 +1,215 @@
+package light
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/chain"
+	"simple-p2p/consensus"
+	"simple-p2p/finality"
+	"simple-p2p/kvstore"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+// put returns the transaction of a put.
+func put(key, value string) chain.Tx {
+	data, _ := json.Marshal(kvstore.Op{Op: kvstore.OpPut, Key: key, Value: value})
+	return chain.Tx{Data: data}
+}
+
+func TestClient(t *testing.T) {
+	network := transport.NewMemory()
+	var nodes []*node.Node
+	var validators []consensus.Validator
+	var keys []ed25519.PrivateKey
+	for i := 0; i < 4; i++ {
+		app, err := kvstore.NewApp(storage.NewMemory())
+		assert.NoError(t, err)
+		n := node.NewNode(fmt.Sprintf("node-%d", i), node.WithTransport(network), node.WithApplication(app))
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		nodes = append(nodes, n)
+		pub, key, _ := ed25519.GenerateKey(nil)
+		keys = append(keys, key)
+		validators = append(validators, consensus.Validator{ID: n.Address, Address: n.Address, Weight: 1, PubKey: pub})
+	}
+	set, err := consensus.NewValidatorSet(validators...)
+	assert.NoError(t, err)
+
+	// the first three validators finalize the same blocks, the last one is
+	// ahead on another chain
+	finalize := func(nodes []*node.Node, value int, txs ...chain.Tx) {
+		for _, n := range nodes {
+			_, err := n.Finalize(value, txs)
+			assert.NoError(t, err)
+		}
+	}
+	for i := 1; i <= 5; i++ {
+		finalize(nodes[:3], i, put(fmt.Sprintf("k%d", i), fmt.Sprint(i)), put("last", fmt.Sprint(i)))
+	}
+	for i := 1; i <= 8; i++ {
+		finalize(nodes[3:], -i, put("last", "forked"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the highest header a quorum serves is final
+	client := New(set, WithTransport(network), WithUnsignedQuorum())
+	defer client.Close()
+	added, err := client.Sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	head, ok := client.Head()
+	assert.True(t, ok)
+	expected, _ := nodes[0].Chain.Get(5)
+	assert.Equal(t, expected.Header(), head)
+
+	// from a trusted header, the headers between link to the final one
+	first, _ := nodes[0].Chain.Get(1)
+	client = New(set, WithTransport(network), WithUnsignedQuorum(), WithTrustedHeader(first.Header()))
+	defer client.Close()
+	added, err = client.Sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, added)
+
+	b3, _ := nodes[1].Chain.Get(3)
+	tx, err := client.Tx(ctx, 3, b3.Txs[1].Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, b3.Txs[1], tx)
+	forked, _ := nodes[3].Chain.Get(3)
+	proof, err := nodes[3].ProveTx(3, forked.Txs[0].Hash())
+	assert.NoError(t, err)
+	assert.ErrorIs(t, client.VerifyTx(ctx, proof), chain.ErrInvalidBlock)
+
+	// the state of the last block is final with the next block only
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelShort()
+	_, err = client.Key(short, []byte("last"))
+	assert.ErrorIs(t, err, ErrNotFinal)
+	var state node.StateProof
+	height := 6
+	whileFinalizing := func(f func()) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				finalize(nodes[:3], height)
+				height++
+			}
+		}
+	}
+	whileFinalizing(func() {
+		state, err = client.Key(ctx, []byte("last"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "5", string(state.Value))
+	assert.GreaterOrEqual(t, state.Height, uint64(5))
+
+	stale, err := nodes[3].ProveKey([]byte("last"))
+	assert.NoError(t, err)
+	stale.Height = 5
+	assert.Error(t, client.VerifyState(ctx, stale))
+
+	// without a quorum nothing is final
+	minority := New(set, WithTransport(network), WithUnsignedQuorum(), WithQuorum(4, 4))
+	defer minority.Close()
+	_, err = minority.Sync(ctx)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+
+	// a quorum that is not a majority is ignored
+	assert.Equal(t, finality.DefaultThreshold, New(set, WithQuorum(1, 3)).threshold)
+
+	// a certificate of a quorum proves a header final on its own
+	b5, _ := nodes[0].Chain.Get(5)
+	certificate := finality.Certificate{Height: 5, BlockHash: b5.Hash}
+	for i := 0; i < 2; i++ {
+		certificate.Votes = append(certificate.Votes, finality.Sign(keys[i], validators[i].ID, 0, 5, b5.Hash))
+	}
+	assert.NoError(t, nodes[0].AddCertificate(certificate))
+	assert.ErrorIs(t, client.checkCertificate(ctx, nodes[0].Address, b5.Header()), finality.ErrInvalidCertificate)
+	certificate.Votes = append(certificate.Votes, finality.Sign(keys[2], validators[2].ID, 0, 5, b5.Hash))
+	assert.NoError(t, nodes[0].AddCertificate(certificate))
+	assert.NoError(t, client.checkCertificate(ctx, nodes[0].Address, b5.Header()))
+	assert.Error(t, client.checkCertificate(ctx, nodes[1].Address, b5.Header()))
+
+	api := NewAPI(client)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chain/headers/3", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), b3.Hash)
+
+	w = httptest.NewRecorder()
+	whileFinalizing(func() {
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state/k2", nil).WithContext(ctx))
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/txs/"+b3.Txs[0].Hash(), nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestClientCertificate(t *testing.T) {
+	network := transport.NewMemory()
+	var nodes []*node.Node
+	var validators []consensus.Validator
+	var keys []ed25519.PrivateKey
+	for i := 0; i < 3; i++ {
+		n := node.NewNode(fmt.Sprintf("node-%d", i), node.WithTransport(network))
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		nodes = append(nodes, n)
+		pub, key, _ := ed25519.GenerateKey(nil)
+		keys = append(keys, key)
+		validators = append(validators, consensus.Validator{ID: n.Address, Address: n.Address, Weight: 1, PubKey: pub})
+
+		for value := 1; value <= 3; value++ {
+			_, err := n.Finalize(value, nil)
+			assert.NoError(t, err)
+		}
+	}
+	set, err := consensus.NewValidatorSet(validators...)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// every validator serves the header, but without a certificate it is not
+	// final
+	client := New(set, WithTransport(network))
+	defer client.Close()
+	_, err = client.Sync(ctx)
+	assert.ErrorIs(t, err, ErrNotFinal)
+	_, ok := client.Head()
+	assert.False(t, ok)
+
+	// a certificate served by a single validator is enough
+	b3, _ := nodes[0].Chain.Get(3)
+	certificate := finality.Certificate{Height: 3, BlockHash: b3.Hash}
+	for i := 0; i < 2; i++ {
+		certificate.Votes = append(certificate.Votes, finality.Sign(keys[i], validators[i].ID, 0, 3, b3.Hash))
+	}
+	assert.NoError(t, nodes[2].AddCertificate(certificate))
+	added, err := client.Sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	head, _ := client.Head()
+	assert.Equal(t, b3.Header(), head)
+}
@@ -0,0 +1,249 @@
+package lincheck
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// Result is the outcome of a check.
+type Result int
+
+const (
+	Ok      Result = iota // the history is linearizable
+	Illegal               // the history is not linearizable
+	Timeout               // the check did not complete in time
+)
+
+// String returns the name of the result.
+func (r Result) String() string {
+	switch r {
+	case Ok:
+		return "ok"
+	case Illegal:
+		return "illegal"
+	case Timeout:
+		return "timeout"
+	}
+	return fmt.Sprintf("Result(%d)", int(r))
+}
+
+// Report is the outcome of the check of a history.
+type Report struct {
+	Result     Result
+	Operations int // number of operations checked
+	Partitions int // number of independent histories checked
+
+	// Illegal describes the operations of the first independent history that
+	// is not linearizable, in order of call.
+	Illegal []string
+}
+
+// String returns a one line summary of the report.
+func (r Report) String() string {
+	return fmt.Sprintf("result=%v operations=%d partitions=%d", r.Result, r.Operations, r.Partitions)
+}
+
+// Check checks that a history is linearizable with respect to a model. Each
+// partition of the history is searched for a valid order with the algorithm
+// of Wing and Gong, improved by Lowe with a cache of the visited states. The
+// search is exponential in the worst case, it gives up with Timeout after the
+// timeout, if positive.
+func Check(m Model, history []Operation, timeout time.Duration) Report {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	partitions := [][]Operation{history}
+	if m.Partition != nil {
+		partitions = m.Partition(history)
+	}
+
+	report := Report{Result: Ok, Operations: len(history), Partitions: len(partitions)}
+	for _, p := range partitions {
+		switch result := checkPartition(m, p, deadline); result {
+		case Illegal:
+			report.Result = Illegal
+			sorted := append([]Operation{}, p...)
+			sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Call < sorted[j].Call })
+			for _, o := range sorted {
+				report.Illegal = append(report.Illegal, fmt.Sprintf("client %d: %s", o.Client, m.describe(o)))
+			}
+			return report
+		case Timeout:
+			report.Result = Timeout
+		}
+	}
+	return report
+}
+
+// entry is the call or the return of an operation, in the list of entries
+// ordered by time.
+type entry struct {
+	id     int         // index of the operation
+	call   bool        // the call of the operation, its return otherwise
+	time   int64       // time of the call or the return
+	input  interface{} // input of the operation, on calls
+	output interface{} // output of the operation, nil if unknown, on calls
+	match  *entry      // return of the operation, on calls
+	prev   *entry
+	next   *entry
+}
+
+// lift removes a call and its return from the list.
+func (e *entry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift puts back a call and its return removed by lift.
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// list returns the entries of a history ordered by time after a sentinel
+// head. A call comes before a return at the same time, so the operations are
+// concurrent.
+func list(history []Operation) *entry {
+	entries := make([]*entry, 0, 2*len(history))
+	for i, o := range history {
+		output := o.Output
+		if o.Return == Unknown {
+			output = nil
+		}
+		ret := &entry{id: i, time: o.Return}
+		entries = append(entries,
+			&entry{id: i, call: true, time: o.Call, input: o.Input, output: output, match: ret},
+			ret,
+		)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].call && !entries[j].call
+	})
+
+	head := &entry{id: -1}
+	last := head
+	for _, e := range entries {
+		e.prev = last
+		last.next = e
+		last = e
+	}
+	return head
+}
+
+// bitset is the set of linearized operations.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << uint(i%64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << uint(i%64) }
+
+func (b bitset) clone() bitset {
+	return append(bitset{}, b...)
+}
+
+func (b bitset) equal(other bitset) bool {
+	for i := range b {
+		if b[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 {
+	var h uint64
+	for i, word := range b {
+		h ^= bits.RotateLeft64(word, i*7) * 0x9e3779b97f4a7c15
+	}
+	return h
+}
+
+// visited is a set of linearized operations and the state after them.
+type visited struct {
+	linearized bitset
+	state      interface{}
+}
+
+// checkPartition searches a valid order of the operations of a history. It
+// linearizes the first call of the list it can, and backtracks when it meets
+// a return whose call is not linearized yet.
+func checkPartition(m Model, history []Operation, deadline time.Time) Result {
+	type frame struct {
+		entry *entry
+		state interface{}
+	}
+
+	head := list(history)
+	state := m.Init()
+	linearized := newBitset(len(history))
+	cache := make(map[uint64][]visited)
+	var calls []frame
+
+	seen := func(linearized bitset, state interface{}) bool {
+		for _, v := range cache[linearized.hash()] {
+			if v.linearized.equal(linearized) && m.equal(v.state, state) {
+				return true
+			}
+		}
+		return false
+	}
+
+	steps := 0
+	e := head.next
+	for head.next != nil {
+		if steps++; steps%1000 == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return Timeout
+		}
+
+		if !e.call {
+			// the operation of the return cannot take effect later, undo the last one
+			if len(calls) == 0 {
+				return Illegal
+			}
+			top := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			e, state = top.entry, top.state
+			linearized.clear(e.id)
+			e.unlift()
+			e = e.next
+			continue
+		}
+
+		ok, next := m.Step(state, e.input, e.output)
+		if ok {
+			after := linearized.clone()
+			after.set(e.id)
+			if !seen(after, next) {
+				h := after.hash()
+				cache[h] = append(cache[h], visited{linearized: after.clone(), state: next})
+				calls = append(calls, frame{entry: e, state: state})
+				state, linearized = next, after
+				e.lift()
+				e = head.next
+				continue
+			}
+		}
+		e = e.next
+	}
+	return Ok
+}
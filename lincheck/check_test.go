@@ -0,0 +1,83 @@
+package lincheck
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// op returns an operation of a client between call and ret.
+func op(client int, input interface{}, output interface{}, call, ret int64) Operation {
+	return Operation{Client: client, Input: input, Output: output, Call: call, Return: ret}
+}
+
+func TestCheckRegister(t *testing.T) {
+	write := func(v int) RegisterInput { return RegisterInput{Write: true, Value: v} }
+	read := RegisterInput{}
+
+	// the read overlapping the write may see either value
+	history := []Operation{
+		op(0, write(1), nil, 0, 10),
+		op(1, read, 0, 5, 15),
+		op(2, read, 1, 6, 16),
+		op(1, read, 1, 20, 30),
+	}
+	assert.Equal(t, Ok, Check(RegisterModel, history, 0).Result)
+
+	// a read after the write completed cannot see the old value
+	history = append(history, op(2, read, 0, 31, 40))
+	report := Check(RegisterModel, history, 0)
+	assert.Equal(t, Illegal, report.Result)
+	assert.Len(t, report.Illegal, 5)
+	assert.Equal(t, "client 0: write(1)", report.Illegal[0])
+
+	// two reads cannot see the writes in different orders
+	history = []Operation{
+		op(0, write(1), nil, 0, 100),
+		op(1, write(2), nil, 0, 100),
+		op(2, read, 1, 10, 20),
+		op(2, read, 2, 30, 40),
+		op(3, read, 2, 10, 20),
+		op(3, read, 1, 30, 40),
+	}
+	assert.Equal(t, Illegal, Check(RegisterModel, history, 0).Result)
+}
+
+func TestCheckKV(t *testing.T) {
+	put := func(key, value string) KVInput { return KVInput{Op: KVPut, Key: key, Value: value} }
+	get := func(key string) KVInput { return KVInput{Op: KVGet, Key: key} }
+
+	// a write whose outcome is unknown may take effect at any time after its call
+	history := []Operation{
+		op(0, put("a", "1"), nil, 0, 10),
+		op(1, put("a", "2"), nil, 5, Unknown),
+		op(2, get("a"), KVOutput{Value: "1", Found: true}, 20, 30),
+		op(2, get("a"), KVOutput{Value: "2", Found: true}, 40, 50),
+		op(0, KVInput{Op: KVDelete, Key: "b"}, nil, 0, 10),
+		op(0, get("b"), KVOutput{}, 20, 30),
+	}
+	report := Check(KVModel, history, time.Second)
+	assert.Equal(t, Ok, report.Result)
+	assert.Equal(t, 2, report.Partitions)
+
+	// but it cannot be undone, the read sees a stale value
+	history = append(history, op(2, get("a"), KVOutput{Value: "1", Found: true}, 60, 70))
+	report = Check(KVModel, history, time.Second)
+	assert.Equal(t, Illegal, report.Result)
+	assert.Contains(t, report.Illegal, `client 1: put("a", "2") (unknown)`)
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	put := r.Invoke(0, KVInput{Op: KVPut, Key: "a", Value: "1"})
+	get := r.Invoke(1, KVInput{Op: KVGet, Key: "a"})
+	pending := r.Invoke(2, KVInput{Op: KVDelete, Key: "a"})
+	r.Return(put, nil)
+	r.Fail(get)
+
+	history := r.History()
+	assert.Len(t, history, 2)
+	assert.Less(t, history[0].Call, history[0].Return)
+	assert.Equal(t, Unknown, history[pending-1].Return)
+}
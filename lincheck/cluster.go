@@ -0,0 +1,346 @@
+package lincheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"math/rand"
+	"simple-p2p/consensus"
+	"simple-p2p/fault"
+	"simple-p2p/kvstore"
+	"simple-p2p/mempool"
+	"simple-p2p/node"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fault is a fault injected during a cluster run.
+type Fault string
+
+const (
+	// FaultPartition splits the nodes into a minority and a majority that
+	// cannot reach each other.
+	FaultPartition Fault = "partition"
+
+	// FaultCrash stops a node abruptly, then restarts it from its storage.
+	FaultCrash Fault = "crash"
+)
+
+// ClusterConfig is the setup of a cluster run.
+type ClusterConfig struct {
+	Nodes         int                  // number of nodes of the kvstore cluster
+	Clients       int                  // number of concurrent clients, spread over the nodes
+	Keys          int                  // number of keys, fewer keys give more concurrent operations on each
+	Duration      time.Duration        // time the clients submit operations
+	Params        consensus.SnowParams // parameters of the consensus of every node
+	BlockInterval time.Duration        // block interval of every node
+	OpTimeout     time.Duration        // wait for the commit of an operation, a write left is unknown
+	Faults        []Fault              // faults injected in turn, none if empty
+	FaultInterval time.Duration        // duration of each fault and of the calm before it
+	CheckTimeout  time.Duration        // time limit of the check, none if zero
+	Seed          int64                // seed of the operations and the faults
+}
+
+// ClusterReport is the outcome of a cluster run.
+type ClusterReport struct {
+	Report
+	Completed int         // operations with a known outcome
+	Unknown   int         // writes whose outcome is unknown
+	Failed    int         // operations that failed without taking effect, left out of the history
+	Faults    []string    // faults injected and healed, in order
+	History   []Operation // operations of KVModel checked
+}
+
+// String returns a one line summary of the report.
+func (r ClusterReport) String() string {
+	return fmt.Sprintf("%v completed=%d unknown=%d failed=%d faults=%d", r.Report, r.Completed, r.Unknown, r.Failed, len(r.Faults))
+}
+
+// appPrefix is the prefix of the keys of the store application in the
+// storage of a node.
+const appPrefix = "kvstore/"
+
+// member is a node of the cluster. Its storage outlives the node, so a
+// crashed node restarts with the state it had written.
+type member struct {
+	addr   string
+	store  storage.Store
+	node   *node.Node
+	client *kvstore.Client
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the block builder stopped
+}
+
+// cluster is a kvstore cluster over an in-memory network whose links go
+// through a fault controller.
+type cluster struct {
+	cfg        ClusterConfig
+	network    *transport.Memory
+	controller *fault.Controller
+	members    []*member
+	mux        sync.RWMutex // mutual exclusion lock for the nodes of the members
+}
+
+// RunCluster starts a kvstore cluster, runs concurrent clients on it while
+// injecting the faults in turn, then checks that the history of the clients
+// is linearizable with KVModel. Writes and linearizable reads are recorded,
+// local reads are not since they may be stale. All nodes are stopped before
+// it returns.
+func RunCluster(cfg ClusterConfig) (ClusterReport, error) {
+	c := &cluster{
+		cfg:        cfg,
+		network:    transport.NewMemory(),
+		controller: fault.NewController(),
+	}
+	for i := 0; i < cfg.Nodes; i++ {
+		c.members = append(c.members, &member{addr: fmt.Sprintf("kv-%d", i), store: storage.NewMemory()})
+	}
+	defer c.stopAll()
+
+	for i := range c.members {
+		if err := c.start(i); err != nil {
+			return ClusterReport{}, err
+		}
+	}
+
+	recorder := NewRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	defer cancel()
+
+	var report ClusterReport
+	var waiter sync.WaitGroup
+	var counts sync.Mutex
+	for i := 0; i < cfg.Clients; i++ {
+		waiter.Add(1)
+		go func(client int) {
+			defer waiter.Done()
+			failed := c.runClient(ctx, client, recorder)
+			counts.Lock()
+			report.Failed += failed
+			counts.Unlock()
+		}(i)
+	}
+
+	faults, err := c.runNemesis(ctx)
+	waiter.Wait()
+	report.Faults = faults
+	if err != nil {
+		return report, err
+	}
+
+	report.History = recorder.History()
+	for _, o := range report.History {
+		if o.Return == Unknown {
+			report.Unknown++
+		} else {
+			report.Completed++
+		}
+	}
+	report.Report = Check(KVModel, report.History, cfg.CheckTimeout)
+	return report, nil
+}
+
+// runClient submits random operations through the node of a client until ctx
+// is done, and returns the number of failed operations.
+func (c *cluster) runClient(ctx context.Context, client int, recorder *Recorder) int {
+	random := rand.New(rand.NewSource(c.cfg.Seed + int64(client)))
+	failed := 0
+
+	for seq := 0; ctx.Err() == nil; seq++ {
+		kv := c.client(client % len(c.members))
+		if kv == nil {
+			// the node of the client is down
+			select {
+			case <-ctx.Done():
+			case <-time.After(c.cfg.BlockInterval):
+			}
+			continue
+		}
+
+		in := KVInput{Key: fmt.Sprintf("k%d", random.Intn(c.cfg.Keys))}
+		switch p := random.Float64(); {
+		case p < 0.5:
+			in.Op = KVGet
+		case p < 0.8:
+			in.Op, in.Value = KVPut, fmt.Sprintf("%d-%d", client, seq)
+		default:
+			in.Op = KVDelete
+		}
+
+		opCtx, cancel := context.WithTimeout(context.Background(), c.cfg.OpTimeout)
+		id := recorder.Invoke(client, in)
+		var err error
+		switch in.Op {
+		case KVGet:
+			var r kvstore.Result
+			r, err = kv.Get(opCtx, in.Key, kvstore.Linearizable)
+			if err == nil || errors.Is(err, kvstore.ErrKeyNotFound) {
+				recorder.Return(id, KVOutput{Value: r.Value, Found: r.Found})
+				err = nil
+			}
+		case KVPut:
+			if _, err = kv.Put(opCtx, in.Key, in.Value); err == nil {
+				recorder.Return(id, nil)
+			}
+		case KVDelete:
+			if _, err = kv.Delete(opCtx, in.Key); err == nil {
+				recorder.Return(id, nil)
+			}
+		}
+		cancel()
+
+		// a read has no effect, a write that timed out may still be committed
+		if err != nil && (in.Op == KVGet || !errors.Is(err, context.DeadlineExceeded)) {
+			recorder.Fail(id)
+			failed++
+		}
+	}
+	return failed
+}
+
+// runNemesis injects the faults in turn until ctx is done, each one after a
+// calm interval and for an interval, then heals the cluster. It returns the
+// log of the faults.
+func (c *cluster) runNemesis(ctx context.Context) ([]string, error) {
+	var log []string
+	if len(c.cfg.Faults) == 0 {
+		<-ctx.Done()
+		return log, nil
+	}
+
+	random := rand.New(rand.NewSource(c.cfg.Seed))
+	wait := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.cfg.FaultInterval):
+		}
+	}
+
+	for i := 0; ; i++ {
+		wait()
+		if ctx.Err() != nil {
+			return log, nil
+		}
+
+		switch f := c.cfg.Faults[i%len(c.cfg.Faults)]; f {
+		case FaultPartition:
+			addrs := make([]string, len(c.members))
+			for j, m := range c.members {
+				addrs[j] = m.addr
+			}
+			random.Shuffle(len(addrs), func(a, b int) { addrs[a], addrs[b] = addrs[b], addrs[a] })
+			minority, majority := addrs[:(len(addrs)-1)/2], addrs[(len(addrs)-1)/2:]
+			sort.Strings(minority)
+			sort.Strings(majority)
+
+			c.controller.Partition(minority, majority)
+			log = append(log, fmt.Sprintf("partition %v %v", minority, majority))
+			wait()
+			c.controller.Heal()
+			log = append(log, "heal")
+		case FaultCrash:
+			j := random.Intn(len(c.members))
+			c.crash(j)
+			log = append(log, fmt.Sprintf("crash %v", c.members[j].addr))
+			wait()
+			if err := c.start(j); err != nil {
+				return log, err
+			}
+			log = append(log, fmt.Sprintf("restart %v", c.members[j].addr))
+		default:
+			return log, fmt.Errorf("unknown fault %q", f)
+		}
+	}
+}
+
+// client returns the client of a member, nil if its node is down.
+func (c *cluster) client(i int) *kvstore.Client {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.members[i].client
+}
+
+// start starts the node of a member from its storage, connected to the
+// other members. The node votes once its chain is caught up.
+func (c *cluster) start(i int) error {
+	m := c.members[i]
+
+	app, err := kvstore.NewApp(storage.NewPrefix(m.store, appPrefix))
+	if err != nil {
+		return err
+	}
+	engine := consensus.NewConsensus(c.cfg.Params)
+	n := node.NewNode(m.addr,
+		node.WithTransport(c.network),
+		node.WithStorage(m.store),
+		node.WithConsensus(engine),
+		node.WithApplication(app),
+		node.WithDialOptions(
+			c.controller.DialOption(m.addr),
+			// reconnect quickly to the restarted nodes
+			grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff:           backoff.Config{BaseDelay: 10 * time.Millisecond, Multiplier: 1.6, MaxDelay: 100 * time.Millisecond},
+				MinConnectTimeout: 100 * time.Millisecond,
+			}),
+		),
+	)
+	pool := mempool.New(n)
+	builder := consensus.NewBlockBuilder(n, engine, pool)
+	client := kvstore.NewClient(n, app, pool)
+
+	for _, other := range c.members {
+		if other != m {
+			n.PeerManager.AddPeers(other.addr)
+		}
+	}
+	n.SetVoter(false)
+	if err := n.StartServer(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if n.SyncChain(ctx) == nil {
+			builder.Run(ctx, c.cfg.BlockInterval)
+		}
+	}()
+
+	c.mux.Lock()
+	m.node, m.client, m.cancel, m.done = n, client, cancel, done
+	c.mux.Unlock()
+	return nil
+}
+
+// crash stops the node of a member abruptly, without draining its RPCs.
+func (c *cluster) crash(i int) {
+	c.mux.Lock()
+	m := c.members[i]
+	n, cancel, done := m.node, m.cancel, m.done
+	m.node, m.client = nil, nil
+	c.mux.Unlock()
+
+	if n == nil {
+		return
+	}
+	cancel()
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	_ = n.Shutdown(ctx)
+	<-done
+}
+
+// stopAll stops the nodes of every member.
+func (c *cluster) stopAll() {
+	c.controller.Reset()
+	for i := range c.members {
+		c.crash(i)
+	}
+}
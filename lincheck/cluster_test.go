@@ -0,0 +1,37 @@
+package lincheck
+
+import (
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/consensus"
+	"testing"
+	"time"
+)
+
+func TestCluster(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a cluster under faults for seconds")
+	}
+
+	// no side of a partition reaches a quorum of A=3 among K=4 peers, the
+	// cluster stops making progress rather than diverging
+	report, err := RunCluster(ClusterConfig{
+		Nodes:         5,
+		Clients:       10,
+		Keys:          3,
+		Duration:      6 * time.Second,
+		Params:        consensus.SnowParams{K: 4, A: 3, B: 10, MaxStep: 50, QueryTimeout: 50 * time.Millisecond},
+		BlockInterval: 20 * time.Millisecond,
+		OpTimeout:     time.Second,
+		Faults:        []Fault{FaultPartition, FaultCrash},
+		FaultInterval: time.Second,
+		CheckTimeout:  30 * time.Second,
+		Seed:          1,
+	})
+	assert.NoError(t, err)
+	t.Log(report)
+	t.Log(report.Faults)
+
+	assert.Equal(t, Ok, report.Result, report.Illegal)
+	assert.Positive(t, report.Completed)
+	assert.GreaterOrEqual(t, len(report.Faults), 4)
+}
@@ -0,0 +1,90 @@
+// Package lincheck records the history of the operations of concurrent
+// clients on a replicated application, and checks that the history is
+// linearizable: every operation appears to take effect at a single point
+// between its call and its return, in an order every client agrees with. It
+// follows the approach of Jepsen and Porcupine, and comes with a harness that
+// drives a local kvstore cluster under partitions and crashes.
+package lincheck
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Unknown is the return time of an operation whose outcome is unknown, such
+// as a write that timed out. It may take effect at any time after its call.
+const Unknown int64 = math.MaxInt64
+
+// Operation is an operation of a client, with the times of its call and its
+// return in nanoseconds since the start of the history.
+type Operation struct {
+	Client int         // client that called the operation
+	Input  interface{} // arguments of the operation
+	Output interface{} // result of the operation, ignored if the outcome is unknown
+	Call   int64       // time of the call
+	Return int64       // time of the return, Unknown if the outcome is unknown
+}
+
+// Recorder records the operations of concurrent clients. All methods are safe
+// to call from several goroutines.
+type Recorder struct {
+	start   time.Time
+	ops     []Operation // recorded operations, in order of call
+	dropped []bool      // operations that failed without taking effect
+	mux     sync.Mutex  // mutual exclusion lock for the operations
+}
+
+// NewRecorder creates an empty history starting now.
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now()}
+}
+
+// Invoke records the call of an operation and returns its id.
+func (r *Recorder) Invoke(client int, input interface{}) int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.ops = append(r.ops, Operation{Client: client, Input: input, Call: r.now(), Return: Unknown})
+	r.dropped = append(r.dropped, false)
+	return len(r.ops) - 1
+}
+
+// Return records the result of an operation.
+func (r *Recorder) Return(id int, output interface{}) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.ops[id].Output = output
+	r.ops[id].Return = r.now()
+}
+
+// Fail records that an operation failed without taking effect, such as a
+// write refused before it was submitted or a read that timed out. It is left
+// out of the history.
+func (r *Recorder) Fail(id int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.dropped[id] = true
+}
+
+// History returns the recorded operations. The operations without a result
+// have an unknown outcome.
+func (r *Recorder) History() []Operation {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	history := make([]Operation, 0, len(r.ops))
+	for i, o := range r.ops {
+		if !r.dropped[i] {
+			history = append(history, o)
+		}
+	}
+	return history
+}
+
+// now returns the time since the start of the history, the lock must be held.
+func (r *Recorder) now() int64 {
+	return int64(time.Since(r.start))
+}
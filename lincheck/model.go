@@ -0,0 +1,162 @@
+package lincheck
+
+import (
+	"fmt"
+)
+
+// Model is the sequential specification of an application, the behavior
+// the history of the replicated application must be equivalent to.
+type Model struct {
+	// Partition splits a history into independent histories, such as the
+	// operations on each key, which are checked separately. Optional.
+	Partition func(history []Operation) [][]Operation
+
+	// Init returns the initial state.
+	Init func() interface{}
+
+	// Step applies an operation to a state. It returns false if the output is
+	// not a possible result of the input in that state, and the next state
+	// otherwise. The output is nil if the outcome of the operation is unknown,
+	// any result is then possible.
+	Step func(state interface{}, input interface{}, output interface{}) (bool, interface{})
+
+	// Equal reports whether two states are equal. Optional, states are
+	// compared with == by default.
+	Equal func(a, b interface{}) bool
+
+	// Describe returns a description of an operation for reports. Optional.
+	Describe func(input interface{}, output interface{}) string
+}
+
+// equal compares two states with Equal, or ==.
+func (m Model) equal(a, b interface{}) bool {
+	if m.Equal != nil {
+		return m.Equal(a, b)
+	}
+	return a == b
+}
+
+// describe describes an operation with Describe, or its input and output.
+func (m Model) describe(o Operation) string {
+	if o.Return == Unknown {
+		if m.Describe != nil {
+			return m.Describe(o.Input, nil) + " (unknown)"
+		}
+		return fmt.Sprintf("%v (unknown)", o.Input)
+	}
+	if m.Describe != nil {
+		return m.Describe(o.Input, o.Output)
+	}
+	return fmt.Sprintf("%v -> %v", o.Input, o.Output)
+}
+
+// Types of the operations of KVModel.
+const (
+	KVGet    = "get"
+	KVPut    = "put"
+	KVDelete = "delete"
+)
+
+// KVInput is the input of an operation of KVModel.
+type KVInput struct {
+	Op    string // KVGet, KVPut or KVDelete
+	Key   string
+	Value string // value written by a put
+}
+
+// KVOutput is the output of a get of KVModel.
+type KVOutput struct {
+	Value string
+	Found bool
+}
+
+// kvState is the state of a key of KVModel.
+type kvState struct {
+	value string
+	found bool
+}
+
+// KVModel is a key-value store of string keys and values, initially empty,
+// whose histories are checked key by key. The output of a put or a delete
+// is ignored.
+var KVModel = Model{
+	Partition: func(history []Operation) [][]Operation {
+		byKey := make(map[string]int)
+		var partitions [][]Operation
+		for _, o := range history {
+			key := o.Input.(KVInput).Key
+			i, ok := byKey[key]
+			if !ok {
+				i = len(partitions)
+				byKey[key] = i
+				partitions = append(partitions, nil)
+			}
+			partitions[i] = append(partitions[i], o)
+		}
+		return partitions
+	},
+	Init: func() interface{} {
+		return kvState{}
+	},
+	Step: func(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+		s := state.(kvState)
+		in := input.(KVInput)
+		switch in.Op {
+		case KVGet:
+			if output == nil {
+				return true, s
+			}
+			out, ok := output.(KVOutput)
+			return ok && out.Found == s.found && (!s.found || out.Value == s.value), s
+		case KVPut:
+			return true, kvState{value: in.Value, found: true}
+		case KVDelete:
+			return true, kvState{}
+		}
+		return false, s
+	},
+	Describe: func(input interface{}, output interface{}) string {
+		in := input.(KVInput)
+		switch in.Op {
+		case KVGet:
+			if out, ok := output.(KVOutput); ok && out.Found {
+				return fmt.Sprintf("get(%q) -> %q", in.Key, out.Value)
+			}
+			if output != nil {
+				return fmt.Sprintf("get(%q) -> not found", in.Key)
+			}
+			return fmt.Sprintf("get(%q)", in.Key)
+		case KVPut:
+			return fmt.Sprintf("put(%q, %q)", in.Key, in.Value)
+		}
+		return fmt.Sprintf("%s(%q)", in.Op, in.Key)
+	},
+}
+
+// RegisterInput is the input of an operation of RegisterModel.
+type RegisterInput struct {
+	Write bool // a write of Value, a read otherwise
+	Value int
+}
+
+// RegisterModel is a single integer register, initially 0. The output of a
+// read is the int read, the output of a write is ignored.
+var RegisterModel = Model{
+	Init: func() interface{} {
+		return 0
+	},
+	Step: func(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+		in := input.(RegisterInput)
+		if in.Write {
+			return true, in.Value
+		}
+		return output == nil || output == state, state
+	},
+	Describe: func(input interface{}, output interface{}) string {
+		in := input.(RegisterInput)
+		if in.Write {
+			return fmt.Sprintf("write(%d)", in.Value)
+		}
+		return fmt.Sprintf("read() -> %v", output)
+	},
+}
@@ -0,0 +1,222 @@
+// Package logger writes leveled logs with structured fields, as text or JSON.
+// Components log through the Logger interface and default to Nop, so library
+// users and tests see nothing unless they inject a logger.
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownLevel  = errors.New("unknown log level")
+	ErrUnknownFormat = errors.New("unknown log format")
+)
+
+// Level is the severity of a log.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel returns the level of a name: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownLevel, s)
+}
+
+// Format is the encoding of the logs.
+type Format string
+
+const (
+	FormatText Format = "text" // key=value pairs, one log per line
+	FormatJSON Format = "json" // a JSON object per line
+)
+
+// ParseFormat returns the format of a name: text or json.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+// Field is a key and value attached to a log.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the field of an error.
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Logger writes leveled logs with structured fields.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+
+	// With returns a logger that adds fields to every log.
+	With(fields ...Field) Logger
+}
+
+// Nop returns a logger that discards every log.
+func Nop() Logger {
+	return nop{}
+}
+
+// nop discards every log.
+type nop struct{}
+
+func (nop) Debug(string, ...Field) {}
+func (nop) Info(string, ...Field)  {}
+func (nop) Warn(string, ...Field)  {}
+func (nop) Error(string, ...Field) {}
+func (n nop) With(...Field) Logger { return n }
+
+// output is the destination shared by a logger and the loggers derived from it.
+type output struct {
+	w      io.Writer
+	level  Level
+	format Format
+	mux    sync.Mutex // mutual exclusion lock for w
+}
+
+// logger writes logs of at least a level to an output.
+type logger struct {
+	out    *output
+	fields []Field // fields added to every log
+}
+
+// New creates a logger that writes the logs of at least level to w.
+func New(w io.Writer, level Level, format Format) Logger {
+	return &logger{out: &output{w: w, level: level, format: format}}
+}
+
+func (l *logger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *logger) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *logger) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *logger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+// With returns a logger that adds fields to every log.
+func (l *logger) With(fields ...Field) Logger {
+	return &logger{
+		out:    l.out,
+		fields: append(append([]Field(nil), l.fields...), fields...),
+	}
+}
+
+// log writes a log if its level is enabled.
+func (l *logger) log(level Level, msg string, fields []Field) {
+	if level < l.out.level {
+		return
+	}
+
+	all := make([]Field, 0, 3+len(l.fields)+len(fields))
+	all = append(all, F("time", time.Now().UTC().Format(time.RFC3339Nano)), F("level", level.String()), F("msg", msg))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+
+	var line string
+	if l.out.format == FormatJSON {
+		line = encodeJSON(all)
+	} else {
+		line = encodeText(all)
+	}
+
+	l.out.mux.Lock()
+	defer l.out.mux.Unlock()
+
+	_, _ = io.WriteString(l.out.w, line)
+}
+
+// value returns the value of a field as it is encoded.
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// encodeText encodes fields as key=value pairs, quoting values when needed.
+func encodeText(fields []Field) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		s := fmt.Sprint(value(f.Value))
+		if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(s)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// encodeJSON encodes fields as a JSON object in their order.
+func encodeJSON(fields []Field) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.Key)
+		v, err := json.Marshal(value(f.Value))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(f.Value))
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	var b strings.Builder
+	l := New(&b, LevelInfo, FormatText).With(F("node", "127.0.0.1:9447"))
+
+	l.Debug("hidden")
+	l.Info("add peer", F("peer", "127.0.0.1:9448"), F("round", 3))
+	l.Error("failed to query", Err(errors.New("deadline exceeded")))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.Regexp(t, `^time=\S+ level=info msg="add peer" node=127.0.0.1:9447 peer=127.0.0.1:9448 round=3$`, lines[0])
+	assert.Regexp(t, `^time=\S+ level=error msg="failed to query" node=127.0.0.1:9447 error="deadline exceeded"$`, lines[1])
+}
+
+func TestJSON(t *testing.T) {
+	var b strings.Builder
+	l := New(&b, LevelDebug, FormatJSON)
+
+	l.With(F("topic", "reconfig")).Debug("round", F("round", 2), F("accepted", false))
+
+	var log map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(b.String()), &log))
+	assert.Equal(t, "debug", log["level"])
+	assert.Equal(t, "round", log["msg"])
+	assert.Equal(t, "reconfig", log["topic"])
+	assert.Equal(t, float64(2), log["round"])
+	assert.Equal(t, false, log["accepted"])
+	assert.Contains(t, log, "time")
+}
+
+func TestWithDoesNotShareFields(t *testing.T) {
+	var b strings.Builder
+	base := New(&b, LevelInfo, FormatText).With(F("node", "a"))
+
+	base.With(F("peer", "b")).Info("one")
+	base.With(F("peer", "c")).Info("two")
+
+	assert.Contains(t, b.String(), "msg=one node=a peer=b\n")
+	assert.Contains(t, b.String(), "msg=two node=a peer=c\n")
+}
+
+func TestParse(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+
+	_, err = ParseLevel("verbose")
+	assert.ErrorIs(t, err, ErrUnknownLevel)
+
+	format, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, format)
+
+	_, err = ParseFormat("xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
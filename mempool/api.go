@@ -0,0 +1,106 @@
+package mempool
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"simple-p2p/node"
+	"strings"
+)
+
+// defaultListLimit is the number of transactions listed without a limit.
+const defaultListLimit = 100
+
+// txBodyOverhead is the size of a transaction request beyond its base64
+// data: the keys, the fee and some whitespace.
+const txBodyOverhead = 1 << 10
+
+// TxInfo is a pending transaction and its hash.
+type TxInfo struct {
+	Hash string `json:"hash"`
+	Tx
+}
+
+// Status is the overview of the mempool.
+type Status struct {
+	Txs     int      `json:"txs"`
+	Bytes   int      `json:"bytes"`
+	Pending []TxInfo `json:"pending"` // transactions of highest priority first
+}
+
+// api serves the mempool endpoints of the node API.
+type api struct {
+	pool *Mempool
+}
+
+// RegisterAPI adds the endpoints of the mempool to the node API:
+//
+//	POST /txs          submit a transaction, body {"data": "<base64>", "fee": 1}
+//	GET  /txs/{hash}   pending transaction
+//	GET  /mempool      size of the pool and its transactions, ?limit= bounds them
+func RegisterAPI(a *node.API, p *Mempool) {
+	s := &api{pool: p}
+
+	a.HandleFunc("/txs", http.MethodPost, s.postTx)
+	a.HandleFunc("/txs/", http.MethodGet, s.getTx)
+	a.HandleFunc("/mempool", http.MethodGet, s.getStatus)
+}
+
+// postTx adds a transaction to the pool and gossips it. The body is read up
+// to the size of the largest transaction the pool accepts.
+func (s *api) postTx(w http.ResponseWriter, r *http.Request) {
+	limit := int64(base64.StdEncoding.EncodedLen(s.pool.maxTxBytes)) + txBodyOverhead
+
+	var tx Tx
+	if err := node.ReadJSONLimit(r, &tx, limit); err != nil {
+		node.WriteError(w, node.BodyStatus(err), err)
+		return
+	}
+
+	if err := s.pool.Add(r.Context(), tx); err != nil {
+		node.WriteError(w, statusCode(err), err)
+		return
+	}
+	node.WriteJSON(w, http.StatusAccepted, TxInfo{Hash: tx.Hash(), Tx: tx})
+}
+
+// statusCode returns the HTTP status of an error of Add.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrTxExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrTxTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrMempoolFull):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// getTx returns the pending transaction of the path /txs/{hash}.
+func (s *api) getTx(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/txs/")
+	tx, ok := s.pool.Get(hash)
+	if !ok {
+		node.WriteError(w, http.StatusNotFound, fmt.Errorf("transaction %v: %w", hash, node.ErrNotFound))
+		return
+	}
+	node.WriteJSON(w, http.StatusOK, TxInfo{Hash: hash, Tx: tx})
+}
+
+// getStatus returns the size of the pool and its transactions of highest
+// priority.
+func (s *api) getStatus(w http.ResponseWriter, r *http.Request) {
+	limit, err := node.QueryUint(r, "limit", defaultListLimit)
+	if err != nil {
+		node.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := Status{Txs: s.pool.Len(), Bytes: s.pool.Bytes(), Pending: make([]TxInfo, 0)}
+	for _, tx := range s.pool.Reap(int(limit), 0) {
+		status.Pending = append(status.Pending, TxInfo{Hash: tx.Hash(), Tx: tx})
+	}
+	node.WriteJSON(w, http.StatusOK, status)
+}
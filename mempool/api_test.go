@@ -0,0 +1,61 @@
+package mempool
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/node"
+	"simple-p2p/transport"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	n := node.NewNode("node-1", node.WithTransport(transport.NewMemory()))
+	p := New(n, WithMaxTxBytes(4))
+	api := node.NewAPI(n)
+	RegisterAPI(api, p)
+
+	// data is base64 encoded
+	var info TxInfo
+	assert.Equal(t, http.StatusAccepted, send(api, http.MethodPost, "/txs", `{"data": "YQ==", "fee": 2}`, &info))
+	assert.Equal(t, TxInfo{Hash: tx("a", 2).Hash(), Tx: tx("a", 2)}, info)
+
+	assert.Equal(t, http.StatusConflict, send(api, http.MethodPost, "/txs", `{"data": "YQ==", "fee": 2}`, nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send(api, http.MethodPost, "/txs", `{"data": "YWJjZGU=", "fee": 2}`, nil))
+	assert.Equal(t, http.StatusBadRequest, send(api, http.MethodPost, "/txs", `{`, nil))
+
+	// a body far beyond the largest transaction is not read
+	huge := `{"data": "` + strings.Repeat("YWJj", 1<<10) + `", "fee": 2}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send(api, http.MethodPost, "/txs", huge, nil))
+
+	// nor one without a length
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/txs", strings.NewReader(huge))
+	r.ContentLength = -1
+	api.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	assert.NoError(t, p.add(tx("b", 3)))
+
+	assert.Equal(t, http.StatusOK, send(api, http.MethodGet, "/txs/"+info.Hash, "", &info))
+	assert.Equal(t, tx("a", 2), info.Tx)
+	assert.Equal(t, http.StatusNotFound, send(api, http.MethodGet, "/txs/unknown", "", nil))
+
+	var status Status
+	assert.Equal(t, http.StatusOK, send(api, http.MethodGet, "/mempool?limit=1", "", &status))
+	assert.Equal(t, 2, status.Txs)
+	assert.Equal(t, 2, status.Bytes)
+	assert.Equal(t, []TxInfo{{Hash: tx("b", 3).Hash(), Tx: tx("b", 3)}}, status.Pending)
+}
+
+// send sends a request to the API and decodes the response into v.
+func send(api *node.API, method string, path string, body string, v interface{}) int {
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if v != nil {
+		_ = json.NewDecoder(w.Body).Decode(v)
+	}
+	return w.Code
+}
@@ -0,0 +1,500 @@
+// Package mempool holds the transactions submitted by clients until a block
+// includes them. Transactions are checked by the application, deduplicated by
+// hash and gossiped to the peers. When the pool is full the transactions with
+// the lowest fees are evicted first, and transactions expire after a maximum
+// age.
+package mempool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"simple-p2p/chain"
+	"simple-p2p/logger"
+	"simple-p2p/node"
+	"simple-p2p/proto/proto"
+	"simple-p2p/tracing"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTxExists    = errors.New("transaction already known")
+	ErrTxTooLarge  = errors.New("transaction too large")
+	ErrMempoolFull = errors.New("mempool is full")
+	ErrInvalidTx   = errors.New("invalid transaction")
+)
+
+var (
+	maxTxs     = 5000             // default number of transactions of the pool
+	maxBytes   = 64 << 20         // default total size of the transactions of the pool
+	maxTxBytes = 1 << 20          // default size of the largest transaction
+	maxAge     = 10 * time.Minute // default time after which a transaction expires
+	cacheSize  = 10000            // number of recent hashes remembered to deduplicate transactions
+)
+
+// Tx is a transaction submitted by a client.
+type Tx = chain.Tx
+
+// CheckFunc validates a transaction for the current state of the application.
+type CheckFunc func(Tx) error
+
+// entry is a transaction of the pool.
+type entry struct {
+	tx    Tx
+	hash  string
+	added time.Time // time the transaction was added
+	seq   uint64    // arrival order, breaks ties between equal fees
+}
+
+// before reports whether e has a higher priority than other: a higher fee, or
+// the same fee and an earlier arrival.
+func (e *entry) before(other *entry) bool {
+	if e.tx.Fee != other.tx.Fee {
+		return e.tx.Fee > other.tx.Fee
+	}
+	return e.seq < other.seq
+}
+
+// Mempool is the pool of pending transactions of a node.
+type Mempool struct {
+	node  *node.Node
+	check CheckFunc // validates transactions, nil accepts all
+
+	maxTxs     int           // number of transactions beyond which the pool is full
+	maxBytes   int           // total size beyond which the pool is full
+	maxTxBytes int           // size of the largest transaction
+	maxAge     time.Duration // time after which a transaction expires
+
+	txs   map[string]*entry // pending transactions by hash
+	bytes int               // total size of the pending transactions
+	seq   uint64            // arrival order of the last transaction
+	seen  *cache            // hashes of the pending and recently included transactions
+	mux   sync.Mutex        // mutual exclusion lock for the fields above
+}
+
+// Option configures a mempool.
+type Option func(*Mempool)
+
+// WithCheckTx sets the validation of the transactions. It is called before a
+// transaction is added, and again for the pending transactions after each
+// Update. The default is CheckTx of the application of the node, and accepts
+// all transactions if the node has none.
+func WithCheckTx(check CheckFunc) Option {
+	return func(p *Mempool) {
+		p.check = check
+	}
+}
+
+// WithMaxTxs sets the number of transactions beyond which the pool is full.
+// The default is 5000.
+func WithMaxTxs(n int) Option {
+	return func(p *Mempool) {
+		p.maxTxs = n
+	}
+}
+
+// WithMaxBytes sets the total size of the transactions beyond which the pool
+// is full. The default is 64 MiB.
+func WithMaxBytes(n int) Option {
+	return func(p *Mempool) {
+		p.maxBytes = n
+	}
+}
+
+// WithMaxTxBytes sets the size of the largest transaction. The default is
+// 1 MiB.
+func WithMaxTxBytes(n int) Option {
+	return func(p *Mempool) {
+		p.maxTxBytes = n
+	}
+}
+
+// WithMaxAge sets the time after which a pending transaction expires. The
+// default is 10m.
+func WithMaxAge(d time.Duration) Option {
+	return func(p *Mempool) {
+		p.maxAge = d
+	}
+}
+
+// New creates the mempool of a node and registers the handler of the
+// transactions gossiped by its peers. The transactions of each block
+// committed by the node are removed with Update.
+func New(n *node.Node, opts ...Option) *Mempool {
+	p := &Mempool{
+		node:       n,
+		maxTxs:     maxTxs,
+		maxBytes:   maxBytes,
+		maxTxBytes: maxTxBytes,
+		maxAge:     maxAge,
+		txs:        make(map[string]*entry),
+		seen:       newCache(cacheSize),
+	}
+
+	if app := n.Application(); app != nil {
+		p.check = app.CheckTx
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	n.MessageManager.RegisterHandler(proto.MessageType_TX, p.receive)
+	n.OnCommit(func(b chain.Block) {
+		p.Update(b.Txs)
+	})
+	return p
+}
+
+// Add checks a transaction, adds it to the pool and gossips it to the peers.
+// It returns ErrTxExists if the transaction is pending or was included
+// recently, ErrInvalidTx if the check fails, and ErrTxTooLarge or
+// ErrMempoolFull if there is no room for it.
+func (p *Mempool) Add(ctx context.Context, tx Tx) error {
+	if err := p.add(tx); err != nil {
+		return err
+	}
+
+	p.gossip(ctx, tx)
+	return nil
+}
+
+// add adds a transaction to the pool, evicting transactions with lower fees
+// if it is full.
+func (p *Mempool) add(tx Tx) error {
+	if tx.Size() > p.maxTxBytes {
+		rejectedTotal.With("too_large").Inc()
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrTxTooLarge, tx.Size(), p.maxTxBytes)
+	}
+
+	hash := tx.Hash()
+	if p.known(hash) {
+		return fmt.Errorf("%w: %v", ErrTxExists, hash)
+	}
+
+	// the check may be slow, it runs without the lock
+	if err := p.validate(tx); err != nil {
+		rejectedTotal.With("invalid").Inc()
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.knownLocked(hash) {
+		return fmt.Errorf("%w: %v", ErrTxExists, hash)
+	}
+
+	p.expire()
+
+	e := &entry{tx: tx, hash: hash, added: p.node.Clock().Now(), seq: p.seq + 1}
+	if err := p.makeRoom(e); err != nil {
+		rejectedTotal.With("full").Inc()
+		return err
+	}
+
+	p.seq++
+	p.txs[hash] = e
+	p.bytes += tx.Size()
+	p.seen.add(hash)
+	addedTotal.With().Inc()
+	p.updateMetrics()
+	p.node.Logger().Debug("add transaction", logger.F("hash", hash), logger.F("fee", tx.Fee))
+	return nil
+}
+
+// Check checks a transaction like Add without adding it: it returns
+// ErrTxTooLarge or ErrInvalidTx if the pool would reject it. Transactions
+// proposed by the peers for a block are checked too.
+func (p *Mempool) Check(tx Tx) error {
+	if tx.Size() > p.maxTxBytes {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrTxTooLarge, tx.Size(), p.maxTxBytes)
+	}
+	return p.validate(tx)
+}
+
+// validate runs the check of the transactions, if any.
+func (p *Mempool) validate(tx Tx) error {
+	if p.check == nil {
+		return nil
+	}
+	if err := p.check(tx); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTx, err)
+	}
+	return nil
+}
+
+// known reports whether a transaction is pending or was included recently.
+func (p *Mempool) known(hash string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.knownLocked(hash)
+}
+
+// knownLocked is known with the lock held.
+func (p *Mempool) knownLocked(hash string) bool {
+	_, ok := p.txs[hash]
+	return ok || p.seen.has(hash)
+}
+
+// makeRoom evicts the transactions of lowest priority until the pool has
+// room for e. It evicts nothing and returns ErrMempoolFull if it would have to
+// evict a transaction with a fee higher than or equal to the fee of e. The
+// lock must be held.
+func (p *Mempool) makeRoom(e *entry) error {
+	count, bytes := len(p.txs)+1, p.bytes+e.tx.Size()
+	if count <= p.maxTxs && bytes <= p.maxBytes {
+		return nil
+	}
+
+	entries := p.sorted()
+	var victims []*entry
+	for i := len(entries) - 1; i >= 0 && (count > p.maxTxs || bytes > p.maxBytes); i-- {
+		victim := entries[i]
+		if victim.tx.Fee >= e.tx.Fee {
+			return fmt.Errorf("%w: the fee must be higher than %d", ErrMempoolFull, victim.tx.Fee)
+		}
+
+		victims = append(victims, victim)
+		count--
+		bytes -= victim.tx.Size()
+	}
+	if count > p.maxTxs || bytes > p.maxBytes {
+		return ErrMempoolFull
+	}
+
+	for _, victim := range victims {
+		p.evict(victim, "full")
+	}
+	return nil
+}
+
+// expire evicts the transactions older than the maximum age. The lock must be
+// held.
+func (p *Mempool) expire() {
+	now := p.node.Clock().Now()
+	for _, e := range p.txs {
+		if now.Sub(e.added) > p.maxAge {
+			p.evict(e, "expired")
+		}
+	}
+}
+
+// evict removes a pending transaction that is not included in a block, so it
+// can be submitted again. The lock must be held.
+func (p *Mempool) evict(e *entry, reason string) {
+	p.remove(e)
+	p.seen.remove(e.hash)
+	evictedTotal.With(reason).Inc()
+	p.node.Logger().Debug("evict transaction", logger.F("hash", e.hash), logger.F("reason", reason))
+}
+
+// remove removes a pending transaction. The lock must be held.
+func (p *Mempool) remove(e *entry) {
+	delete(p.txs, e.hash)
+	p.bytes -= e.tx.Size()
+}
+
+// sorted returns the pending transactions by decreasing priority. The lock
+// must be held.
+func (p *Mempool) sorted() []*entry {
+	entries := make([]*entry, 0, len(p.txs))
+	for _, e := range p.txs {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].before(entries[j])
+	})
+	return entries
+}
+
+// Reap returns the pending transactions of highest priority for the next
+// block, at most maxTxs transactions of at most maxBytes in total. A limit of
+// 0 is no limit. The transactions stay pending until Update.
+func (p *Mempool) Reap(maxTxs int, maxBytes int) []Tx {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.expire()
+	p.updateMetrics()
+
+	var (
+		txs   []Tx
+		bytes int
+	)
+	for _, e := range p.sorted() {
+		if maxTxs > 0 && len(txs) >= maxTxs {
+			break
+		}
+		if maxBytes > 0 && bytes+e.tx.Size() > maxBytes {
+			continue
+		}
+
+		txs = append(txs, e.tx)
+		bytes += e.tx.Size()
+	}
+	return txs
+}
+
+// Update removes the transactions included in a block, then checks the
+// pending transactions again against the new state of the application and
+// evicts those that fail.
+func (p *Mempool) Update(included []Tx) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, tx := range included {
+		hash := tx.Hash()
+		if e, ok := p.txs[hash]; ok {
+			p.remove(e)
+		}
+		p.seen.add(hash)
+	}
+
+	if p.check != nil {
+		for _, e := range p.sorted() {
+			if err := p.check(e.tx); err != nil {
+				p.evict(e, "invalid")
+			}
+		}
+	}
+	p.updateMetrics()
+}
+
+// Get returns a pending transaction.
+func (p *Mempool) Get(hash string) (Tx, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	e, ok := p.txs[hash]
+	if !ok {
+		return Tx{}, false
+	}
+	return e.tx, true
+}
+
+// Len returns the number of pending transactions.
+func (p *Mempool) Len() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return len(p.txs)
+}
+
+// Bytes returns the total size of the pending transactions.
+func (p *Mempool) Bytes() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.bytes
+}
+
+// updateMetrics sets the size metrics. The lock must be held.
+func (p *Mempool) updateMetrics() {
+	poolTxs.With().Set(float64(len(p.txs)))
+	poolBytes.With().Set(float64(p.bytes))
+}
+
+// gossip sends a transaction to all known peers, within the trace of ctx.
+func (p *Mempool) gossip(ctx context.Context, tx Tx) {
+	ctx, span := p.node.Tracer().Start(ctx, "mempool.gossip", tracing.Attr("hash", tx.Hash()))
+	defer span.End()
+
+	value, err := json.Marshal(tx)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	for _, peer := range p.node.PeerManager.GetPeers() {
+		conn, err := p.node.PeerManager.GetConnection(peer)
+		if err != nil {
+			continue
+		}
+
+		_ = p.node.MessageManager.SendMessage(ctx, conn, &proto.MessageRequest{
+			Type:  proto.MessageType_TX,
+			Value: value,
+		})
+	}
+}
+
+// receive handles a transaction gossiped by a peer and forwards it if it is
+// added. Known transactions and transactions without room are ignored.
+func (p *Mempool) receive(ctx context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	var tx Tx
+	if err := json.Unmarshal(request.Value, &tx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTx, err)
+	}
+
+	err := p.add(tx)
+	switch {
+	case err == nil:
+		go p.gossip(tracing.Detach(ctx), tx)
+	case errors.Is(err, ErrInvalidTx), errors.Is(err, ErrTxTooLarge):
+		return nil, err
+	}
+	return &proto.MessageResponse{Type: request.Type}, nil
+}
+
+// cache is a set of hashes that forgets the oldest hash beyond its size.
+type cache struct {
+	hashes map[string]uint64 // insertion number of each hash
+	order  []cached          // hashes in insertion order, including those removed since
+	last   uint64            // insertion number of the last hash
+	size   int               // number of hashes beyond which the oldest is forgotten
+}
+
+// cached is a hash and its insertion number.
+type cached struct {
+	hash string
+	n    uint64
+}
+
+// newCache creates an empty cache of a size.
+func newCache(size int) *cache {
+	return &cache{hashes: make(map[string]uint64), size: size}
+}
+
+// has reports whether the cache holds a hash.
+func (c *cache) has(hash string) bool {
+	_, ok := c.hashes[hash]
+	return ok
+}
+
+// add adds a hash, forgetting the oldest one if the cache is full.
+func (c *cache) add(hash string) {
+	if c.has(hash) {
+		return
+	}
+
+	c.last++
+	c.hashes[hash] = c.last
+	c.order = append(c.order, cached{hash: hash, n: c.last})
+	for len(c.hashes) > c.size {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		if c.hashes[oldest.hash] == oldest.n {
+			delete(c.hashes, oldest.hash)
+		}
+	}
+
+	// drop the removed hashes once they outnumber the others
+	if len(c.order) > 2*c.size {
+		order := make([]cached, 0, len(c.hashes))
+		for _, item := range c.order {
+			if c.hashes[item.hash] == item.n {
+				order = append(order, item)
+			}
+		}
+		c.order = order
+	}
+}
+
+// remove removes a hash.
+func (c *cache) remove(hash string) {
+	delete(c.hashes, hash)
+}
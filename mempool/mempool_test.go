@@ -0,0 +1,117 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/clock"
+	"simple-p2p/node"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+// tx returns a transaction of a fee and of size bytes.
+func tx(data string, fee uint64) Tx {
+	return Tx{Data: []byte(data), Fee: fee}
+}
+
+func TestMempool(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	n := node.NewNode("node-1", node.WithTransport(transport.NewMemory()), node.WithClock(clk))
+
+	banned := errors.New("banned")
+	check := func(tx Tx) error {
+		if string(tx.Data) == "banned" {
+			return banned
+		}
+		return nil
+	}
+	p := New(n, WithCheckTx(check), WithMaxTxs(3), WithMaxBytes(12), WithMaxTxBytes(8), WithMaxAge(time.Minute))
+	ctx := context.Background()
+
+	assert.NoError(t, p.Add(ctx, tx("a", 1)))
+	assert.ErrorIs(t, p.Add(ctx, tx("a", 1)), ErrTxExists)
+	assert.ErrorIs(t, p.Add(ctx, tx("banned", 5)), ErrInvalidTx)
+	assert.ErrorIs(t, p.Add(ctx, tx("too large", 5)), ErrTxTooLarge)
+
+	// the same data with another fee is another transaction
+	assert.NoError(t, p.Add(ctx, tx("a", 2)))
+	assert.NoError(t, p.Add(ctx, tx("b", 2)))
+	assert.Equal(t, 3, p.Len())
+
+	// a full pool evicts the lowest fee, and rejects a fee that is not higher
+	assert.ErrorIs(t, p.Add(ctx, tx("c", 1)), ErrMempoolFull)
+	assert.NoError(t, p.Add(ctx, tx("c", 3)))
+	_, ok := p.Get(tx("a", 1).Hash())
+	assert.False(t, ok)
+
+	// larger transactions evict as many as needed to fit the size
+	assert.NoError(t, p.Add(ctx, tx("dddddddd", 4)))
+	assert.Equal(t, []Tx{tx("dddddddd", 4), tx("c", 3), tx("a", 2)}, p.Reap(0, 0))
+	assert.Equal(t, 10, p.Bytes())
+
+	// blocks take the highest fees first within their limits
+	assert.Equal(t, []Tx{tx("dddddddd", 4)}, p.Reap(1, 0))
+	assert.Equal(t, []Tx{tx("c", 3), tx("a", 2)}, p.Reap(0, 2))
+
+	// included transactions are removed and not added again, and the others
+	// are checked again
+	check = func(tx Tx) error {
+		if string(tx.Data) == "a" {
+			return banned
+		}
+		return nil
+	}
+	p.check = check
+	p.Update([]Tx{tx("dddddddd", 4)})
+	assert.Equal(t, []Tx{tx("c", 3)}, p.Reap(0, 0))
+	assert.ErrorIs(t, p.Add(ctx, tx("dddddddd", 4)), ErrTxExists)
+
+	// old transactions expire and can be submitted again
+	clk.Advance(2 * time.Minute)
+	assert.Empty(t, p.Reap(0, 0))
+	assert.NoError(t, p.Add(ctx, tx("c", 3)))
+	assert.Equal(t, 1, p.Len())
+}
+
+func TestGossip(t *testing.T) {
+	network := transport.NewMemory()
+
+	var pools []*Mempool
+	for _, addr := range []string{"node-1", "node-2", "node-3"} {
+		n := node.NewNode(addr, node.WithTransport(network))
+		pools = append(pools, New(n))
+		assert.NoError(t, n.StartServer())
+		defer n.StopServer()
+	}
+
+	// node-1 only knows node-2, which forwards to node-3
+	pools[0].node.PeerManager.AddPeers("node-2")
+	pools[1].node.PeerManager.AddPeers("node-1", "node-3")
+	pools[2].node.PeerManager.AddPeers("node-2")
+
+	assert.NoError(t, pools[0].Add(context.Background(), tx("a", 1)))
+	assert.Eventually(t, func() bool {
+		for _, p := range pools {
+			if p.Len() != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestCache checks that the hashes beyond the size are forgotten oldest first.
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	c.add("a")
+	c.add("b")
+	c.remove("a")
+	c.add("a")
+	c.add("c")
+
+	assert.False(t, c.has("b"))
+	assert.True(t, c.has("a"))
+	assert.True(t, c.has("c"))
+}
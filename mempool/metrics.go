@@ -0,0 +1,13 @@
+package mempool
+
+import (
+	"simple-p2p/metrics"
+)
+
+var (
+	poolTxs       = metrics.Default.Gauge("mempool_txs", "Number of pending transactions.")
+	poolBytes     = metrics.Default.Gauge("mempool_bytes", "Total size of the pending transactions.")
+	addedTotal    = metrics.Default.Counter("mempool_added_total", "Number of transactions added to the pool.")
+	rejectedTotal = metrics.Default.Counter("mempool_rejected_total", "Number of rejected transactions by reason.", "reason")
+	evictedTotal  = metrics.Default.Counter("mempool_evicted_total", "Number of evicted transactions by reason.", "reason")
+)
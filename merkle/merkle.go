@@ -0,0 +1,168 @@
+// Package merkle builds Merkle trees over lists of leaves, and proves and
+// verifies the inclusion of a leaf. Trees follow RFC 6962: leaves and inner
+// nodes are hashed with SHA-256 under different prefixes, and a list is split
+// at the largest power of two below its length, so no leaf is duplicated.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+var ErrInvalidProof = errors.New("invalid proof")
+
+// Prefixes of the hashed data, so a leaf cannot pass for an inner node.
+const (
+	leafPrefix  = 0
+	innerPrefix = 1
+)
+
+// Proof is the proof that a leaf is at an index of a tree of total leaves: the
+// hashes of the siblings on the path from the leaf to the root, bottom up.
+type Proof struct {
+	Index int      `json:"index"`
+	Total int      `json:"total"`
+	Aunts []string `json:"aunts"` // hex hashes of the siblings
+}
+
+// leafHash returns the hash of a leaf.
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// innerHash returns the hash of an inner node.
+func innerHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{innerPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the size of the left subtree of n > 1 leaves, the largest
+// power of two below n.
+func split(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// root returns the root of the hashes of leaves.
+func root(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return hashes[0]
+	}
+	k := split(len(hashes))
+	return innerHash(root(hashes[:k]), root(hashes[k:]))
+}
+
+// hashLeaves returns the hashes of leaves.
+func hashLeaves(leaves [][]byte) [][]byte {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = leafHash(leaf)
+	}
+	return hashes
+}
+
+// Root returns the hex root of a tree of leaves. The root of no leaf is the
+// SHA-256 of nothing.
+func Root(leaves [][]byte) string {
+	return hex.EncodeToString(root(hashLeaves(leaves)))
+}
+
+// Prove returns the proof of the leaf at an index of a tree of leaves.
+func Prove(leaves [][]byte, index int) (Proof, error) {
+	if index < 0 || index >= len(leaves) {
+		return Proof{}, fmt.Errorf("index %d out of %d leaves", index, len(leaves))
+	}
+
+	var aunts []string
+	hashes := hashLeaves(leaves)
+	i := index
+	// descend from the root, the siblings are collected top down
+	for len(hashes) > 1 {
+		k := split(len(hashes))
+		if i < k {
+			aunts = append(aunts, hex.EncodeToString(root(hashes[k:])))
+			hashes = hashes[:k]
+		} else {
+			aunts = append(aunts, hex.EncodeToString(root(hashes[:k])))
+			hashes, i = hashes[k:], i-k
+		}
+	}
+	for l, r := 0, len(aunts)-1; l < r; l, r = l+1, r-1 {
+		aunts[l], aunts[r] = aunts[r], aunts[l]
+	}
+	return Proof{Index: index, Total: len(leaves), Aunts: aunts}, nil
+}
+
+// Verify checks that a leaf is at the index of the proof in the tree of a hex
+// root. It returns ErrInvalidProof otherwise.
+func (p Proof) Verify(rootHash string, leaf []byte) error {
+	expected, err := hex.DecodeString(rootHash)
+	if err != nil {
+		return fmt.Errorf("%w: root %q: %v", ErrInvalidProof, rootHash, err)
+	}
+	if p.Index < 0 || p.Index >= p.Total {
+		return fmt.Errorf("%w: index %d out of %d leaves", ErrInvalidProof, p.Index, p.Total)
+	}
+
+	aunts := make([][]byte, len(p.Aunts))
+	for i, aunt := range p.Aunts {
+		if aunts[i], err = hex.DecodeString(aunt); err != nil {
+			return fmt.Errorf("%w: aunt %q: %v", ErrInvalidProof, aunt, err)
+		}
+	}
+
+	computed, ok := fromAunts(p.Index, p.Total, leafHash(leaf), aunts)
+	if !ok || !bytes.Equal(computed, expected) {
+		return fmt.Errorf("%w: the leaf is not at index %d of root %v", ErrInvalidProof, p.Index, rootHash)
+	}
+	return nil
+}
+
+// fromAunts returns the root of the tree of total leaves whose leaf at index
+// has a hash, and the siblings of its path bottom up. It returns false if the
+// number of siblings does not match the shape of the tree.
+func fromAunts(index int, total int, hash []byte, aunts [][]byte) ([]byte, bool) {
+	if total == 1 {
+		return hash, len(aunts) == 0
+	}
+	if len(aunts) == 0 {
+		return nil, false
+	}
+
+	last := aunts[len(aunts)-1]
+	k := split(total)
+	if index < k {
+		left, ok := fromAunts(index, k, hash, aunts[:len(aunts)-1])
+		return innerHash(left, last), ok
+	}
+	right, ok := fromAunts(index-k, total-k, hash, aunts[:len(aunts)-1])
+	return innerHash(last, right), ok
+}
+
+// KeyValueLeaf returns the leaf of a key and its value in the tree of a
+// key-value state: the lengths of both, as 8-byte big-endian integers, each
+// followed by its bytes. The leaves of such a tree are sorted by key.
+func KeyValueLeaf(key []byte, value []byte) []byte {
+	leaf := make([]byte, 0, 16+len(key)+len(value))
+	var size [8]byte
+	for _, b := range [][]byte{key, value} {
+		binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		leaf = append(leaf, size[:]...)
+		leaf = append(leaf, b...)
+	}
+	return leaf
+}
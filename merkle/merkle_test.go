@@ -0,0 +1,55 @@
+package merkle
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// leaves returns n distinct leaves.
+func leaves(n int) [][]byte {
+	var list [][]byte
+	for i := 0; i < n; i++ {
+		list = append(list, []byte(fmt.Sprintf("leaf-%d", i)))
+	}
+	return list
+}
+
+func TestProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		list := leaves(n)
+		root := Root(list)
+		for i := range list {
+			proof, err := Prove(list, i)
+			assert.NoError(t, err)
+			assert.NoError(t, proof.Verify(root, list[i]), "leaf %d of %d", i, n)
+
+			// the proof holds for that leaf at that index only
+			assert.ErrorIs(t, proof.Verify(root, []byte("other")), ErrInvalidProof)
+			if n > 1 {
+				moved := proof
+				moved.Index = (i + 1) % n
+				assert.ErrorIs(t, moved.Verify(root, list[i]), ErrInvalidProof)
+			}
+		}
+	}
+
+	_, err := Prove(leaves(3), 3)
+	assert.Error(t, err)
+	assert.ErrorIs(t, Proof{Index: 0, Total: 2}.Verify(Root(leaves(2)), []byte("leaf-0")), ErrInvalidProof)
+}
+
+func TestRoot(t *testing.T) {
+	// the empty tree is the hash of nothing
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Root(nil))
+
+	// the root depends on the order of the leaves, and a leaf cannot pass for
+	// the inner node of two others
+	list := leaves(2)
+	assert.NotEqual(t, Root(list), Root([][]byte{list[1], list[0]}))
+	assert.NotEqual(t, Root(list), Root(leaves(3)))
+	inner := append(leafHash(list[0]), leafHash(list[1])...)
+	assert.NotEqual(t, Root(list), Root([][]byte{inner}))
+
+	assert.NotEqual(t, KeyValueLeaf([]byte("ab"), []byte("c")), KeyValueLeaf([]byte("a"), []byte("bc")))
+}
@@ -0,0 +1,364 @@
+// Package metrics collects counters, gauges and histograms and exposes them
+// in the Prometheus text format, so that a Prometheus server can scrape them.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry of the metrics of the node.
+var Default = NewRegistry()
+
+// DefBuckets are the default histogram buckets, for latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, the first one at start and each
+// next one factor times the previous one.
+func ExponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// kind is the Prometheus type of a metric.
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// Registry keeps metric families by name.
+type Registry struct {
+	families map[string]*family // metric families by name
+	mux      sync.RWMutex       // mutual exclusion lock for families
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric and its series, one per combination of label values.
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string  // label names
+	buckets []float64 // upper bounds of the histogram buckets
+
+	series map[string]*series // series by label values
+	mux    sync.RWMutex       // mutual exclusion lock for series
+}
+
+// series is the value of a metric for a combination of label values.
+type series struct {
+	values []string // label values
+
+	value  float64  // value of a counter or gauge, sum of a histogram
+	counts []uint64 // observations per histogram bucket
+	count  uint64   // observations of a histogram
+	mux    sync.Mutex
+}
+
+// register returns the family of a name, creating it if needed. It panics if
+// the name is already registered with another type or other labels.
+func (r *Registry) register(name string, help string, k kind, buckets []float64, labels []string) *family {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %v is already registered as another metric", name))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series of the label values, creating it if needed.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %v needs %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mux.RLock()
+	s, ok := f.series[key]
+	f.mux.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s = &series{values: append([]string(nil), values...)}
+	if f.kind == histogramKind {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	f.series[key] = s
+	return s
+}
+
+// reset removes every series.
+func (f *family) reset() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.series = make(map[string]*series)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+// Counter registers a counter, a value that only goes up.
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, counterKind, nil, labels)}
+}
+
+// With returns the counter of the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{s: v.f.with(values)}
+}
+
+// Counter is a single counter series.
+type Counter struct {
+	s *series
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds a non-negative delta to the counter.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+
+	c.s.mux.Lock()
+	defer c.s.mux.Unlock()
+
+	c.s.value += delta
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+// Gauge registers a gauge, a value that goes up and down.
+func (r *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, gaugeKind, nil, labels)}
+}
+
+// With returns the gauge of the label values, in the order of the labels.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{s: v.f.with(values)}
+}
+
+// Reset removes every series of the gauge, e.g. before setting all of them again.
+func (v *GaugeVec) Reset() {
+	v.f.reset()
+}
+
+// Gauge is a single gauge series.
+type Gauge struct {
+	s *series
+}
+
+// Set sets the gauge.
+func (g *Gauge) Set(value float64) {
+	g.s.mux.Lock()
+	defer g.s.mux.Unlock()
+
+	g.s.value = value
+}
+
+// Add adds a delta to the gauge.
+func (g *Gauge) Add(delta float64) {
+	g.s.mux.Lock()
+	defer g.s.mux.Unlock()
+
+	g.s.value += delta
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	f *family
+}
+
+// Histogram registers a histogram that counts observations in buckets, given
+// by their sorted upper bounds. DefBuckets suits latencies in seconds.
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{f: r.register(name, help, histogramKind, buckets, labels)}
+}
+
+// With returns the histogram of the label values, in the order of the labels.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{s: v.f.with(values), buckets: v.f.buckets}
+}
+
+// Histogram is a single histogram series.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.s.mux.Lock()
+	defer h.s.mux.Unlock()
+
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.count++
+	h.s.value += value
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.RLock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mux.RUnlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// write writes the family and its series sorted by label values.
+func (f *family) write(b *strings.Builder) {
+	f.mux.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mux.RUnlock()
+
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+
+	fmt.Fprintf(b, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %v %v\n", f.name, f.kind)
+
+	for _, s := range all {
+		s.mux.Lock()
+		if f.kind != histogramKind {
+			fmt.Fprintf(b, "%v%v %v\n", f.name, labels(f.labels, s.values, "", ""), formatFloat(s.value))
+			s.mux.Unlock()
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%v_bucket%v %d\n", f.name, labels(f.labels, s.values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%v_bucket%v %d\n", f.name, labels(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%v_sum%v %v\n", f.name, labels(f.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(b, "%v_count%v %d\n", f.name, labels(f.labels, s.values, "", ""), s.count)
+		s.mux.Unlock()
+	}
+}
+
+// labels formats label pairs as {name="value",...}, with an optional extra
+// label, or returns an empty string without labels.
+func labels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", extraName, escapeLabel(extraValue)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes backslashes, double quotes and line feeds of a label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp escapes backslashes and line feeds of a help text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
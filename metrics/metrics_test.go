@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	sent := r.Counter("messages_sent_total", "Number of sent messages.", "type")
+	sent.With("QUERY").Inc()
+	sent.With("QUERY").Add(2)
+	sent.With("RECONFIG").Inc()
+
+	peers := r.Gauge("peers", "Number of peers.")
+	peers.With().Set(4)
+	peers.With().Dec()
+
+	latency := r.Histogram("rpc_duration_seconds", "Latency of RPCs.", []float64{0.1, 1}, "method")
+	latency.With("/Ping").Observe(0.05)
+	latency.With("/Ping").Observe(0.5)
+	latency.With("/Ping").Observe(3)
+
+	// a registered metric without series is not written
+	r.Counter("unused_total", "Never incremented.")
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP messages_sent_total Number of sent messages.
+# TYPE messages_sent_total counter
+messages_sent_total{type="QUERY"} 3
+messages_sent_total{type="RECONFIG"} 1
+# HELP peers Number of peers.
+# TYPE peers gauge
+peers 3
+# HELP rpc_duration_seconds Latency of RPCs.
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{method="/Ping",le="0.1"} 1
+rpc_duration_seconds_bucket{method="/Ping",le="1"} 2
+rpc_duration_seconds_bucket{method="/Ping",le="+Inf"} 3
+rpc_duration_seconds_sum{method="/Ping"} 3.55
+rpc_duration_seconds_count{method="/Ping"} 3
+`, b.String())
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+
+	// registering the same metric again returns the same series
+	r.Counter("calls_total", "Calls.", "method").With("a").Inc()
+	r.Counter("calls_total", "Calls.", "method").With("a").Inc()
+
+	var b strings.Builder
+	_, _ = r.WriteTo(&b)
+	assert.Contains(t, b.String(), `calls_total{method="a"} 2`)
+
+	assert.Panics(t, func() { r.Gauge("calls_total", "Calls.", "method") })
+	assert.Panics(t, func() { r.Counter("calls_total", "Calls.", "method").With() })
+	assert.Panics(t, func() { r.Counter("calls_total", "Calls.", "method").With("a").Add(-1) })
+}
+
+func TestGaugeReset(t *testing.T) {
+	r := NewRegistry()
+
+	peers := r.Gauge("peers", "Peers by state.", "state")
+	peers.With("READY").Set(2)
+	peers.Reset()
+	peers.With("IDLE").Set(1)
+
+	var b strings.Builder
+	_, _ = r.WriteTo(&b)
+	assert.NotContains(t, b.String(), "READY")
+	assert.Contains(t, b.String(), `peers{state="IDLE"} 1`)
+}
+
+func TestEscape(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("errors_total", "Errors\nby peer.", "peer").With(`a"b\c`).Inc()
+
+	var b strings.Builder
+	_, _ = r.WriteTo(&b)
+	assert.Contains(t, b.String(), `# HELP errors_total Errors\nby peer.`)
+	assert.Contains(t, b.String(), `errors_total{peer="a\"b\\c"} 1`)
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("up", "Node is up.").With().Set(1)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "up 1\n")
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 2, 4, 8}, ExponentialBuckets(1, 2, 4))
+}
@@ -1 +1,323 @@
 package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"simple-p2p/logger"
+	"simple-p2p/metrics"
+	"simple-p2p/p2p/message"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadRequest = errors.New("bad request")
+	ErrNotFound   = errors.New("not found")
+	ErrTooLarge   = errors.New("request body too large")
+)
+
+// defaultListLimit is the number of items returned by list endpoints without a limit.
+const defaultListLimit = 100
+
+// maxBodyBytes is the size of the largest body read by ReadJSON.
+const maxBodyBytes = 4 << 20
+
+// maxBytesMessage is the message of the error of a body read past the limit
+// of http.MaxBytesReader.
+const maxBytesMessage = "http: request body too large"
+
+// API is the HTTP/JSON API of a node. It serves the node itself, its peers,
+// chain, proofs, snapshots and message log. Other components, like consensus, add their own
+// endpoints with Handle.
+type API struct {
+	node   *Node
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+// PeerInfo is a peer and the state of the connection to it.
+type PeerInfo struct {
+	Address string `json:"address"`
+	State   string `json:"state"`
+}
+
+// NodeInfo is the overview of a node.
+type NodeInfo struct {
+	Address string `json:"address"`
+	Peers   int    `json:"peers"`
+	Height  uint64 `json:"height"`
+	Voter   bool   `json:"voter"`
+}
+
+// QueryRequest is the body of a query to the application.
+type QueryRequest struct {
+	Path string `json:"path"`
+	Data []byte `json:"data"`
+}
+
+// QueryResponse is the answer of the application to a query, at the height of
+// the last committed block.
+type QueryResponse struct {
+	Height uint64 `json:"height"`
+	Value  []byte `json:"value"`
+}
+
+// NewAPI creates the API of a node.
+func NewAPI(n *Node) *API {
+	a := &API{
+		node: n,
+		mux:  http.NewServeMux(),
+	}
+
+	a.HandleFunc("/node", http.MethodGet, a.getNode)
+	a.HandleFunc("/peers", http.MethodGet, a.getPeers)
+	a.HandleFunc("/chain/head", http.MethodGet, a.getHead)
+	a.HandleFunc("/chain/blocks", http.MethodGet, a.getBlocks)
+	a.HandleFunc("/chain/blocks/", http.MethodGet, a.getBlock)
+	a.HandleFunc("/chain/certificates/", http.MethodGet, a.getCertificate)
+	a.HandleFunc("/query", http.MethodPost, a.postQuery)
+	a.HandleFunc("/snapshots", http.MethodGet, a.getSnapshots)
+	a.HandleFunc("/proofs/txs/", http.MethodGet, a.getTxProof)
+	a.HandleFunc("/proofs/state/", http.MethodGet, a.getStateProof)
+	a.HandleFunc("/messages", http.MethodGet, a.getMessages)
+	a.HandleFunc("/metrics", http.MethodGet, a.getMetrics)
+	return a
+}
+
+// Handle registers the handler of a path pattern.
+func (a *API) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler of a path pattern that only accepts the
+// given method.
+func (a *API) HandleFunc(pattern string, method string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// ServeHTTP serves an API request.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Start starts serving the API on a TCP address in background.
+func (a *API) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	a.server = &http.Server{Handler: a, ReadHeaderTimeout: 5 * time.Second}
+	a.node.logger.Info("api is listening", logger.F("addr", lis.Addr()))
+
+	go func() {
+		if err := a.server.Serve(lis); err != nil && err != http.ErrServerClosed {
+			a.node.logger.Error("api stopped", logger.Err(err))
+		}
+	}()
+	return nil
+}
+
+// Stop stops the API server.
+func (a *API) Stop(ctx context.Context) error {
+	if a.server == nil {
+		return nil
+	}
+	return a.server.Shutdown(ctx)
+}
+
+// getNode returns the overview of the node.
+func (a *API) getNode(w http.ResponseWriter, _ *http.Request) {
+	WriteJSON(w, http.StatusOK, NodeInfo{
+		Address: a.node.Address,
+		Peers:   a.node.PeerManager.GetPeersNum(),
+		Height:  a.node.Chain.Height(),
+		Voter:   a.node.Voter(),
+	})
+}
+
+// getPeers returns the peers and their connection states.
+func (a *API) getPeers(w http.ResponseWriter, _ *http.Request) {
+	peers := make([]PeerInfo, 0)
+	for _, addr := range a.node.PeerManager.GetPeers() {
+		state := "NOT_CONNECTED"
+		if s := a.node.PeerManager.GetPeerState(addr); s >= 0 {
+			state = s.String()
+		}
+		peers = append(peers, PeerInfo{Address: addr, State: state})
+	}
+	WriteJSON(w, http.StatusOK, peers)
+}
+
+// getHead returns the last block of the chain.
+func (a *API) getHead(w http.ResponseWriter, _ *http.Request) {
+	head, ok := a.node.Chain.Head()
+	if !ok {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("chain is empty: %w", ErrNotFound))
+		return
+	}
+	WriteJSON(w, http.StatusOK, head)
+}
+
+// getBlocks returns the blocks from the height of the query parameter "from".
+func (a *API) getBlocks(w http.ResponseWriter, r *http.Request) {
+	from, err := QueryUint(r, "from", 1)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit, err := QueryUint(r, "limit", defaultListLimit)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if limit > uint64(maxRangeLimit) {
+		limit = uint64(maxRangeLimit)
+	}
+	WriteJSON(w, http.StatusOK, a.node.Chain.Range(from, int(limit)))
+}
+
+// getBlock returns the block at the height of the path /chain/blocks/{height}.
+func (a *API) getBlock(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/chain/blocks/"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid height: %w", ErrBadRequest))
+		return
+	}
+
+	block, ok := a.node.Chain.Get(height)
+	if !ok {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("block %d: %w", height, ErrNotFound))
+		return
+	}
+	WriteJSON(w, http.StatusOK, block)
+}
+
+// postQuery queries the application.
+func (a *API) postQuery(w http.ResponseWriter, r *http.Request) {
+	if a.node.app == nil {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("no application: %w", ErrNotFound))
+		return
+	}
+
+	var request QueryRequest
+	if err := ReadJSON(r, &request); err != nil {
+		WriteError(w, BodyStatus(err), err)
+		return
+	}
+
+	height, _ := a.node.AppInfo()
+	value, err := a.node.app.Query(request.Path, request.Data)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		WriteError(w, status, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, QueryResponse{Height: height, Value: value})
+}
+
+// getSnapshots returns the snapshots of the application state.
+func (a *API) getSnapshots(w http.ResponseWriter, _ *http.Request) {
+	snapshots, err := a.node.Snapshots()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, snapshots)
+}
+
+// getMessages returns the tail of the message log.
+func (a *API) getMessages(w http.ResponseWriter, r *http.Request) {
+	limit, err := QueryUint(r, "limit", defaultListLimit)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logs := a.node.MessageManager.GetMessageLogs(int(limit))
+	if logs == nil {
+		logs = []message.MessageLog{}
+	}
+	WriteJSON(w, http.StatusOK, logs)
+}
+
+// getMetrics returns the metrics of the node in the Prometheus text format,
+// followed by those of the registry given with WithMetrics.
+func (a *API) getMetrics(w http.ResponseWriter, r *http.Request) {
+	a.node.collectMetrics()
+	metrics.Default.ServeHTTP(w, r)
+	if a.node.metrics != nil {
+		_, _ = a.node.metrics.WriteTo(w)
+	}
+}
+
+// QueryUint returns an unsigned integer query parameter, or def if it is not set.
+func QueryUint(r *http.Request, name string, def uint64) (uint64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v: %w", name, ErrBadRequest)
+	}
+	return v, nil
+}
+
+// ReadJSON decodes the JSON body of a request, of at most maxBodyBytes.
+func ReadJSON(r *http.Request, v interface{}) error {
+	return ReadJSONLimit(r, v, maxBodyBytes)
+}
+
+// ReadJSONLimit decodes the JSON body of a request. It returns ErrTooLarge if
+// the body is longer than limit bytes.
+func ReadJSONLimit(r *http.Request, v interface{}, limit int64) error {
+	if r.ContentLength > limit {
+		return fmt.Errorf("body of %d bytes, the limit is %d: %w", r.ContentLength, limit, ErrTooLarge)
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, limit)).Decode(v); err != nil {
+		if err.Error() == maxBytesMessage {
+			return fmt.Errorf("body over %d bytes: %w", limit, ErrTooLarge)
+		}
+		return fmt.Errorf("invalid body: %v: %w", err, ErrBadRequest)
+	}
+	return nil
+}
+
+// BodyStatus returns the HTTP status of an error of ReadJSON.
+func BodyStatus(err error) int {
+	if errors.Is(err, ErrTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// WriteJSON writes a JSON response.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes a JSON error response.
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/chain"
+	"simple-p2p/finality"
+	"simple-p2p/p2p/message"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	network := transport.NewMemory()
+
+	node1 := NewNode("node-1", WithTransport(network))
+	node1.StartServer()
+	defer node1.StopServer()
+
+	node2 := NewNode("node-2", WithTransport(network))
+	node2.StartServer()
+	defer node2.StopServer()
+
+	node1.PeerManager.AddPeers(node2.Address)
+	conn, err := node1.PeerManager.GetConnection(node2.Address)
+	assert.NoError(t, err)
+	assert.NoError(t, node1.MessageManager.SendMessage(context.Background(), conn, &proto.MessageRequest{Type: proto.MessageType_DECISION, Value: []byte("1")}))
+
+	node1.Chain.Append(3)
+	node1.Chain.Append(5)
+
+	api := NewAPI(node1)
+
+	var info NodeInfo
+	assert.Equal(t, http.StatusOK, get(api, "/node", &info))
+	assert.Equal(t, NodeInfo{Address: "node-1", Peers: 1, Height: 2, Voter: true}, info)
+
+	var peers []PeerInfo
+	assert.Equal(t, http.StatusOK, get(api, "/peers", &peers))
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "node-2", peers[0].Address)
+
+	var head chain.Block
+	assert.Equal(t, http.StatusOK, get(api, "/chain/head", &head))
+	assert.Equal(t, uint64(2), head.Height)
+	assert.Equal(t, 5, head.Value)
+
+	var blocks []chain.Block
+	assert.Equal(t, http.StatusOK, get(api, "/chain/blocks?from=1&limit=1", &blocks))
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, blocks[0].Hash, head.PrevHash)
+	assert.Equal(t, http.StatusOK, get(api, "/chain/blocks?limit=18446744073709551615", &blocks))
+	assert.Len(t, blocks, 2)
+
+	var block chain.Block
+	assert.Equal(t, http.StatusOK, get(api, "/chain/blocks/1", &block))
+	assert.Equal(t, 3, block.Value)
+	assert.Equal(t, http.StatusNotFound, get(api, "/chain/blocks/3", nil))
+	assert.Equal(t, http.StatusBadRequest, get(api, "/chain/blocks/x", nil))
+
+	// certificates are only stored for the blocks of the chain
+	assert.Equal(t, http.StatusNotFound, get(api, "/chain/certificates/1", nil))
+	assert.ErrorIs(t, node1.AddCertificate(finality.Certificate{Height: 1, BlockHash: head.Hash}), chain.ErrInvalidBlock)
+	assert.NoError(t, node1.AddCertificate(finality.Certificate{Height: 1, BlockHash: block.Hash, Votes: []finality.Vote{}}))
+	var certificate finality.Certificate
+	assert.Equal(t, http.StatusOK, get(api, "/chain/certificates/1", &certificate))
+	assert.Equal(t, block.Hash, certificate.BlockHash)
+
+	var logs []message.MessageLog
+	assert.Equal(t, http.StatusOK, get(api, "/messages?limit=10", &logs))
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "node-2", logs[0].Receiver)
+	assert.Equal(t, "DECISION", logs[0].MessageType)
+
+	// the receiver logs the message as well
+	assert.Len(t, node2.MessageManager.GetMessageLogs(0), 1)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/peers", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `p2p_peers{state="READY"} 1`)
+	assert.Contains(t, w.Body.String(), `p2p_messages_sent_total{type="DECISION"}`)
+	assert.Contains(t, w.Body.String(), `p2p_rpc_duration_seconds_count{method="/p2p.MessageService/ReceiveMessage",peer="node-2"}`)
+}
+
+func TestReadJSON(t *testing.T) {
+	var v map[string]string
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a": "b"}`))
+	assert.NoError(t, ReadJSON(r, &v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+
+	// a body over the limit is refused, with or without a length
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a": "b"}`))
+	err := ReadJSONLimit(r, &v, 4)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, BodyStatus(err))
+	r.ContentLength = -1
+	assert.ErrorIs(t, ReadJSONLimit(r, &v, 4), ErrTooLarge)
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a"`))
+	err = ReadJSON(r, &v)
+	assert.ErrorIs(t, err, ErrBadRequest)
+	assert.Equal(t, http.StatusBadRequest, BodyStatus(err))
+}
+
+// get sends a GET request to the API and decodes the response into v.
+func get(api *API, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil {
+		_ = json.NewDecoder(w.Body).Decode(v)
+	}
+	return w.Code
+}
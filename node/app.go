@@ -0,0 +1,180 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"simple-p2p/chain"
+	"simple-p2p/logger"
+)
+
+var (
+	ErrAppHash   = errors.New("application state hash mismatch")
+	ErrAppHalted = errors.New("application halted")
+)
+
+// Application is the state machine replicated by the nodes, in the style of
+// ABCI. The node checks the transactions submitted to the mempool with
+// CheckTx, executes each finalized block with Execute then Commit, and serves
+// read requests with Query. Execute must be deterministic: every node executes
+// the same blocks and reaches the same state hash, which is recorded in the
+// next block.
+type Application interface {
+	// Info returns the height of the last committed block and the state hash
+	// after it. The node executes the blocks above that height on start.
+	Info() (height uint64, appHash string)
+
+	// CheckTx validates a transaction against the committed state before it
+	// enters the mempool. It must not change the state.
+	CheckTx(tx chain.Tx) error
+
+	// Execute executes the transactions of a finalized block in order. Invalid
+	// transactions are skipped, an error halts the application.
+	Execute(block chain.Block) error
+
+	// Commit persists the state after the executed block and returns its hash.
+	Commit() (string, error)
+
+	// Query answers a read request on the committed state. An error wrapping
+	// ErrNotFound is served as a not found error by the API.
+	Query(path string, data []byte) ([]byte, error)
+}
+
+// Application returns the application of the node, nil if none.
+func (n *Node) Application() Application {
+	return n.app
+}
+
+// OnCommit registers a hook called with each block appended to the chain,
+// decided or synced, once the application committed it.
+func (n *Node) OnCommit(fn func(chain.Block)) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.onCommit = append(n.onCommit, fn)
+}
+
+// AppInfo returns the height of the last block committed by the application
+// and the state hash after it.
+func (n *Node) AppInfo() (uint64, string) {
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	return n.appHeight, n.appHash
+}
+
+// Finalize appends a decided value and its transactions as the next block,
+// then executes and commits it. The block records the state hash of the
+// application after the previous block.
+func (n *Node) Finalize(value int, txs []chain.Tx) (chain.Block, error) {
+	return n.FinalizeOp(value, txs, nil)
+}
+
+// FinalizeOp is Finalize with a validator set operation recorded in the block.
+func (n *Node) FinalizeOp(value int, txs []chain.Tx, op []byte) (chain.Block, error) {
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	if n.appErr != nil {
+		return chain.Block{}, n.appErr
+	}
+
+	b, err := n.Chain.AppendOp(value, txs, op, n.appHash)
+	if err != nil {
+		return chain.Block{}, err
+	}
+	return b, n.commit(b)
+}
+
+// appendBlock appends a block finalized by the peers, then executes and
+// commits it. It returns ErrAppHash if the state hash recorded in the block
+// differs from the local one.
+func (n *Node) appendBlock(b chain.Block) error {
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	if n.appErr != nil {
+		return n.appErr
+	}
+	if n.app != nil && b.AppHash != n.appHash {
+		return fmt.Errorf("%w at height %d: %v, the local state is %v", ErrAppHash, b.Height, b.AppHash, n.appHash)
+	}
+
+	if err := n.Chain.AppendBlock(b); err != nil {
+		return err
+	}
+	return n.commit(b)
+}
+
+// commit executes and commits an appended block, then runs the OnCommit
+// hooks. A failure halts the application. The application lock must be held.
+func (n *Node) commit(b chain.Block) error {
+	if n.app != nil {
+		if err := n.execute(b); err != nil {
+			n.appErr = fmt.Errorf("%w at height %d: %v", ErrAppHalted, b.Height, err)
+			n.logger.Error("failed to execute block", logger.F("height", b.Height), logger.Err(err))
+			return n.appErr
+		}
+	}
+	n.appHeight = b.Height
+	n.takeSnapshot(b)
+
+	n.mux.Lock()
+	hooks := append([]func(chain.Block){}, n.onCommit...)
+	n.mux.Unlock()
+
+	for _, fn := range hooks {
+		fn(b)
+	}
+	return nil
+}
+
+// execute executes and commits a block with the application.
+func (n *Node) execute(b chain.Block) error {
+	if err := n.app.Execute(b); err != nil {
+		return err
+	}
+
+	hash, err := n.app.Commit()
+	if err != nil {
+		return err
+	}
+	n.appHash = hash
+	return nil
+}
+
+// replay executes the blocks of the chain above the last block committed by
+// the application, after a restart.
+func (n *Node) replay() error {
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	height, hash := n.app.Info()
+	if height > n.Chain.Height() {
+		return fmt.Errorf("%w: the application is at height %d, above the chain at height %d", ErrAppHash, height, n.Chain.Height())
+	}
+	if base := n.Chain.Base(); base > height+1 {
+		return fmt.Errorf("%w: the application is at height %d, below the chain restored at height %d", ErrAppHash, height, base)
+	}
+	if next, ok := n.Chain.Get(height + 1); ok && next.AppHash != hash {
+		return fmt.Errorf("%w at height %d: %v, the local state is %v", ErrAppHash, next.Height, next.AppHash, hash)
+	}
+	n.appHeight, n.appHash = height, hash
+
+	for h := height + 1; h <= n.Chain.Height(); h++ {
+		b, _ := n.Chain.Get(h)
+		if err := n.execute(b); err != nil {
+			return fmt.Errorf("failed to replay block %d: %w", h, err)
+		}
+		n.appHeight = h
+
+		// the state must match the hash recorded by the next block
+		if next, ok := n.Chain.Get(h + 1); ok && next.AppHash != n.appHash {
+			return fmt.Errorf("%w at height %d: %v, the local state is %v", ErrAppHash, next.Height, next.AppHash, n.appHash)
+		}
+	}
+
+	if n.appHeight > height {
+		n.logger.Info("replayed blocks", logger.F("from", height+1), logger.F("to", n.appHeight))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/chain"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is an application counting the transactions of each data.
+type counter struct {
+	counts map[string]int
+	height uint64
+	hash   string
+	mux    sync.Mutex
+}
+
+func newCounter() *counter {
+	return &counter{counts: make(map[string]int)}
+}
+
+func (c *counter) Info() (uint64, string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.height, c.hash
+}
+
+func (c *counter) CheckTx(tx chain.Tx) error {
+	if string(tx.Data) == "invalid" {
+		return errors.New("invalid data")
+	}
+	return nil
+}
+
+func (c *counter) Execute(b chain.Block) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, tx := range b.Txs {
+		if c.CheckTx(tx) == nil {
+			c.counts[string(tx.Data)]++
+		}
+	}
+	c.height = b.Height
+	return nil
+}
+
+func (c *counter) Commit() (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	data, _ := json.Marshal(c.counts)
+	sum := sha256.Sum256(data)
+	c.hash = hex.EncodeToString(sum[:])
+	return c.hash, nil
+}
+
+func (c *counter) Query(path string, data []byte) ([]byte, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if path != "count" {
+		return nil, fmt.Errorf("path %q: %w", path, ErrNotFound)
+	}
+	return []byte(strconv.Itoa(c.counts[string(data)])), nil
+}
+
+// txs returns transactions of data.
+func txs(data ...string) []chain.Tx {
+	var txs []chain.Tx
+	for _, d := range data {
+		txs = append(txs, chain.Tx{Data: []byte(d)})
+	}
+	return txs
+}
+
+func TestApplication(t *testing.T) {
+	network := transport.NewMemory()
+	store := storage.NewMemory()
+
+	app := newCounter()
+	node1 := NewNode("node-1", WithTransport(network), WithStorage(store), WithApplication(app))
+	assert.NoError(t, node1.StartServer())
+	defer node1.StopServer()
+
+	var committed []uint64
+	node1.OnCommit(func(b chain.Block) {
+		committed = append(committed, b.Height)
+	})
+
+	// each block records the state after the previous one
+	b1, err := node1.Finalize(1, txs("a", "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", b1.AppHash)
+	b2, err := node1.Finalize(2, txs("a", "invalid"))
+	assert.NoError(t, err)
+	_, hash := app.Info()
+	assert.NotEqual(t, hash, b2.AppHash)
+	b3, err := node1.Finalize(0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, b3.AppHash)
+	assert.Equal(t, []uint64{1, 2, 3}, committed)
+
+	// queries are answered at the last committed height
+	api := NewAPI(node1)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"path": "count", "data": "YQ=="}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response QueryResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, QueryResponse{Height: 3, Value: []byte("2")}, response)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"path": "unknown"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// a lagging node executes the synced blocks and checks their state hashes
+	lagging := NewNode("node-2", WithTransport(network), WithApplication(newCounter()))
+	assert.NoError(t, lagging.StartServer())
+	defer lagging.StopServer()
+	lagging.PeerManager.AddPeers(node1.Address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, lagging.SyncChain(ctx))
+	height, lagHash := lagging.AppInfo()
+	assert.Equal(t, uint64(3), height)
+	assert.Equal(t, hash, lagHash)
+
+	// a diverging state is detected by the next block
+	diverged := NewNode("node-3", WithTransport(network), WithApplication(newCounter()))
+	b, _ := node1.Chain.Get(1)
+	assert.NoError(t, diverged.appendBlock(b))
+	diverged.app.(*counter).counts["c"] = 1
+	diverged.appHash, _ = diverged.app.Commit()
+	b, _ = node1.Chain.Get(2)
+	assert.ErrorIs(t, diverged.appendBlock(b), ErrAppHash)
+
+	// a restarted application replays the blocks it did not commit
+	restarted := NewNode("node-1", WithTransport(network), WithStorage(store), WithApplication(newCounter()))
+	height, restartedHash := restarted.AppInfo()
+	assert.Equal(t, uint64(3), height)
+	assert.Equal(t, hash, restartedHash)
+}
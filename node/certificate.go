@@ -0,0 +1,123 @@
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"simple-p2p/chain"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/proto/proto"
+	"strconv"
+	"strings"
+)
+
+// certificateKey returns the key of the certificate of the block at a height.
+func certificateKey(height uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], height)
+	return key[:]
+}
+
+// Certificate returns the finality certificate of the block at a height, if
+// the node has one.
+func (n *Node) Certificate(height uint64) (finality.Certificate, bool) {
+	data, err := n.certificates.Get(certificateKey(height))
+	if err != nil {
+		return finality.Certificate{}, false
+	}
+
+	var c finality.Certificate
+	if err := json.Unmarshal(data, &c); err != nil {
+		n.logger.Warn("failed to read certificate", logger.F("height", height), logger.Err(err))
+		return finality.Certificate{}, false
+	}
+	return c, true
+}
+
+// AddCertificate stores the finality certificate of a block of the chain,
+// replacing the one stored, if any. The votes must be verified by the caller.
+// It returns chain.ErrInvalidBlock unless the certificate is for the block of
+// the chain at its height.
+func (n *Node) AddCertificate(c finality.Certificate) error {
+	b, ok := n.Chain.Get(c.Height)
+	if !ok || b.Hash != c.BlockHash {
+		return fmt.Errorf("%w: no block %v at height %d", chain.ErrInvalidBlock, c.BlockHash, c.Height)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return n.certificates.Set(certificateKey(c.Height), data)
+}
+
+// SetFinality makes the node restore a snapshot only once the block above it
+// has a finality certificate of the validators, holding a threshold of their
+// weight.
+func (n *Node) SetFinality(validators finality.Validators, threshold finality.Threshold) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.validators, n.threshold = validators, threshold
+}
+
+// GetCertificate returns the finality certificate of the block at a height.
+func (s *chainService) GetCertificate(_ context.Context, request *proto.BlockRequest) (*proto.Certificate, error) {
+	c, ok := s.node.Certificate(request.Height)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no certificate of block %d", request.Height)
+	}
+	return CertificateToProto(c), nil
+}
+
+// CertificateToProto converts a finality certificate to its proto message.
+func CertificateToProto(c finality.Certificate) *proto.Certificate {
+	pb := &proto.Certificate{Epoch: c.Epoch, Height: c.Height, BlockHash: c.BlockHash, Votes: make([]*proto.Vote, 0, len(c.Votes))}
+	for _, v := range c.Votes {
+		pb.Votes = append(pb.Votes, &proto.Vote{
+			Epoch:     v.Epoch,
+			Height:    v.Height,
+			BlockHash: v.BlockHash,
+			Validator: v.Validator,
+			Signature: v.Signature,
+		})
+	}
+	return pb
+}
+
+// CertificateFromProto converts a proto message to a finality certificate.
+func CertificateFromProto(pb *proto.Certificate) finality.Certificate {
+	c := finality.Certificate{Epoch: pb.Epoch, Height: pb.Height, BlockHash: pb.BlockHash, Votes: make([]finality.Vote, 0, len(pb.Votes))}
+	for _, v := range pb.Votes {
+		c.Votes = append(c.Votes, finality.Vote{
+			Epoch:     v.Epoch,
+			Height:    v.Height,
+			BlockHash: v.BlockHash,
+			Validator: v.Validator,
+			Signature: v.Signature,
+		})
+	}
+	return c
+}
+
+// getCertificate returns the finality certificate of the block at the height
+// of the path /chain/certificates/{height}.
+func (a *API) getCertificate(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/chain/certificates/"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid height: %w", ErrBadRequest))
+		return
+	}
+
+	c, ok := a.node.Certificate(height)
+	if !ok {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("certificate of block %d: %w", height, ErrNotFound))
+		return
+	}
+	WriteJSON(w, http.StatusOK, c)
+}
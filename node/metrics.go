@@ -0,0 +1,24 @@
+package node
+
+import (
+	"simple-p2p/metrics"
+)
+
+var peersByState = metrics.Default.Gauge("p2p_peers", "Number of peers by connection state.", "state")
+
+// collectMetrics sets the metrics that are read from the node state rather
+// than counted as they happen.
+func (n *Node) collectMetrics() {
+	peersByState.Reset()
+	for _, addr := range n.PeerManager.GetPeers() {
+		state := "NOT_CONNECTED"
+		if s := n.PeerManager.GetPeerState(addr); s >= 0 {
+			state = s.String()
+		}
+		peersByState.With(state).Inc()
+	}
+
+	if banned := len(n.PeerManager.GetBannedPeers()); banned > 0 {
+		peersByState.With("BANNED").Set(float64(banned))
+	}
+}
@@ -1,14 +1,22 @@
 package node
 
 import (
+	"context"
+	"fmt"
 	"google.golang.org/grpc"
-	"log"
 	"net"
+	"simple-p2p/chain"
+	"simple-p2p/clock"
+	"simple-p2p/finality"
+	"simple-p2p/logger"
+	"simple-p2p/metrics"
 	"simple-p2p/p2p"
 	"simple-p2p/p2p/message"
 	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/tracing"
+	"simple-p2p/transport"
 	"sync"
-	"time"
 )
 
 // Node represents a P2P node in the network.
@@ -23,52 +31,392 @@ type Node struct {
 	PeerManager p2p.Peer // Peer manager instance
 
 	MessageManager message.MessageManager // Message manager instance
+
+	Chain *chain.Chain // Chain of decided values
+
+	transport     transport.Transport           // transport to listen and dial on
+	clock         clock.Clock                   // clock of background loops
+	dialOptions   []grpc.DialOption             // extra options of peer connections
+	peerOptions   []p2p.Option                  // extra options of the peer manager
+	serverOptions []grpc.ServerOption           // extra options of the server
+	interceptors  []grpc.UnaryServerInterceptor // extra unary interceptors of the server
+	services      []service                     // extra services of the server
+	engines       []Engine                      // consensus instances run on the node
+	metrics       *metrics.Registry             // extra metrics served by the API, nil if none
+	store         storage.Store                 // store of the node state, nil if the state is kept in memory only
+	err           error                         // error of loading the stored state, returned by StartServer
+	listener      net.Listener                  // listener of the server
+	logger        logger.Logger                 // logger of the node and its components
+	tracer        *tracing.Tracer               // tracer of the node and its components, nil if tracing is off
+	app           Application                   // application executing the finalized blocks, nil if none
+	snapshots     *snapshotStore                // snapshots of the application state
+	certificates  storage.Store                 // finality certificates of the blocks by height
+
+	snapshotInterval uint64 // blocks between two snapshots, none if 0
+	snapshotKeep     int    // number of snapshots kept, all if 0
+
+	appMux    sync.Mutex // executes the blocks one at a time, lock for the fields below
+	appHeight uint64     // height of the last committed block
+	appHash   string     // state hash of the application after the last committed block
+	appErr    error      // error that halted the application
+
+	mux        sync.Mutex          // mutual exclusion lock for the hooks and syncing
+	onShutdown []func()            // hooks to stop producing work, run first on shutdown
+	onClose    []func() error      // hooks to flush state, run last on shutdown
+	onCommit   []func(chain.Block) // hooks called with each committed block
+	syncing    bool                // the chain is catching up, the node is not a voter
+	validators finality.Validators // validators of the certificates of the restored snapshots, nil if none
+	threshold  finality.Threshold  // share of the weight of the validators a certificate holds
+	stopOnce   sync.Once           // stop the server once
+}
+
+// service is a gRPC service registered by the node.
+type service struct {
+	desc *grpc.ServiceDesc
+	impl interface{}
+}
+
+// Option configures a node.
+type Option func(*Node)
+
+// WithTransport sets the transport of the node and its peer manager. The
+// default is TCP.
+func WithTransport(t transport.Transport) Option {
+	return func(n *Node) {
+		n.transport = t
+	}
+}
+
+// WithClock sets the clock of the node background loops. The default is the
+// wall clock.
+func WithClock(c clock.Clock) Option {
+	return func(n *Node) {
+		n.clock = c
+	}
 }
 
-// NewNode creates a new node instance.
-func NewNode(address string) *Node {
-	return &Node{
-		Address:        address,
-		Server:         grpc.NewServer(),
-		Waiter:         &sync.WaitGroup{},
-		PeerManager:    p2p.NewPeerManager(address),
-		MessageManager: message.NewMessageManager(),
+// WithDialOptions adds options to every connection to a peer, e.g. client
+// interceptors.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(n *Node) {
+		n.dialOptions = append(n.dialOptions, opts...)
 	}
 }
 
+// WithPeerOptions adds options to the peer manager, e.g. discovery settings.
+func WithPeerOptions(opts ...p2p.Option) Option {
+	return func(n *Node) {
+		n.peerOptions = append(n.peerOptions, opts...)
+	}
+}
+
+// WithServerOptions adds options to the server, e.g. credentials or message
+// size limits.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(n *Node) {
+		n.serverOptions = append(n.serverOptions, opts...)
+	}
+}
+
+// WithUnaryInterceptors adds unary interceptors to the server. They run in
+// order, after the tracing interceptor.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(n *Node) {
+		n.interceptors = append(n.interceptors, interceptors...)
+	}
+}
+
+// WithService registers a service to the server, like the Register*Server
+// functions of the generated code.
+func WithService(desc *grpc.ServiceDesc, impl interface{}) Option {
+	return func(n *Node) {
+		n.services = append(n.services, service{desc: desc, impl: impl})
+	}
+}
+
+// WithPeerManager sets the peer manager of the node. The transport, clock,
+// dial, logger and peer options of the node do not apply to it.
+func WithPeerManager(pm p2p.Peer) Option {
+	return func(n *Node) {
+		n.PeerManager = pm
+	}
+}
+
+// WithMessageManager sets the message manager of the node. The logger of the
+// node does not apply to it.
+func WithMessageManager(mm message.MessageManager) Option {
+	return func(n *Node) {
+		n.MessageManager = mm
+	}
+}
+
+// WithConsensus adds consensus instances to the node. Each is attached to the
+// node and queried through the consensus service of the node, by topic.
+func WithConsensus(engines ...Engine) Option {
+	return func(n *Node) {
+		n.engines = append(n.engines, engines...)
+	}
+}
+
+// WithMetrics adds a registry served by the API at /metrics after
+// metrics.Default, e.g. the metrics of the application.
+func WithMetrics(r *metrics.Registry) Option {
+	return func(n *Node) {
+		n.metrics = r
+	}
+}
+
+// WithStorage sets the store of the node state: the chain, the address book
+// of the default peer manager, the journal of the default message manager and
+// the state of the consensus instances, each under its own prefix. The store
+// is not closed by the node.
+func WithStorage(s storage.Store) Option {
+	return func(n *Node) {
+		n.store = s
+	}
+}
+
+// WithLogger sets the logger of the node, its peer manager, message manager
+// and consensus. Every log carries the node address. The default discards logs.
+func WithLogger(l logger.Logger) Option {
+	return func(n *Node) {
+		n.logger = l
+	}
+}
+
+// WithTracer sets the tracer of the node and its consensus. Calls between
+// nodes carry the trace context, so a trace follows a message hop by hop.
+// Every span carries the node address. The default records nothing.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(n *Node) {
+		n.tracer = t
+	}
+}
+
+// WithApplication sets the application executing the finalized blocks. The
+// default is none: blocks are only appended to the chain.
+func WithApplication(app Application) Option {
+	return func(n *Node) {
+		n.app = app
+	}
+}
+
+// NewNode creates a new node instance. The peer, message and consensus
+// services, and those of WithService, are served once the server starts.
+func NewNode(address string, opts ...Option) *Node {
+	n := &Node{
+		Address:   address,
+		Waiter:    &sync.WaitGroup{},
+		Chain:     chain.New(),
+		transport: transport.NewTCP(),
+		clock:     clock.Real{},
+		logger:    logger.Nop(),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.logger = n.logger.With(logger.F("node", address))
+
+	var interceptors []grpc.UnaryServerInterceptor
+	if n.tracer != nil {
+		n.tracer = n.tracer.With(tracing.Attr("node", address))
+		interceptors = append(interceptors, tracing.UnaryServerInterceptor(n.tracer))
+		n.dialOptions = append(n.dialOptions, grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(n.tracer)))
+	}
+	interceptors = append(interceptors, n.interceptors...)
+	n.Server = grpc.NewServer(append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}, n.serverOptions...)...)
+
+	messageOptions := []message.Option{message.WithLogger(n.logger)}
+	peerOptions := []p2p.Option{
+		p2p.WithTransport(n.transport),
+		p2p.WithClock(n.clock),
+		p2p.WithDialOptions(n.dialOptions...),
+		p2p.WithLogger(n.logger),
+	}
+	if n.store != nil {
+		if c, err := chain.Open(n.Storage("chain")); err != nil {
+			n.err = err
+		} else {
+			n.Chain = c
+		}
+		messageOptions = append(messageOptions, message.WithStore(n.Storage("messages")))
+		peerOptions = append(peerOptions, p2p.WithStore(n.Storage("peers")))
+		n.snapshots = &snapshotStore{store: n.Storage("snapshots")}
+		n.certificates = n.Storage("certificates")
+	} else {
+		n.snapshots = &snapshotStore{store: storage.NewMemory()}
+		n.certificates = storage.NewMemory()
+	}
+
+	if n.MessageManager == nil {
+		n.MessageManager = message.NewMessageManager(messageOptions...)
+	}
+	if n.PeerManager == nil {
+		n.PeerManager = p2p.NewPeerManager(address, append(peerOptions, n.peerOptions...)...)
+	}
+
+	if n.app != nil && n.err == nil {
+		n.err = n.replay()
+	}
+
+	for _, engine := range n.engines {
+		engine.AddNode(n)
+	}
+	return n
+}
+
+// Logger returns the logger of the node, for the components built on it.
+func (n *Node) Logger() logger.Logger {
+	return n.logger
+}
+
+// Clock returns the clock of the node, for the components built on it.
+func (n *Node) Clock() clock.Clock {
+	return n.clock
+}
+
+// Storage returns the view of the store of the node under a prefix, for the
+// components built on it. It returns nil if the state is kept in memory only.
+func (n *Node) Storage(prefix string) storage.Store {
+	if n.store == nil {
+		return nil
+	}
+	return storage.NewPrefix(n.store, prefix+"/")
+}
+
+// Engines returns the consensus instances of the node.
+func (n *Node) Engines() []Engine {
+	return n.engines
+}
+
+// Tracer returns the tracer of the node, nil if tracing is off.
+func (n *Node) Tracer() *tracing.Tracer {
+	return n.tracer
+}
+
 // StartServer starts server to provide services. This must be called after
 // registering any other external service.
-func (n *Node) StartServer() {
-	conn, _ := net.DialTimeout("tcp", n.Address, 5*time.Second)
-	if conn != nil {
-		conn.Close()
+func (n *Node) StartServer() error {
+	if n.err != nil {
+		return fmt.Errorf("failed to load state: %w", n.err)
 	}
 
-	lis, err := net.Listen("tcp", n.Address)
+	lis, err := n.transport.Listen(n.Address)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
+	n.listener = lis
 
 	// register internal service
 	proto.RegisterPeerServiceServer(n.Server, n.PeerManager)
 	proto.RegisterMessageServiceServer(n.Server, n.MessageManager)
+	proto.RegisterChainServiceServer(n.Server, &chainService{node: n, chain: n.Chain, snapshots: n.snapshots})
+	if len(n.engines) > 0 {
+		proto.RegisterConsensusServiceServer(n.Server, NewConsensusService(n.engines...))
+	}
+	for _, s := range n.services {
+		n.Server.RegisterService(s.desc, s.impl)
+	}
 
-	log.Printf("server is listening at: %v", n.Address)
+	n.logger.Info("server is listening")
 	n.Waiter.Add(1)
 
 	go func() {
 		err := n.Server.Serve(lis)
-		if err != nil {
-			log.Fatalf("failed to serve: %v", err)
+		if err != nil && err != grpc.ErrServerStopped {
+			n.logger.Error("failed to serve", logger.Err(err))
 		}
 	}()
+	return nil
+}
+
+// OnShutdown registers a hook run at the start of Shutdown, to stop the
+// components producing work such as consensus.
+func (n *Node) OnShutdown(fn func()) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.onShutdown = append(n.onShutdown, fn)
+}
+
+// OnClose registers a hook run at the end of Shutdown, once no RPC is served,
+// to flush state to disk. Hooks run in reverse order of registration.
+func (n *Node) OnClose(fn func() error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.onClose = append(n.onClose, fn)
+}
+
+// Shutdown stops the node in order: it runs the OnShutdown hooks, stops the
+// peer discovery, drains the in-flight RPCs, closes the peer connections and
+// runs the OnClose hooks. When ctx is done before the RPCs are drained, the
+// server is stopped abruptly and the returned error wraps ctx.Err().
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.mux.Lock()
+	onShutdown := append([]func(){}, n.onShutdown...)
+	onClose := append([]func() error{}, n.onClose...)
+	n.mux.Unlock()
+
+	n.logger.Info("shutting down")
+	for _, fn := range onShutdown {
+		fn()
+	}
+	n.PeerManager.StopDiscoverPeers()
+
+	var errs []error
+	n.stop(func() {
+		drained := make(chan struct{})
+		go func() {
+			n.Server.GracefulStop()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			n.logger.Warn("in-flight rpcs not drained, stopping server", logger.Err(ctx.Err()))
+			n.Server.Stop()
+			<-drained
+			errs = append(errs, ctx.Err())
+		}
+	})
+
+	if err := n.PeerManager.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	for i := len(onClose) - 1; i >= 0; i-- {
+		if err := onClose[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		n.logger.Error("shutdown failed", logger.F("errors", errs))
+		if rest := errs[1:]; len(rest) > 0 {
+			return fmt.Errorf("%v failed to shut down: %w, %v", n.Address, errs[0], rest)
+		}
+		return fmt.Errorf("%v failed to shut down: %w", n.Address, errs[0])
+	}
+	n.logger.Info("shutdown complete")
+	return nil
 }
 
-// StopServer stops the server.
+// StopServer stops the server abruptly, without draining the in-flight RPCs.
 func (n *Node) StopServer() {
-	if n.Server != nil {
-		n.Server.Stop()
-		log.Printf("server stopped: %v", n.Address)
+	n.stop(n.Server.Stop)
+}
+
+// stop stops the server once with the given function and releases the Waiter.
+func (n *Node) stop(stopServer func()) {
+	n.stopOnce.Do(func() {
+		if n.listener == nil {
+			return
+		}
+		stopServer()
+		n.listener.Close()
+		n.logger.Info("server stopped")
 		n.Waiter.Done()
-	}
+	})
 }
@@ -1,8 +1,25 @@
 package node
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"simple-p2p/chain"
+	"simple-p2p/clock"
+	"simple-p2p/logger"
+	"simple-p2p/metrics"
+	"simple-p2p/p2p"
+	"simple-p2p/p2p/message"
+	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,23 +27,25 @@ import (
 var host = "127.0.0.1"
 
 func TestDiscoverPeers(t *testing.T) {
-	
-	node1 := createNode(9447)
+	network := transport.NewMemory()
+	clk := clock.NewFake(time.Now())
+
+	node1 := createNode(network, clk, 9447)
 	node1.StartServer()
 
-	node2 := createNode(9448)
+	node2 := createNode(network, clk, 9448)
 	node2.StartServer()
 
-	node3 := createNode(9449)
+	node3 := createNode(network, clk, 9449)
 	node3.StartServer()
 
-	node4 := createNode(9450)
+	node4 := createNode(network, clk, 9450)
 	node4.StartServer()
 
-	node5 := createNode(9451)
+	node5 := createNode(network, clk, 9451)
 	node5.StartServer()
 
-	node6 := createNode(9452)
+	node6 := createNode(network, clk, 9452)
 	node6.StartServer()
 
 	node1.PeerManager.StartDiscoverPeers(node2.Address, node3.Address, node4.Address, node5.Address, node6.Address)
@@ -36,17 +55,247 @@ func TestDiscoverPeers(t *testing.T) {
 	node5.PeerManager.StartDiscoverPeers(node1.Address)
 	node6.PeerManager.StartDiscoverPeers(node1.Address)
 
-	// wait for each node to discover all others nodes
-	time.Sleep(2 * time.Second)
+	// advance the discovery loops until each node discovers all others nodes
+	nodes := []*Node{node1, node2, node3, node4, node5, node6}
+	assert.Eventually(t, func() bool {
+		clk.Advance(time.Second)
+		for _, n := range nodes {
+			if len(n.PeerManager.GetPeers()) != 5 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, n := range nodes {
+		assert.Equal(t, 5, len(n.PeerManager.GetPeers()))
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	network := transport.NewMemory()
+
+	node1 := NewNode("node-1", WithTransport(network))
+	node1.StartServer()
+
+	// an address can only be listened on once
+	_, err := network.Listen("node-1")
+	assert.ErrorIs(t, err, transport.ErrAddressInUse)
+
+	// the address is free again once the node stops
+	node1.StopServer()
+	lis, err := network.Listen("node-1")
+	assert.NoError(t, err)
+	assert.NoError(t, lis.Close())
+}
+
+func TestLogger(t *testing.T) {
+	network := transport.NewMemory()
+
+	var logs strings.Builder
+	node1 := NewNode("node-1", WithTransport(network), WithLogger(logger.New(&logs, logger.LevelInfo, logger.FormatText)))
+	assert.NoError(t, node1.StartServer())
+	defer node1.StopServer()
+
+	node1.PeerManager.AddPeers("node-2")
+	assert.Contains(t, logs.String(), "msg=\"server is listening\" node=node-1\n")
+	assert.Contains(t, logs.String(), "msg=\"add peer\" node=node-1 peer=node-2\n")
+
+	// a second server on the same address fails instead of exiting
+	node2 := NewNode("node-1", WithTransport(network))
+	assert.ErrorIs(t, node2.StartServer(), transport.ErrAddressInUse)
+}
+
+// blockingServer answers preference queries once released.
+type blockingServer struct {
+	received chan struct{}
+	release  chan struct{}
+}
+
+func (s *blockingServer) GetPreference(context.Context, *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error) {
+	s.received <- struct{}{}
+	<-s.release
+	return &proto.GetPreferenceResponse{}, nil
+}
+
+func TestShutdown(t *testing.T) {
+	network := transport.NewMemory()
+
+	start := func(addr string) (*Node, *blockingServer) {
+		n := NewNode(addr, WithTransport(network))
+		server := &blockingServer{received: make(chan struct{}), release: make(chan struct{})}
+		proto.RegisterConsensusServiceServer(n.Server, server)
+		assert.NoError(t, n.StartServer())
+		return n, server
+	}
+	query := func(from *Node, to string) chan error {
+		done := make(chan error, 1)
+		conn, err := from.PeerManager.GetConnection(to)
+		assert.NoError(t, err)
+		go func() {
+			_, err := proto.NewConsensusServiceClient(conn).GetPreference(context.Background(), &proto.GetPreferenceRequest{})
+			done <- err
+		}()
+		return done
+	}
+
+	node1, server1 := start("node-1")
+	node2, server2 := start("node-2")
+	defer node2.StopServer()
+	node1.PeerManager.StartDiscoverPeers(node2.Address)
+
+	var order []string
+	node1.OnShutdown(func() { order = append(order, "shutdown") })
+	node1.OnClose(func() error { order = append(order, "close 1"); return nil })
+	node1.OnClose(func() error { order = append(order, "close 2"); return nil })
+
+	// the in-flight query is answered before the server stops
+	done := query(node2, node1.Address)
+	<-server1.received
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(server1.release)
+	}()
+	assert.NoError(t, node1.Shutdown(context.Background()))
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"shutdown", "close 2", "close 1"}, order)
+
+	// the waiter is released, the connections are closed and the peers kept
+	node1.Waiter.Wait()
+	assert.Equal(t, []string{node2.Address}, node1.PeerManager.GetPeers())
+	assert.Equal(t, connectivity.State(-1), node1.PeerManager.GetPeerState(node2.Address))
+
+	// the server is stopped when the query outlasts the timeout
+	done = query(node1, node2.Address)
+	<-server2.received
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, node2.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Error(t, <-done)
+	close(server2.release)
+}
+
+// engine is a consensus instance that always prefers its value.
+type engine struct {
+	topic string
+	value int64
+	node  *Node
+}
+
+func (e *engine) AddNode(n *Node) {
+	e.node = n
+}
+
+func (e *engine) Topic() string {
+	return e.topic
+}
+
+func (e *engine) GetPreference(context.Context, *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error) {
+	return &proto.GetPreferenceResponse{Preference: e.value}, nil
+}
+
+func TestOptions(t *testing.T) {
+	network := transport.NewMemory()
+
+	var methods []string
+	count := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		methods = append(methods, info.FullMethod)
+		return handler(ctx, req)
+	}
+
+	pm := p2p.NewPeerManager("node-1", p2p.WithTransport(network))
+	mm := message.NewMessageManager()
+	engine1, engine2 := &engine{topic: "", value: 1}, &engine{topic: "reconfig", value: 2}
+	registry := metrics.NewRegistry()
+	registry.Counter("app_transactions_total", "Number of transactions.").With().Inc()
+
+	node1 := NewNode("node-1",
+		WithTransport(network),
+		WithPeerManager(pm),
+		WithMessageManager(mm),
+		WithUnaryInterceptors(count),
+		WithConsensus(engine1, engine2),
+		WithService(&proto.NodeAdminService_ServiceDesc, proto.UnimplementedNodeAdminServiceServer{}),
+		WithMetrics(registry),
+	)
+	assert.NoError(t, node1.StartServer())
+	defer node1.StopServer()
+
+	assert.Equal(t, pm, node1.PeerManager)
+	assert.Equal(t, mm, node1.MessageManager)
+	assert.Equal(t, node1, engine1.node)
+	assert.Equal(t, []Engine{engine1, engine2}, node1.Engines())
+
+	node2 := NewNode("node-2", WithTransport(network))
+	conn, err := node2.PeerManager.GetConnection(node1.Address)
+	assert.NoError(t, err)
+
+	// the consensus service dispatches by topic
+	client := proto.NewConsensusServiceClient(conn)
+	response, err := client.GetPreference(context.Background(), &proto.GetPreferenceRequest{Topic: "reconfig"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), response.Preference)
+	_, err = client.GetPreference(context.Background(), &proto.GetPreferenceRequest{Topic: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the extra service is registered
+	_, err = proto.NewNodeAdminServiceClient(conn).ListPeers(context.Background(), &proto.Empty{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	assert.Equal(t, []string{
+		"/p2p.ConsensusService/GetPreference",
+		"/p2p.ConsensusService/GetPreference",
+		"/p2p.NodeAdminService/ListPeers",
+	}, methods)
+
+	// the extra metrics are served after the default ones
+	w := httptest.NewRecorder()
+	NewAPI(node1).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, "app_transactions_total 1\n")
+	assert.Less(t, strings.Index(body, "p2p_rpc_duration_seconds"), strings.Index(body, "app_transactions_total"))
+}
+
+func TestStorage(t *testing.T) {
+	network := transport.NewMemory()
+	store := storage.NewMemory()
+
+	node1 := NewNode("node-1", WithTransport(network), WithStorage(store))
+	assert.NoError(t, node1.StartServer())
+	node2 := NewNode("node-2", WithTransport(network))
+	assert.NoError(t, node2.StartServer())
+	defer node2.StopServer()
+
+	_, err := node1.Chain.Append(3)
+	assert.NoError(t, err)
+	_, err = node1.Chain.Append(5)
+	assert.NoError(t, err)
+	node1.PeerManager.AddPeers(node2.Address, "node-3", "node-4")
+	assert.NoError(t, node1.PeerManager.BanPeer("node-4"))
+
+	conn, err := node1.PeerManager.GetConnection(node2.Address)
+	assert.NoError(t, err)
+	assert.NoError(t, node1.MessageManager.SendMessage(context.Background(), conn, &proto.MessageRequest{Type: proto.MessageType_DECISION, Value: []byte("1")}))
+	assert.NoError(t, node1.Shutdown(context.Background()))
+
+	// a restarted node resumes from the stored state
+	restarted := NewNode("node-1", WithTransport(network), WithStorage(store))
+	assert.NoError(t, restarted.StartServer())
+	defer restarted.StopServer()
+
+	head, ok := restarted.Chain.Head()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), head.Height)
+	assert.Equal(t, 5, head.Value)
+	assert.ElementsMatch(t, []string{"node-2", "node-3"}, restarted.PeerManager.GetPeers())
+	assert.Equal(t, []string{"node-4"}, restarted.PeerManager.GetBannedPeers())
+	assert.Equal(t, node1.MessageManager.GetMessageLogs(0)[0].Hash, restarted.MessageManager.GetMessageLogs(0)[0].Hash)
 
-	assert.Equal(t, 5, len(node1.PeerManager.GetPeers()))
-	assert.Equal(t, 5, len(node2.PeerManager.GetPeers()))
-	assert.Equal(t, 5, len(node3.PeerManager.GetPeers()))
-	assert.Equal(t, 5, len(node4.PeerManager.GetPeers()))
-	assert.Equal(t, 5, len(node5.PeerManager.GetPeers()))
-	assert.Equal(t, 5, len(node6.PeerManager.GetPeers()))
+	// a corrupted chain is reported when the server starts
+	assert.NoError(t, store.Set([]byte("chain/x"), []byte("{")))
+	corrupted := NewNode("node-5", WithTransport(network), WithStorage(store))
+	assert.ErrorIs(t, corrupted.StartServer(), chain.ErrCorrupted)
 }
 
-func createNode(port int64) *Node {
-	return NewNode(fmt.Sprintf("%v:%d", host, port))
+func createNode(network transport.Transport, clk clock.Clock, port int64) *Node {
+	return NewNode(fmt.Sprintf("%v:%d", host, port), WithTransport(network), WithClock(clk))
 }
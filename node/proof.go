@@ -0,0 +1,231 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"simple-p2p/chain"
+	"simple-p2p/merkle"
+	"simple-p2p/proto/proto"
+	"strings"
+)
+
+// StateProver is implemented by the applications whose state hash is the
+// Merkle root of their keys and values, the leaves merkle.KeyValueLeaf sorted
+// by key, so a single node proves the value of a key to a client that only
+// trusts the headers.
+type StateProver interface {
+	// ProveKey returns the value of a key in the committed state and the proof
+	// of its leaf against the state hash. It returns an error wrapping
+	// ErrNotFound if the key is not set.
+	ProveKey(key []byte) ([]byte, merkle.Proof, error)
+}
+
+// TxProof is the proof that a transaction is in a block.
+type TxProof struct {
+	Height    uint64       `json:"height"`     // height of the block
+	BlockHash string       `json:"block_hash"` // hash of the block
+	TxRoot    string       `json:"tx_root"`    // Merkle root of the transactions of the block
+	Tx        chain.Tx     `json:"tx"`
+	Proof     merkle.Proof `json:"proof"`
+}
+
+// Verify checks the proof against the Merkle root of the transactions. The
+// root must come from a trusted header of the block.
+func (p TxProof) Verify(txRoot string) error {
+	return chain.VerifyTx(txRoot, p.Tx, p.Proof)
+}
+
+// StateProof is the proof of the value of a key in the application state.
+type StateProof struct {
+	Height uint64       `json:"height"` // height of the last block executed in the state
+	Root   string       `json:"root"`   // state hash, recorded as the app_hash of the next block
+	Key    []byte       `json:"key"`
+	Value  []byte       `json:"value"`
+	Proof  merkle.Proof `json:"proof"`
+}
+
+// Verify checks the proof against a state hash. The hash must come from the
+// trusted header of the block at Height + 1.
+func (p StateProof) Verify(appHash string) error {
+	return p.Proof.Verify(appHash, merkle.KeyValueLeaf(p.Key, p.Value))
+}
+
+// ProveTx returns the proof that the transaction of a hash is in the block at
+// a height. It returns an error wrapping ErrNotFound if it is not.
+func (n *Node) ProveTx(height uint64, hash string) (TxProof, error) {
+	b, ok := n.Chain.Get(height)
+	if !ok {
+		return TxProof{}, fmt.Errorf("block %d: %w", height, ErrNotFound)
+	}
+
+	for i, tx := range b.Txs {
+		if tx.Hash() != hash {
+			continue
+		}
+		proof, err := chain.ProveTx(b.Txs, i)
+		if err != nil {
+			return TxProof{}, err
+		}
+		return TxProof{Height: b.Height, BlockHash: b.Hash, TxRoot: b.TxRoot, Tx: tx, Proof: proof}, nil
+	}
+	return TxProof{}, fmt.Errorf("transaction %v in block %d: %w", hash, height, ErrNotFound)
+}
+
+// ProveKey returns the proof of the value of a key in the committed state of
+// the application. It returns an error wrapping ErrNotFound if the key is not
+// set or the application is not a StateProver.
+func (n *Node) ProveKey(key []byte) (StateProof, error) {
+	prover, ok := n.app.(StateProver)
+	if !ok {
+		return StateProof{}, fmt.Errorf("no application with state proofs: %w", ErrNotFound)
+	}
+
+	// the state does not change while it is proved
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	value, proof, err := prover.ProveKey(key)
+	if err != nil {
+		return StateProof{}, err
+	}
+	return StateProof{Height: n.appHeight, Root: n.appHash, Key: key, Value: value, Proof: proof}, nil
+}
+
+// GetTxProof returns the proof that a transaction is in a block.
+func (s *chainService) GetTxProof(_ context.Context, request *proto.TxProofRequest) (*proto.TxProof, error) {
+	proof, err := s.node.ProveTx(request.Height, request.Hash)
+	if err != nil {
+		return nil, proofError(err)
+	}
+	return TxProofToProto(proof), nil
+}
+
+// GetStateProof returns the proof of the value of a key in the application
+// state.
+func (s *chainService) GetStateProof(_ context.Context, request *proto.StateProofRequest) (*proto.StateProof, error) {
+	proof, err := s.node.ProveKey(request.Key)
+	if err != nil {
+		return nil, proofError(err)
+	}
+	return StateProofToProto(proof), nil
+}
+
+// proofError returns the status of a failed proof, NotFound if the error
+// wraps ErrNotFound.
+func proofError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// TxProofToProto converts a transaction proof to its proto message.
+func TxProofToProto(p TxProof) *proto.TxProof {
+	return &proto.TxProof{
+		Height:    p.Height,
+		BlockHash: p.BlockHash,
+		TxRoot:    p.TxRoot,
+		Tx:        &proto.Tx{Data: p.Tx.Data, Fee: p.Tx.Fee},
+		Proof:     merkleProofToProto(p.Proof),
+	}
+}
+
+// TxProofFromProto converts a proto message to a transaction proof.
+func TxProofFromProto(pb *proto.TxProof) TxProof {
+	p := TxProof{
+		Height:    pb.Height,
+		BlockHash: pb.BlockHash,
+		TxRoot:    pb.TxRoot,
+		Proof:     merkleProofFromProto(pb.Proof),
+	}
+	if pb.Tx != nil {
+		p.Tx = chain.Tx{Data: pb.Tx.Data, Fee: pb.Tx.Fee}
+	}
+	return p
+}
+
+// StateProofToProto converts a state proof to its proto message.
+func StateProofToProto(p StateProof) *proto.StateProof {
+	return &proto.StateProof{
+		Height: p.Height,
+		Root:   p.Root,
+		Key:    p.Key,
+		Value:  p.Value,
+		Proof:  merkleProofToProto(p.Proof),
+	}
+}
+
+// StateProofFromProto converts a proto message to a state proof.
+func StateProofFromProto(pb *proto.StateProof) StateProof {
+	return StateProof{
+		Height: pb.Height,
+		Root:   pb.Root,
+		Key:    pb.Key,
+		Value:  pb.Value,
+		Proof:  merkleProofFromProto(pb.Proof),
+	}
+}
+
+// merkleProofToProto converts a Merkle proof to its proto message.
+func merkleProofToProto(p merkle.Proof) *proto.MerkleProof {
+	return &proto.MerkleProof{Index: int64(p.Index), Total: int64(p.Total), Aunts: p.Aunts}
+}
+
+// merkleProofFromProto converts a proto message to a Merkle proof.
+func merkleProofFromProto(pb *proto.MerkleProof) merkle.Proof {
+	if pb == nil {
+		return merkle.Proof{}
+	}
+	return merkle.Proof{Index: int(pb.Index), Total: int(pb.Total), Aunts: pb.Aunts}
+}
+
+// getTxProof returns the proof of the transaction of the path
+// /proofs/txs/{hash} in the block at the height of the query parameter.
+func (a *API) getTxProof(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/proofs/txs/")
+	height, err := QueryUint(r, "height", 0)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if height == 0 {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("height is required: %w", ErrBadRequest))
+		return
+	}
+
+	proof, err := a.node.ProveTx(height, hash)
+	if err != nil {
+		WriteError(w, errorStatus(err), err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, proof)
+}
+
+// getStateProof returns the proof of the key of the path /proofs/state/{key}.
+func (a *API) getStateProof(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/proofs/state/")
+	if key == "" {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("key is required: %w", ErrBadRequest))
+		return
+	}
+
+	proof, err := a.node.ProveKey([]byte(key))
+	if err != nil {
+		WriteError(w, errorStatus(err), err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, proof)
+}
+
+// errorStatus returns the HTTP status of an error, not found if it wraps
+// ErrNotFound.
+func errorStatus(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
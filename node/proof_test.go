@@ -0,0 +1,40 @@
+package node
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"simple-p2p/merkle"
+	"testing"
+)
+
+func TestProveTx(t *testing.T) {
+	n := NewNode("node-1", WithApplication(newCounter()))
+	_, err := n.Finalize(1, txs("a"))
+	assert.NoError(t, err)
+	b, err := n.Finalize(2, txs("a", "b", "c", "d", "e"))
+	assert.NoError(t, err)
+	api := NewAPI(n)
+
+	// the proof of each transaction verifies against the root of the header
+	for _, tx := range b.Txs {
+		var proof TxProof
+		assert.Equal(t, http.StatusOK, get(api, "/proofs/txs/"+tx.Hash()+"?height=2", &proof))
+		assert.Equal(t, b.Hash, proof.BlockHash)
+		assert.Equal(t, tx, proof.Tx)
+		assert.NoError(t, proof.Verify(b.Header().TxRoot))
+
+		first, _ := n.Chain.Get(1)
+		assert.ErrorIs(t, proof.Verify(first.TxRoot), merkle.ErrInvalidProof)
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(api, "/proofs/txs/"+b.Txs[1].Hash()+"?height=1", nil))
+	assert.Equal(t, http.StatusNotFound, get(api, "/proofs/txs/"+b.Txs[1].Hash()+"?height=3", nil))
+	assert.Equal(t, http.StatusBadRequest, get(api, "/proofs/txs/"+b.Txs[1].Hash(), nil))
+
+	// a tampered block no longer matches its root
+	b.Txs[0].Fee++
+	assert.False(t, b.Valid())
+
+	// the counter has no state proofs
+	assert.Equal(t, http.StatusNotFound, get(api, "/proofs/state/a", nil))
+}
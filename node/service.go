@@ -0,0 +1,51 @@
+package node
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-p2p/proto/proto"
+)
+
+// Engine is a consensus instance run by the node, see package consensus.
+type Engine interface {
+	// AddNode attaches the engine to the node.
+	AddNode(*Node)
+
+	// Topic returns the topic that identifies the consensus instance.
+	Topic() string
+
+	// GetPreference answers the query of a peer.
+	GetPreference(context.Context, *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error)
+}
+
+var _ proto.ConsensusServiceServer = (*consensusService)(nil)
+
+// consensusService serves the consensus queries of several consensus
+// instances of a node, dispatching each query by its topic.
+type consensusService struct {
+	engines map[string]Engine // consensus instances by topic
+}
+
+// NewConsensusService creates a consensus service for the given instances.
+// Only one consensus service can be registered to a gRPC server, so instances
+// with different topics must share it.
+func NewConsensusService(engines ...Engine) proto.ConsensusServiceServer {
+	s := &consensusService{
+		engines: make(map[string]Engine, len(engines)),
+	}
+
+	for _, engine := range engines {
+		s.engines[engine.Topic()] = engine
+	}
+	return s
+}
+
+// GetPreference returns the preference of the instance of the requested topic.
+func (s *consensusService) GetPreference(ctx context.Context, request *proto.GetPreferenceRequest) (*proto.GetPreferenceResponse, error) {
+	engine, ok := s.engines[request.GetTopic()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown consensus topic: %q", request.GetTopic())
+	}
+	return engine.GetPreference(ctx, request)
+}
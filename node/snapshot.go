@@ -0,0 +1,442 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-p2p/chain"
+	"simple-p2p/logger"
+	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"sort"
+	"sync"
+)
+
+var (
+	snapshotChunkSize = 1 << 20 // size of the chunks of a snapshot
+	maxSnapshotChunks = 1 << 12 // largest number of chunks of a snapshot offered by a peer
+)
+
+var ErrSnapshot = errors.New("invalid snapshot")
+
+var (
+	snapshotPrefix = []byte("s/") // metadata of the snapshots by height
+	chunkPrefix    = []byte("c/") // chunks of the snapshots by height and index
+)
+
+// Snapshotter is implemented by the applications whose state can be
+// snapshotted, so that a joining node restores a recent state from its peers
+// instead of executing every block since the first one.
+type Snapshotter interface {
+	// Snapshot returns the committed state. It is called right after Commit,
+	// the state must be the one of the returned hash.
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the state with the data of a snapshot taken after the
+	// block at height, whose state hash must be appHash. Info returns that
+	// height afterward. Data that is invalid or of another state hash is
+	// rejected with an error wrapping ErrSnapshot before the state changes.
+	Restore(height uint64, data []byte, appHash string) error
+}
+
+// Snapshot is a snapshot of the application state after a block, split in
+// chunks.
+type Snapshot struct {
+	Height    uint64   `json:"height"`     // height of the last block executed in the state
+	BlockHash string   `json:"block_hash"` // hash of the block at Height
+	AppHash   string   `json:"app_hash"`   // state hash after the block at Height
+	Chunks    []string `json:"chunks"`     // hex SHA-256 of the chunks, in order
+}
+
+// snapshotStore keeps the snapshots of a node and their chunks.
+type snapshotStore struct {
+	store storage.Store
+	mux   sync.Mutex // serializes the writes
+}
+
+// snapshotKey returns the key of the metadata of the snapshot at a height.
+func snapshotKey(height uint64) []byte {
+	key := make([]byte, len(snapshotPrefix)+8)
+	copy(key, snapshotPrefix)
+	binary.BigEndian.PutUint64(key[len(snapshotPrefix):], height)
+	return key
+}
+
+// chunkKey returns the key of a chunk of the snapshot at a height.
+func chunkKey(height uint64, index uint32) []byte {
+	key := make([]byte, len(chunkPrefix)+12)
+	copy(key, chunkPrefix)
+	binary.BigEndian.PutUint64(key[len(chunkPrefix):], height)
+	binary.BigEndian.PutUint32(key[len(chunkPrefix)+8:], index)
+	return key
+}
+
+// list returns the snapshots by increasing height.
+func (s *snapshotStore) list() ([]Snapshot, error) {
+	it := s.store.Iterator(snapshotPrefix)
+	defer it.Release()
+
+	snapshots := []Snapshot{}
+	for it.Next() {
+		var snapshot Snapshot
+		if err := json.Unmarshal(it.Value(), &snapshot); err != nil {
+			return nil, fmt.Errorf("%w: snapshot %x: %v", ErrSnapshot, it.Key(), err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// chunk returns a chunk of the snapshot at a height, or storage.ErrNotFound.
+func (s *snapshotStore) chunk(height uint64, index uint32) ([]byte, error) {
+	return s.store.Get(chunkKey(height, index))
+}
+
+// save splits the data of a snapshot of the state after a block into chunks,
+// and writes them with the metadata. Snapshots beyond the keep most recent
+// ones are deleted, none if keep is 0.
+func (s *snapshotStore) save(b chain.Block, appHash string, data []byte, keep int) (Snapshot, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	snapshot := Snapshot{Height: b.Height, BlockHash: b.Hash, AppHash: appHash, Chunks: []string{}}
+	batch := storage.NewBatch()
+	for i := 0; i == 0 || i < len(data); i += snapshotChunkSize {
+		end := i + snapshotChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[i:end])
+		batch.Set(chunkKey(b.Height, uint32(len(snapshot.Chunks))), data[i:end])
+		snapshot.Chunks = append(snapshot.Chunks, hex.EncodeToString(sum[:]))
+	}
+
+	meta, err := json.Marshal(snapshot)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	batch.Set(snapshotKey(b.Height), meta)
+
+	snapshots, err := s.list()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if keep > 0 && len(snapshots) >= keep {
+		for _, old := range snapshots[:len(snapshots)-keep+1] {
+			batch.Delete(snapshotKey(old.Height))
+			for i := range old.Chunks {
+				batch.Delete(chunkKey(old.Height, uint32(i)))
+			}
+		}
+	}
+	return snapshot, s.store.Write(batch)
+}
+
+// WithSnapshots takes a snapshot of the application state every interval
+// blocks, and keeps the keep most recent ones, all if keep is 0. The peers
+// joining the network restore the state from them. The application must
+// implement Snapshotter. The default takes no snapshot.
+func WithSnapshots(interval uint64, keep int) Option {
+	return func(n *Node) {
+		n.snapshotInterval, n.snapshotKeep = interval, keep
+	}
+}
+
+// Snapshots returns the snapshots of the node by increasing height.
+func (n *Node) Snapshots() ([]Snapshot, error) {
+	return n.snapshots.list()
+}
+
+// takeSnapshot takes a snapshot of the state after a committed block if its
+// height is a multiple of the interval. A failure is logged, the node goes on
+// without the snapshot. The application lock must be held.
+func (n *Node) takeSnapshot(b chain.Block) {
+	snapshotter, ok := n.app.(Snapshotter)
+	if !ok || n.snapshotInterval == 0 || b.Height%n.snapshotInterval != 0 {
+		return
+	}
+
+	data, err := snapshotter.Snapshot()
+	if err == nil {
+		_, err = n.snapshots.save(b, n.appHash, data, n.snapshotKeep)
+	}
+	if err != nil {
+		n.logger.Warn("failed to take snapshot", logger.F("height", b.Height), logger.Err(err))
+		return
+	}
+	n.logger.Debug("took snapshot", logger.F("height", b.Height), logger.F("size", len(data)))
+}
+
+// ListSnapshots returns the snapshots of the node.
+func (s *chainService) ListSnapshots(context.Context, *proto.Empty) (*proto.SnapshotsResponse, error) {
+	snapshots, err := s.snapshots.list()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &proto.SnapshotsResponse{Snapshots: make([]*proto.Snapshot, 0, len(snapshots))}
+	for _, snapshot := range snapshots {
+		response.Snapshots = append(response.Snapshots, &proto.Snapshot{
+			Height:      snapshot.Height,
+			BlockHash:   snapshot.BlockHash,
+			AppHash:     snapshot.AppHash,
+			ChunkHashes: snapshot.Chunks,
+		})
+	}
+	return response, nil
+}
+
+// GetSnapshotChunk returns a chunk of a snapshot.
+func (s *chainService) GetSnapshotChunk(_ context.Context, request *proto.ChunkRequest) (*proto.ChunkResponse, error) {
+	data, err := s.snapshots.chunk(request.Height, request.Index)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no chunk %d of snapshot %d", request.Index, request.Height)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &proto.ChunkResponse{Data: data}, nil
+}
+
+// snapshotOffer is a snapshot offered by the peers that have it.
+type snapshotOffer struct {
+	snapshot Snapshot
+	peers    []peerStatus
+}
+
+// stateSync restores the state from the most recent snapshot of the peers
+// ahead that can be verified, and reports whether it did. The block of the
+// snapshot must be among the headers the peers agree with, and the next block
+// must record the state hash of the snapshot. The chain then starts at the
+// block of the snapshot. It does nothing if the application is not a
+// Snapshotter or no peer offers a snapshot.
+func (n *Node) stateSync(ctx context.Context, ahead []peerStatus) (bool, error) {
+	snapshotter, ok := n.app.(Snapshotter)
+	if !ok {
+		return false, nil
+	}
+
+	var err error
+	for _, offer := range n.snapshotOffers(ctx, ahead) {
+		if err = n.restoreSnapshot(ctx, snapshotter, offer, ahead); err == nil {
+			return true, nil
+		}
+		n.logger.Warn("failed to restore snapshot", logger.F("height", offer.snapshot.Height), logger.Err(err))
+	}
+	return false, err
+}
+
+// snapshotOffers lists the snapshots of the peers in parallel, and returns
+// those below the head of a peer that has them, by decreasing height. Peers
+// that do not answer and snapshots of more than maxSnapshotChunks chunks are
+// left out.
+func (n *Node) snapshotOffers(ctx context.Context, peers []peerStatus) []snapshotOffer {
+	var (
+		offers = make(map[string]*snapshotOffer)
+		mux    sync.Mutex
+		waiter sync.WaitGroup
+	)
+	for _, peer := range peers {
+		waiter.Add(1)
+		go func(peer peerStatus) {
+			defer waiter.Done()
+
+			client, err := n.chainClient(peer.peer)
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+			defer cancel()
+
+			response, err := client.ListSnapshots(ctx, &proto.Empty{})
+			if err != nil {
+				return
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+			for _, pb := range response.Snapshots {
+				if pb.Height == 0 || pb.Height >= peer.height || len(pb.ChunkHashes) > maxSnapshotChunks {
+					continue
+				}
+				snapshot := Snapshot{Height: pb.Height, BlockHash: pb.BlockHash, AppHash: pb.AppHash, Chunks: pb.ChunkHashes}
+				key, _ := json.Marshal(snapshot)
+				if offers[string(key)] == nil {
+					offers[string(key)] = &snapshotOffer{snapshot: snapshot}
+				}
+				offers[string(key)].peers = append(offers[string(key)].peers, peer)
+			}
+		}(peer)
+	}
+	waiter.Wait()
+
+	sorted := make([]snapshotOffer, 0, len(offers))
+	for _, offer := range offers {
+		sorted = append(sorted, *offer)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].snapshot.Height != sorted[j].snapshot.Height {
+			return sorted[i].snapshot.Height > sorted[j].snapshot.Height
+		}
+		return len(sorted[i].peers) > len(sorted[j].peers)
+	})
+	return sorted
+}
+
+// restoreSnapshot verifies a snapshot against the headers of the peers, and
+// against the finality certificate of the block above it if the node has
+// validators, downloads and restores it, then restarts the chain from its
+// block.
+func (n *Node) restoreSnapshot(ctx context.Context, snapshotter Snapshotter, offer snapshotOffer, ahead []peerStatus) error {
+	snapshot, source := offer.snapshot, offer.peers[0]
+
+	client, err := n.chainClient(source.peer)
+	if err != nil {
+		return err
+	}
+	rctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+	response, err := client.GetHeaders(rctx, &proto.RangeRequest{From: snapshot.Height, Limit: 1})
+	cancel()
+	if err != nil {
+		return err
+	}
+	if len(response.Headers) != 1 || response.Headers[0].Height != snapshot.Height || response.Headers[0].Hash != snapshot.BlockHash {
+		return fmt.Errorf("%w: block %d is not the one of the snapshot", ErrSnapshot, snapshot.Height)
+	}
+	base := response.Headers[0]
+
+	// the headers above the block of the snapshot link it to the head of the
+	// source, which the other peers must agree with
+	headers, err := n.fetchHeaders(ctx, source, chain.Block{Height: base.Height, Hash: base.Hash})
+	if err != nil {
+		return err
+	}
+	above := make([]peerStatus, 0, len(ahead))
+	for _, st := range ahead {
+		if st.height > snapshot.Height {
+			above = append(above, st)
+		}
+	}
+	if !agreed(headers, snapshot.Height, above) {
+		return fmt.Errorf("%w: the other peers disagree", errForked)
+	}
+	if err := n.verifyCertificate(ctx, client, headers[0]); err != nil {
+		return err
+	}
+
+	blocks, err := n.requestBlocks(ctx, source.peer, []*proto.Header{base, headers[0]})
+	if err != nil {
+		return err
+	}
+	if blocks[1].AppHash != snapshot.AppHash {
+		return fmt.Errorf("%w at height %d: %v, the snapshot is %v", ErrAppHash, blocks[1].Height, blocks[1].AppHash, snapshot.AppHash)
+	}
+
+	data, err := n.fetchChunks(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	n.appMux.Lock()
+	defer n.appMux.Unlock()
+
+	if n.appErr != nil {
+		return n.appErr
+	}
+	if n.Chain.Height() != 0 {
+		return fmt.Errorf("%w: the chain is not empty", ErrSnapshot)
+	}
+	// the data of a single peer is not trusted, the application rejects it
+	// before changing the state unless it has the state hash of the block
+	if err := snapshotter.Restore(snapshot.Height, data, snapshot.AppHash); err != nil {
+		if errors.Is(err, ErrSnapshot) {
+			return err
+		}
+		n.appErr = fmt.Errorf("%w at height %d: %v", ErrAppHalted, snapshot.Height, err)
+		return n.appErr
+	}
+	if err := n.Chain.Restore(blocks[0]); err != nil {
+		return err
+	}
+	n.appHeight, n.appHash = snapshot.Height, snapshot.AppHash
+
+	n.logger.Info("restored snapshot", logger.F("height", snapshot.Height), logger.F("peer", source.peer), logger.F("size", len(data)))
+	return nil
+}
+
+// verifyCertificate checks the finality certificate of a header, served by a
+// peer, against the validators set with SetFinality. Without validators the
+// header is not checked.
+func (n *Node) verifyCertificate(ctx context.Context, client proto.ChainServiceClient, header *proto.Header) error {
+	n.mux.Lock()
+	validators, threshold := n.validators, n.threshold
+	n.mux.Unlock()
+	if validators == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+	defer cancel()
+	pb, err := client.GetCertificate(ctx, &proto.BlockRequest{Height: header.Height})
+	if err != nil {
+		return fmt.Errorf("%w: no certificate of block %d: %v", ErrSnapshot, header.Height, err)
+	}
+	c := CertificateFromProto(pb)
+	if c.Height != header.Height || c.BlockHash != header.Hash {
+		return fmt.Errorf("%w: the certificate of block %d is for another block", ErrSnapshot, header.Height)
+	}
+	if err := c.Verify(validators, threshold); err != nil {
+		return fmt.Errorf("%w: block %d: %v", ErrSnapshot, header.Height, err)
+	}
+	return nil
+}
+
+// fetchChunks downloads the chunks of a snapshot, trying the peers that offer
+// it in turn, and checks them against their hashes.
+func (n *Node) fetchChunks(ctx context.Context, offer snapshotOffer) ([]byte, error) {
+	var data []byte
+	for i, hash := range offer.snapshot.Chunks {
+		err := fmt.Errorf("no peer has chunk %d of snapshot %d", i, offer.snapshot.Height)
+		for j := 0; j < len(offer.peers); j++ {
+			peer := offer.peers[(i+j)%len(offer.peers)]
+
+			var chunk []byte
+			if chunk, err = n.requestChunk(ctx, peer.peer, offer.snapshot.Height, uint32(i)); err == nil {
+				if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != hash {
+					err = fmt.Errorf("%w: chunk %d does not match its hash", ErrSnapshot, i)
+				}
+			}
+			if err == nil {
+				data = append(data, chunk...)
+				break
+			}
+			n.logger.Warn("failed to download chunk", logger.F("peer", peer.peer), logger.F("index", i), logger.Err(err))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// requestChunk downloads a chunk of a snapshot from a peer.
+func (n *Node) requestChunk(ctx context.Context, peer string, height uint64, index uint32) ([]byte, error) {
+	client, err := n.chainClient(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+	defer cancel()
+	response, err := client.GetSnapshotChunk(ctx, &proto.ChunkRequest{Height: height, Index: index})
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
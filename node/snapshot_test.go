@@ -0,0 +1,211 @@
+package node
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/chain"
+	"simple-p2p/finality"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+func (c *counter) Snapshot() ([]byte, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return json.Marshal(c.counts)
+}
+
+func (c *counter) Restore(height uint64, data []byte, appHash string) error {
+	counts := make(map[string]int)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshot, err)
+	}
+	sum := sha256.Sum256(data)
+	if hash := hex.EncodeToString(sum[:]); hash != appHash {
+		return fmt.Errorf("%w: state hash %v instead of %v", ErrSnapshot, hash, appHash)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.counts = counts
+	c.height, c.hash = height, appHash
+	return nil
+}
+
+func TestStateSync(t *testing.T) {
+	defer func(size int) { snapshotChunkSize = size }(snapshotChunkSize)
+	snapshotChunkSize = 16
+
+	network := transport.NewMemory()
+	start := func(addr string, opts ...Option) *Node {
+		n := NewNode(addr, append([]Option{WithTransport(network), WithApplication(newCounter())}, opts...)...)
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		return n
+	}
+
+	// the peers take a snapshot every 10 blocks and keep the last two
+	node1 := start("node-1", WithSnapshots(10, 2))
+	node2 := start("node-2", WithSnapshots(10, 2))
+	for i := 1; i <= 35; i++ {
+		for _, n := range []*Node{node1, node2} {
+			_, err := n.Finalize(i, txs(fmt.Sprint(i%7), "x"))
+			assert.NoError(t, err)
+		}
+	}
+
+	snapshots, err := node1.Snapshots()
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, uint64(20), snapshots[0].Height)
+	assert.Equal(t, uint64(30), snapshots[1].Height)
+	assert.Greater(t, len(snapshots[1].Chunks), 1)
+	b30, _ := node1.Chain.Get(30)
+	b31, _ := node1.Chain.Get(31)
+	assert.Equal(t, b30.Hash, snapshots[1].BlockHash)
+	assert.Equal(t, b31.AppHash, snapshots[1].AppHash)
+
+	// a joining node restores the last snapshot, then downloads the tail
+	store := storage.NewMemory()
+	joining := start("node-3", WithStorage(store))
+	joining.PeerManager.AddPeers(node1.Address, node2.Address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, joining.SyncChain(ctx))
+	assert.Equal(t, uint64(30), joining.Chain.Base())
+	assert.Equal(t, uint64(35), joining.Chain.Height())
+	height, hash := joining.AppInfo()
+	expectedHeight, expectedHash := node1.AppInfo()
+	assert.Equal(t, expectedHeight, height)
+	assert.Equal(t, expectedHash, hash)
+	assert.Equal(t, node1.app.(*counter).counts, joining.app.(*counter).counts)
+
+	// the blocks below the snapshot are not kept
+	_, ok := joining.Chain.Get(29)
+	assert.False(t, ok)
+	assert.Empty(t, joining.Chain.Range(1, 10))
+	reopened, err := chain.Open(storage.NewPrefix(store, "chain/"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(30), reopened.Base())
+	assert.Equal(t, uint64(35), reopened.Height())
+
+	// an application restarted below the restored chain cannot replay it
+	restarted := NewNode("node-3", WithTransport(network), WithStorage(store), WithApplication(newCounter()))
+	assert.ErrorIs(t, restarted.StartServer(), ErrAppHash)
+}
+
+func TestStateSyncForgedSnapshot(t *testing.T) {
+	network := transport.NewMemory()
+	start := func(addr string, opts ...Option) *Node {
+		n := NewNode(addr, append([]Option{WithTransport(network), WithApplication(newCounter())}, opts...)...)
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		return n
+	}
+
+	// the honest peer only has the snapshot at 20, the malicious one also
+	// offers one at 30 with the honest state hash but data of its own
+	honest := start("node-1", WithSnapshots(20, 1))
+	malicious := start("node-2", WithSnapshots(10, 2))
+	for i := 1; i <= 35; i++ {
+		for _, n := range []*Node{honest, malicious} {
+			_, err := n.Finalize(i, txs(fmt.Sprint(i%7), "x"))
+			assert.NoError(t, err)
+		}
+	}
+
+	snapshots, err := malicious.Snapshots()
+	assert.NoError(t, err)
+	forged := []byte(`{"x":1000}`)
+	sum := sha256.Sum256(forged)
+	snapshot := snapshots[len(snapshots)-1]
+	snapshot.Chunks = []string{hex.EncodeToString(sum[:])}
+	meta, err := json.Marshal(snapshot)
+	assert.NoError(t, err)
+	batch := storage.NewBatch()
+	batch.Set(snapshotKey(snapshot.Height), meta)
+	batch.Set(chunkKey(snapshot.Height, 0), forged)
+	assert.NoError(t, malicious.snapshots.store.Write(batch))
+
+	// the joining node rejects the forged snapshot and restores the next one
+	joining := start("node-3")
+	joining.PeerManager.AddPeers(honest.Address, malicious.Address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, joining.SyncChain(ctx))
+	assert.Equal(t, uint64(20), joining.Chain.Base())
+	assert.Equal(t, uint64(35), joining.Chain.Height())
+	height, hash := joining.AppInfo()
+	expectedHeight, expectedHash := honest.AppInfo()
+	assert.Equal(t, expectedHeight, height)
+	assert.Equal(t, expectedHash, hash)
+	assert.Equal(t, honest.app.(*counter).counts, joining.app.(*counter).counts)
+}
+
+// signers are validators of weight 1 by ID.
+type signers map[string]ed25519.PublicKey
+
+func (s signers) Signer(id string) (ed25519.PublicKey, uint64, bool) {
+	key, ok := s[id]
+	return key, 1, ok
+}
+
+func (s signers) TotalWeight() uint64 {
+	return uint64(len(s))
+}
+
+func (s signers) Epoch() uint64 {
+	return 0
+}
+
+func TestStateSyncCertificate(t *testing.T) {
+	network := transport.NewMemory()
+	start := func(addr string, opts ...Option) *Node {
+		n := NewNode(addr, append([]Option{WithTransport(network), WithApplication(newCounter())}, opts...)...)
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		return n
+	}
+
+	node1 := start("node-1", WithSnapshots(10, 2))
+	node2 := start("node-2", WithSnapshots(10, 2))
+	for i := 1; i <= 25; i++ {
+		for _, n := range []*Node{node1, node2} {
+			_, err := n.Finalize(i, txs(fmt.Sprint(i%7), "x"))
+			assert.NoError(t, err)
+		}
+	}
+
+	// the block above the snapshot at 10 is certified by the validator, the
+	// one above the snapshot at 20 by another key
+	pub, key, _ := ed25519.GenerateKey(nil)
+	_, forger, _ := ed25519.GenerateKey(nil)
+	for height, signer := range map[uint64]ed25519.PrivateKey{11: key, 21: forger} {
+		b, _ := node1.Chain.Get(height)
+		c := finality.Certificate{Height: height, BlockHash: b.Hash, Votes: []finality.Vote{finality.Sign(signer, "v", 0, height, b.Hash)}}
+		for _, n := range []*Node{node1, node2} {
+			assert.NoError(t, n.AddCertificate(c))
+		}
+	}
+
+	// the joining node only restores the certified snapshot
+	joining := start("node-3")
+	joining.SetFinality(signers{"v": pub}, finality.DefaultThreshold)
+	joining.PeerManager.AddPeers(node1.Address, node2.Address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, joining.SyncChain(ctx))
+	assert.Equal(t, uint64(10), joining.Chain.Base())
+	assert.Equal(t, uint64(25), joining.Chain.Height())
+	assert.Equal(t, node1.app.(*counter).counts, joining.app.(*counter).counts)
+}
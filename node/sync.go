@@ -0,0 +1,497 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-p2p/chain"
+	"simple-p2p/logger"
+	"simple-p2p/proto/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	syncBatchSize      = 128             // number of headers or blocks of a request
+	syncWorkers        = 4               // number of block requests in parallel
+	syncRequestTimeout = 5 * time.Second // timeout of a sync request to a peer
+	syncRetryInterval  = time.Second     // sleep time before retrying a failed sync
+	maxRangeLimit      = 1024            // largest number of headers or blocks served at once
+	maxSyncLead        = 4               // largest number of batches of a peer above the median height of the peers
+)
+
+var (
+	ErrNoPeers   = errors.New("no peer answered")
+	ErrNoHeaders = errors.New("no peer served headers")
+	errForked    = errors.New("peer is on another chain")
+)
+
+var _ proto.ChainServiceServer = (*chainService)(nil)
+
+// chainService serves the chain and the snapshots of a node to the nodes
+// catching up, and proofs to the light clients.
+type chainService struct {
+	node      *Node
+	chain     *chain.Chain
+	snapshots *snapshotStore
+}
+
+// GetChainStatus returns the height and the hash of the last block.
+func (s *chainService) GetChainStatus(context.Context, *proto.Empty) (*proto.ChainStatus, error) {
+	head, _ := s.chain.Head()
+	return &proto.ChainStatus{Height: head.Height, HeadHash: head.Hash}, nil
+}
+
+// GetHeaders returns the headers of a range of blocks.
+func (s *chainService) GetHeaders(_ context.Context, request *proto.RangeRequest) (*proto.HeadersResponse, error) {
+	blocks, err := s.blocks(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &proto.HeadersResponse{Headers: make([]*proto.Header, 0, len(blocks))}
+	for _, b := range blocks {
+		response.Headers = append(response.Headers, HeaderToProto(b.Header()))
+	}
+	return response, nil
+}
+
+// GetBlocks returns a range of blocks.
+func (s *chainService) GetBlocks(_ context.Context, request *proto.RangeRequest) (*proto.BlocksResponse, error) {
+	blocks, err := s.blocks(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &proto.BlocksResponse{Blocks: make([]*proto.Block, 0, len(blocks))}
+	for _, b := range blocks {
+		response.Blocks = append(response.Blocks, BlockToProto(b))
+	}
+	return response, nil
+}
+
+// blocks returns the blocks of a range request.
+func (s *chainService) blocks(request *proto.RangeRequest) ([]chain.Block, error) {
+	if request.From == 0 || request.Limit <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "from and limit must be positive")
+	}
+
+	limit := int(request.Limit)
+	if limit > maxRangeLimit {
+		limit = maxRangeLimit
+	}
+	return s.chain.Range(request.From, limit), nil
+}
+
+// BlockToProto converts a block to its proto message.
+func BlockToProto(b chain.Block) *proto.Block {
+	pb := &proto.Block{
+		Height:   b.Height,
+		Value:    int64(b.Value),
+		PrevHash: b.PrevHash,
+		Hash:     b.Hash,
+		AppHash:  b.AppHash,
+		TxRoot:   b.TxRoot,
+		Op:       b.Op,
+	}
+	for _, tx := range b.Txs {
+		pb.Txs = append(pb.Txs, &proto.Tx{Data: tx.Data, Fee: tx.Fee})
+	}
+	return pb
+}
+
+// BlockFromProto converts a proto message to a block.
+func BlockFromProto(pb *proto.Block) chain.Block {
+	b := chain.Block{
+		Height:   pb.Height,
+		Value:    int(pb.Value),
+		PrevHash: pb.PrevHash,
+		Hash:     pb.Hash,
+		AppHash:  pb.AppHash,
+		TxRoot:   pb.TxRoot,
+		Op:       pb.Op,
+	}
+	for _, tx := range pb.Txs {
+		b.Txs = append(b.Txs, chain.Tx{Data: tx.Data, Fee: tx.Fee})
+	}
+	return b
+}
+
+// HeaderToProto converts a header to its proto message.
+func HeaderToProto(h chain.Header) *proto.Header {
+	return &proto.Header{
+		Height:   h.Height,
+		PrevHash: h.PrevHash,
+		Hash:     h.Hash,
+		Value:    int64(h.Value),
+		AppHash:  h.AppHash,
+		TxRoot:   h.TxRoot,
+		Op:       h.Op,
+	}
+}
+
+// HeaderFromProto converts a proto message to a header.
+func HeaderFromProto(pb *proto.Header) chain.Header {
+	return chain.Header{
+		Height:   pb.Height,
+		Value:    int(pb.Value),
+		PrevHash: pb.PrevHash,
+		Hash:     pb.Hash,
+		AppHash:  pb.AppHash,
+		TxRoot:   pb.TxRoot,
+		Op:       pb.Op,
+	}
+}
+
+// SetVoter sets whether the node takes part in consensus. A node that is not
+// a voter does not answer the queries of its peers nor starts a consensus.
+func (n *Node) SetVoter(voter bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.syncing = !voter
+}
+
+// Voter reports whether the node takes part in consensus.
+func (n *Node) Voter() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return !n.syncing
+}
+
+// SyncChain catches the chain up with the finalized chains of the peers. The
+// node is not a voter meanwhile. Sync rounds are retried until a round finds
+// no peer ahead of the node, except peers on another chain, then the node
+// becomes a voter. A node without peers is caught up at once. It returns
+// ctx.Err() if ctx is done first.
+func (n *Node) SyncChain(ctx context.Context) error {
+	n.SetVoter(false)
+
+	for {
+		appended, err := n.syncRound(ctx)
+		if err == nil && appended == 0 {
+			n.logger.Info("chain synced", logger.F("height", n.Chain.Height()))
+			n.SetVoter(true)
+			return nil
+		}
+		if err != nil {
+			n.logger.Warn("failed to sync chain", logger.F("height", n.Chain.Height()), logger.Err(err))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-n.clock.After(syncRetryInterval):
+			}
+		}
+	}
+}
+
+// peerStatus is the chain status of a peer.
+type peerStatus struct {
+	peer   string
+	height uint64
+	head   string
+}
+
+// syncRound downloads the blocks between the local head and the highest head
+// of the peers, and returns the number of blocks appended. A node with an
+// empty chain first restores the state from a snapshot of the peers, if any,
+// and downloads the blocks above it in the next round.
+func (n *Node) syncRound(ctx context.Context) (int, error) {
+	peers := n.PeerManager.GetPeers()
+	if len(peers) == 0 {
+		return 0, nil
+	}
+
+	statuses := n.chainStatuses(ctx, peers)
+	if len(statuses) == 0 {
+		return 0, ErrNoPeers
+	}
+	statuses = n.plausible(statuses)
+
+	head, _ := n.Chain.Head()
+	ahead := make([]peerStatus, 0, len(statuses))
+	for _, st := range statuses {
+		if st.height > head.Height {
+			ahead = append(ahead, st)
+		}
+	}
+	if len(ahead) == 0 {
+		return 0, nil
+	}
+	sort.Slice(ahead, func(i, j int) bool {
+		return ahead[i].height > ahead[j].height
+	})
+
+	if head.Height == 0 {
+		restored, err := n.stateSync(ctx, ahead)
+		if restored {
+			return 1, nil
+		}
+		if err != nil {
+			n.logger.Warn("failed to sync state, downloading every block", logger.Err(err))
+		}
+	}
+
+	// take the headers of the highest peer that the other peers agree with,
+	// the node is caught up if every peer ahead is on another chain
+	var (
+		headers []*proto.Header
+		failed  bool
+	)
+	for _, source := range ahead {
+		h, err := n.fetchHeaders(ctx, source, head)
+		if err == nil && !agreed(h, head.Height, ahead) {
+			err = fmt.Errorf("%w: the other peers disagree", errForked)
+		}
+		if err == nil {
+			headers = h
+			break
+		}
+
+		n.logger.Warn("failed to get headers", logger.F("peer", source.peer), logger.Err(err))
+		if !errors.Is(err, errForked) {
+			failed = true
+		}
+	}
+	if headers == nil {
+		if failed {
+			return 0, ErrNoHeaders
+		}
+		return 0, nil
+	}
+
+	blocks, err := n.fetchBlocks(ctx, headers, ahead)
+	for _, b := range blocks {
+		if err := n.appendBlock(b); err != nil {
+			return 0, err
+		}
+	}
+	n.logger.Info("downloaded blocks", logger.F("from", head.Height+1), logger.F("blocks", len(blocks)))
+	return len(blocks), err
+}
+
+// chainStatuses asks the peers for their chain status in parallel, the peers
+// that do not answer are left out.
+func (n *Node) chainStatuses(ctx context.Context, peers []string) []peerStatus {
+	var (
+		statuses []peerStatus
+		mux      sync.Mutex
+		waiter   sync.WaitGroup
+	)
+	for _, peer := range peers {
+		waiter.Add(1)
+		go func(peer string) {
+			defer waiter.Done()
+
+			client, err := n.chainClient(peer)
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+			defer cancel()
+
+			st, err := client.GetChainStatus(ctx, &proto.Empty{})
+			if err != nil {
+				return
+			}
+
+			mux.Lock()
+			statuses = append(statuses, peerStatus{peer: peer, height: st.Height, head: st.HeadHash})
+			mux.Unlock()
+		}(peer)
+	}
+	waiter.Wait()
+	return statuses
+}
+
+// plausible leaves out the statuses of the peers whose height is more than
+// maxSyncLead batches above the median height of the peers. A lone peer cannot
+// make the node download a chain longer than the one of the others by more
+// than a few requests.
+func (n *Node) plausible(statuses []peerStatus) []peerStatus {
+	heights := make([]uint64, len(statuses))
+	for i, st := range statuses {
+		heights[i] = st.height
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] > heights[j]
+	})
+	median := heights[len(heights)/2]
+
+	lead := uint64(maxSyncLead * syncBatchSize)
+	kept := statuses[:0]
+	for _, st := range statuses {
+		if st.height > median && st.height-median > lead {
+			n.logger.Warn("peer is too far ahead", logger.F("peer", st.peer), logger.F("height", st.height), logger.F("median", median))
+			continue
+		}
+		kept = append(kept, st)
+	}
+	return kept
+}
+
+// fetchHeaders downloads the headers from the local head to the head of the
+// source, and checks that they link to each other and to both heads.
+func (n *Node) fetchHeaders(ctx context.Context, source peerStatus, head chain.Block) ([]*proto.Header, error) {
+	client, err := n.chainClient(source.peer)
+	if err != nil {
+		return nil, err
+	}
+
+	// the height of the source is not trusted, it does not size the headers
+	var headers []*proto.Header
+	prevHash := head.Hash
+	for from := head.Height + 1; from <= source.height; {
+		ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+		response, err := client.GetHeaders(ctx, &proto.RangeRequest{From: from, Limit: int64(syncBatchSize)})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Headers) == 0 {
+			return nil, fmt.Errorf("no header at height %d", from)
+		}
+
+		for _, h := range response.Headers {
+			if h.Height != from || h.PrevHash != prevHash {
+				return nil, fmt.Errorf("%w: header at height %d does not link", errForked, from)
+			}
+			if HeaderFromProto(h).ComputeHash() != h.Hash {
+				return nil, fmt.Errorf("%w: header at height %d does not match its hash", chain.ErrInvalidBlock, from)
+			}
+			headers = append(headers, h)
+			prevHash = h.Hash
+			if from++; from > source.height {
+				break
+			}
+		}
+	}
+
+	if prevHash != source.head {
+		return nil, fmt.Errorf("%w: headers do not end at the head %v", errForked, source.head)
+	}
+	return headers, nil
+}
+
+// agreed reports whether the peers whose head is among the headers outnumber
+// those whose head is not. Finalized chains of honest peers never fork.
+func agreed(headers []*proto.Header, base uint64, ahead []peerStatus) bool {
+	agree, disagree := 0, 0
+	for _, st := range ahead {
+		if st.height > base+uint64(len(headers)) {
+			continue
+		}
+		if headers[st.height-base-1].Hash == st.head {
+			agree++
+		} else {
+			disagree++
+		}
+	}
+	return agree > disagree
+}
+
+// fetchBlocks downloads the blocks of the headers in batches, from several
+// peers in parallel, and checks them against the headers. It returns the
+// blocks downloaded before the first batch that failed on every peer.
+func (n *Node) fetchBlocks(ctx context.Context, headers []*proto.Header, peers []peerStatus) ([]chain.Block, error) {
+	batches := (len(headers) + syncBatchSize - 1) / syncBatchSize
+	results := make([][]chain.Block, batches)
+	errs := make([]error, batches)
+
+	jobs := make(chan int)
+	var waiter sync.WaitGroup
+	for w := 0; w < syncWorkers; w++ {
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			for i := range jobs {
+				from := i * syncBatchSize
+				to := from + syncBatchSize
+				if to > len(headers) {
+					to = len(headers)
+				}
+				results[i], errs[i] = n.fetchBatch(ctx, headers[from:to], peers, i)
+			}
+		}()
+	}
+	for i := 0; i < batches; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	waiter.Wait()
+
+	var blocks []chain.Block
+	for i := range results {
+		if errs[i] != nil {
+			return blocks, errs[i]
+		}
+		blocks = append(blocks, results[i]...)
+	}
+	return blocks, nil
+}
+
+// fetchBatch downloads the blocks of a batch of headers from the first peer
+// that serves them, trying the peers high enough in turn from the one of the
+// batch index.
+func (n *Node) fetchBatch(ctx context.Context, headers []*proto.Header, peers []peerStatus, index int) ([]chain.Block, error) {
+	last := headers[len(headers)-1].Height
+
+	err := fmt.Errorf("no peer has the blocks up to height %d", last)
+	for i := 0; i < len(peers); i++ {
+		peer := peers[(index+i)%len(peers)]
+		if peer.height < last {
+			continue
+		}
+
+		var blocks []chain.Block
+		blocks, err = n.requestBlocks(ctx, peer.peer, headers)
+		if err == nil {
+			return blocks, nil
+		}
+		n.logger.Warn("failed to download blocks", logger.F("peer", peer.peer), logger.F("from", headers[0].Height), logger.Err(err))
+	}
+	return nil, err
+}
+
+// requestBlocks downloads the blocks of headers from a peer.
+func (n *Node) requestBlocks(ctx context.Context, peer string, headers []*proto.Header) ([]chain.Block, error) {
+	client, err := n.chainClient(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, syncRequestTimeout)
+	defer cancel()
+	response, err := client.GetBlocks(ctx, &proto.RangeRequest{From: headers[0].Height, Limit: int64(len(headers))})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Blocks) != len(headers) {
+		return nil, fmt.Errorf("got %d blocks instead of %d", len(response.Blocks), len(headers))
+	}
+
+	blocks := make([]chain.Block, 0, len(headers))
+	for i, pb := range response.Blocks {
+		b := BlockFromProto(pb)
+		h := headers[i]
+		if b.Height != h.Height || b.PrevHash != h.PrevHash || b.Hash != h.Hash || !b.Valid() {
+			return nil, fmt.Errorf("%w at height %d: does not match its header", chain.ErrInvalidBlock, h.Height)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// chainClient returns a chain service client of a peer.
+func (n *Node) chainClient(peer string) (proto.ChainServiceClient, error) {
+	conn, err := n.PeerManager.GetConnection(peer)
+	if err != nil {
+		return nil, err
+	}
+	return proto.NewChainServiceClient(conn), nil
+}
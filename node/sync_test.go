@@ -0,0 +1,119 @@
+package node
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"simple-p2p/chain"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"testing"
+	"time"
+)
+
+func TestSyncChain(t *testing.T) {
+	defer func(size int) { syncBatchSize = size }(syncBatchSize)
+	syncBatchSize = 16
+
+	network := transport.NewMemory()
+	start := func(addr string, values ...int) *Node {
+		n := NewNode(addr, WithTransport(network))
+		for _, v := range values {
+			_, err := n.Chain.Append(v)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, n.StartServer())
+		t.Cleanup(n.StopServer)
+		return n
+	}
+
+	values := make([]int, 100)
+	forked := make([]int, 150)
+	for i := range values {
+		values[i] = i
+	}
+	for i := range forked {
+		forked[i] = -i - 1
+	}
+
+	node1 := start("node-1", values...)
+	node2 := start("node-2", values...)
+	node3 := start("node-3", forked...)
+
+	// a node without peers is caught up at once
+	lagging := start("node-4", values[:10]...)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, lagging.SyncChain(ctx))
+	assert.True(t, lagging.Voter())
+	assert.Equal(t, uint64(10), lagging.Chain.Height())
+
+	// the highest peer is on another chain, the node follows the majority
+	lagging.PeerManager.AddPeers(node1.Address, node2.Address, node3.Address)
+	assert.NoError(t, lagging.SyncChain(ctx))
+	assert.True(t, lagging.Voter())
+
+	head, _ := lagging.Chain.Head()
+	expected, _ := node1.Chain.Head()
+	assert.Equal(t, expected, head)
+	assert.Equal(t, node1.Chain.Range(1, 100), lagging.Chain.Range(1, 100))
+
+	// blocks that do not extend the chain are rejected
+	b, _ := node3.Chain.Get(101)
+	assert.ErrorIs(t, lagging.Chain.AppendBlock(b), chain.ErrInvalidBlock)
+}
+
+// lyingChain serves a chain but reports a height of its own choosing.
+type lyingChain struct {
+	*chainService
+	height uint64
+}
+
+func (s *lyingChain) GetChainStatus(context.Context, *proto.Empty) (*proto.ChainStatus, error) {
+	return &proto.ChainStatus{Height: s.height, HeadHash: "forged"}, nil
+}
+
+func TestSyncLyingPeer(t *testing.T) {
+	defer func(interval time.Duration) { syncRetryInterval = interval }(syncRetryInterval)
+	syncRetryInterval = 10 * time.Millisecond
+
+	network := transport.NewMemory()
+	honest := NewNode("node-1", WithTransport(network))
+	for i := 0; i < 20; i++ {
+		_, err := honest.Chain.Append(i)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, honest.StartServer())
+	t.Cleanup(honest.StopServer)
+
+	lis, err := network.Listen("liar")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	proto.RegisterChainServiceServer(server, &lyingChain{chainService: &chainService{node: honest, chain: honest.Chain}, height: 1 << 62})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	// alone, the liar cannot make the node allocate its height, the node
+	// retries until it gives up
+	alone := NewNode("node-2", WithTransport(network))
+	alone.PeerManager.AddPeers("liar")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, alone.SyncChain(ctx), context.DeadlineExceeded)
+	assert.False(t, alone.Voter())
+	assert.Equal(t, uint64(0), alone.Chain.Height())
+
+	// with honest peers, the liar is too far ahead and left out
+	joining := NewNode("node-3", WithTransport(network))
+	joining.PeerManager.AddPeers("liar", honest.Address)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, joining.SyncChain(ctx))
+	assert.True(t, joining.Voter())
+	assert.Equal(t, uint64(20), joining.Chain.Height())
+
+	// a peer is only trusted a few batches above the median
+	lead := uint64(maxSyncLead * syncBatchSize)
+	statuses := []peerStatus{{peer: "a", height: 20}, {peer: "b", height: 20}, {peer: "c", height: 20 + lead}, {peer: "d", height: 21 + lead}}
+	assert.Equal(t, statuses[:3], joining.plausible(append([]peerStatus(nil), statuses...)))
+}
@@ -3,62 +3,219 @@ package message
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"simple-p2p/logger"
+	"simple-p2p/metrics"
 	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"sync"
 	"time"
 )
 
 type MessageManager interface {
 
-	// SendMessage sends a message to a peer.
-	SendMessage(conn *grpc.ClientConn, message *proto.MessageRequest) error
+	// SendMessage sends a message to a peer. The trace of ctx follows the
+	// message to the peer.
+	SendMessage(ctx context.Context, conn *grpc.ClientConn, message *proto.MessageRequest) error
 
 	// ReceiveMessage receives a message from a peer.
 	ReceiveMessage(context.Context, *proto.MessageRequest) (*proto.MessageResponse, error)
+
+	// RegisterHandler registers the handler of a message type. A later
+	// registration of the same type replaces the previous one.
+	RegisterHandler(proto.MessageType, Handler)
+
+	// GetMessageLogs returns the last limit logs of sent and received
+	// messages, oldest first. A limit of 0 returns all logs.
+	GetMessageLogs(limit int) []MessageLog
 }
 
+// Handler processes a received message of a single message type.
+type Handler func(context.Context, *proto.MessageRequest) (*proto.MessageResponse, error)
+
 var _ MessageManager = (*messageManager)(nil)
 
-// messageLog is a log item for a message. Only one of sender and receiver
+var (
+	messagesSent     = metrics.Default.Counter("p2p_messages_sent_total", "Number of sent messages by type.", "type")
+	messagesReceived = metrics.Default.Counter("p2p_messages_received_total", "Number of received messages by type.", "type")
+)
+
+// journalSize is the number of message logs kept in the journal.
+const journalSize = 10000
+
+// MessageLog is a log item for a message. Only one of sender and receiver
 // need to be assigned.
-type messageLog struct {
-	hash        string
-	messageType int
-	sender      string
-	receiver    string
-	time        time.Time
+type MessageLog struct {
+	Hash        string    `json:"hash"`
+	MessageType string    `json:"type"`
+	Sender      string    `json:"sender,omitempty"`
+	Receiver    string    `json:"receiver,omitempty"`
+	Time        time.Time `json:"time"`
 }
 
 // MessageManager is the service to receive and process messages.
 type messageManager struct {
-	MessageLogs []messageLog // logs for sent/received messages
+	MessageLogs []MessageLog  // logs for sent/received messages, a ring of the last journalSize logs
+	first       int           // index of the oldest log in MessageLogs
+	logsMux     sync.RWMutex  // mutual exclusion lock for logs
+	journal     storage.Store // journal of the logs, nil if logs are kept in memory only
+	seq         uint64        // sequence number of the last log in the journal
+
+	handlers map[proto.MessageType]Handler // handlers by message type
+	mux      sync.RWMutex                  // mutual exclusion lock for handlers
+
+	logger logger.Logger // logger of the message manager
+}
+
+// Option configures a message manager.
+type Option func(*messageManager)
+
+// WithLogger sets the logger of the message manager. The default discards logs.
+func WithLogger(l logger.Logger) Option {
+	return func(m *messageManager) {
+		m.logger = l
+	}
+}
+
+// WithStore sets the journal of the message logs. The last logs are loaded
+// from it, and each new log is saved to it.
+func WithStore(s storage.Store) Option {
+	return func(m *messageManager) {
+		m.journal = s
+	}
 }
 
 // NewMessageManager creates a new message manager instance.
-func NewMessageManager() MessageManager {
-	return &messageManager{
-		MessageLogs: make([]messageLog, 0),
+func NewMessageManager(opts ...Option) MessageManager {
+	m := &messageManager{
+		MessageLogs: make([]MessageLog, 0),
+		handlers:    make(map[proto.MessageType]Handler),
+		logger:      logger.Nop(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.journal != nil {
+		m.load()
+	}
+	return m
+}
+
+// load loads the logs of the journal. A log that cannot be decoded is skipped.
+func (m *messageManager) load() {
+	it := m.journal.Iterator(nil)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != 8 {
+			continue
+		}
+		m.seq = binary.BigEndian.Uint64(it.Key())
+
+		var l MessageLog
+		if err := json.Unmarshal(it.Value(), &l); err != nil {
+			m.logger.Warn("failed to load message log", logger.F("seq", m.seq), logger.Err(err))
+			continue
+		}
+		m.push(l)
 	}
 }
 
+// seqKey returns the key of a log in the journal, sorted by sequence number.
+func seqKey(seq uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], seq)
+	return key[:]
+}
+
+// GetMessageLogs returns the last limit message logs, oldest first.
+func (m *messageManager) GetMessageLogs(limit int) []MessageLog {
+	m.logsMux.RLock()
+	defer m.logsMux.RUnlock()
+
+	n := len(m.MessageLogs)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	logs := make([]MessageLog, 0, limit)
+	for i := n - limit; i < n; i++ {
+		logs = append(logs, m.MessageLogs[(m.first+i)%n])
+	}
+	return logs
+}
+
+// push adds a log to the ring of logs, in place of the oldest one once the
+// ring holds journalSize logs. The lock must be held.
+func (m *messageManager) push(l MessageLog) {
+	if len(m.MessageLogs) < journalSize {
+		m.MessageLogs = append(m.MessageLogs, l)
+		return
+	}
+	m.MessageLogs[m.first] = l
+	m.first = (m.first + 1) % len(m.MessageLogs)
+}
+
+// addLog appends a message log.
+func (m *messageManager) addLog(l MessageLog) {
+	m.logsMux.Lock()
+	defer m.logsMux.Unlock()
+
+	m.push(l)
+
+	if m.journal != nil {
+		data, err := json.Marshal(l)
+		if err != nil {
+			m.logger.Warn("failed to save message log", logger.Err(err))
+			return
+		}
+
+		m.seq++
+		b := storage.NewBatch()
+		b.Set(seqKey(m.seq), data)
+		if m.seq > journalSize {
+			b.Delete(seqKey(m.seq - journalSize))
+		}
+		if err := m.journal.Write(b); err != nil {
+			m.logger.Warn("failed to save message log", logger.Err(err))
+		}
+	}
+}
+
+// RegisterHandler registers the handler of a message type.
+func (m *messageManager) RegisterHandler(messageType proto.MessageType, handler Handler) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.handlers[messageType] = handler
+}
+
 // SendMessage sends a message to a peer with given grpc connection.
-func (m *messageManager) SendMessage(conn *grpc.ClientConn, message *proto.MessageRequest) error {
+func (m *messageManager) SendMessage(ctx context.Context, conn *grpc.ClientConn, message *proto.MessageRequest) error {
 	// create a client
 	client := proto.NewMessageServiceClient(conn)
 
 	// send message
-	_, err := client.ReceiveMessage(context.Background(), message)
+	_, err := client.ReceiveMessage(ctx, message)
 	if err != nil {
+		m.logger.Warn("failed to send message", logger.F("peer", conn.Target()), logger.F("type", message.Type), logger.Err(err))
 		return err
 	}
 
-	m.MessageLogs = append(m.MessageLogs, messageLog{
-		hash:        hash(message.GetValue()),
-		receiver:    conn.Target(),
-		time:        time.Now(),
-		messageType: int(message.Type),
-	})
+	l := MessageLog{
+		Hash:        hash(message.GetValue()),
+		Receiver:    conn.Target(),
+		Time:        time.Now(),
+		MessageType: message.Type.String(),
+	}
+	messagesSent.With(l.MessageType).Inc()
+	m.logger.Debug("send message", logger.F("peer", l.Receiver), logger.F("type", l.MessageType), logger.F("hash", l.Hash))
+	m.addLog(l)
 
 	return nil
 }
@@ -69,12 +226,30 @@ func hash(data []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// ReceiveMessage receives a message from a peer and passes it to the handler
+// registered for its type. Messages without a handler are ignored.
 func (m *messageManager) ReceiveMessage(ctx context.Context, request *proto.MessageRequest) (*proto.MessageResponse, error) {
+	var sender string
+	if p, ok := peer.FromContext(ctx); ok {
+		sender = p.Addr.String()
+	}
+
+	l := MessageLog{
+		Hash:        hash(request.GetValue()),
+		Sender:      sender,
+		Time:        time.Now(),
+		MessageType: request.Type.String(),
+	}
+	messagesReceived.With(l.MessageType).Inc()
+	m.logger.Debug("receive message", logger.F("peer", l.Sender), logger.F("type", l.MessageType), logger.F("hash", l.Hash))
+	m.addLog(l)
 
-	switch request.Type {
-	case proto.MessageType_QUERY:
+	m.mux.RLock()
+	handler, ok := m.handlers[request.Type]
+	m.mux.RUnlock()
 
-	case proto.MessageType_DECISION:
+	if ok {
+		return handler(ctx, request)
 	}
-	return &proto.MessageResponse{}, nil
+	return &proto.MessageResponse{Type: request.Type}, nil
 }
@@ -0,0 +1,29 @@
+package message
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"simple-p2p/storage"
+	"testing"
+)
+
+func TestMessageLogs(t *testing.T) {
+	journal := storage.NewMemory()
+	m := NewMessageManager(WithStore(journal)).(*messageManager)
+
+	// only the last journalSize logs are kept, in memory and in the journal
+	for i := 0; i < journalSize+5; i++ {
+		m.addLog(MessageLog{Hash: fmt.Sprint(i)})
+	}
+	assert.Len(t, m.MessageLogs, journalSize)
+
+	logs := m.GetMessageLogs(0)
+	assert.Len(t, logs, journalSize)
+	assert.Equal(t, "5", logs[0].Hash)
+	assert.Equal(t, fmt.Sprint(journalSize+4), logs[journalSize-1].Hash)
+	assert.Equal(t, []MessageLog{{Hash: fmt.Sprint(journalSize + 3)}, {Hash: fmt.Sprint(journalSize + 4)}}, m.GetMessageLogs(2))
+
+	// a restart loads the same logs
+	restarted := NewMessageManager(WithStore(journal))
+	assert.Equal(t, logs, restarted.GetMessageLogs(0))
+}
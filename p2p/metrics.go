@@ -0,0 +1,29 @@
+package p2p
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"simple-p2p/metrics"
+	"time"
+)
+
+var (
+	discoveryRounds = metrics.Default.Counter("p2p_discovery_rounds_total", "Number of peer discovery rounds.")
+	rpcDuration     = metrics.Default.Histogram("p2p_rpc_duration_seconds", "Latency of RPCs to peers.", metrics.DefBuckets, "method", "peer")
+	rpcErrors       = metrics.Default.Counter("p2p_rpc_errors_total", "Number of failed RPCs to peers.", "method", "peer", "code")
+)
+
+// unaryClientMetrics records the latency and the errors of the calls to a peer.
+func unaryClientMetrics(peer string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		rpcDuration.With(method, peer).Observe(time.Since(start).Seconds())
+		if err != nil {
+			rpcErrors.With(method, peer, status.Code(err).String()).Inc()
+		}
+		return err
+	}
+}
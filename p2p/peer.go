@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
-	"log"
+	"math"
 	"math/rand"
+	"simple-p2p/clock"
+	"simple-p2p/logger"
 	"simple-p2p/proto/proto"
+	"simple-p2p/storage"
+	"simple-p2p/transport"
+	"sort"
 	"sync"
 	"time"
 )
@@ -22,6 +27,12 @@ type Peer interface {
 	// RemoveAllPeers removes all peers from the peer manager.
 	RemoveAllPeers() error
 
+	// BanPeer removes a peer and never adds it again.
+	BanPeer(addr string) error
+
+	// GetBannedPeers returns the addresses of the banned peers.
+	GetBannedPeers() []string
+
 	// Disconnect closes the connection to the peer.
 	Disconnect(addr string) error
 
@@ -40,11 +51,21 @@ type Peer interface {
 	// StartDiscoverPeers starts the peer discovery process.
 	StartDiscoverPeers(bootstraps ...string)
 
+	// StopDiscoverPeers stops the peer discovery process, if it is running.
+	StopDiscoverPeers()
+
+	// Close closes the connections to all peers and keeps their addresses.
+	Close() error
+
 	// PingPong sends a ping message to a peer and waits for a pong message.
 	PingPong(context.Context, *proto.Ping) (*proto.Pong, error)
 
 	// GetSamplePeers returns a list of peers from the peer manager by a given number.
 	GetSamplePeers(num int) []string
+
+	// GetWeightedSamplePeers returns a list of peers sampled with probability
+	// proportional to their weights. Peers without a positive weight are never sampled.
+	GetWeightedSamplePeers(num int, weights map[string]uint64) []string
 }
 
 // peer is the remote node that a local node can connect to.
@@ -54,8 +75,9 @@ type peer struct {
 }
 
 var (
-	maxPeerNum           = 20              // max neighbor peers' number
-	maxDiscoverSleepTime = 5 * time.Second // sleep time between discover neighbor peers
+	maxPeerNum           = 20              // default max neighbor peers' number
+	maxDiscoverSleepTime = 5 * time.Second // default sleep time between discover neighbor peers
+	requestTimeout       = 5 * time.Second // default timeout of a request to a peer during discovery
 )
 
 var _ Peer = (*peerManager)(nil)
@@ -64,23 +86,157 @@ var _ Peer = (*peerManager)(nil)
 type peerManager struct {
 	addr string // network address of local node
 
-	Peers map[string]*peer // known remote peers
-	Mux   sync.RWMutex     // mutual exclusion lock for peers
+	Peers  map[string]*peer // known remote peers
+	Banned map[string]bool  // banned remote peers
+	Mux    sync.RWMutex     // mutual exclusion lock for peers
 
 	stopDiscover    chan struct{}  // stop discover neighbor peers signal
 	discoverStopped chan struct{}  // discover neighbor peers stopped signal
+	discovering     bool           // whether the discovery loop is running
 	waiter          sync.WaitGroup // wait background goroutines
+
+	transport   transport.Transport // transport to dial peers
+	clock       clock.Clock         // clock of the discovery loop
+	dialOptions []grpc.DialOption   // extra options of peer connections
+	logger      logger.Logger       // logger of the peer manager
+	store       storage.Store       // address book, nil if peers are kept in memory only
+	random      *rand.Rand          // random source of the samples, the global source if nil
+	randomMux   sync.Mutex          // mutual exclusion lock for random
+
+	maxPeers         int           // discovery stops adding peers beyond this number
+	discoverInterval time.Duration // sleep time between discovery rounds
+	requestTimeout   time.Duration // timeout of a request to a peer during discovery
+}
+
+// Option configures a peer manager.
+type Option func(*peerManager)
+
+// WithTransport sets the transport used to dial peers. The default is TCP.
+func WithTransport(t transport.Transport) Option {
+	return func(pm *peerManager) {
+		pm.transport = t
+	}
+}
+
+// WithClock sets the clock of the discovery loop. The default is the wall clock.
+func WithClock(c clock.Clock) Option {
+	return func(pm *peerManager) {
+		pm.clock = c
+	}
+}
+
+// WithDialOptions adds options to every connection to a peer, e.g. client
+// interceptors.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(pm *peerManager) {
+		pm.dialOptions = append(pm.dialOptions, opts...)
+	}
+}
+
+// WithLogger sets the logger of the peer manager. The default discards logs.
+func WithLogger(l logger.Logger) Option {
+	return func(pm *peerManager) {
+		pm.logger = l
+	}
+}
+
+// WithStore sets the address book of the peer manager. The known and banned
+// peers are loaded from it, and saved to it as they change.
+func WithStore(s storage.Store) Option {
+	return func(pm *peerManager) {
+		pm.store = s
+	}
+}
+
+// WithMaxPeers sets the number of peers beyond which discovery stops adding
+// peers. The default is 20.
+func WithMaxPeers(n int) Option {
+	return func(pm *peerManager) {
+		pm.maxPeers = n
+	}
+}
+
+// WithDiscoverInterval sets the sleep time between discovery rounds. The
+// default is 5s.
+func WithDiscoverInterval(d time.Duration) Option {
+	return func(pm *peerManager) {
+		pm.discoverInterval = d
+	}
+}
+
+// WithRequestTimeout sets the timeout of a request to a peer during discovery.
+// The default is 5s.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(pm *peerManager) {
+		pm.requestTimeout = d
+	}
+}
+
+// WithSeed seeds the random source of the peer samples, so that a test
+// samples the same peers on every run. The default is the global source.
+func WithSeed(seed int64) Option {
+	return func(pm *peerManager) {
+		pm.random = rand.New(rand.NewSource(seed))
+	}
 }
 
 // NewPeerManager returns a new peer manager with its own network address.
-func NewPeerManager(add string) Peer {
-	return &peerManager{
-		addr:            add,
-		Peers:           make(map[string]*peer),
-		Mux:             sync.RWMutex{},
-		stopDiscover:    make(chan struct{}),
-		discoverStopped: make(chan struct{}),
-		waiter:          sync.WaitGroup{},
+func NewPeerManager(add string, opts ...Option) Peer {
+	pm := &peerManager{
+		addr:             add,
+		Peers:            make(map[string]*peer),
+		Banned:           make(map[string]bool),
+		Mux:              sync.RWMutex{},
+		stopDiscover:     make(chan struct{}),
+		discoverStopped:  make(chan struct{}),
+		waiter:           sync.WaitGroup{},
+		transport:        transport.NewTCP(),
+		clock:            clock.Real{},
+		logger:           logger.Nop(),
+		maxPeers:         maxPeerNum,
+		discoverInterval: maxDiscoverSleepTime,
+		requestTimeout:   requestTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(pm)
+	}
+	if pm.store != nil {
+		pm.load()
+	}
+	return pm
+}
+
+// Key prefixes of the address book.
+const (
+	peerPrefix   = "peer/"
+	bannedPrefix = "banned/"
+)
+
+// load loads the known and banned peers of the address book.
+func (pm *peerManager) load() {
+	it := pm.store.Iterator([]byte(peerPrefix))
+	for it.Next() {
+		addr := string(it.Key()[len(peerPrefix):])
+		pm.Peers[addr] = &peer{Address: addr}
+	}
+	it.Release()
+
+	it = pm.store.Iterator([]byte(bannedPrefix))
+	for it.Next() {
+		pm.Banned[string(it.Key()[len(bannedPrefix):])] = true
+	}
+	it.Release()
+}
+
+// save saves a change of the address book, a failure is only logged as the
+// peers in memory stay right.
+func (pm *peerManager) save(b *storage.Batch) {
+	if pm.store == nil {
+		return
+	}
+	if err := pm.store.Write(b); err != nil {
+		pm.logger.Warn("failed to save address book", logger.Err(err))
 	}
 }
 
@@ -89,12 +245,16 @@ func (pm *peerManager) addPeer(addr string) {
 	pm.Mux.Lock()
 	defer pm.Mux.Unlock()
 
-	if _, ok := pm.Peers[addr]; ok {
+	if _, ok := pm.Peers[addr]; ok || pm.Banned[addr] {
 		return
 	}
 
-	fmt.Printf("node %v - add peer: %v \n", pm.addr, addr)
+	pm.logger.Info("add peer", logger.F("peer", addr))
 	pm.Peers[addr] = &peer{Address: addr}
+
+	b := storage.NewBatch()
+	b.Set([]byte(peerPrefix+addr), nil)
+	pm.save(b)
 }
 
 // AddPeers add list of addresses to the peer manager.
@@ -113,11 +273,15 @@ func (pm *peerManager) RemovePeer(addr string) error {
 	defer pm.Mux.Unlock()
 
 	if _, ok := pm.Peers[addr]; ok {
-		if err := pm.disconnect(addr); err != err {
+		if err := pm.disconnect(addr); err != nil {
 			return err
 		}
 
 		delete(pm.Peers, addr)
+
+		b := storage.NewBatch()
+		b.Delete([]byte(peerPrefix + addr))
+		pm.save(b)
 	}
 	return nil
 }
@@ -125,7 +289,7 @@ func (pm *peerManager) RemovePeer(addr string) error {
 // RemoveAllPeers removes all peers from the peer manager.
 func (pm *peerManager) RemoveAllPeers() error {
 
-	for addr := range pm.Peers {
+	for _, addr := range pm.GetPeers() {
 		if err := pm.RemovePeer(addr); err != nil {
 			return err
 		}
@@ -133,6 +297,36 @@ func (pm *peerManager) RemoveAllPeers() error {
 	return nil
 }
 
+// BanPeer removes a peer and never adds it again.
+func (pm *peerManager) BanPeer(addr string) error {
+	if err := pm.RemovePeer(addr); err != nil {
+		return err
+	}
+
+	pm.Mux.Lock()
+	defer pm.Mux.Unlock()
+
+	pm.Banned[addr] = true
+
+	b := storage.NewBatch()
+	b.Set([]byte(bannedPrefix+addr), nil)
+	pm.save(b)
+	return nil
+}
+
+// GetBannedPeers returns the addresses of the banned peers.
+func (pm *peerManager) GetBannedPeers() []string {
+	pm.Mux.RLock()
+	defer pm.Mux.RUnlock()
+
+	addresses := make([]string, 0, len(pm.Banned))
+	for addr := range pm.Banned {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
 // Disconnect closes the connection to the peer.
 func (pm *peerManager) Disconnect(addr string) error {
 	pm.Mux.Lock()
@@ -183,19 +377,19 @@ func (pm *peerManager) GetPeerState(addr string) connectivity.State {
 
 // GetConnection returns a connection to a peer.
 func (pm *peerManager) GetConnection(addr string) (*grpc.ClientConn, error) {
+	pm.addPeer(addr)
 
-	p, ok := pm.Peers[addr]
-	if !ok {
-		pm.addPeer(addr)
-	}
+	pm.Mux.Lock()
+	defer pm.Mux.Unlock()
 
-	p, ok = pm.Peers[addr]
+	p, ok := pm.Peers[addr]
 	if !ok {
 		return nil, fmt.Errorf("failed to get connection to peer: %v", addr)
 	}
 
 	if p.conn == nil || p.conn.GetState() == connectivity.Shutdown {
-		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		opts := append([]grpc.DialOption{grpc.WithChainUnaryInterceptor(unaryClientMetrics(addr))}, pm.dialOptions...)
+		conn, err := pm.transport.Dial(addr, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -218,12 +412,12 @@ func (pm *peerManager) discoverPeers(addr string) {
 
 	client := proto.NewPeerServiceClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), pm.requestTimeout)
 	defer cancel()
 
 	peers, err := client.PingPong(ctx, &proto.Ping{Address: pm.addr})
 	if err != nil {
-		log.Printf("%v failed to get neighbors of peer: %v: %v", pm.addr, addr, err)
+		pm.logger.Warn("failed to get neighbors of peer", logger.F("peer", addr), logger.Err(err))
 		return
 	}
 
@@ -243,13 +437,21 @@ func (pm *peerManager) StartDiscoverPeers(bootstraps ...string) {
 
 	pm.AddPeers(bootstraps...)
 
+	pm.Mux.Lock()
+	defer pm.Mux.Unlock()
+	if pm.discovering {
+		return
+	}
+	pm.discovering = true
+
 	pm.waiter.Add(1)
 	go func() {
 		for {
-			if pm.GetPeersNum() < maxPeerNum {
+			if pm.GetPeersNum() < pm.maxPeers {
+				discoveryRounds.With().Inc()
 				for _, addr := range pm.GetPeers() {
 					pm.discoverPeers(addr)
-					if pm.GetPeersNum() >= maxPeerNum {
+					if pm.GetPeersNum() >= pm.maxPeers {
 						break
 					}
 				}
@@ -260,7 +462,7 @@ func (pm *peerManager) StartDiscoverPeers(bootstraps ...string) {
 				pm.waiter.Done()
 				pm.discoverStopped <- struct{}{}
 				return
-			case <-time.After(maxDiscoverSleepTime):
+			case <-pm.clock.After(pm.discoverInterval):
 				continue
 			}
 		}
@@ -273,15 +475,45 @@ func (pm *peerManager) PingPong(ctx context.Context, ping *proto.Ping) (*proto.P
 	return &proto.Pong{Addresses: peers}, nil
 }
 
+// StopDiscoverPeers stops the peer discovery process and waits for the
+// current round, if it is running.
 func (pm *peerManager) StopDiscoverPeers() {
-	pm.stopDiscover <- struct{}{}
-	<-pm.discoverStopped
+	pm.Mux.Lock()
+	discovering := pm.discovering
+	pm.discovering = false
+	pm.Mux.Unlock()
+
+	if discovering {
+		pm.stopDiscover <- struct{}{}
+		<-pm.discoverStopped
+	}
 }
 
-func (pm *peerManager) GetSamplePeers(num int) []string {
-	pm.Mux.RLock()
-	defer pm.Mux.RUnlock()
+// Close stops the peer discovery and closes the connections to all peers. The
+// peers are kept, a later GetConnection dials them again.
+func (pm *peerManager) Close() error {
+	pm.StopDiscoverPeers()
+
+	pm.Mux.Lock()
+	defer pm.Mux.Unlock()
+
+	var errs []error
+	for addr, p := range pm.Peers {
+		if p.conn == nil {
+			continue
+		}
+		if err := p.conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", addr, err))
+		}
+		p.conn = nil
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v failed to close connections: %v", pm.addr, errs)
+	}
+	return nil
+}
 
+func (pm *peerManager) GetSamplePeers(num int) []string {
 	// get all peer addresses
 	peers := pm.GetPeers()
 
@@ -290,10 +522,83 @@ func (pm *peerManager) GetSamplePeers(num int) []string {
 		return peers
 	}
 
-	// randomly sample peers
-	rand.Shuffle(len(peers), func(i, j int) {
-		peers[i], peers[j] = peers[j], peers[i]
-	})
+	// randomly sample peers, from a sorted list as the order of the map would
+	// defeat a seed
+	sort.Strings(peers)
+	pm.shuffle(peers)
 
 	return peers[:num]
 }
+
+// GetWeightedSamplePeers returns num peers sampled without replacement, with
+// probability proportional to their weights. Only known peers that have a
+// positive weight are candidates.
+func (pm *peerManager) GetWeightedSamplePeers(num int, weights map[string]uint64) []string {
+	pm.Mux.RLock()
+	defer pm.Mux.RUnlock()
+
+	type candidate struct {
+		addr string
+		key  float64
+	}
+
+	// each candidate gets the key u^(1/w), the num largest keys form a
+	// weighted sample without replacement (Efraimidis-Spirakis)
+	addrs := make([]string, 0, len(pm.Peers))
+	for addr := range pm.Peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var candidates []candidate
+	for _, addr := range addrs {
+		weight := weights[addr]
+		if weight == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			addr: addr,
+			key:  math.Pow(pm.float64(), 1/float64(weight)),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].key > candidates[j].key
+	})
+
+	if len(candidates) > num {
+		candidates = candidates[:num]
+	}
+
+	peers := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		peers = append(peers, c.addr)
+	}
+	return peers
+}
+
+// shuffle shuffles the addresses with the random source of the samples.
+func (pm *peerManager) shuffle(addrs []string) {
+	swap := func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	if pm.random == nil {
+		rand.Shuffle(len(addrs), swap)
+		return
+	}
+
+	pm.randomMux.Lock()
+	defer pm.randomMux.Unlock()
+	pm.random.Shuffle(len(addrs), swap)
+}
+
+// float64 returns a number in [0.0,1.0) from the random source of the samples.
+func (pm *peerManager) float64() float64 {
+	if pm.random == nil {
+		return rand.Float64()
+	}
+
+	pm.randomMux.Lock()
+	defer pm.randomMux.Unlock()
+	return pm.random.Float64()
+}
@@ -32,35 +32,169 @@ var file_p2p_proto_rawDesc = []byte{
 	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x13, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x4d, 0x65, 0x73,
 	0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32,
 	0x70, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
-	0x65, 0x22, 0x00, 0x32, 0x4d, 0x0a, 0x10, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73,
-	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x39, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x50, 0x72,
-	0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45,
-	0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x72,
-	0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
-	0x22, 0x00, 0x42, 0x09, 0x5a, 0x07, 0x2e, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x22, 0x00, 0x32, 0x5c, 0x0a, 0x10, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x48, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x50, 0x72,
+	0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x19, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x47,
+	0x65, 0x74, 0x50, 0x72, 0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x72, 0x65,
+	0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
+	0x00, 0x32, 0xcc, 0x03, 0x0a, 0x0c, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x12, 0x30, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x12, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79,
+	0x1a, 0x10, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x22, 0x00, 0x12, 0x37, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x73, 0x12, 0x11, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x48, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x35, 0x0a,
+	0x09, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x11, 0x2e, 0x70, 0x32, 0x70,
+	0x2e, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e,
+	0x70, 0x32, 0x70, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x22, 0x00, 0x12, 0x35, 0x0a, 0x0d, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x73, 0x12, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74,
+	0x79, 0x1a, 0x16, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3b, 0x0a, 0x10, 0x47,
+	0x65, 0x74, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12,
+	0x11, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x12, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x54,
+	0x78, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x12, 0x13, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x54, 0x78, 0x50,
+	0x72, 0x6f, 0x6f, 0x66, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0c, 0x2e, 0x70, 0x32,
+	0x70, 0x2e, 0x54, 0x78, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x22, 0x00, 0x12, 0x3a, 0x0a, 0x0d, 0x47,
+	0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x12, 0x16, 0x2e, 0x70,
+	0x32, 0x70, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x50, 0x72, 0x6f, 0x6f, 0x66, 0x22, 0x00, 0x12, 0x37, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x43, 0x65,
+	0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x11, 0x2e, 0x70, 0x32, 0x70, 0x2e,
+	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x70,
+	0x32, 0x70, 0x2e, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x22, 0x00,
+	0x32, 0x9d, 0x05, 0x0a, 0x10, 0x4e, 0x6f, 0x64, 0x65, 0x41, 0x64, 0x6d, 0x69, 0x6e, 0x53, 0x65,
+	0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x29, 0x0a, 0x07, 0x41, 0x64, 0x64, 0x50, 0x65, 0x65, 0x72,
+	0x12, 0x10, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00,
+	0x12, 0x2c, 0x0a, 0x0a, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x50, 0x65, 0x65, 0x72, 0x12, 0x10,
+	0x2e, 0x70, 0x32, 0x70, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x29,
+	0x0a, 0x07, 0x42, 0x61, 0x6e, 0x50, 0x65, 0x65, 0x72, 0x12, 0x10, 0x2e, 0x70, 0x32, 0x70, 0x2e,
+	0x50, 0x65, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0a, 0x2e, 0x70, 0x32,
+	0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x09, 0x4c, 0x69, 0x73,
+	0x74, 0x50, 0x65, 0x65, 0x72, 0x73, 0x12, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x1a, 0x16, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x65, 0x65,
+	0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x43, 0x0a, 0x12,
+	0x47, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x15, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73,
+	0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32, 0x70, 0x2e,
+	0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22,
+	0x00, 0x12, 0x3f, 0x0a, 0x0e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x73, 0x75, 0x73, 0x12, 0x15, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x73, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32, 0x70,
+	0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0d, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x73, 0x75, 0x73, 0x12, 0x15, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x73, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32, 0x70,
+	0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x22, 0x00, 0x12, 0x48, 0x0a, 0x10, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x72, 0x65, 0x66,
+	0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x1c, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x55, 0x70, 0x64,
+	0x61, 0x74, 0x65, 0x50, 0x72, 0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x65,
+	0x6e, 0x73, 0x75, 0x73, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x00, 0x12, 0x28, 0x0a, 0x0c,
+	0x47, 0x65, 0x74, 0x43, 0x68, 0x61, 0x69, 0x6e, 0x48, 0x65, 0x61, 0x64, 0x12, 0x0a, 0x2e, 0x70,
+	0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x42,
+	0x6c, 0x6f, 0x63, 0x6b, 0x22, 0x00, 0x12, 0x2b, 0x0a, 0x08, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x12, 0x11, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x22, 0x00, 0x12, 0x45, 0x0a, 0x0c, 0x54, 0x61, 0x69, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x73, 0x12, 0x18, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x54, 0x61, 0x69, 0x6c, 0x4d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e,
+	0x70, 0x32, 0x70, 0x2e, 0x54, 0x61, 0x69, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x73,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x24, 0x0a, 0x08, 0x53, 0x68,
+	0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x12, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x1a, 0x0a, 0x2e, 0x70, 0x32, 0x70, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00,
+	0x42, 0x09, 0x5a, 0x07, 0x2e, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
 }
 
 var file_p2p_proto_goTypes = []interface{}{
-	(*Ping)(nil),                  // 0: p2p.Ping
-	(*MessageRequest)(nil),        // 1: p2p.MessageRequest
-	(*Empty)(nil),                 // 2: p2p.Empty
-	(*Pong)(nil),                  // 3: p2p.Pong
-	(*MessageResponse)(nil),       // 4: p2p.MessageResponse
-	(*GetPreferenceResponse)(nil), // 5: p2p.GetPreferenceResponse
+	(*Ping)(nil),                    // 0: p2p.Ping
+	(*MessageRequest)(nil),          // 1: p2p.MessageRequest
+	(*GetPreferenceRequest)(nil),    // 2: p2p.GetPreferenceRequest
+	(*Empty)(nil),                   // 3: p2p.Empty
+	(*RangeRequest)(nil),            // 4: p2p.RangeRequest
+	(*ChunkRequest)(nil),            // 5: p2p.ChunkRequest
+	(*TxProofRequest)(nil),          // 6: p2p.TxProofRequest
+	(*StateProofRequest)(nil),       // 7: p2p.StateProofRequest
+	(*BlockRequest)(nil),            // 8: p2p.BlockRequest
+	(*PeerRequest)(nil),             // 9: p2p.PeerRequest
+	(*ConsensusRequest)(nil),        // 10: p2p.ConsensusRequest
+	(*UpdatePreferenceRequest)(nil), // 11: p2p.UpdatePreferenceRequest
+	(*TailMessagesRequest)(nil),     // 12: p2p.TailMessagesRequest
+	(*Pong)(nil),                    // 13: p2p.Pong
+	(*MessageResponse)(nil),         // 14: p2p.MessageResponse
+	(*GetPreferenceResponse)(nil),   // 15: p2p.GetPreferenceResponse
+	(*ChainStatus)(nil),             // 16: p2p.ChainStatus
+	(*HeadersResponse)(nil),         // 17: p2p.HeadersResponse
+	(*BlocksResponse)(nil),          // 18: p2p.BlocksResponse
+	(*SnapshotsResponse)(nil),       // 19: p2p.SnapshotsResponse
+	(*ChunkResponse)(nil),           // 20: p2p.ChunkResponse
+	(*TxProof)(nil),                 // 21: p2p.TxProof
+	(*StateProof)(nil),              // 22: p2p.StateProof
+	(*Certificate)(nil),             // 23: p2p.Certificate
+	(*ListPeersResponse)(nil),       // 24: p2p.ListPeersResponse
+	(*ConsensusStatus)(nil),         // 25: p2p.ConsensusStatus
+	(*Block)(nil),                   // 26: p2p.Block
+	(*TailMessagesResponse)(nil),    // 27: p2p.TailMessagesResponse
 }
 var file_p2p_proto_depIdxs = []int32{
-	0, // 0: p2p.PeerService.PingPong:input_type -> p2p.Ping
-	1, // 1: p2p.MessageService.ReceiveMessage:input_type -> p2p.MessageRequest
-	2, // 2: p2p.ConsensusService.GetPreference:input_type -> p2p.Empty
-	3, // 3: p2p.PeerService.PingPong:output_type -> p2p.Pong
-	4, // 4: p2p.MessageService.ReceiveMessage:output_type -> p2p.MessageResponse
-	5, // 5: p2p.ConsensusService.GetPreference:output_type -> p2p.GetPreferenceResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: p2p.PeerService.PingPong:input_type -> p2p.Ping
+	1,  // 1: p2p.MessageService.ReceiveMessage:input_type -> p2p.MessageRequest
+	2,  // 2: p2p.ConsensusService.GetPreference:input_type -> p2p.GetPreferenceRequest
+	3,  // 3: p2p.ChainService.GetChainStatus:input_type -> p2p.Empty
+	4,  // 4: p2p.ChainService.GetHeaders:input_type -> p2p.RangeRequest
+	4,  // 5: p2p.ChainService.GetBlocks:input_type -> p2p.RangeRequest
+	3,  // 6: p2p.ChainService.ListSnapshots:input_type -> p2p.Empty
+	5,  // 7: p2p.ChainService.GetSnapshotChunk:input_type -> p2p.ChunkRequest
+	6,  // 8: p2p.ChainService.GetTxProof:input_type -> p2p.TxProofRequest
+	7,  // 9: p2p.ChainService.GetStateProof:input_type -> p2p.StateProofRequest
+	8,  // 10: p2p.ChainService.GetCertificate:input_type -> p2p.BlockRequest
+	9,  // 11: p2p.NodeAdminService.AddPeer:input_type -> p2p.PeerRequest
+	9,  // 12: p2p.NodeAdminService.RemovePeer:input_type -> p2p.PeerRequest
+	9,  // 13: p2p.NodeAdminService.BanPeer:input_type -> p2p.PeerRequest
+	3,  // 14: p2p.NodeAdminService.ListPeers:input_type -> p2p.Empty
+	10, // 15: p2p.NodeAdminService.GetConsensusStatus:input_type -> p2p.ConsensusRequest
+	10, // 16: p2p.NodeAdminService.StartConsensus:input_type -> p2p.ConsensusRequest
+	10, // 17: p2p.NodeAdminService.StopConsensus:input_type -> p2p.ConsensusRequest
+	11, // 18: p2p.NodeAdminService.UpdatePreference:input_type -> p2p.UpdatePreferenceRequest
+	3,  // 19: p2p.NodeAdminService.GetChainHead:input_type -> p2p.Empty
+	8,  // 20: p2p.NodeAdminService.GetBlock:input_type -> p2p.BlockRequest
+	12, // 21: p2p.NodeAdminService.TailMessages:input_type -> p2p.TailMessagesRequest
+	3,  // 22: p2p.NodeAdminService.Shutdown:input_type -> p2p.Empty
+	13, // 23: p2p.PeerService.PingPong:output_type -> p2p.Pong
+	14, // 24: p2p.MessageService.ReceiveMessage:output_type -> p2p.MessageResponse
+	15, // 25: p2p.ConsensusService.GetPreference:output_type -> p2p.GetPreferenceResponse
+	16, // 26: p2p.ChainService.GetChainStatus:output_type -> p2p.ChainStatus
+	17, // 27: p2p.ChainService.GetHeaders:output_type -> p2p.HeadersResponse
+	18, // 28: p2p.ChainService.GetBlocks:output_type -> p2p.BlocksResponse
+	19, // 29: p2p.ChainService.ListSnapshots:output_type -> p2p.SnapshotsResponse
+	20, // 30: p2p.ChainService.GetSnapshotChunk:output_type -> p2p.ChunkResponse
+	21, // 31: p2p.ChainService.GetTxProof:output_type -> p2p.TxProof
+	22, // 32: p2p.ChainService.GetStateProof:output_type -> p2p.StateProof
+	23, // 33: p2p.ChainService.GetCertificate:output_type -> p2p.Certificate
+	3,  // 34: p2p.NodeAdminService.AddPeer:output_type -> p2p.Empty
+	3,  // 35: p2p.NodeAdminService.RemovePeer:output_type -> p2p.Empty
+	3,  // 36: p2p.NodeAdminService.BanPeer:output_type -> p2p.Empty
+	24, // 37: p2p.NodeAdminService.ListPeers:output_type -> p2p.ListPeersResponse
+	25, // 38: p2p.NodeAdminService.GetConsensusStatus:output_type -> p2p.ConsensusStatus
+	25, // 39: p2p.NodeAdminService.StartConsensus:output_type -> p2p.ConsensusStatus
+	25, // 40: p2p.NodeAdminService.StopConsensus:output_type -> p2p.ConsensusStatus
+	25, // 41: p2p.NodeAdminService.UpdatePreference:output_type -> p2p.ConsensusStatus
+	26, // 42: p2p.NodeAdminService.GetChainHead:output_type -> p2p.Block
+	26, // 43: p2p.NodeAdminService.GetBlock:output_type -> p2p.Block
+	27, // 44: p2p.NodeAdminService.TailMessages:output_type -> p2p.TailMessagesResponse
+	3,  // 45: p2p.NodeAdminService.Shutdown:output_type -> p2p.Empty
+	23, // [23:46] is the sub-list for method output_type
+	0,  // [0:23] is the sub-list for method input_type
+	0,  // [0:0] is the sub-list for extension type_name
+	0,  // [0:0] is the sub-list for extension extendee
+	0,  // [0:0] is the sub-list for field type_name
 }
 
 func init() { file_p2p_proto_init() }
@@ -77,7 +211,7 @@ func file_p2p_proto_init() {
 			NumEnums:      0,
 			NumMessages:   0,
 			NumExtensions: 0,
-			NumServices:   3,
+			NumServices:   5,
 		},
 		GoTypes:           file_p2p_proto_goTypes,
 		DependencyIndexes: file_p2p_proto_depIdxs,
@@ -192,7 +192,7 @@ var MessageService_ServiceDesc = grpc.ServiceDesc{
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ConsensusServiceClient interface {
-	GetPreference(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GetPreferenceResponse, error)
+	GetPreference(ctx context.Context, in *GetPreferenceRequest, opts ...grpc.CallOption) (*GetPreferenceResponse, error)
 }
 
 type consensusServiceClient struct {
@@ -203,7 +203,7 @@ func NewConsensusServiceClient(cc grpc.ClientConnInterface) ConsensusServiceClie
 	return &consensusServiceClient{cc}
 }
 
-func (c *consensusServiceClient) GetPreference(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GetPreferenceResponse, error) {
+func (c *consensusServiceClient) GetPreference(ctx context.Context, in *GetPreferenceRequest, opts ...grpc.CallOption) (*GetPreferenceResponse, error) {
 	out := new(GetPreferenceResponse)
 	err := c.cc.Invoke(ctx, "/p2p.ConsensusService/GetPreference", in, out, opts...)
 	if err != nil {
@@ -216,14 +216,14 @@ func (c *consensusServiceClient) GetPreference(ctx context.Context, in *Empty, o
 // All implementations should embed UnimplementedConsensusServiceServer
 // for forward compatibility
 type ConsensusServiceServer interface {
-	GetPreference(context.Context, *Empty) (*GetPreferenceResponse, error)
+	GetPreference(context.Context, *GetPreferenceRequest) (*GetPreferenceResponse, error)
 }
 
 // UnimplementedConsensusServiceServer should be embedded to have forward compatible implementations.
 type UnimplementedConsensusServiceServer struct {
 }
 
-func (UnimplementedConsensusServiceServer) GetPreference(context.Context, *Empty) (*GetPreferenceResponse, error) {
+func (UnimplementedConsensusServiceServer) GetPreference(context.Context, *GetPreferenceRequest) (*GetPreferenceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetPreference not implemented")
 }
 
@@ -239,7 +239,7 @@ func RegisterConsensusServiceServer(s grpc.ServiceRegistrar, srv ConsensusServic
 }
 
 func _ConsensusService_GetPreference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
+	in := new(GetPreferenceRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -251,7 +251,7 @@ func _ConsensusService_GetPreference_Handler(srv interface{}, ctx context.Contex
 		FullMethod: "/p2p.ConsensusService/GetPreference",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ConsensusServiceServer).GetPreference(ctx, req.(*Empty))
+		return srv.(ConsensusServiceServer).GetPreference(ctx, req.(*GetPreferenceRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -271,3 +271,819 @@ var ConsensusService_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "p2p.proto",
 }
+
+// ChainServiceClient is the client API for ChainService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ChainServiceClient interface {
+	GetChainStatus(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ChainStatus, error)
+	GetHeaders(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*HeadersResponse, error)
+	GetBlocks(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*BlocksResponse, error)
+	ListSnapshots(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*SnapshotsResponse, error)
+	GetSnapshotChunk(ctx context.Context, in *ChunkRequest, opts ...grpc.CallOption) (*ChunkResponse, error)
+	GetTxProof(ctx context.Context, in *TxProofRequest, opts ...grpc.CallOption) (*TxProof, error)
+	GetStateProof(ctx context.Context, in *StateProofRequest, opts ...grpc.CallOption) (*StateProof, error)
+	GetCertificate(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Certificate, error)
+}
+
+type chainServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChainServiceClient(cc grpc.ClientConnInterface) ChainServiceClient {
+	return &chainServiceClient{cc}
+}
+
+func (c *chainServiceClient) GetChainStatus(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ChainStatus, error) {
+	out := new(ChainStatus)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetChainStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetHeaders(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*HeadersResponse, error) {
+	out := new(HeadersResponse)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetHeaders", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetBlocks(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*BlocksResponse, error) {
+	out := new(BlocksResponse)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) ListSnapshots(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*SnapshotsResponse, error) {
+	out := new(SnapshotsResponse)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/ListSnapshots", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetSnapshotChunk(ctx context.Context, in *ChunkRequest, opts ...grpc.CallOption) (*ChunkResponse, error) {
+	out := new(ChunkResponse)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetSnapshotChunk", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetTxProof(ctx context.Context, in *TxProofRequest, opts ...grpc.CallOption) (*TxProof, error) {
+	out := new(TxProof)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetTxProof", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetStateProof(ctx context.Context, in *StateProofRequest, opts ...grpc.CallOption) (*StateProof, error) {
+	out := new(StateProof)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetStateProof", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chainServiceClient) GetCertificate(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, "/p2p.ChainService/GetCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChainServiceServer is the server API for ChainService service.
+// All implementations should embed UnimplementedChainServiceServer
+// for forward compatibility
+type ChainServiceServer interface {
+	GetChainStatus(context.Context, *Empty) (*ChainStatus, error)
+	GetHeaders(context.Context, *RangeRequest) (*HeadersResponse, error)
+	GetBlocks(context.Context, *RangeRequest) (*BlocksResponse, error)
+	ListSnapshots(context.Context, *Empty) (*SnapshotsResponse, error)
+	GetSnapshotChunk(context.Context, *ChunkRequest) (*ChunkResponse, error)
+	GetTxProof(context.Context, *TxProofRequest) (*TxProof, error)
+	GetStateProof(context.Context, *StateProofRequest) (*StateProof, error)
+	GetCertificate(context.Context, *BlockRequest) (*Certificate, error)
+}
+
+// UnimplementedChainServiceServer should be embedded to have forward compatible implementations.
+type UnimplementedChainServiceServer struct {
+}
+
+func (UnimplementedChainServiceServer) GetChainStatus(context.Context, *Empty) (*ChainStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChainStatus not implemented")
+}
+func (UnimplementedChainServiceServer) GetHeaders(context.Context, *RangeRequest) (*HeadersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHeaders not implemented")
+}
+func (UnimplementedChainServiceServer) GetBlocks(context.Context, *RangeRequest) (*BlocksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlocks not implemented")
+}
+func (UnimplementedChainServiceServer) ListSnapshots(context.Context, *Empty) (*SnapshotsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (UnimplementedChainServiceServer) GetSnapshotChunk(context.Context, *ChunkRequest) (*ChunkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSnapshotChunk not implemented")
+}
+func (UnimplementedChainServiceServer) GetTxProof(context.Context, *TxProofRequest) (*TxProof, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTxProof not implemented")
+}
+func (UnimplementedChainServiceServer) GetStateProof(context.Context, *StateProofRequest) (*StateProof, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStateProof not implemented")
+}
+func (UnimplementedChainServiceServer) GetCertificate(context.Context, *BlockRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCertificate not implemented")
+}
+
+// UnsafeChainServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChainServiceServer will
+// result in compilation errors.
+type UnsafeChainServiceServer interface {
+	mustEmbedUnimplementedChainServiceServer()
+}
+
+func RegisterChainServiceServer(s grpc.ServiceRegistrar, srv ChainServiceServer) {
+	s.RegisterService(&ChainService_ServiceDesc, srv)
+}
+
+func _ChainService_GetChainStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetChainStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetChainStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetChainStatus(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetHeaders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetHeaders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetHeaders",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetHeaders(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetBlocks(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_ListSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).ListSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/ListSnapshots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).ListSnapshots(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetSnapshotChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChunkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetSnapshotChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetSnapshotChunk",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetSnapshotChunk(ctx, req.(*ChunkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetTxProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetTxProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetTxProof",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetTxProof(ctx, req.(*TxProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetStateProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StateProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetStateProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetStateProof",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetStateProof(ctx, req.(*StateProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChainService_GetCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChainServiceServer).GetCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.ChainService/GetCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChainServiceServer).GetCertificate(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChainService_ServiceDesc is the grpc.ServiceDesc for ChainService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChainService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "p2p.ChainService",
+	HandlerType: (*ChainServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetChainStatus",
+			Handler:    _ChainService_GetChainStatus_Handler,
+		},
+		{
+			MethodName: "GetHeaders",
+			Handler:    _ChainService_GetHeaders_Handler,
+		},
+		{
+			MethodName: "GetBlocks",
+			Handler:    _ChainService_GetBlocks_Handler,
+		},
+		{
+			MethodName: "ListSnapshots",
+			Handler:    _ChainService_ListSnapshots_Handler,
+		},
+		{
+			MethodName: "GetSnapshotChunk",
+			Handler:    _ChainService_GetSnapshotChunk_Handler,
+		},
+		{
+			MethodName: "GetTxProof",
+			Handler:    _ChainService_GetTxProof_Handler,
+		},
+		{
+			MethodName: "GetStateProof",
+			Handler:    _ChainService_GetStateProof_Handler,
+		},
+		{
+			MethodName: "GetCertificate",
+			Handler:    _ChainService_GetCertificate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "p2p.proto",
+}
+
+// NodeAdminServiceClient is the client API for NodeAdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NodeAdminServiceClient interface {
+	AddPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error)
+	RemovePeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error)
+	BanPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListPeers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListPeersResponse, error)
+	GetConsensusStatus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	StartConsensus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	StopConsensus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	UpdatePreference(ctx context.Context, in *UpdatePreferenceRequest, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	GetChainHead(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Block, error)
+	GetBlock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Block, error)
+	TailMessages(ctx context.Context, in *TailMessagesRequest, opts ...grpc.CallOption) (*TailMessagesResponse, error)
+	Shutdown(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type nodeAdminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNodeAdminServiceClient(cc grpc.ClientConnInterface) NodeAdminServiceClient {
+	return &nodeAdminServiceClient{cc}
+}
+
+func (c *nodeAdminServiceClient) AddPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/AddPeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) RemovePeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/RemovePeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) BanPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/BanPeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) ListPeers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListPeersResponse, error) {
+	out := new(ListPeersResponse)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/ListPeers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) GetConsensusStatus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/GetConsensusStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) StartConsensus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/StartConsensus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) StopConsensus(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/StopConsensus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) UpdatePreference(ctx context.Context, in *UpdatePreferenceRequest, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/UpdatePreference", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) GetChainHead(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Block, error) {
+	out := new(Block)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/GetChainHead", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) GetBlock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Block, error) {
+	out := new(Block)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/GetBlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) TailMessages(ctx context.Context, in *TailMessagesRequest, opts ...grpc.CallOption) (*TailMessagesResponse, error) {
+	out := new(TailMessagesResponse)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/TailMessages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAdminServiceClient) Shutdown(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/p2p.NodeAdminService/Shutdown", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NodeAdminServiceServer is the server API for NodeAdminService service.
+// All implementations should embed UnimplementedNodeAdminServiceServer
+// for forward compatibility
+type NodeAdminServiceServer interface {
+	AddPeer(context.Context, *PeerRequest) (*Empty, error)
+	RemovePeer(context.Context, *PeerRequest) (*Empty, error)
+	BanPeer(context.Context, *PeerRequest) (*Empty, error)
+	ListPeers(context.Context, *Empty) (*ListPeersResponse, error)
+	GetConsensusStatus(context.Context, *ConsensusRequest) (*ConsensusStatus, error)
+	StartConsensus(context.Context, *ConsensusRequest) (*ConsensusStatus, error)
+	StopConsensus(context.Context, *ConsensusRequest) (*ConsensusStatus, error)
+	UpdatePreference(context.Context, *UpdatePreferenceRequest) (*ConsensusStatus, error)
+	GetChainHead(context.Context, *Empty) (*Block, error)
+	GetBlock(context.Context, *BlockRequest) (*Block, error)
+	TailMessages(context.Context, *TailMessagesRequest) (*TailMessagesResponse, error)
+	Shutdown(context.Context, *Empty) (*Empty, error)
+}
+
+// UnimplementedNodeAdminServiceServer should be embedded to have forward compatible implementations.
+type UnimplementedNodeAdminServiceServer struct {
+}
+
+func (UnimplementedNodeAdminServiceServer) AddPeer(context.Context, *PeerRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPeer not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) RemovePeer(context.Context, *PeerRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePeer not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) BanPeer(context.Context, *PeerRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanPeer not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) ListPeers(context.Context, *Empty) (*ListPeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) GetConsensusStatus(context.Context, *ConsensusRequest) (*ConsensusStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConsensusStatus not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) StartConsensus(context.Context, *ConsensusRequest) (*ConsensusStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartConsensus not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) StopConsensus(context.Context, *ConsensusRequest) (*ConsensusStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopConsensus not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) UpdatePreference(context.Context, *UpdatePreferenceRequest) (*ConsensusStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePreference not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) GetChainHead(context.Context, *Empty) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChainHead not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) GetBlock(context.Context, *BlockRequest) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlock not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) TailMessages(context.Context, *TailMessagesRequest) (*TailMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TailMessages not implemented")
+}
+func (UnimplementedNodeAdminServiceServer) Shutdown(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Shutdown not implemented")
+}
+
+// UnsafeNodeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NodeAdminServiceServer will
+// result in compilation errors.
+type UnsafeNodeAdminServiceServer interface {
+	mustEmbedUnimplementedNodeAdminServiceServer()
+}
+
+func RegisterNodeAdminServiceServer(s grpc.ServiceRegistrar, srv NodeAdminServiceServer) {
+	s.RegisterService(&NodeAdminService_ServiceDesc, srv)
+}
+
+func _NodeAdminService_AddPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).AddPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/AddPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).AddPeer(ctx, req.(*PeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_RemovePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/RemovePeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).RemovePeer(ctx, req.(*PeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_BanPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).BanPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/BanPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).BanPeer(ctx, req.(*PeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/ListPeers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).ListPeers(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_GetConsensusStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsensusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).GetConsensusStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/GetConsensusStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).GetConsensusStatus(ctx, req.(*ConsensusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_StartConsensus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsensusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).StartConsensus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/StartConsensus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).StartConsensus(ctx, req.(*ConsensusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_StopConsensus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsensusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).StopConsensus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/StopConsensus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).StopConsensus(ctx, req.(*ConsensusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_UpdatePreference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePreferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).UpdatePreference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/UpdatePreference",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).UpdatePreference(ctx, req.(*UpdatePreferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_GetChainHead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).GetChainHead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/GetChainHead",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).GetChainHead(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).GetBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/GetBlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).GetBlock(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_TailMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TailMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).TailMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/TailMessages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).TailMessages(ctx, req.(*TailMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAdminService_Shutdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAdminServiceServer).Shutdown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2p.NodeAdminService/Shutdown",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAdminServiceServer).Shutdown(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NodeAdminService_ServiceDesc is the grpc.ServiceDesc for NodeAdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NodeAdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "p2p.NodeAdminService",
+	HandlerType: (*NodeAdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddPeer",
+			Handler:    _NodeAdminService_AddPeer_Handler,
+		},
+		{
+			MethodName: "RemovePeer",
+			Handler:    _NodeAdminService_RemovePeer_Handler,
+		},
+		{
+			MethodName: "BanPeer",
+			Handler:    _NodeAdminService_BanPeer_Handler,
+		},
+		{
+			MethodName: "ListPeers",
+			Handler:    _NodeAdminService_ListPeers_Handler,
+		},
+		{
+			MethodName: "GetConsensusStatus",
+			Handler:    _NodeAdminService_GetConsensusStatus_Handler,
+		},
+		{
+			MethodName: "StartConsensus",
+			Handler:    _NodeAdminService_StartConsensus_Handler,
+		},
+		{
+			MethodName: "StopConsensus",
+			Handler:    _NodeAdminService_StopConsensus_Handler,
+		},
+		{
+			MethodName: "UpdatePreference",
+			Handler:    _NodeAdminService_UpdatePreference_Handler,
+		},
+		{
+			MethodName: "GetChainHead",
+			Handler:    _NodeAdminService_GetChainHead_Handler,
+		},
+		{
+			MethodName: "GetBlock",
+			Handler:    _NodeAdminService_GetBlock_Handler,
+		},
+		{
+			MethodName: "TailMessages",
+			Handler:    _NodeAdminService_TailMessages_Handler,
+		},
+		{
+			MethodName: "Shutdown",
+			Handler:    _NodeAdminService_Shutdown_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "p2p.proto",
+}
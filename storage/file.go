@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var _ Store = (*file)(nil)
+
+const (
+	compactMinOps = 1024    // number of operations in the log below which it is never compacted
+	maxRecordSize = 1 << 30 // size of the largest record, a larger one is corrupted
+)
+
+// file is a store kept in memory and persisted to an append-only log file.
+// Every batch is appended as a record and synced before it is applied, so a
+// batch survives a crash either completely or not at all. The log is
+// compacted, when it is opened or written, once it holds more than twice as
+// many operations as keys.
+//
+// A record is the length and the CRC-32 of its payload, as big endian
+// uint32, followed by the payload: the operations, each a kind byte then the
+// key and, for a set, the value, both prefixed by their uvarint length.
+type file struct {
+	mem  *memory    // current state of the log
+	path string     // path of the log
+	f    *os.File   // log opened for appending
+	ops  int        // number of operations in the log
+	mux  sync.Mutex // writes to the log one at a time
+}
+
+// Kinds of operation of a record.
+const (
+	kindSet    byte = 1
+	kindDelete byte = 2
+)
+
+// Open opens the store of a log file, creating it if needed. A torn record at
+// the end of the log, left by a crash during a write, is discarded, while a
+// corrupted record followed by others fails with ErrCorrupted. A store must be
+// opened by one process at a time.
+func Open(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &file{mem: newMemory(), path: path, f: f}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to load %v: %w", path, err)
+	}
+
+	if s.compactable() {
+		if err := s.compact(); err != nil {
+			s.f.Close()
+			return nil, fmt.Errorf("failed to compact %v: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// load applies the records of the log. A torn last record is truncated, any
+// other invalid record or read error fails the load, as dropping it would
+// drop the valid records after it.
+func (s *file) load() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	r := bufio.NewReader(s.f)
+
+	var offset int64
+	for offset < size {
+		ops, n, err := readRecord(r, size-offset)
+		if errors.Is(err, errTorn) {
+			if err := s.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		s.mem.apply(ops)
+		s.ops += len(ops)
+		offset += n
+	}
+
+	_, err = s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Get returns the value of a key.
+func (s *file) Get(key []byte) ([]byte, error) {
+	return s.mem.Get(key)
+}
+
+// Iterator returns an iterator over the keys starting with prefix.
+func (s *file) Iterator(prefix []byte) Iterator {
+	return s.mem.Iterator(prefix)
+}
+
+// Snapshot returns a consistent view of the store.
+func (s *file) Snapshot() (Snapshot, error) {
+	return s.mem.Snapshot()
+}
+
+// Set sets the value of a key.
+func (s *file) Set(key []byte, value []byte) error {
+	b := NewBatch()
+	b.Set(key, value)
+	return s.Write(b)
+}
+
+// Delete deletes a key.
+func (s *file) Delete(key []byte) error {
+	b := NewBatch()
+	b.Delete(key)
+	return s.Write(b)
+}
+
+// Write appends the batch to the log, then applies it and compacts the log if
+// needed. A record that fails to be written or synced is truncated, so no
+// partial record is followed by others. A compaction error is returned once
+// the batch is applied.
+func (s *file) Write(b *Batch) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	offset, err := s.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := s.append(encodeRecord(b.ops)); err != nil {
+		if terr := s.f.Truncate(offset); terr != nil {
+			// the log may end with a partial record, no record must follow it
+			s.f.Close()
+			s.f = nil
+			return fmt.Errorf("%w, and failed to truncate %v: %v", err, s.path, terr)
+		}
+		return err
+	}
+
+	s.ops += b.Len()
+	if err := s.mem.Write(b); err != nil {
+		return err
+	}
+
+	if s.compactable() {
+		if err := s.compact(); err != nil {
+			return fmt.Errorf("failed to compact %v: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+// append writes a record at the end of the log and syncs it.
+func (s *file) append(record []byte) error {
+	if _, err := s.f.Write(record); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// compactable reports whether the log holds enough operations beyond the keys
+// to be compacted.
+func (s *file) compactable() bool {
+	return s.ops > compactMinOps && s.ops > 2*s.mem.len()
+}
+
+// compact rewrites the log with a single record of the current keys.
+func (s *file) compact() error {
+	data, err := s.mem.view()
+	if err != nil {
+		return err
+	}
+
+	ops := make([]op, 0, len(data))
+	for k, v := range data {
+		ops = append(ops, op{key: []byte(k), value: v})
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, encodeRecord(ops), 0o644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f = f
+	s.ops = len(ops)
+	return syncDir(s.path)
+}
+
+// syncDir syncs the directory of a path, so that a rename to the path
+// survives a crash.
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close closes the log.
+func (s *file) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	s.mem.Close()
+	return err
+}
+
+// encodeRecord encodes operations as a record.
+func encodeRecord(ops []op) []byte {
+	payload := make([]byte, 0, 64)
+	for _, o := range ops {
+		if o.delete {
+			payload = append(payload, kindDelete)
+			payload = appendBytes(payload, o.key)
+		} else {
+			payload = append(payload, kindSet)
+			payload = appendBytes(payload, o.key)
+			payload = appendBytes(payload, o.value)
+		}
+	}
+
+	record := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// appendBytes appends a byte slice prefixed by its uvarint length.
+func appendBytes(dst []byte, b []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(b)))
+	return append(append(dst, buf[:n]...), b...)
+}
+
+var errTorn = errors.New("torn record")
+
+// readRecord reads a record among the remaining bytes of the log and returns
+// its operations and size. It returns errTorn for a record cut by the end of
+// the log or a last record that does not match its CRC, as a crash during a
+// write leaves, and ErrCorrupted for other invalid records.
+func readRecord(r io.Reader, remaining int64) ([]op, int64, error) {
+	var header [8]byte
+	if remaining < int64(len(header)) {
+		return nil, 0, errTorn
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if int64(len(header))+int64(size) > remaining {
+		return nil, 0, errTorn
+	}
+	if size > maxRecordSize {
+		return nil, 0, ErrCorrupted
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		if int64(len(header))+int64(size) == remaining {
+			return nil, 0, errTorn
+		}
+		return nil, 0, ErrCorrupted
+	}
+
+	var ops []op
+	for len(payload) > 0 {
+		kind := payload[0]
+		payload = payload[1:]
+
+		key, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		payload = rest
+
+		switch kind {
+		case kindSet:
+			value, rest, err := readBytes(payload)
+			if err != nil {
+				return nil, 0, err
+			}
+			payload = rest
+			ops = append(ops, op{key: key, value: value})
+		case kindDelete:
+			ops = append(ops, op{delete: true, key: key})
+		default:
+			return nil, 0, ErrCorrupted
+		}
+	}
+	return ops, int64(len(header)) + int64(size), nil
+}
+
+// readBytes reads a byte slice prefixed by its uvarint length.
+func readBytes(b []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || uint64(len(b)-size) < n {
+		return nil, nil, ErrCorrupted
+	}
+	return clone(b[size : size+int(n)]), b[size+int(n):], nil
+}
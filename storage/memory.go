@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+var _ Store = (*memory)(nil)
+
+// memory is a store kept in memory. Snapshots and iterators share the data
+// until the next write, which copies it.
+type memory struct {
+	data   map[string][]byte // values by key
+	shared bool              // data is read by a snapshot or an iterator
+	closed bool              // the store is closed
+	mux    sync.RWMutex      // mutual exclusion lock for the fields above
+}
+
+// NewMemory creates an empty store kept in memory.
+func NewMemory() Store {
+	return newMemory()
+}
+
+// newMemory creates an empty memory store.
+func newMemory() *memory {
+	return &memory{data: make(map[string][]byte)}
+}
+
+// Get returns the value of a key.
+func (m *memory) Get(key []byte) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	return get(m.data, key)
+}
+
+// Iterator returns an iterator over the keys starting with prefix.
+func (m *memory) Iterator(prefix []byte) Iterator {
+	data, err := m.view()
+	if err != nil {
+		return &iterator{}
+	}
+	return newIterator(data, prefix)
+}
+
+// Set sets the value of a key.
+func (m *memory) Set(key []byte, value []byte) error {
+	b := NewBatch()
+	b.Set(key, value)
+	return m.Write(b)
+}
+
+// Delete deletes a key.
+func (m *memory) Delete(key []byte) error {
+	b := NewBatch()
+	b.Delete(key)
+	return m.Write(b)
+}
+
+// Write applies the operations of a batch atomically.
+func (m *memory) Write(b *Batch) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	m.apply(b.ops)
+	return nil
+}
+
+// apply applies operations, the lock must be held.
+func (m *memory) apply(ops []op) {
+	if len(ops) == 0 {
+		return
+	}
+
+	if m.shared {
+		data := make(map[string][]byte, len(m.data))
+		for k, v := range m.data {
+			data[k] = v
+		}
+		m.data = data
+		m.shared = false
+	}
+
+	for _, o := range ops {
+		if o.delete {
+			delete(m.data, string(o.key))
+		} else {
+			m.data[string(o.key)] = o.value
+		}
+	}
+}
+
+// Snapshot returns a consistent view of the store.
+func (m *memory) Snapshot() (Snapshot, error) {
+	data, err := m.view()
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{data: data}, nil
+}
+
+// view returns the current data, which the next write copies instead of
+// modifying.
+func (m *memory) view() (map[string][]byte, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	m.shared = true
+	return m.data, nil
+}
+
+// len returns the number of keys.
+func (m *memory) len() int {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	return len(m.data)
+}
+
+// Close closes the store and drops its data.
+func (m *memory) Close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.closed = true
+	m.data = nil
+	return nil
+}
+
+// snapshot is a view of the data of a memory store.
+type snapshot struct {
+	data map[string][]byte
+}
+
+// Get returns the value of a key at the time of the snapshot.
+func (s *snapshot) Get(key []byte) ([]byte, error) {
+	if s.data == nil {
+		return nil, ErrClosed
+	}
+	return get(s.data, key)
+}
+
+// Iterator returns an iterator over the keys at the time of the snapshot.
+func (s *snapshot) Iterator(prefix []byte) Iterator {
+	return newIterator(s.data, prefix)
+}
+
+// Release releases the snapshot.
+func (s *snapshot) Release() {
+	s.data = nil
+}
+
+// get returns a copy of the value of a key.
+func get(data map[string][]byte, key []byte) ([]byte, error) {
+	v, ok := data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(v), nil
+}
+
+// iterator iterates over the sorted keys of a view of the data.
+type iterator struct {
+	data map[string][]byte // view of the data
+	keys []string          // sorted keys
+	pos  int               // position of the current key plus one
+}
+
+// newIterator creates an iterator over the keys of data starting with prefix.
+func newIterator(data map[string][]byte, prefix []byte) *iterator {
+	it := &iterator{data: data}
+	for k := range data {
+		if strings.HasPrefix(k, string(prefix)) {
+			it.keys = append(it.keys, k)
+		}
+	}
+	sort.Strings(it.keys)
+	return it
+}
+
+// Next moves to the next key.
+func (it *iterator) Next() bool {
+	if it.pos >= len(it.keys) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+// Key returns the current key.
+func (it *iterator) Key() []byte {
+	return []byte(it.keys[it.pos-1])
+}
+
+// Value returns the value of the current key.
+func (it *iterator) Value() []byte {
+	return it.data[it.keys[it.pos-1]]
+}
+
+// Release releases the iterator.
+func (it *iterator) Release() {
+	it.data, it.keys = nil, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+var _ Store = (*prefixed)(nil)
+
+// prefixed is a view of the keys of a store starting with a prefix.
+type prefixed struct {
+	s      Store
+	prefix []byte
+}
+
+// NewPrefix returns the view of the keys of s starting with prefix, without
+// the prefix. Components sharing a store each use their own prefix. Closing
+// the view does not close s.
+func NewPrefix(s Store, prefix string) Store {
+	return &prefixed{s: s, prefix: []byte(prefix)}
+}
+
+// key returns the key of the store.
+func (p *prefixed) key(key []byte) []byte {
+	return append(append([]byte{}, p.prefix...), key...)
+}
+
+// Get returns the value of a key.
+func (p *prefixed) Get(key []byte) ([]byte, error) {
+	return p.s.Get(p.key(key))
+}
+
+// Iterator returns an iterator over the keys starting with prefix.
+func (p *prefixed) Iterator(prefix []byte) Iterator {
+	return &prefixedIterator{Iterator: p.s.Iterator(p.key(prefix)), n: len(p.prefix)}
+}
+
+// Set sets the value of a key.
+func (p *prefixed) Set(key []byte, value []byte) error {
+	return p.s.Set(p.key(key), value)
+}
+
+// Delete deletes a key.
+func (p *prefixed) Delete(key []byte) error {
+	return p.s.Delete(p.key(key))
+}
+
+// Write applies the operations of a batch atomically.
+func (p *prefixed) Write(b *Batch) error {
+	ops := make([]op, 0, len(b.ops))
+	for _, o := range b.ops {
+		ops = append(ops, op{delete: o.delete, key: p.key(o.key), value: o.value})
+	}
+	return p.s.Write(&Batch{ops: ops})
+}
+
+// Snapshot returns a consistent view of the keys.
+func (p *prefixed) Snapshot() (Snapshot, error) {
+	s, err := p.s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedSnapshot{Snapshot: s, prefix: p.prefix}, nil
+}
+
+// Close does nothing, the store stays open.
+func (p *prefixed) Close() error {
+	return nil
+}
+
+// prefixedSnapshot is the view of the keys of a snapshot starting with a prefix.
+type prefixedSnapshot struct {
+	Snapshot
+	prefix []byte
+}
+
+// Get returns the value of a key.
+func (s *prefixedSnapshot) Get(key []byte) ([]byte, error) {
+	return s.Snapshot.Get(append(append([]byte{}, s.prefix...), key...))
+}
+
+// Iterator returns an iterator over the keys starting with prefix.
+func (s *prefixedSnapshot) Iterator(prefix []byte) Iterator {
+	it := s.Snapshot.Iterator(append(append([]byte{}, s.prefix...), prefix...))
+	return &prefixedIterator{Iterator: it, n: len(s.prefix)}
+}
+
+// prefixedIterator strips the prefix from the keys of an iterator.
+type prefixedIterator struct {
+	Iterator
+	n int
+}
+
+// Key returns the current key without the prefix.
+func (it *prefixedIterator) Key() []byte {
+	return it.Iterator.Key()[it.n:]
+}
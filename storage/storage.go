@@ -0,0 +1,112 @@
+// Package storage is the key-value storage of the node state: the chain, the
+// address book, the consensus state and the message journal. Keys are sorted
+// bytewise, writes are grouped in atomic batches and reads can be made from a
+// consistent snapshot.
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotFound  = errors.New("key not found")
+	ErrClosed    = errors.New("store is closed")
+	ErrCorrupted = errors.New("corrupted record")
+)
+
+// Reader reads keys and values.
+type Reader interface {
+	// Get returns the value of a key, or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+
+	// Iterator returns an iterator over the keys starting with prefix, in
+	// ascending order. It reads a consistent view of the store.
+	Iterator(prefix []byte) Iterator
+}
+
+// Store is a key-value store.
+type Store interface {
+	Reader
+
+	// Set sets the value of a key.
+	Set(key []byte, value []byte) error
+
+	// Delete deletes a key, deleting a missing key is not an error.
+	Delete(key []byte) error
+
+	// Write applies the operations of a batch atomically.
+	Write(b *Batch) error
+
+	// Snapshot returns a consistent view of the store, unaffected by later
+	// writes.
+	Snapshot() (Snapshot, error)
+
+	// Close closes the store, it must not be used afterward.
+	Close() error
+}
+
+// Snapshot is a read-only view of a store at a point in time.
+type Snapshot interface {
+	Reader
+
+	// Release releases the snapshot, it must not be used afterward.
+	Release()
+}
+
+// Iterator iterates over keys and values. The returned slices must not be
+// modified.
+type Iterator interface {
+	// Next moves to the next key, it returns false once there is none.
+	Next() bool
+
+	// Key returns the current key.
+	Key() []byte
+
+	// Value returns the value of the current key.
+	Value() []byte
+
+	// Release releases the iterator.
+	Release()
+}
+
+// op is an operation of a batch.
+type op struct {
+	delete bool
+	key    []byte
+	value  []byte
+}
+
+// Batch groups operations written atomically with Store.Write.
+type Batch struct {
+	ops []op
+}
+
+// NewBatch creates an empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Set adds the setting of a key.
+func (b *Batch) Set(key []byte, value []byte) {
+	b.ops = append(b.ops, op{key: clone(key), value: clone(value)})
+}
+
+// Delete adds the deletion of a key.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, op{delete: true, key: clone(key)})
+}
+
+// Len returns the number of operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset removes all operations.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// clone returns a copy of a byte slice that is never nil.
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// keys returns the keys and values of an iterator.
+func keys(it Iterator) map[string]string {
+	defer it.Release()
+
+	kv := make(map[string]string)
+	var last string
+	for it.Next() {
+		if last != "" && string(it.Key()) <= last {
+			panic("keys are not sorted")
+		}
+		last = string(it.Key())
+		kv[last] = string(it.Value())
+	}
+	return kv
+}
+
+func testStore(t *testing.T, s Store) {
+	assert.NoError(t, s.Set([]byte("a"), []byte("1")))
+	assert.NoError(t, s.Set([]byte("b/1"), []byte("2")))
+	assert.NoError(t, s.Set([]byte("b/2"), []byte("3")))
+
+	v, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+	_, err = s.Get([]byte("c"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// a snapshot is unaffected by later writes
+	snap, err := s.Snapshot()
+	assert.NoError(t, err)
+	defer snap.Release()
+
+	b := NewBatch()
+	b.Delete([]byte("a"))
+	b.Set([]byte("b/3"), []byte("4"))
+	b.Set([]byte("b/1"), []byte("5"))
+	assert.NoError(t, s.Write(b))
+	assert.NoError(t, s.Delete([]byte("missing")))
+
+	_, err = s.Get([]byte("a"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, map[string]string{"b/1": "5", "b/2": "3", "b/3": "4"}, keys(s.Iterator([]byte("b/"))))
+
+	v, err = snap.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+	assert.Equal(t, map[string]string{"a": "1", "b/1": "2", "b/2": "3"}, keys(snap.Iterator(nil)))
+
+	// a prefix view strips its prefix
+	p := NewPrefix(s, "b/")
+	assert.NoError(t, p.Set([]byte("4"), []byte("6")))
+	v, err = s.Get([]byte("b/4"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("6"), v)
+	assert.Equal(t, map[string]string{"1": "5", "2": "3", "3": "4", "4": "6"}, keys(p.Iterator(nil)))
+	assert.NoError(t, p.Close())
+	_, err = s.Get([]byte("b/4"))
+	assert.NoError(t, err)
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	testStore(t, s)
+
+	assert.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Set([]byte("a"), nil), ErrClosed)
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "node.db")
+	s, err := Open(path)
+	assert.NoError(t, err)
+	testStore(t, s)
+	assert.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Set([]byte("a"), nil), ErrClosed)
+
+	// the data survives a restart
+	s, err = Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"b/1": "5", "b/2": "3", "b/3": "4", "b/4": "6"}, keys(s.Iterator(nil)))
+	assert.NoError(t, s.Close())
+
+	// a torn record at the end is discarded
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write(encodeRecord([]op{{key: []byte("c"), value: []byte("7")}})[:10])
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	_, err = s.Get([]byte("c"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, s.Set([]byte("c"), []byte("8")))
+	assert.NoError(t, s.Close())
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	v, err := s.Get([]byte("c"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("8"), v)
+	assert.NoError(t, s.Close())
+
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Greater(t, after.Size(), info.Size())
+
+	// so is a last record that does not match its CRC
+	last := encodeRecord([]op{{key: []byte("d"), value: []byte("9")}})
+	last[len(last)-1] ^= 0xff
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write(last)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	_, err = s.Get([]byte("d"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, s.Close())
+
+	// but a corrupted record followed by others fails the load instead of
+	// dropping them
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.NoError(t, appendRecord(path, []op{{key: []byte("e"), value: []byte("10")}}))
+	_, err = Open(path)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	corrupted, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, corrupted, len(data)+len(encodeRecord([]op{{key: []byte("e"), value: []byte("10")}})))
+}
+
+func TestFileWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.db")
+	s, err := Open(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set([]byte("a"), []byte("1")))
+
+	// a log that can neither be written nor truncated is closed
+	f := s.(*file)
+	readOnly, err := os.Open(path)
+	assert.NoError(t, err)
+	f.f.Close()
+	f.f = readOnly
+	assert.Error(t, s.Set([]byte("b"), []byte("2")))
+	assert.ErrorIs(t, s.Set([]byte("c"), []byte("3")), ErrClosed)
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, keys(s.Iterator(nil)))
+	assert.NoError(t, s.Close())
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.db")
+	s, err := Open(path)
+	assert.NoError(t, err)
+
+	// the log is compacted while it is written
+	record := int64(len(encodeRecord([]op{{key: []byte("key"), value: []byte(strconv.Itoa(4 * compactMinOps))}})))
+	for i := 0; i < 4*compactMinOps; i++ {
+		assert.NoError(t, s.Set([]byte("key"), []byte(strconv.Itoa(i))))
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), (compactMinOps+1)*record)
+	assert.NoError(t, s.Close())
+
+	// and when it is opened
+	for i := 0; i < compactMinOps; i++ {
+		assert.NoError(t, appendRecord(path, []op{{key: []byte("key"), value: []byte(strconv.Itoa(4*compactMinOps - 1))}}))
+	}
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+	s, err = Open(path)
+	assert.NoError(t, err)
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size()/100)
+
+	// writes after the compaction are appended to the new log
+	assert.NoError(t, s.Set([]byte("other"), []byte("1")))
+	assert.NoError(t, s.Close())
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": strconv.Itoa(4*compactMinOps - 1), "other": "1"}, keys(s.Iterator(nil)))
+	assert.NoError(t, s.Close())
+}
+
+// appendRecord appends a record of operations to a log file.
+func appendRecord(path string, ops []op) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(encodeRecord(ops))
+	return err
+}
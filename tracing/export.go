@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SpanData is an ended span as it is exported.
+type SpanData struct {
+	Service    string
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID // zero for the root span of a trace
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string // error recorded on the span, empty if it succeeded
+}
+
+// Attribute returns the value of an attribute of the span, nil if it is not set.
+func (d SpanData) Attribute(key string) interface{} {
+	for i := len(d.Attributes) - 1; i >= 0; i-- {
+		if d.Attributes[i].Key == key {
+			return d.Attributes[i].Value
+		}
+	}
+	return nil
+}
+
+// Exporter sends ended spans to a backend.
+type Exporter interface {
+	// Export exports an ended span. It is called concurrently.
+	Export(SpanData) error
+
+	// Close flushes the pending spans and releases the exporter.
+	Close() error
+}
+
+// jsonSpan is the JSON line of a span written by a WriterExporter.
+type jsonSpan struct {
+	Service    string                 `json:"service"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMs float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// WriterExporter writes each span as a JSON line, e.g. to stdout or a file.
+type WriterExporter struct {
+	w      io.Writer
+	closer io.Closer  // closed by Close, nil if the writer is not owned
+	mux    sync.Mutex // mutual exclusion lock for w
+}
+
+// NewWriterExporter creates an exporter that writes to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter creates an exporter that appends to a file, created if needed.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+// Export writes a span.
+func (e *WriterExporter) Export(d SpanData) error {
+	s := jsonSpan{
+		Service:    d.Service,
+		Name:       d.Name,
+		Kind:       d.Kind.String(),
+		TraceID:    d.Context.TraceID.String(),
+		SpanID:     d.Context.SpanID.String(),
+		Start:      d.Start,
+		DurationMs: float64(d.End.Sub(d.Start)) / float64(time.Millisecond),
+		Error:      d.Error,
+	}
+	if d.Parent != (SpanID{}) {
+		s.ParentID = d.Parent.String()
+	}
+	if len(d.Attributes) > 0 {
+		s.Attributes = make(map[string]interface{}, len(d.Attributes))
+		for _, a := range d.Attributes {
+			s.Attributes[a.Key] = attributeValue(a.Value)
+		}
+	}
+
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file of the exporter, if any.
+func (e *WriterExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// Recorder keeps the exported spans in memory, for tests.
+type Recorder struct {
+	spans []SpanData
+	mux   sync.Mutex // mutual exclusion lock for spans
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Export records a span.
+func (r *Recorder) Export(d SpanData) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.spans = append(r.spans, d)
+	return nil
+}
+
+// Close does nothing.
+func (r *Recorder) Close() error {
+	return nil
+}
+
+// Spans returns the recorded spans in export order.
+func (r *Recorder) Spans() []SpanData {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]SpanData(nil), r.spans...)
+}
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case KindInternal:
+		return "internal"
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// attributeValue returns an attribute value as a string, bool, integer or float.
+func attributeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, int64, float64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
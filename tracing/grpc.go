@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// traceparentKey is the metadata key of the W3C traceparent header.
+const traceparentKey = "traceparent"
+
+// UnaryClientInterceptor records a client span for each call and sends its
+// span context to the server in the traceparent metadata.
+func UnaryClientInterceptor(t *Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.start(ctx, method, KindClient, []Attribute{Attr("rpc.method", method), Attr("peer", cc.Target())})
+		defer span.End()
+
+		if sc := SpanContextFromContext(ctx); sc.IsValid() {
+			ctx = metadata.AppendToOutgoingContext(ctx, traceparentKey, sc.Traceparent())
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		span.RecordError(err)
+		return err
+	}
+}
+
+// UnaryServerInterceptor records a server span for each call, child of the
+// span of the caller when the call carries a traceparent.
+func UnaryServerInterceptor(t *Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get(traceparentKey) {
+				if sc, err := ParseTraceparent(value); err == nil {
+					ctx = ContextWithRemoteSpanContext(ctx, sc)
+					break
+				}
+			}
+		}
+
+		attrs := []Attribute{Attr("rpc.method", info.FullMethod)}
+		if p, ok := peer.FromContext(ctx); ok {
+			attrs = append(attrs, Attr("peer", p.Addr.String()))
+		}
+
+		ctx, span := t.start(ctx, info.FullMethod, KindServer, attrs)
+		defer span.End()
+
+		response, err := handler(ctx, req)
+		span.RecordError(err)
+		return response, err
+	}
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"simple-p2p/proto/proto"
+	"simple-p2p/transport"
+	"testing"
+)
+
+// pingServer answers pings with the span context of the call.
+type pingServer struct {
+	received chan SpanContext
+}
+
+func (s *pingServer) PingPong(ctx context.Context, _ *proto.Ping) (*proto.Pong, error) {
+	s.received <- SpanContextFromContext(ctx)
+	return &proto.Pong{}, nil
+}
+
+func TestPropagation(t *testing.T) {
+	recorder := NewRecorder()
+	network := transport.NewMemory()
+
+	lis, err := network.Listen("server")
+	assert.NoError(t, err)
+
+	ping := &pingServer{received: make(chan SpanContext, 1)}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(UnaryServerInterceptor(NewTracer("server", recorder))))
+	proto.RegisterPeerServiceServer(server, ping)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	clientTracer := NewTracer("client", recorder)
+	conn, err := network.Dial("server", grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(clientTracer)))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, root := clientTracer.Start(context.Background(), "gossip")
+	_, err = proto.NewPeerServiceClient(conn).PingPong(ctx, &proto.Ping{})
+	assert.NoError(t, err)
+	root.End()
+
+	// the handler runs within the server span
+	handled := <-ping.received
+
+	spans := recorder.Spans()
+	assert.Len(t, spans, 3)
+
+	serverSpan, clientSpan := spans[0], spans[1]
+	assert.Equal(t, KindServer, serverSpan.Kind)
+	assert.Equal(t, "server", serverSpan.Service)
+	assert.Equal(t, KindClient, clientSpan.Kind)
+	assert.Equal(t, "/p2p.PeerService/PingPong", clientSpan.Name)
+	assert.Equal(t, "server", clientSpan.Attribute("peer"))
+
+	// root -> client span -> server span, all in one trace
+	assert.Equal(t, root.Context().SpanID, clientSpan.Parent)
+	assert.Equal(t, clientSpan.Context.SpanID, serverSpan.Parent)
+	assert.Equal(t, root.Context().TraceID, serverSpan.Context.TraceID)
+	assert.Equal(t, serverSpan.Context, handled)
+}
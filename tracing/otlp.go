@@ -0,0 +1,342 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpPath is the path of the OTLP/HTTP traces endpoint.
+const otlpPath = "/v1/traces"
+
+var (
+	defaultBatchSize     = 512             // spans sent in one OTLP request
+	defaultFlushInterval = 5 * time.Second // longest time a span waits to be sent
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector with the
+// OTLP/HTTP protocol and its JSON encoding.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+
+	batch []SpanData    // spans not sent yet
+	mux   sync.Mutex    // mutual exclusion lock for batch
+	send  sync.Mutex    // only one batch is sent at a time
+	stop  chan struct{} // stops the flush loop
+	done  chan struct{} // the flush loop stopped
+}
+
+// NewOTLPExporter creates an exporter to the collector at endpoint, e.g.
+// http://localhost:4318. Spans are sent at least every few seconds and on Close.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + otlpPath,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(defaultFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = e.Flush()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+	return e
+}
+
+// Export queues a span, and sends the batch once it is full.
+func (e *OTLPExporter) Export(d SpanData) error {
+	e.mux.Lock()
+	e.batch = append(e.batch, d)
+	full := len(e.batch) >= defaultBatchSize
+	e.mux.Unlock()
+
+	if full {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush sends the queued spans.
+func (e *OTLPExporter) Flush() error {
+	e.send.Lock()
+	defer e.send.Unlock()
+
+	e.mux.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.mux.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(encodeOTLP(batch))
+	if err != nil {
+		return err
+	}
+
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export: %v", response.Status)
+	}
+	return nil
+}
+
+// Close stops the flush loop and sends the queued spans.
+func (e *OTLPExporter) Close() error {
+	close(e.stop)
+	<-e.done
+	return e.Flush()
+}
+
+// The types below are the OTLP/HTTP JSON encoding of an
+// ExportTraceServiceRequest. IDs are hex strings and 64-bit integers are
+// decimal strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 1 for ok, 2 for error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// encodeOTLP groups spans by service, the service.name resource attribute.
+func encodeOTLP(batch []SpanData) otlpRequest {
+	var request otlpRequest
+	index := make(map[string]int)
+
+	for _, d := range batch {
+		i, ok := index[d.Service]
+		if !ok {
+			i = len(request.ResourceSpans)
+			index[d.Service] = i
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: []otlpKeyValue{keyValue(Attr("service.name", d.Service))}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "simple-p2p"}}},
+			})
+		}
+
+		span := otlpSpan{
+			TraceID:           d.Context.TraceID.String(),
+			SpanID:            d.Context.SpanID.String(),
+			Name:              d.Name,
+			Kind:              int(d.Kind),
+			StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if d.Parent != (SpanID{}) {
+			span.ParentSpanID = d.Parent.String()
+		}
+		if d.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: d.Error}
+		}
+		for _, a := range d.Attributes {
+			span.Attributes = append(span.Attributes, keyValue(a))
+		}
+
+		scope := &request.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+	return request
+}
+
+// keyValue encodes an attribute.
+func keyValue(a Attribute) otlpKeyValue {
+	kv := otlpKeyValue{Key: a.Key}
+	switch v := attributeValue(a.Value).(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	}
+	return kv
+}
+
+// Collector is a minimal stand-in of an OpenTelemetry collector that accepts
+// OTLP/HTTP JSON requests and keeps the spans in memory, for tests.
+type Collector struct {
+	spans []SpanData
+	mux   sync.Mutex // mutual exclusion lock for spans
+}
+
+// NewCollector creates an empty collector.
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// ServeHTTP accepts an export request.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != otlpPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	var request otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spans, err := decodeOTLP(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mux.Lock()
+	c.spans = append(c.spans, spans...)
+	c.mux.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// Spans returns the received spans in arrival order.
+func (c *Collector) Spans() []SpanData {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return append([]SpanData(nil), c.spans...)
+}
+
+// decodeOTLP decodes the spans of an export request.
+func decodeOTLP(request otlpRequest) ([]SpanData, error) {
+	var spans []SpanData
+	for _, rs := range request.ResourceSpans {
+		var service string
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" && kv.Value.StringValue != nil {
+				service = *kv.Value.StringValue
+			}
+		}
+
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				d := SpanData{Service: service, Name: s.Name, Kind: Kind(s.Kind)}
+				if err := decodeID(d.Context.TraceID[:], s.TraceID); err != nil {
+					return nil, err
+				}
+				if err := decodeID(d.Context.SpanID[:], s.SpanID); err != nil {
+					return nil, err
+				}
+				if s.ParentSpanID != "" {
+					if err := decodeID(d.Parent[:], s.ParentSpanID); err != nil {
+						return nil, err
+					}
+				}
+
+				start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+				end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+				d.Start, d.End = time.Unix(0, start), time.Unix(0, end)
+				if s.Status.Code == 2 {
+					d.Error = s.Status.Message
+				}
+
+				for _, kv := range s.Attributes {
+					d.Attributes = append(d.Attributes, Attr(kv.Key, decodeValue(kv.Value)))
+				}
+				spans = append(spans, d)
+			}
+		}
+	}
+	return spans, nil
+}
+
+// decodeID decodes a hex ID of exactly len(dst) bytes.
+func decodeID(dst []byte, s string) error {
+	if len(s) != 2*len(dst) {
+		return fmt.Errorf("invalid id: %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// decodeValue decodes an attribute value.
+func decodeValue(v otlpValue) interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		i, _ := strconv.ParseInt(*v.IntValue, 10, 64)
+		return i
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+// Package tracing records spans of work across nodes. It follows the
+// OpenTelemetry data model: spans share the trace ID of their root and
+// propagate across nodes with the W3C traceparent header, and they can be
+// exported to an OpenTelemetry collector over OTLP.
+//
+// A nil *Tracer is valid and records nothing, so tracing is off by default.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace, every span of a trace shares it.
+type TraceID [16]byte
+
+// String returns the hex encoding of the trace ID.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the hex encoding of the span ID.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that propagates to other nodes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the span context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%v-%v-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent parses a W3C traceparent header.
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	return sc, nil
+}
+
+// Attribute is a key and value attached to a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr returns an attribute.
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Kind is the role of a span in a call between nodes.
+type Kind int
+
+const (
+	KindInternal Kind = iota + 1 // work within a node
+	KindServer                   // handling of a call from another node
+	KindClient                   // call to another node
+)
+
+// Tracer starts spans and exports them when they end.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	attrs    []Attribute // attributes of every span
+}
+
+// NewTracer creates a tracer of a service that exports spans to an exporter.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// With returns a tracer that adds attributes to every span.
+func (t *Tracer) With(attrs ...Attribute) *Tracer {
+	if t == nil {
+		return nil
+	}
+	return &Tracer{
+		service:  t.service,
+		exporter: t.exporter,
+		attrs:    append(append([]Attribute(nil), t.attrs...), attrs...),
+	}
+}
+
+// Start starts a span, child of the span of ctx if any. The returned context
+// carries the new span. End must be called on the span.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return t.start(ctx, name, KindInternal, attrs)
+}
+
+// start starts a span of a kind.
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, attrs []Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Service: t.service,
+			Name:    name,
+			Kind:    kind,
+			Parent:  parent.SpanID,
+			Start:   time.Now(),
+		},
+	}
+	s.data.Context.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		s.data.Context.TraceID = newTraceID()
+	}
+	s.data.Context.SpanID = newSpanID()
+	s.data.Attributes = append(append(s.data.Attributes, t.attrs...), attrs...)
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// Span is a timed operation of a trace. A nil *Span is valid and records nothing.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+	mux    sync.Mutex // mutual exclusion lock for data and ended
+}
+
+// Context returns the span context, the zero value for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError marks the span as failed with an error. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+// End ends the span and exports it. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mux.Unlock()
+
+	_ = s.tracer.exporter.Export(data)
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// remoteKey is the context key of a span context received from another node.
+type remoteKey struct{}
+
+// ContextWithSpan returns a context that carries a span.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span of a context, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemoteSpanContext returns a context whose next span is a child of
+// a span of another node.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the span of a context, or
+// the remote span context if the context has no span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Detach returns a background context that carries the span of ctx but not
+// its deadline and cancellation, for work that outlives a call.
+func Detach(ctx context.Context) context.Context {
+	if s := SpanFromContext(ctx); s != nil {
+		return ContextWithSpan(context.Background(), s)
+	}
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		return ContextWithRemoteSpanContext(context.Background(), sc)
+	}
+	return context.Background()
+}
+
+// newTraceID returns a random trace ID.
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID.
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
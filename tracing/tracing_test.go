@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736zz-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(invalid)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, invalid)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	recorder := NewRecorder()
+	tracer := NewTracer("test", recorder).With(Attr("node", "node-1"))
+
+	ctx, root := tracer.Start(context.Background(), "sync", Attr("topic", ""))
+	_, child := tracer.Start(ctx, "round", Attr("round", 1))
+	child.RecordError(errors.New("timeout"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := recorder.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "round", spans[0].Name)
+	assert.Equal(t, "sync", spans[1].Name)
+
+	// the child belongs to the trace of the root
+	assert.Equal(t, spans[1].Context.TraceID, spans[0].Context.TraceID)
+	assert.Equal(t, spans[1].Context.SpanID, spans[0].Parent)
+	assert.Equal(t, SpanID{}, spans[1].Parent)
+
+	assert.Equal(t, "timeout", spans[0].Error)
+	assert.Equal(t, "node-1", spans[0].Attribute("node"))
+	assert.Equal(t, 1, spans[0].Attribute("round"))
+	assert.Equal(t, "test", spans[0].Service)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.With(Attr("node", "node-1")).Start(context.Background(), "sync")
+	span.SetAttributes(Attr("round", 1))
+	span.RecordError(errors.New("timeout"))
+	span.End()
+
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+}
+
+func TestDetach(t *testing.T) {
+	tracer := NewTracer("test", NewRecorder())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := tracer.Start(ctx, "call")
+	cancel()
+
+	detached := Detach(ctx)
+	assert.NoError(t, detached.Err())
+	assert.Equal(t, span, SpanFromContext(detached))
+
+	// a remote span context survives as well
+	sc := span.Context()
+	detached = Detach(ContextWithRemoteSpanContext(context.Background(), sc))
+	assert.Equal(t, sc, SpanContextFromContext(detached))
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewFileExporter(path)
+	assert.NoError(t, err)
+
+	tracer := NewTracer("test", exporter)
+	ctx, root := tracer.Start(context.Background(), "sync")
+	_, child := tracer.Start(ctx, "round", Attr("round", 2))
+	child.End()
+	root.End()
+	assert.NoError(t, exporter.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	var span map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &span))
+	assert.Equal(t, "round", span["name"])
+	assert.Equal(t, "internal", span["kind"])
+	assert.Equal(t, root.Context().TraceID.String(), span["trace_id"])
+	assert.Equal(t, root.Context().SpanID.String(), span["parent_id"])
+	assert.Equal(t, map[string]interface{}{"round": float64(2)}, span["attributes"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	collector := NewCollector()
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL)
+	tracer := NewTracer("node-1", exporter)
+
+	ctx, root := tracer.Start(context.Background(), "sync", Attr("topic", "reconfig"))
+	_, child := tracer.Start(ctx, "query", Attr("accepted", true), Attr("confidence", 0.5))
+	child.RecordError(errors.New("unavailable"))
+	child.End()
+	root.End()
+
+	// nothing is sent before the batch is flushed
+	assert.Empty(t, collector.Spans())
+	assert.NoError(t, exporter.Close())
+
+	spans := collector.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "query", spans[0].Name)
+	assert.Equal(t, "node-1", spans[0].Service)
+	assert.Equal(t, root.Context().TraceID, spans[0].Context.TraceID)
+	assert.Equal(t, root.Context().SpanID, spans[0].Parent)
+	assert.Equal(t, "unavailable", spans[0].Error)
+	assert.Equal(t, true, spans[0].Attribute("accepted"))
+	assert.Equal(t, 0.5, spans[0].Attribute("confidence"))
+	assert.Equal(t, "reconfig", spans[1].Attribute("topic"))
+	assert.True(t, spans[0].End.After(spans[0].Start) || spans[0].End.Equal(spans[0].Start))
+}
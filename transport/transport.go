@@ -0,0 +1,138 @@
+// Package transport abstracts how nodes listen for and dial gRPC connections,
+// so nodes can run over TCP or entirely in memory.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync"
+)
+
+var (
+	ErrAddressInUse   = errors.New("address already in use")
+	ErrConnectRefused = errors.New("connection refused")
+)
+
+// Transport creates listeners and client connections for network addresses.
+type Transport interface {
+	// Listen announces on the network address.
+	Listen(addr string) (net.Listener, error)
+
+	// Dial creates a client connection to the network address.
+	Dial(addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error)
+}
+
+var _ Transport = TCP{}
+
+// TCP is the transport over the real network.
+type TCP struct{}
+
+// NewTCP returns the TCP transport.
+func NewTCP() Transport {
+	return TCP{}
+}
+
+// Listen announces on the TCP address.
+func (TCP) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// Dial creates an insecure client connection to the TCP address.
+func (TCP) Dial(addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr, append([]grpc.DialOption{grpc.WithInsecure()}, opts...)...)
+}
+
+var _ Transport = (*Memory)(nil)
+
+// memoryBufferSize is the buffer size of each in-memory connection.
+const memoryBufferSize = 256 * 1024
+
+// Memory is an in-process network. Addresses are plain names in a registry of
+// listeners, so any number of nodes can run in one test without binding ports.
+type Memory struct {
+	listeners map[string]*memoryListener // listeners by address
+	mux       sync.RWMutex               // mutual exclusion lock for listeners
+}
+
+// NewMemory returns an empty in-memory network.
+func NewMemory() *Memory {
+	return &Memory{
+		listeners: make(map[string]*memoryListener),
+	}
+}
+
+// memoryListener is a listener of the in-memory network that unregisters
+// itself when it is closed.
+type memoryListener struct {
+	*bufconn.Listener
+	addr    string
+	network *Memory
+	once    sync.Once
+}
+
+// Close closes the listener and frees its address.
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		l.network.mux.Lock()
+		defer l.network.mux.Unlock()
+
+		if l.network.listeners[l.addr] == l {
+			delete(l.network.listeners, l.addr)
+		}
+	})
+	return l.Listener.Close()
+}
+
+// Addr returns the address of the listener.
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr(l.addr)
+}
+
+// memoryAddr is an address of the in-memory network.
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// Listen registers a listener for the address.
+func (m *Memory) Listen(addr string) (net.Listener, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.listeners[addr]; ok {
+		return nil, fmt.Errorf("listen %v: %w", addr, ErrAddressInUse)
+	}
+
+	l := &memoryListener{
+		Listener: bufconn.Listen(memoryBufferSize),
+		addr:     addr,
+		network:  m,
+	}
+	m.listeners[addr] = l
+	return l, nil
+}
+
+// Dial creates a client connection to the listener of the address. As with
+// TCP, the connection is established lazily, so dialing an address without a
+// listener only fails on the first call.
+func (m *Memory) Dial(addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialer := func(ctx context.Context, target string) (net.Conn, error) {
+		m.mux.RLock()
+		l, ok := m.listeners[target]
+		m.mux.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("dial %v: %w", target, ErrConnectRefused)
+		}
+		return l.DialContext(ctx)
+	}
+
+	return grpc.Dial(addr, append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(dialer),
+	}, opts...)...)
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	network := NewMemory()
+	lis, err := network.Listen("node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", lis.Addr().String())
+	assert.Equal(t, "memory", lis.Addr().Network())
+
+	_, err = network.Listen("node-1")
+	assert.ErrorIs(t, err, ErrAddressInUse)
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// a call goes through the in-memory connection
+	conn, err := network.Dial("node-1")
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.Status)
+
+	// the address is free again once the listener is closed
+	server.Stop()
+	lis, err = network.Listen("node-1")
+	assert.NoError(t, err)
+	assert.NoError(t, lis.Close())
+}
+
+func TestMemoryUnknownAddress(t *testing.T) {
+	network := NewMemory()
+
+	// dialing succeeds, the first call fails
+	conn, err := network.Dial("unknown")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), ErrConnectRefused.Error())
+}
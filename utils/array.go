@@ -25,3 +25,28 @@ func GetMostFrequentValue(values []int) (int, int) {
 	}
 	return mostFrequentValue, maxCount
 }
+
+// GetMostWeightedValue returns the value with the highest total weight and
+// that weight. values and weights must have the same length.
+// Example: [1, 2, 2], [5, 1, 1] -> 1, 5
+// Example: [1, 2, 2], [1, 1, 1] -> 2, 2
+func GetMostWeightedValue(values []int, weights []uint64) (int, uint64) {
+	var (
+		mostWeightedValue int
+		maxWeight         uint64
+	)
+
+	totals := make(map[int]uint64, len(values))
+	for i, value := range values {
+		totals[value] += weights[i]
+	}
+
+	// iterate over values instead of the map to keep ties deterministic
+	for _, value := range values {
+		if totals[value] > maxWeight {
+			maxWeight = totals[value]
+			mostWeightedValue = value
+		}
+	}
+	return mostWeightedValue, maxWeight
+}